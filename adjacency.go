@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// maxAdjacencyEntries caps the total number of neighbour IDs returned by the
+// adjacency endpoint across all markers.
+const maxAdjacencyEntries = 100000
+
+// maxAdjacencyRadii caps how many distinct radii are cached per generation.
+const maxAdjacencyRadii = 16
+
+// adjacencyResult is an adjacency list together with whether it was cut short.
+type adjacencyResult struct {
+	neighbors map[string][]string
+	truncated bool
+}
+
+// adjacencyCache holds adjacency lists by radius for one snapshot generation.
+var adjacencyCache struct {
+	mu         sync.Mutex
+	generation uint64
+	byRadius   map[float64]*adjacencyResult
+}
+
+// adjacencyHandler returns, for each marker, the IDs of the other markers
+// within ?radius= of it. A radius beyond the diagonal of the map is taken as
+// the diagonal, which already reaches every marker, so that it neither
+// overflows the grid keys nor takes up a cache entry of its own.
+func adjacencyHandler(w http.ResponseWriter, r *http.Request) {
+	radius, err := strconv.ParseFloat(r.URL.Query().Get("radius"), 64)
+	if err != nil || radius <= 0 || !isFinite(radius) {
+		http.Error(w, "Query parameter radius must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	if b, ok := locationBounds(snap.locations); ok {
+		radius = min(radius, math.Hypot(b.MaxX-b.MinX, b.MaxY-b.MinY))
+	}
+
+	adjacencyCache.mu.Lock()
+	if adjacencyCache.byRadius == nil || adjacencyCache.generation != snap.generation ||
+		len(adjacencyCache.byRadius) >= maxAdjacencyRadii {
+		adjacencyCache.byRadius = make(map[float64]*adjacencyResult)
+		adjacencyCache.generation = snap.generation
+	}
+	result, ok := adjacencyCache.byRadius[radius]
+	if !ok {
+		result = computeAdjacency(snap.grid(), radius, maxAdjacencyEntries)
+		adjacencyCache.byRadius[radius] = result
+	}
+	adjacencyCache.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	if result.truncated {
+		w.Header().Set("X-Result-Truncated", "true")
+	}
+
+	writeJSON(w, result.neighbors, "adjacency list")
+}
+
+// computeAdjacency lists, for every location in grid, the other locations
+// within radius ordered by distance. Once limit neighbour entries have been
+// collected the remaining markers are left out and the result is flagged as
+// truncated.
+func computeAdjacency(grid *gridIndex, radius float64, limit int) *adjacencyResult {
+	result := &adjacencyResult{neighbors: make(map[string][]string)}
+	total := 0
+
+	for i, loc := range grid.locations {
+		var found []neighbor
+		grid.within(loc.XY.X, loc.XY.Y, radius, func(j int, dist float64) {
+			if j != i {
+				found = append(found, neighbor{i: j, dist: dist})
+			}
+		})
+
+		if total+len(found) > limit {
+			result.truncated = true
+			break
+		}
+		total += len(found)
+
+		sort.Slice(found, func(a, b int) bool {
+			if found[a].dist != found[b].dist {
+				return found[a].dist < found[b].dist
+			}
+			return grid.locations[found[a].i].ID < grid.locations[found[b].i].ID
+		})
+
+		ids := make([]string, len(found))
+		for k, n := range found {
+			ids[k] = grid.locations[n.i].ID
+		}
+		result.neighbors[loc.ID] = ids
+	}
+
+	return result
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAdjacencyRadius(t *testing.T) {
+	server := newTestServer(t, nil,
+		MapLocation{ID: "a", Location: "A", XY: Coordinates{X: 0, Y: 0}},
+		MapLocation{ID: "b", Location: "B", XY: Coordinates{X: 3, Y: 4}},
+		MapLocation{ID: "c", Location: "C", XY: Coordinates{X: 100, Y: 0}},
+	)
+
+	for _, radius := range []string{"", "abc", "0", "-1", "NaN", "nan", "Inf", "-Inf"} {
+		resp, body := send(t, server, http.MethodGet, "/api/map/adjacency?radius="+radius, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("radius=%s: status %d, want 400: %s", radius, resp.StatusCode, body)
+		}
+	}
+
+	tests := []struct {
+		radius string
+		want   map[string][]string
+	}{
+		{"5", map[string][]string{"a": {"b"}, "b": {"a"}, "c": {}}},
+		// Beyond the map's extent every marker neighbours every other
+		{"1e308", map[string][]string{"a": {"b", "c"}, "b": {"a", "c"}, "c": {"b", "a"}}},
+	}
+	for _, tt := range tests {
+		resp, body := send(t, server, http.MethodGet, "/api/map/adjacency?radius="+tt.radius, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("radius=%s: status %d, want 200: %s", tt.radius, resp.StatusCode, body)
+			continue
+		}
+		var got map[string][]string
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("radius=%s: %v", tt.radius, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("radius=%s: got %v, want %v", tt.radius, got, tt.want)
+		}
+	}
+}
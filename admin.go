@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin only lets admins through to next; see requireRole.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(roleAdmin, next)
+}
+
+// authenticateAPIKey checks the X-API-Key header, which must carry either the
+// config.Auth.AdminAPIKey or a key issued with the keys subcommand that has not been
+// revoked. Both count as admin. On failure the error response is written and
+// false returned; with no way to authenticate at all the endpoints are
+// disabled.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	adminKey := config.Auth.AdminAPIKey
+	keys, haveKeys := store.(APIKeyStorage)
+	if adminKey == "" && !haveKeys && config.Auth.JWTSecret == "" {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return principal{}, false
+	}
+
+	given := r.Header.Get("X-API-Key")
+	if given == "" {
+		http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+		return principal{}, false
+	}
+	if adminKey != "" && subtle.ConstantTimeCompare([]byte(given), []byte(adminKey)) == 1 {
+		return principal{Name: "key:admin", Role: roleAdmin}, true
+	}
+	if !haveKeys || !strings.HasPrefix(given, apiKeyPrefix) {
+		http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+		return principal{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	key, err := verifyAPIKey(ctx, keys, given)
+	switch {
+	case errors.Is(err, errInvalidAPIKey):
+		http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+		return principal{}, false
+	case err != nil:
+		logFor(r.Context()).Error("failed to verify API key", "error", err)
+		http.Error(w, "Failed to verify API key", http.StatusInternalServerError)
+		return principal{}, false
+	}
+	return principal{Name: "key:" + key.ID, Role: roleAdmin}, true
+}
+
+// LocationViolations lists the validation failures of one stored location.
+type LocationViolations struct {
+	ID     string       `json:"id"`
+	Errors []FieldError `json:"errors"`
+}
+
+// adminValidateHandler reports every cached location that fails the current
+// validation rules. It never modifies any data.
+func adminValidateHandler(w http.ResponseWriter, r *http.Request) {
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	violations := []LocationViolations{}
+	for _, loc := range snap.locations {
+		if errs := validateLocation(loc); errs != nil {
+			violations = append(violations, LocationViolations{ID: loc.ID, Errors: errs})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, violations, "validation report")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CacheEntry describes one cached snapshot in /api/admin/cache.
+type CacheEntry struct {
+	// Name is "map" for the default world, "map:{world}" for the others and
+	// the dataset name for datasets
+	Name    string `json:"name"`
+	Loaded  bool   `json:"loaded"`
+	Entries int    `json:"entries"`
+	// SizeBytes is the size of the entries encoded as JSON
+	SizeBytes  int        `json:"sizeBytes"`
+	Generation uint64     `json:"generation"`
+	LoadedAt   *time.Time `json:"loadedAt,omitempty"`
+	// AgeSeconds is how long ago the snapshot was read from storage. A
+	// refresh that finds nothing changed keeps the snapshot and its age.
+	AgeSeconds float64 `json:"ageSeconds"`
+	// CheckedAt is when the snapshot was last loaded or found unchanged
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// Stale marks a snapshot a write has outdated; the next read reloads it
+	Stale bool `json:"stale"`
+}
+
+// CacheReport is the /api/admin/cache response body.
+type CacheReport struct {
+	Entries []CacheEntry `json:"entries"`
+	// RefreshIntervalSeconds is how often the background refresher reloads
+	RefreshIntervalSeconds float64       `json:"refreshIntervalSeconds"`
+	Refresh                RefreshStatus `json:"refresh"`
+	// Election is left out unless the replicas elect a refresher
+	Election *ElectionStatus `json:"election,omitempty"`
+}
+
+// snapshotEntry describes a world's cache, which is empty until the world is
+// first loaded.
+func snapshotEntry(name string, c *mapCache) CacheEntry {
+	e := CacheEntry{Name: name, Stale: c.Stale()}
+	s := c.Peek()
+	if s == nil {
+		return e
+	}
+	loadedAt, checkedAt := s.loadedAt.UTC(), c.Checked().UTC()
+	e.Loaded = true
+	e.CheckedAt = &checkedAt
+	e.Entries = len(s.locations)
+	e.Generation = s.generation
+	e.LoadedAt = &loadedAt
+	e.AgeSeconds = time.Since(s.loadedAt).Seconds()
+	if body, err := s.encodedJSON(); err == nil {
+		e.SizeBytes = len(body)
+	}
+	return e
+}
+
+// cacheEntry describes the dataset's snapshot.
+func (d *dataset[T]) cacheEntry() CacheEntry {
+	e := CacheEntry{Name: d.name, Stale: d.cache.Stale()}
+	s := d.cache.Peek()
+	if s == nil {
+		return e
+	}
+	loadedAt, checkedAt := s.loadedAt.UTC(), d.cache.Checked().UTC()
+	e.Loaded = true
+	e.CheckedAt = &checkedAt
+	e.Entries = len(s.items)
+	e.Generation = s.generation
+	e.LoadedAt = &loadedAt
+	e.AgeSeconds = time.Since(s.loadedAt).Seconds()
+	if body, err := json.Marshal(s.items); err == nil {
+		e.SizeBytes = len(body)
+	}
+	return e
+}
+
+// cacheReport describes every world's snapshot and every dataset's.
+func cacheReport() CacheReport {
+	report := CacheReport{RefreshIntervalSeconds: config.RefreshInterval.Seconds(), Election: electionStatus()}
+	for _, wd := range worlds {
+		report.Entries = append(report.Entries, snapshotEntry(wd.cacheName(), wd.cache))
+	}
+	for _, d := range datasets {
+		report.Entries = append(report.Entries, d.cacheEntry())
+	}
+
+	refreshState.mu.Lock()
+	report.Refresh = refreshState.status
+	refreshState.mu.Unlock()
+	return report
+}
+
+// adminCacheHandler reports the state of the caches.
+func adminCacheHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, cacheReport(), "cache report")
+}
+
+// adminCacheRefreshHandler reloads every cache from storage right away, for
+// when the database was edited by hand, and answers with the new report.
+// Worlds nobody has asked for stay unloaded.
+func adminCacheRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	manualReloads.Add(1)
+	err = errors.Join(refreshCache(r.Context()), refreshWorlds(r.Context()), refreshDatasets(r.Context()))
+	manualReloads.Add(-1)
+	release()
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+	logFor(r.Context()).Info("cache refreshed on request", "principal", p.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, cacheReport(), "cache report")
+}
+
+// manualReloads counts the reloads adminCacheRefreshHandler is running.
+var manualReloads atomic.Int32
+
+// maintenanceDuringReload answers reads of the data with 503 while a reload
+// asked for at /api/admin/cache/refresh runs, when
+// config.MaintenanceDuringReload is set. The admin routes, probes and
+// metrics stay up.
+func maintenanceDuringReload(route string, handler http.HandlerFunc) http.HandlerFunc {
+	api := unversionedRoute(route)
+	serves := (strings.HasPrefix(api, "/api/") && !strings.HasPrefix(api, "/api/admin/")) || route == "/graphql" || route == "/ws"
+	return func(w http.ResponseWriter, r *http.Request) {
+		if serves && config.MaintenanceDuringReload && manualReloads.Load() > 0 && rateGroup(route, r) == rateGroupRead {
+			w.Header().Set("Retry-After", "5")
+			writeProblem(w, r, http.StatusServiceUnavailable, "The map data is being reloaded, please retry shortly")
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bounds of the usage analytics.
+const (
+	// maxAnalyticsClients and maxAnalyticsTerms cap the distinct clients
+	// and search terms told apart per day; past them requests are still
+	// counted but new clients and terms are not
+	maxAnalyticsClients = 100000
+	maxAnalyticsTerms   = 1000
+	// analyticsTopTerms is how many search terms the report lists
+	analyticsTopTerms = 50
+	// defaultAnalyticsDays is the span of the report without ?days=
+	defaultAnalyticsDays = 7
+)
+
+// analyticsDayFormat names the UTC days the figures are kept by.
+const analyticsDayFormat = "2006-01-02"
+
+// AnalyticsCount is a name and how often it came up. Counts are kept as
+// lists rather than maps since routes and search terms may hold dots, which
+// MongoDB field names should not.
+type AnalyticsCount struct {
+	Name  string `json:"name" bson:"name"`
+	Count uint64 `json:"count" bson:"count"`
+}
+
+// AnalyticsDay is one process's figures of one UTC day, stored in the
+// analytics collection under "{day}/{instance}" so that replicas never write
+// the same document.
+type AnalyticsDay struct {
+	ID       string `json:"id" bson:"_id"`
+	Day      string `json:"day" bson:"day"`
+	Instance string `json:"instance" bson:"instance"`
+	// Requests counts the answered requests by route, the same for every
+	// API version and world, and Datasets those to the map and each dataset
+	Requests []AnalyticsCount `json:"requests" bson:"requests"`
+	Datasets []AnalyticsCount `json:"datasets" bson:"datasets"`
+	// Clients are the salted hashes of the client IPs; the day is part of
+	// the hash, so a client cannot be followed from one day to the next
+	Clients     []string         `json:"-" bson:"clients"`
+	SearchTerms []AnalyticsCount `json:"searchTerms" bson:"searchTerms"`
+	UpdatedAt   time.Time        `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (d AnalyticsDay) RecordID() string { return d.ID }
+
+// analyticsTally is the figures of a day being counted.
+type analyticsTally struct {
+	day                string
+	requests, datasets map[string]uint64
+	searchTerms        map[string]uint64
+	clients            map[string]bool
+	// seeded is set once the figures stored by an earlier run have been
+	// added, and dirty while there are figures not yet stored
+	seeded, dirty bool
+}
+
+func newAnalyticsTally(day string) *analyticsTally {
+	return &analyticsTally{day: day, requests: map[string]uint64{}, datasets: map[string]uint64{}, searchTerms: map[string]uint64{}, clients: map[string]bool{}}
+}
+
+// analyticsRecords stores the days; it is nil while analytics are off.
+var analyticsRecords RecordStore[AnalyticsDay]
+
+// analytics is what has been counted. Today is the day being counted;
+// previous is the day before until it has been flushed.
+var analytics struct {
+	mu              sync.Mutex
+	instance        string
+	salt            []byte
+	today, previous *analyticsTally
+
+	// flushMu lets one flush run at a time, without holding up the
+	// requests being counted while it writes
+	flushMu sync.Mutex
+	// pruned is the day days past the retention were last deleted
+	pruned string
+}
+
+// openAnalytics connects the analytics to the storage backend, unless the
+// deployment opted out. It must run after initStorage.
+func openAnalytics() error {
+	if !config.Analytics.Enabled {
+		analyticsRecords = nil
+		return nil
+	}
+	s, err := recordStoreFor[AnalyticsDay]("analytics")
+	if err != nil {
+		return err
+	}
+	analyticsRecords = s
+
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	analytics.instance, _ = os.Hostname()
+	if analytics.instance == "" {
+		analytics.instance = "local"
+	}
+	analytics.salt = []byte(config.Analytics.Salt)
+	if len(analytics.salt) == 0 {
+		salt, err := generatedAnalyticsSalt()
+		if err != nil {
+			return err
+		}
+		analytics.salt = salt
+	}
+	analytics.today, analytics.previous = nil, nil
+	return nil
+}
+
+// analyticsSaltID is the ID the generated salt is stored under in the
+// analytics collection, beside the days.
+const analyticsSaltID = "salt"
+
+// AnalyticsSalt is the salt generated for a deployment without
+// ANALYTICS_SALT, kept so that replicas and restarts hash clients alike.
+type AnalyticsSalt struct {
+	ID string `json:"id" bson:"_id"`
+	// Salt is hex-encoded; clients are hashed with the bytes it encodes
+	Salt string `json:"salt" bson:"salt"`
+}
+
+func (s AnalyticsSalt) RecordID() string { return s.ID }
+
+// generatedAnalyticsSalt returns the salt to hash clients with when none is
+// configured: the one kept in the analytics collection. The memory backend
+// keeps nothing across restarts, so there it only warns and draws a salt for
+// this process.
+func generatedAnalyticsSalt() ([]byte, error) {
+	if _, ok := store.(*mongoStorage); !ok {
+		slog.Warn("ANALYTICS_SALT is unset and the storage backend cannot keep a generated one; clients are counted again after a restart and once per replica")
+		return newAnalyticsSalt()
+	}
+	salts, err := recordStoreFor[AnalyticsSalt]("analytics")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Mongo.QueryTimeout)
+	defer cancel()
+	return storedAnalyticsSalt(ctx, salts)
+}
+
+// storedAnalyticsSalt returns the salt stored in salts, generating and
+// storing one on first use; the first replica to store it wins.
+func storedAnalyticsSalt(ctx context.Context, salts RecordStore[AnalyticsSalt]) ([]byte, error) {
+	stored, err := salts.Get(ctx, analyticsSaltID)
+	if errors.Is(err, ErrNotFound) {
+		salt, drawErr := newAnalyticsSalt()
+		if drawErr != nil {
+			return nil, drawErr
+		}
+		err = salts.Insert(ctx, AnalyticsSalt{ID: analyticsSaltID, Salt: hex.EncodeToString(salt)})
+		if err == nil {
+			return salt, nil
+		}
+		if !errors.Is(err, ErrAlreadyExists) {
+			return nil, fmt.Errorf("failed to store the analytics salt: %w", err)
+		}
+		stored, err = salts.Get(ctx, analyticsSaltID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the analytics salt: %w", err)
+	}
+	salt, err := hex.DecodeString(stored.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("the stored analytics salt %q is not hex", stored.Salt)
+	}
+	return salt, nil
+}
+
+// newAnalyticsSalt draws a random salt.
+func newAnalyticsSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to draw the analytics salt: %w", err)
+	}
+	return salt, nil
+}
+
+// analyticsOptOut reports whether the client asked not to be tracked.
+func analyticsOptOut(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// tally returns the figures of the current day, rolling over to a new one
+// at midnight UTC. The caller holds analytics.mu.
+func tally(now time.Time) *analyticsTally {
+	day := now.UTC().Format(analyticsDayFormat)
+	if analytics.today == nil || analytics.today.day != day {
+		if analytics.today != nil && analytics.today.dirty {
+			analytics.previous = analytics.today
+		}
+		analytics.today = newAnalyticsTally(day)
+	}
+	return analytics.today
+}
+
+// recordUsage counts an answered request to route, given as registered.
+// Failed requests, CORS preflights and the routes outside /api or under
+// /api/admin, which are the operators' rather than the community's, are
+// left out.
+func recordUsage(r *http.Request, route string, status int) {
+	if analyticsRecords == nil || status >= http.StatusBadRequest || r.Method == http.MethodOptions || analyticsOptOut(r) {
+		return
+	}
+	route = unversionedRoute(route)
+	if !strings.HasPrefix(route, "/api/") || strings.HasPrefix(route, "/api/admin") {
+		return
+	}
+	dataset := routeDataset(route)
+
+	h := sha256.New()
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	t := tally(time.Now())
+	h.Write(analytics.salt)
+	h.Write([]byte(t.day + "|" + clientIP(r)))
+	client := hex.EncodeToString(h.Sum(nil)[:12])
+
+	t.requests[r.Method+" "+route]++
+	if dataset != "" {
+		t.datasets[dataset]++
+	}
+	if len(t.clients) < maxAnalyticsClients {
+		t.clients[client] = true
+	}
+	t.dirty = true
+}
+
+// recordSearchTerm counts a search, folded to lower case and single spaces.
+func recordSearchTerm(r *http.Request, query string) {
+	if analyticsRecords == nil || analyticsOptOut(r) {
+		return
+	}
+	term := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	t := tally(time.Now())
+	if _, known := t.searchTerms[term]; known || len(t.searchTerms) < maxAnalyticsTerms {
+		t.searchTerms[term]++
+		t.dirty = true
+	}
+}
+
+// routeDataset returns the dataset an unversioned route serves: "map", the
+// name of a dataset, or "" for the others.
+func routeDataset(route string) string {
+	if route == "/api/map" || strings.HasPrefix(route, "/api/map/") {
+		return "map"
+	}
+	for _, d := range datasets {
+		if base := d.basePath(); route == base || strings.HasPrefix(route, base+"/") {
+			return d.collection()
+		}
+	}
+	return ""
+}
+
+// seedTally adds the figures stored for t's day by an earlier run of this
+// instance, so that a restart carries on from them.
+func seedTally(ctx context.Context, t *analyticsTally, id string) error {
+	stored, err := analyticsRecords.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	for _, c := range stored.Requests {
+		t.requests[c.Name] += c.Count
+	}
+	for _, c := range stored.Datasets {
+		t.datasets[c.Name] += c.Count
+	}
+	for _, c := range stored.SearchTerms {
+		t.searchTerms[c.Name] += c.Count
+	}
+	for _, client := range stored.Clients {
+		t.clients[client] = true
+	}
+	t.seeded = true
+	return nil
+}
+
+// storedDay returns the document of t's figures so far. The caller holds
+// analytics.mu.
+func storedDay(t *analyticsTally) AnalyticsDay {
+	day := AnalyticsDay{
+		ID:          t.day + "/" + analytics.instance,
+		Day:         t.day,
+		Instance:    analytics.instance,
+		Requests:    sortedCounts(t.requests, 0),
+		Datasets:    sortedCounts(t.datasets, 0),
+		Clients:     make([]string, 0, len(t.clients)),
+		SearchTerms: sortedCounts(t.searchTerms, 0),
+		UpdatedAt:   time.Now().UTC(),
+	}
+	for client := range t.clients {
+		day.Clients = append(day.Clients, client)
+	}
+	sort.Strings(day.Clients)
+	return day
+}
+
+// flushAnalytics writes the days counted since the last flush, and once a
+// day deletes those past the retention.
+func flushAnalytics(ctx context.Context) error {
+	if analyticsRecords == nil {
+		return nil
+	}
+	analytics.flushMu.Lock()
+	defer analytics.flushMu.Unlock()
+
+	analytics.mu.Lock()
+	tallies := []*analyticsTally{analytics.previous, analytics.today}
+	analytics.mu.Unlock()
+
+	for _, t := range tallies {
+		if t == nil {
+			continue
+		}
+		analytics.mu.Lock()
+		dirty, seeded := t.dirty, t.seeded
+		analytics.mu.Unlock()
+		if !dirty {
+			continue
+		}
+		if !seeded {
+			if err := seedTally(ctx, t, t.day+"/"+analytics.instance); err != nil {
+				return fmt.Errorf("failed to load the analytics of %s: %w", t.day, err)
+			}
+		}
+
+		analytics.mu.Lock()
+		day := storedDay(t)
+		t.dirty = false
+		analytics.mu.Unlock()
+		if _, err := analyticsRecords.Upsert(ctx, day); err != nil {
+			analytics.mu.Lock()
+			t.dirty = true
+			analytics.mu.Unlock()
+			return fmt.Errorf("failed to store the analytics of %s: %w", t.day, err)
+		}
+	}
+	analytics.mu.Lock()
+	if analytics.previous == tallies[0] {
+		analytics.previous = nil
+	}
+	analytics.mu.Unlock()
+
+	today := time.Now().UTC().Format(analyticsDayFormat)
+	if analytics.pruned == today {
+		return nil
+	}
+	days, err := analyticsRecords.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the analytics: %w", err)
+	}
+	oldest := time.Now().UTC().Add(-config.Analytics.Retention).Format(analyticsDayFormat)
+	for _, d := range days {
+		if d.ID != analyticsSaltID && d.Day < oldest {
+			if err := analyticsRecords.Delete(ctx, d.ID); err != nil && !errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("failed to delete the analytics of %s: %w", d.Day, err)
+			}
+		}
+	}
+	analytics.pruned = today
+	return nil
+}
+
+// runAnalytics flushes the analytics every config.Analytics.FlushInterval.
+// The last flush is left to the shutdown, once no more requests come in.
+func runAnalytics(ctx context.Context) {
+	ticker := time.NewTicker(config.Analytics.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flushCtx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+			if err := flushAnalytics(flushCtx); err != nil {
+				slog.Error("failed to flush analytics", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// sortedCounts lists counts, largest first and then by name, keeping the
+// first limit, or all of them for 0.
+func sortedCounts(counts map[string]uint64, limit int) []AnalyticsCount {
+	out := make([]AnalyticsCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, AnalyticsCount{Name: name, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// AnalyticsDaySummary is one day of an AnalyticsReport.
+type AnalyticsDaySummary struct {
+	Day      string `json:"day"`
+	Requests uint64 `json:"requests"`
+	// Clients counts the distinct clients of the day across replicas
+	Clients int `json:"clients"`
+}
+
+// AnalyticsReport is the /api/admin/analytics response body: the figures of
+// every replica over the last days, today included.
+type AnalyticsReport struct {
+	From     string                `json:"from"`
+	To       string                `json:"to"`
+	Requests uint64                `json:"requests"`
+	Days     []AnalyticsDaySummary `json:"days"`
+	// Routes and Datasets are busiest first, and SearchTerms the
+	// analyticsTopTerms most searched for
+	Routes      []AnalyticsCount `json:"routes"`
+	Datasets    []AnalyticsCount `json:"datasets"`
+	SearchTerms []AnalyticsCount `json:"searchTerms"`
+}
+
+// analyticsReport sums the stored days from..to, both included.
+func analyticsReport(stored []AnalyticsDay, from, to string) AnalyticsReport {
+	report := AnalyticsReport{From: from, To: to, Days: []AnalyticsDaySummary{}}
+	routes, sets, terms := map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	byDay := map[string]*AnalyticsDaySummary{}
+	clients := map[string]map[string]bool{}
+	for _, d := range stored {
+		if d.Day < from || d.Day > to {
+			continue
+		}
+		summary := byDay[d.Day]
+		if summary == nil {
+			summary = &AnalyticsDaySummary{Day: d.Day}
+			byDay[d.Day], clients[d.Day] = summary, map[string]bool{}
+		}
+		for _, c := range d.Requests {
+			routes[c.Name] += c.Count
+			summary.Requests += c.Count
+			report.Requests += c.Count
+		}
+		for _, c := range d.Datasets {
+			sets[c.Name] += c.Count
+		}
+		for _, c := range d.SearchTerms {
+			terms[c.Name] += c.Count
+		}
+		for _, client := range d.Clients {
+			clients[d.Day][client] = true
+		}
+	}
+	for day, summary := range byDay {
+		summary.Clients = len(clients[day])
+		report.Days = append(report.Days, *summary)
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day < report.Days[j].Day })
+	report.Routes, report.Datasets = sortedCounts(routes, 0), sortedCounts(sets, 0)
+	report.SearchTerms = sortedCounts(terms, analyticsTopTerms)
+	return report
+}
+
+// analyticsHandler reports the usage of the last ?days= days, flushing this
+// process's figures first so that they are current.
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if analyticsRecords == nil {
+		http.Error(w, "Analytics are turned off", http.StatusNotImplemented)
+		return
+	}
+	maxDays := int(config.Analytics.Retention / (24 * time.Hour))
+	days := defaultAnalyticsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDays {
+			http.Error(w, fmt.Sprintf("Query parameter days must be between 1 and %d", maxDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+	if err := flushAnalytics(ctx); err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	stored, err := analyticsRecords.List(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	from := now.AddDate(0, 0, 1-days).Format(analyticsDayFormat)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, analyticsReport(stored, from, now.Format(analyticsDayFormat)), "analytics")
+}
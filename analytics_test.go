@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestStoredAnalyticsSalt(t *testing.T) {
+	ctx := context.Background()
+	salts := newMemoryRecords[AnalyticsSalt]()
+
+	created, err := storedAnalyticsSalt(ctx, salts)
+	if err != nil {
+		t.Fatalf("creating the salt: %v", err)
+	}
+	if len(created) == 0 {
+		t.Fatal("created an empty salt")
+	}
+	// Another replica, or the next run, must hash with the same key
+	loaded, err := storedAnalyticsSalt(ctx, salts)
+	if err != nil {
+		t.Fatalf("loading the salt: %v", err)
+	}
+	if !bytes.Equal(loaded, created) {
+		t.Errorf("loaded salt %x, want the created %x", loaded, created)
+	}
+
+	if err := salts.Delete(ctx, analyticsSaltID); err != nil {
+		t.Fatal(err)
+	}
+	if err := salts.Insert(ctx, AnalyticsSalt{ID: analyticsSaltID, Salt: "not hex"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storedAnalyticsSalt(ctx, salts); err == nil {
+		t.Error("a salt that is not hex was accepted")
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// Annotation kinds.
+const (
+	annotationPin     = "pin"
+	annotationNote    = "note"
+	annotationPolygon = "polygon"
+)
+
+// Annotation visibilities.
+const (
+	// visibilityPrivate annotations are seen by their owner only
+	visibilityPrivate = "private"
+	// visibilityShared annotations are seen by every signed-in user
+	visibilityShared = "shared"
+	// visibilityPublic annotations are seen by everyone
+	visibilityPublic = "public"
+)
+
+// Limits on annotations.
+const (
+	maxAnnotationPoints = 500
+	maxAnnotationText   = 2000
+	// maxAnnotationsPerOwner keeps one user from flooding the collection
+	maxAnnotationsPerOwner = 1000
+)
+
+// Annotation is a user's own mark on the map, kept in the annotations
+// collection apart from the curated locations: a pin or a note at one
+// point, or a polygon through three or more. Admins see and may edit every
+// annotation, whatever its visibility.
+type Annotation struct {
+	ID         string        `json:"id" bson:"_id"`
+	Kind       string        `json:"kind" bson:"kind"`
+	Points     []Coordinates `json:"points" bson:"points"`
+	Title      string        `json:"title,omitempty" bson:"title,omitempty"`
+	Text       string        `json:"text,omitempty" bson:"text,omitempty"`
+	Visibility string        `json:"visibility" bson:"visibility"`
+	Owner      string        `json:"owner" bson:"owner"`
+	CreatedAt  time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (a Annotation) RecordID() string { return a.ID }
+
+// visibleTo reports whether p, the zero principal for anonymous callers,
+// may see the annotation.
+func (a *Annotation) visibleTo(p principal) bool {
+	switch {
+	case a.Visibility == visibilityPublic:
+		return true
+	case p.Name == "":
+		return false
+	case a.Visibility == visibilityShared:
+		return true
+	}
+	return a.Owner == p.Name || roleRank[p.Role] >= roleRank[roleAdmin]
+}
+
+// editableBy reports whether p may change or delete the annotation.
+func (a *Annotation) editableBy(p principal) bool {
+	return p.Name != "" && (a.Owner == p.Name || roleRank[p.Role] >= roleRank[roleAdmin])
+}
+
+// bounds returns the bounding box of the annotation's points.
+func (a *Annotation) bounds() Bounds {
+	b := Bounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, pt := range a.Points {
+		b.MinX, b.MaxX = math.Min(b.MinX, pt.X), math.Max(b.MaxX, pt.X)
+		b.MinY, b.MaxY = math.Min(b.MinY, pt.Y), math.Max(b.MaxY, pt.Y)
+	}
+	return b
+}
+
+// annotations stores the annotations. Like the submissions it is not cached,
+// as what a caller sees depends on who they are.
+var annotations RecordStore[Annotation]
+
+// openAnnotations connects the annotations to the storage backend. It must
+// run after initStorage.
+func openAnnotations() error {
+	s, err := recordStoreFor[Annotation]("annotations")
+	if err != nil {
+		return err
+	}
+	annotations = s
+	return nil
+}
+
+// annotationOp runs op against the annotations within the usual slot and
+// timeout. On failure the error response is written.
+func annotationOp(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) bool {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return false
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := op(ctx); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "Annotation not found", http.StatusNotFound)
+		case errors.Is(err, errTooManyAnnotations):
+			http.Error(w, fmt.Sprintf("You already have %d annotations; delete some first", maxAnnotationsPerOwner), http.StatusConflict)
+		default:
+			logFor(r.Context()).Error("failed to access annotations", "error", err)
+			http.Error(w, "Failed to access annotations", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+var errTooManyAnnotations = errors.New("too many annotations")
+
+// listAnnotationsHandler lists the annotations the caller may see, newest
+// first, optionally only those of ?owner=, of ?visibility= or overlapping
+// the ?minX=&minY=&maxX=&maxY= box.
+func listAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	owner, visibility := q.Get("owner"), q.Get("visibility")
+	switch visibility {
+	case "", visibilityPrivate, visibilityShared, visibilityPublic:
+	default:
+		http.Error(w, "Query parameter visibility must be private, shared or public", http.StatusBadRequest)
+		return
+	}
+	box, err := parseBoxQuery(q)
+	if err != nil {
+		http.Error(w, "Invalid bounding box: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+
+	var all []Annotation
+	if !annotationOp(w, r, func(ctx context.Context) (err error) {
+		all, err = annotations.List(ctx)
+		return err
+	}) {
+		return
+	}
+
+	list := []Annotation{}
+	for _, a := range all {
+		if !a.visibleTo(p) || (owner != "" && a.Owner != owner) || (visibility != "" && a.Visibility != visibility) {
+			continue
+		}
+		if box != nil {
+			if b := a.bounds(); b.MaxX < box.MinX || b.MinX > box.MaxX || b.MaxY < box.MinY || b.MinY > box.MaxY {
+				continue
+			}
+		}
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, list, "annotations")
+}
+
+func getAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	p, _ := requestPrincipal(r.Context())
+
+	var a Annotation
+	if !annotationOp(w, r, func(ctx context.Context) (err error) {
+		a, err = annotations.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	if !a.visibleTo(p) {
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, a, "annotation")
+}
+
+// annotationRequest is the body of POST /api/annotations and of PUT
+// /api/annotations/{id}. Visibility defaults to private.
+type annotationRequest struct {
+	Kind       string        `json:"kind"`
+	Points     []Coordinates `json:"points"`
+	Title      string        `json:"title"`
+	Text       string        `json:"text"`
+	Visibility string        `json:"visibility"`
+}
+
+// decodeAnnotation reads and validates an annotation body. On failure the
+// error response is written.
+func decodeAnnotation(w http.ResponseWriter, r *http.Request) (annotationRequest, bool) {
+	var req annotationRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return req, false
+	}
+	if req.Visibility == "" {
+		req.Visibility = visibilityPrivate
+	}
+	if errs := checkAnnotation(req); errs != nil {
+		writeValidationErrors(w, r, "Invalid annotation", errs)
+		return req, false
+	}
+	return req, true
+}
+
+// checkAnnotation validates an annotation body. Points only need to be
+// finite and within the configured bounds: annotations may mark places no
+// region covers yet.
+func checkAnnotation(req annotationRequest) []FieldError {
+	var errs validation.Errors
+
+	switch req.Kind {
+	case annotationPin, annotationNote:
+		if len(req.Points) != 1 {
+			errs.Add("points", "must have exactly one point for a %s, got %d", req.Kind, len(req.Points))
+		}
+	case annotationPolygon:
+		if len(req.Points) < 3 || len(req.Points) > maxAnnotationPoints {
+			errs.Add("points", "must have 3 to %d points for a polygon, got %d", maxAnnotationPoints, len(req.Points))
+		}
+	default:
+		errs.Add("kind", "must be pin, note or polygon, got %q", req.Kind)
+	}
+	for i, pt := range req.Points {
+		field := fmt.Sprintf("points[%d]", i)
+		finiteX, finiteY := errs.Finite(field+".x", pt.X), errs.Finite(field+".y", pt.Y)
+		if b := validationRules.Bounds; b != nil && finiteX && finiteY {
+			errs.Between(field+".x", pt.X, b.MinX, b.MaxX)
+			errs.Between(field+".y", pt.Y, b.MinY, b.MaxY)
+		}
+	}
+	errs.MaxLength("title", req.Title, validationRules.MaxNameLength)
+	errs.MaxLength("text", req.Text, maxAnnotationText)
+	if req.Kind == annotationNote {
+		errs.NonEmpty("text", req.Text)
+	}
+	switch req.Visibility {
+	case visibilityPrivate, visibilityShared, visibilityPublic:
+	default:
+		errs.Add("visibility", "must be private, shared or public, got %q", req.Visibility)
+	}
+
+	return errs
+}
+
+// createAnnotationHandler stores a new annotation owned by the caller.
+func createAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAnnotation(w, r)
+	if !ok {
+		return
+	}
+	id, err := randomToken(8)
+	if err != nil {
+		http.Error(w, "Failed to create annotation", http.StatusInternalServerError)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+	now := time.Now().UTC()
+	a := Annotation{
+		ID:         id,
+		Kind:       req.Kind,
+		Points:     req.Points,
+		Title:      req.Title,
+		Text:       req.Text,
+		Visibility: req.Visibility,
+		Owner:      p.Name,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if !annotationOp(w, r, func(ctx context.Context) error {
+		all, err := annotations.List(ctx)
+		if err != nil {
+			return err
+		}
+		owned := 0
+		for _, other := range all {
+			if other.Owner == a.Owner {
+				owned++
+			}
+		}
+		if owned >= maxAnnotationsPerOwner {
+			return errTooManyAnnotations
+		}
+		return annotations.Insert(ctx, a)
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPath(r.Context(), "/api/annotations/"+url.PathEscape(a.ID)))
+	w.WriteHeader(http.StatusCreated)
+
+	writeJSON(w, a, "annotation")
+}
+
+// updateAnnotationHandler replaces the content of an annotation, keeping its
+// owner and creation time. Only the owner and admins may.
+func updateAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	req, ok := decodeAnnotation(w, r)
+	if !ok {
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+
+	var a Annotation
+	if !annotationOp(w, r, func(ctx context.Context) (err error) {
+		a, err = annotations.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	if !a.editableBy(p) {
+		writeAnnotationDenied(w, a, p)
+		return
+	}
+	a.Kind, a.Points, a.Title, a.Text, a.Visibility = req.Kind, req.Points, req.Title, req.Text, req.Visibility
+	a.UpdatedAt = time.Now().UTC()
+
+	if !annotationOp(w, r, func(ctx context.Context) error {
+		_, err := annotations.Upsert(ctx, a)
+		return err
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	writeJSON(w, a, "annotation")
+}
+
+// deleteAnnotationHandler removes an annotation. Only the owner and admins
+// may.
+func deleteAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	p, _ := requestPrincipal(r.Context())
+
+	var a Annotation
+	if !annotationOp(w, r, func(ctx context.Context) (err error) {
+		a, err = annotations.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	if !a.editableBy(p) {
+		writeAnnotationDenied(w, a, p)
+		return
+	}
+	if !annotationOp(w, r, func(ctx context.Context) error { return annotations.Delete(ctx, id) }) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAnnotationDenied refuses a change to someone else's annotation,
+// without revealing the ones the caller cannot see.
+func writeAnnotationDenied(w http.ResponseWriter, a Annotation, p principal) {
+	if !a.visibleTo(p) {
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Only the owner of an annotation may change it", http.StatusForbidden)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeyPrefix starts every issued key, which makes leaked keys easy to grep
+// for and tells them apart from ADMIN_API_KEY.
+const apiKeyPrefix = "sfk_"
+
+// errInvalidAPIKey is returned for keys that are malformed, unknown or revoked.
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is a stored write key. Only the SHA-256 of the secret part is kept;
+// the ID is not secret and is what gets logged and revoked.
+type APIKey struct {
+	ID        string     `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name"`
+	Hash      string     `json:"-" bson:"hash"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// APIKeyStorage is implemented by backends that can persist API keys.
+type APIKeyStorage interface {
+	// InsertAPIKey stores a new key.
+	InsertAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKey returns the key with the given ID or ErrNotFound.
+	GetAPIKey(ctx context.Context, id string) (APIKey, error)
+	// ListAPIKeys returns every key, revoked ones included.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RevokeAPIKey marks the key revoked or returns ErrNotFound.
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// hashAPISecret returns the stored form of a key's secret part.
+func hashAPISecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes hex-encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issueAPIKey creates and stores a key named name. The returned plaintext key
+// is shown once and cannot be recovered later.
+func issueAPIKey(ctx context.Context, keys APIKeyStorage, name string) (APIKey, string, error) {
+	id, err := randomToken(6)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomToken(24)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key := APIKey{ID: id, Name: name, Hash: hashAPISecret(secret), CreatedAt: time.Now().UTC()}
+	if err := keys.InsertAPIKey(ctx, key); err != nil {
+		return APIKey{}, "", err
+	}
+	return key, apiKeyPrefix + id + "_" + secret, nil
+}
+
+// verifyAPIKey checks a plaintext key of the form sfk_<id>_<secret> and
+// returns the stored key it belongs to.
+func verifyAPIKey(ctx context.Context, keys APIKeyStorage, plaintext string) (APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(plaintext, apiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(plaintext, apiKeyPrefix) || id == "" || secret == "" {
+		return APIKey{}, errInvalidAPIKey
+	}
+
+	key, err := keys.GetAPIKey(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	if key.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hashAPISecret(secret)), []byte(key.Hash)) != 1 {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// runKeysCommand implements the "keys" subcommand:
+//
+//	soulforged-go keys issue <name>
+//	soulforged-go keys list
+//	soulforged-go keys revoke <id>
+func runKeysCommand(ctx context.Context, args []string, out io.Writer) error {
+	keys, ok := store.(APIKeyStorage)
+	if !ok {
+		return fmt.Errorf("the storage backend does not support API keys")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	usage := errors.New("usage: keys issue <name> | keys list | keys revoke <id>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "issue":
+		if len(args) != 2 || strings.TrimSpace(args[1]) == "" {
+			return usage
+		}
+		key, plaintext, err := issueAPIKey(ctx, keys, args[1])
+		if err != nil {
+			return fmt.Errorf("failed to issue key: %w", err)
+		}
+		fmt.Fprintf(out, "Issued key %s (%s). Store it now, it is not shown again:\n%s\n", key.ID, key.Name, plaintext)
+		return nil
+
+	case "list":
+		if len(args) != 1 {
+			return usage
+		}
+		list, err := keys.ListAPIKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCREATED\tREVOKED")
+		for _, key := range list {
+			revoked := "-"
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.ID, key.Name, key.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := keys.RevokeAPIKey(ctx, args[1], time.Now().UTC()); err != nil {
+			return fmt.Errorf("failed to revoke key %s: %w", args[1], err)
+		}
+		fmt.Fprintf(out, "Revoked key %s\n", args[1])
+		return nil
+
+	default:
+		return usage
+	}
+}
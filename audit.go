@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits on /api/admin/audit and on the in-memory audit log.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	maxMemoryAudit    = 10000
+)
+
+// Audit actions.
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+	// auditRestore and auditPurge take a location out of the trash, back
+	// onto the map or for good
+	auditRestore = "restore"
+	auditPurge   = "purge"
+	// auditRevert writes a location back as an earlier revision left it
+	auditRevert = "revert"
+)
+
+// AuditEntry records one write to the map or a dataset. Before and After are
+// the JSON documents on either side of the write; Before is absent for a
+// create and After for a delete.
+type AuditEntry struct {
+	ID     string    `json:"id" bson:"_id"`
+	At     time.Time `json:"at" bson:"at"`
+	Actor  string    `json:"actor" bson:"actor"`
+	Action string    `json:"action" bson:"action"`
+	// Collection is "maplocations" or the name of a dataset
+	Collection string          `json:"collection" bson:"collection"`
+	DocumentID string          `json:"documentId" bson:"documentId"`
+	Before     json.RawMessage `json:"before,omitempty" bson:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditQuery selects audit entries. Zero fields do not filter.
+type AuditQuery struct {
+	Collection string
+	DocumentID string
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// matches reports whether e falls within q, ignoring the limit.
+func (q AuditQuery) matches(e AuditEntry) bool {
+	return (q.Collection == "" || e.Collection == q.Collection) &&
+		(q.DocumentID == "" || e.DocumentID == q.DocumentID) &&
+		(q.Action == "" || e.Action == q.Action) &&
+		(q.Since.IsZero() || !e.At.Before(q.Since)) &&
+		(q.Until.IsZero() || e.At.Before(q.Until))
+}
+
+// AuditStorage is implemented by backends that keep an audit log.
+type AuditStorage interface {
+	InsertAudit(ctx context.Context, e AuditEntry) error
+	// ListAudit returns the entries matching q, newest first.
+	ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+}
+
+// mapCollection names the map locations in audit entries, whatever
+// MONGO_COLLECTION is set to.
+const mapCollection = "maplocations"
+
+// recordAudit logs a write made by the caller of the request carrying ctx.
+// before or after is nil when there was no document on that side. The write
+// has already happened by now, so a failure to record it is logged rather
+// than failing the request. The snapshots are encoded with plain json, at
+// full precision, since history diffs and reverts are read from them.
+func recordAudit(ctx context.Context, action, collection, id string, before, after any) {
+	audit, ok := store.(AuditStorage)
+	if !ok {
+		return
+	}
+	entryID, err := randomToken(12)
+	if err != nil {
+		logFor(ctx).Error("failed to record audit entry", "error", err)
+		return
+	}
+	actor := "anonymous"
+	if p, ok := requestPrincipal(ctx); ok {
+		actor = p.Name
+	}
+	e := AuditEntry{
+		ID:         entryID,
+		At:         time.Now().UTC(),
+		Actor:      actor,
+		Action:     action,
+		Collection: collection,
+		DocumentID: id,
+	}
+	if before != nil {
+		e.Before, _ = json.Marshal(exact(before))
+	}
+	if after != nil {
+		e.After, _ = json.Marshal(exact(after))
+	}
+
+	// The request context may be about to expire once the write returns
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), config.Mongo.QueryTimeout)
+	defer cancel()
+	if err := audit.InsertAudit(ctx, e); err != nil {
+		logFor(ctx).Error("failed to record audit entry", "action", action, "collection", collection, "id", id, "error", err)
+	}
+}
+
+// auditedLocation returns the stored location with the given ID as the
+// Before of an audit entry, or nil if there is none. It skips the read when
+// nothing is audited.
+func auditedLocation(ctx context.Context, id string) any {
+	if _, ok := store.(AuditStorage); !ok {
+		return nil
+	}
+	loc, err := storeFor(ctx).GetLocation(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// auditHandler lists the audit log, newest first, filtered by ?id= (the
+// document ID), ?collection=, ?action= and a ?since=&until= range of RFC 3339 times or
+// dates, up to ?limit=.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	audit, ok := store.(AuditStorage)
+	if !ok {
+		http.Error(w, "The storage backend does not keep an audit log", http.StatusNotImplemented)
+		return
+	}
+
+	v := r.URL.Query()
+	q := AuditQuery{Collection: v.Get("collection"), DocumentID: v.Get("id"), Action: v.Get("action"), Limit: defaultAuditLimit}
+	var err error
+	if q.Since, err = parseAuditTime(v.Get("since")); err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseAuditTime(v.Get("until")); err != nil {
+		http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			http.Error(w, "Query parameter limit must be between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	entries, err := audit.ListAudit(ctx, q)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, entries, "audit log")
+}
+
+// parseAuditTime accepts an RFC 3339 time or a date, which means midnight
+// UTC. An empty value is the zero time.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
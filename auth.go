@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles, from least to most privileged. Each role can do everything the ones
+// before it can.
+const (
+	roleViewer      = "viewer"
+	roleContributor = "contributor"
+	roleAdmin       = "admin"
+)
+
+var roleRank = map[string]int{roleViewer: 1, roleContributor: 2, roleAdmin: 3}
+
+// Limits on account credentials.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt ignores anything longer
+	maxAuthBody       = 4 << 10
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// errInvalidToken is returned for bearer tokens that fail verification.
+var errInvalidToken = errors.New("invalid or expired token")
+
+// User is a community account. Usernames are unique case-insensitively.
+type User struct {
+	ID           string    `json:"id" bson:"_id"`
+	Username     string    `json:"username" bson:"username"`
+	PasswordHash string    `json:"-" bson:"passwordHash"`
+	Role         string    `json:"role" bson:"role"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// UserStorage is implemented by backends that can persist user accounts.
+type UserStorage interface {
+	// InsertUser stores a new user or fails with ErrAlreadyExists.
+	InsertUser(ctx context.Context, user User) error
+	// GetUser returns the user with the given ID or ErrNotFound.
+	GetUser(ctx context.Context, id string) (User, error)
+	// SetUserRole changes a user's role or returns ErrNotFound.
+	SetUserRole(ctx context.Context, id, role string) error
+}
+
+// userID is the storage key of a username.
+func userID(username string) string {
+	return strings.ToLower(username)
+}
+
+// principal is whoever a request was authenticated as.
+type principal struct {
+	// Name is the username, or "key:<id>" for API keys
+	Name string
+	Role string
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns the authenticated caller of the request carrying
+// ctx, if any.
+func requestPrincipal(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// tokenClaims are the claims of the JWTs issued by /api/auth/login.
+type tokenClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// issueToken signs a token for user valid for config.Auth.TokenTTL.
+func issueToken(user User, now time.Time) (string, time.Time, error) {
+	expires := now.Add(config.Auth.TokenTTL)
+	claims := tokenClaims{
+		Role: user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			Issuer:    "soulforged-go",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.JWTSecret))
+	return signed, expires, err
+}
+
+// verifyToken checks a bearer token and returns its holder.
+func verifyToken(raw string) (principal, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) {
+		return []byte(config.Auth.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("soulforged-go"))
+	if err != nil || roleRank[claims.Role] == 0 || claims.Subject == "" {
+		return principal{}, errInvalidToken
+	}
+	return principal{Name: claims.Subject, Role: claims.Role}, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requireRole lets requests through to next when they are authenticated with
+// at least role: either a bearer token from /api/auth/login or an API key in
+// X-API-Key, which counts as admin. The caller is recorded in the request
+// context for attribution.
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p principal
+		if token, ok := bearerToken(r); ok {
+			if config.Auth.JWTSecret == "" {
+				http.Error(w, "User accounts are disabled", http.StatusForbidden)
+				return
+			}
+			var err error
+			if p, err = verifyToken(token); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			var ok bool
+			if p, ok = authenticateAPIKey(w, r); !ok {
+				return
+			}
+		}
+
+		if roleRank[p.Role] < roleRank[role] {
+			http.Error(w, fmt.Sprintf("This operation needs the %s role", role), http.StatusForbidden)
+			return
+		}
+
+		logFor(r.Context()).Info("authenticated", "principal", p.Name, "role", p.Role)
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// identify is requireRole for routes that also serve anonymous callers. A
+// request without credentials goes through unauthenticated; one with
+// credentials is authenticated as by requireRole, so that bad credentials
+// are still refused rather than silently ignored.
+func identify(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := requireRole(roleViewer, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); !ok && r.Header.Get("X-API-Key") == "" {
+			next(w, r)
+			return
+		}
+		authenticated(w, r)
+	}
+}
+
+// authCredentials is the body of /api/auth/register and /api/auth/login.
+type authCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// tokenResponse is returned by a successful login.
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	User      User      `json:"user"`
+}
+
+// authUsers returns the user store, answering the request itself when
+// accounts are unavailable.
+func authUsers(w http.ResponseWriter, r *http.Request) (UserStorage, bool) {
+	users, ok := store.(UserStorage)
+	if !ok || config.Auth.JWTSecret == "" {
+		http.Error(w, "User accounts are disabled", http.StatusNotImplemented)
+		return nil, false
+	}
+	return users, true
+}
+
+// decodeCredentials reads the request body, answering 400 when it is invalid.
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (authCredentials, bool) {
+	var creds authCredentials
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&creds); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return authCredentials{}, false
+	}
+	return creds, true
+}
+
+// registerHandler creates an account with the default role.
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	users, ok := authUsers(w, r)
+	if !ok {
+		return
+	}
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	var errs []FieldError
+	if !usernamePattern.MatchString(creds.Username) {
+		errs = append(errs, FieldError{Field: "username", Message: "must be 3 to 32 letters, digits, '.', '_' or '-'"})
+	}
+	if n := len(creds.Password); n < minPasswordLength || n > maxPasswordLength {
+		errs = append(errs, FieldError{Field: "password", Message: fmt.Sprintf("must be %d to %d bytes long", minPasswordLength, maxPasswordLength)})
+	}
+	if errs != nil {
+		writeValidationErrors(w, r, "Invalid account details", errs)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+	if err != nil {
+		logFor(r.Context()).Error("failed to hash password", "error", err)
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	user := User{
+		ID:           userID(creds.Username),
+		Username:     creds.Username,
+		PasswordHash: string(hash),
+		Role:         config.Auth.DefaultRole,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := users.InsertUser(ctx, user); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			http.Error(w, "Username is taken", http.StatusConflict)
+			return
+		}
+		logFor(r.Context()).Error("failed to create account", "error", err)
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	writeJSON(w, user, "user")
+}
+
+// loginHandler exchanges a username and password for a token.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	users, ok := authUsers(w, r)
+	if !ok {
+		return
+	}
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	user, err := users.GetUser(ctx, userID(creds.Username))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logFor(r.Context()).Error("failed to load user", "error", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	// Compare against a dummy hash for unknown users so response times don't
+	// reveal which usernames exist
+	hash := []byte(user.PasswordHash)
+	if err != nil {
+		hash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(creds.Password)) != nil || err != nil {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, expires, err := issueToken(user, time.Now())
+	if err != nil {
+		logFor(r.Context()).Error("failed to sign token", "error", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, tokenResponse{Token: token, ExpiresAt: expires, User: user}, "token")
+}
+
+// dummyPasswordHash is compared against when a login names an unknown user.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
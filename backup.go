@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// backupFormat versions the layout of Backup. Restore refuses other formats.
+const backupFormat = 1
+
+// maxRestoreErrors caps the validation errors a failed restore reports.
+const maxRestoreErrors = 20
+
+// backupName matches the names createBackup gives dumps, which sort by time.
+var backupName = regexp.MustCompile(`^soulforged-\d{8}T\d{6}Z\.json$`)
+
+// Backup is a dump of the curated data: every world's locations, every
+// dataset and the submissions. Credentials (users, API keys and webhook
+// secrets) are left out; they can be reissued, and a dump should not need
+// guarding like a password file. The audit log is left out as well.
+type Backup struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ServerVersion is the build that wrote the dump
+	ServerVersion string                     `json:"serverVersion"`
+	Worlds        map[string][]MapLocation   `json:"worlds"`
+	Datasets      map[string]json.RawMessage `json:"datasets"`
+	Submissions   []Submission               `json:"submissions"`
+}
+
+// exact returns b to encode with its coordinates at full precision.
+func (b *Backup) exact() any {
+	worlds := make(map[string][]any, len(b.Worlds))
+	for name, locs := range b.Worlds {
+		worlds[name] = exactAll(locs)
+	}
+	return struct {
+		*Backup
+		Worlds      map[string][]any `json:"worlds"`
+		Submissions []any            `json:"submissions"`
+	}{b, worlds, exactAll(b.Submissions)}
+}
+
+// BackupInfo describes a stored dump.
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RestoreCount is what a restore did to one collection.
+type RestoreCount struct {
+	Written int `json:"written"`
+	// Deleted counts the documents missing from the dump that a replacing
+	// restore removed
+	Deleted int `json:"deleted"`
+}
+
+// restoreFunc writes validated records, deleting the others with replace.
+type restoreFunc func(ctx context.Context, replace bool) (RestoreCount, error)
+
+// backupTarget is where dumps are kept: a directory or an S3 bucket.
+type backupTarget interface {
+	put(ctx context.Context, name string, data []byte) error
+	get(ctx context.Context, name string) ([]byte, error)
+	// list returns the stored dumps, oldest first
+	list(ctx context.Context) ([]BackupInfo, error)
+	remove(ctx context.Context, name string) error
+	String() string
+}
+
+// newBackupTarget returns the configured target, or nil when backups are
+// not configured.
+func newBackupTarget(ctx context.Context) (backupTarget, error) {
+	switch {
+	case config.Backup.Dir != "":
+		return dirTarget(config.Backup.Dir), nil
+	case config.Backup.S3Bucket != "":
+		// Region and credentials come from the standard AWS_* variables,
+		// shared config files or the instance role
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		return &s3Target{client: s3.NewFromConfig(cfg), bucket: config.Backup.S3Bucket, prefix: config.Backup.S3Prefix}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// dirTarget keeps dumps as files in a directory.
+type dirTarget string
+
+func (d dirTarget) String() string { return string(d) }
+
+// put writes the dump to a temporary file first so that a crash never
+// leaves a truncated dump under a valid name.
+func (d dirTarget) put(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), name))
+}
+
+func (d dirTarget) get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d dirTarget) list(ctx context.Context) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(string(d))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []BackupInfo{}
+	for _, e := range entries {
+		if !backupName.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, BackupInfo{Name: e.Name(), Size: info.Size(), CreatedAt: info.ModTime().UTC()})
+	}
+	return list, nil
+}
+
+func (d dirTarget) remove(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// s3Target keeps dumps as objects under a prefix of an S3 bucket.
+type s3Target struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (t *s3Target) String() string { return "s3://" + t.bucket + "/" + t.prefix }
+
+func (t *s3Target) key(name string) string { return path.Join(t.prefix, name) }
+
+func (t *s3Target) put(ctx context.Context, name string, data []byte) error {
+	_, err := t.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(t.bucket),
+		Key:         aws.String(t.key(name)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (t *s3Target) get(ctx context.Context, name string) ([]byte, error) {
+	out, err := t.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(t.bucket), Key: aws.String(t.key(name))})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (t *s3Target) list(ctx context.Context) ([]BackupInfo, error) {
+	prefix := t.prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	list := []BackupInfo{}
+	pages := s3.NewListObjectsV2Paginator(t.client, &s3.ListObjectsV2Input{Bucket: aws.String(t.bucket), Prefix: aws.String(prefix)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			name := path.Base(aws.ToString(obj.Key))
+			if !backupName.MatchString(name) {
+				continue
+			}
+			list = append(list, BackupInfo{Name: name, Size: aws.ToInt64(obj.Size), CreatedAt: aws.ToTime(obj.LastModified).UTC()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (t *s3Target) remove(ctx context.Context, name string) error {
+	_, err := t.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(t.bucket), Key: aws.String(t.key(name))})
+	return err
+}
+
+// dumpAll reads every collection a Backup holds from storage.
+func dumpAll(ctx context.Context) (*Backup, error) {
+	b := &Backup{
+		Format:        backupFormat,
+		CreatedAt:     time.Now().UTC(),
+		ServerVersion: version,
+		Worlds:        make(map[string][]MapLocation, len(worlds)),
+		Datasets:      make(map[string]json.RawMessage, len(datasets)),
+	}
+	for _, wd := range worlds {
+		locs, err := wd.storage().ListLocations(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("world %s: %w", wd.name, err)
+		}
+		b.Worlds[wd.name] = locs
+	}
+	for _, d := range datasets {
+		recs, err := d.dump(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", d.collection(), err)
+		}
+		raw, err := json.Marshal(recs)
+		if err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", d.collection(), err)
+		}
+		b.Datasets[d.collection()] = raw
+	}
+	var err error
+	if b.Submissions, err = submissions.List(ctx); err != nil {
+		return nil, fmt.Errorf("submissions: %w", err)
+	}
+	return b, nil
+}
+
+// createBackup dumps the data to target and prunes the dumps beyond
+// config.Backup.Keep. A failed prune is logged; the new dump is there. The
+// dump keeps coordinates at full precision whatever COORDINATE_PRECISION is,
+// and so do the documents a restore writes back.
+func createBackup(ctx context.Context, target backupTarget) (BackupInfo, error) {
+	b, err := dumpAll(ctx)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	data, err := json.MarshalIndent(b.exact(), "", "  ")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	info := BackupInfo{
+		Name:      "soulforged-" + b.CreatedAt.Format("20060102T150405Z") + ".json",
+		Size:      int64(len(data)),
+		CreatedAt: b.CreatedAt,
+	}
+	if err := target.put(ctx, info.Name, data); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to store %s: %w", info.Name, err)
+	}
+	slog.Info("backup created", "name", info.Name, "target", target.String(), "bytes", info.Size)
+
+	if keep := config.Backup.Keep; keep > 0 {
+		list, err := target.list(ctx)
+		if err != nil {
+			slog.Warn("failed to list backups for pruning", "error", err)
+			return info, nil
+		}
+		for _, old := range list[:max(0, len(list)-keep)] {
+			if err := target.remove(ctx, old.Name); err != nil {
+				slog.Warn("failed to prune backup", "name", old.Name, "error", err)
+			}
+		}
+	}
+	return info, nil
+}
+
+// runScheduledBackups creates a backup every config.Backup.Interval until
+// ctx is cancelled. Every replica with an interval runs them, so in a cluster
+// set it on one of them only.
+func runScheduledBackups(ctx context.Context, target backupTarget) {
+	ticker := time.NewTicker(config.Backup.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := createBackup(ctx, target); err != nil {
+				slog.Error("scheduled backup failed", "error", err)
+			}
+		}
+	}
+}
+
+// prepareRestore validates a dump in full and returns the writes restoring
+// it, so that a dump with any invalid document writes nothing. Worlds and
+// datasets the dump has but the server does not are errors; those the server
+// has but the dump lacks are left alone.
+func prepareRestore(b *Backup) (map[string]restoreFunc, []string) {
+	steps := make(map[string]restoreFunc)
+	var errs []string
+	if b.Format != backupFormat {
+		return nil, []string{fmt.Sprintf("unsupported backup format %d, want %d", b.Format, backupFormat)}
+	}
+
+	for name, locs := range b.Worlds {
+		wd, ok := worldsByName[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("world %s is not configured", name))
+			continue
+		}
+		seen := make(map[string]bool, len(locs))
+		for _, loc := range locs {
+			if seen[loc.ID] {
+				errs = append(errs, fmt.Sprintf("world %s: location %s appears twice", name, loc.ID))
+			}
+			seen[loc.ID] = true
+			for _, e := range validateLocation(loc) {
+				errs = append(errs, fmt.Sprintf("world %s: location %s: %s: %s", name, loc.ID, e.Field, e.Message))
+			}
+		}
+		steps[wd.collection()] = func(ctx context.Context, replace bool) (RestoreCount, error) {
+			return restoreLocations(context.WithValue(ctx, worldKey{}, wd), locs, replace)
+		}
+	}
+
+	byName := make(map[string]datasetRefresher, len(datasets))
+	for _, d := range datasets {
+		byName[d.collection()] = d
+	}
+	for name, raw := range b.Datasets {
+		d, ok := byName[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("dataset %s does not exist", name))
+			continue
+		}
+		step, dErrs := d.prepareRestore(raw)
+		for _, e := range dErrs {
+			errs = append(errs, fmt.Sprintf("dataset %s: %s", name, e))
+		}
+		steps[name] = step
+	}
+
+	for _, s := range b.Submissions {
+		if strings.TrimSpace(s.ID) == "" {
+			errs = append(errs, "submissions: a submission has no ID")
+		}
+	}
+	if b.Submissions != nil {
+		steps["submissions"] = func(ctx context.Context, replace bool) (RestoreCount, error) {
+			return restoreRecords(ctx, submissions, b.Submissions, replace)
+		}
+	}
+	return steps, errs
+}
+
+// restore writes a validated dump. Each collection is written in turn; a
+// failure stops the restore with the collections before it restored.
+func restore(ctx context.Context, b *Backup, replace bool) (map[string]RestoreCount, error) {
+	steps, errs := prepareRestore(b)
+	if errs != nil {
+		sort.Strings(errs)
+		if len(errs) > maxRestoreErrors {
+			errs = append(errs[:maxRestoreErrors], fmt.Sprintf("and %d more", len(errs)-maxRestoreErrors))
+		}
+		return nil, fmt.Errorf("the backup is invalid, nothing was restored:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	names := make([]string, 0, len(steps))
+	for name := range steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := make(map[string]RestoreCount, len(steps))
+	for _, name := range names {
+		n, err := steps[name](ctx, replace)
+		report[name] = n
+		if err != nil {
+			return report, fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return report, nil
+}
+
+// restoreLocations upserts locs into the world of ctx and, with replace,
+// deletes the stored locations missing from locs.
+func restoreLocations(ctx context.Context, locs []MapLocation, replace bool) (RestoreCount, error) {
+	var n RestoreCount
+	if len(locs) > 0 {
+		_, errs, err := upsertLocations(ctx, locs)
+		if err != nil {
+			return n, err
+		}
+		for i, err := range errs {
+			if err != nil {
+				return n, fmt.Errorf("location %s: %w", locs[i].ID, err)
+			}
+			n.Written++
+		}
+	}
+	if !replace {
+		return n, nil
+	}
+	keep := make(map[string]bool, len(locs))
+	for _, loc := range locs {
+		keep[loc.ID] = true
+	}
+	stored, err := storeFor(ctx).ListLocations(ctx)
+	if err != nil {
+		return n, err
+	}
+	for _, loc := range stored {
+		if keep[loc.ID] {
+			continue
+		}
+		if err := storeFor(ctx).DeleteLocation(ctx, loc.ID, anyVersion); err != nil && !errors.Is(err, ErrNotFound) {
+			return n, fmt.Errorf("location %s: %w", loc.ID, err)
+		}
+		n.Deleted++
+	}
+	return n, nil
+}
+
+// restoreRecords is restoreLocations for a record store.
+func restoreRecords[T Record](ctx context.Context, s RecordStore[T], recs []T, replace bool) (RestoreCount, error) {
+	var n RestoreCount
+	keep := make(map[string]bool, len(recs))
+	for _, rec := range recs {
+		if _, err := s.Upsert(ctx, rec); err != nil {
+			return n, fmt.Errorf("record %s: %w", rec.RecordID(), err)
+		}
+		keep[rec.RecordID()] = true
+		n.Written++
+	}
+	if !replace {
+		return n, nil
+	}
+	stored, err := s.List(ctx)
+	if err != nil {
+		return n, err
+	}
+	for _, rec := range stored {
+		if keep[rec.RecordID()] {
+			continue
+		}
+		if err := s.Delete(ctx, rec.RecordID()); err != nil && !errors.Is(err, ErrNotFound) {
+			return n, fmt.Errorf("record %s: %w", rec.RecordID(), err)
+		}
+		n.Deleted++
+	}
+	return n, nil
+}
+
+func (d *dataset[T]) dump(ctx context.Context) (any, error) {
+	recs, err := d.store.List(ctx)
+	return exactAll(recs), err
+}
+
+func (d *dataset[T]) prepareRestore(raw json.RawMessage) (restoreFunc, []string) {
+	var recs []T
+	if err := json.Unmarshal(raw, &recs); err != nil {
+		return nil, []string{"invalid records: " + err.Error()}
+	}
+	var errs []string
+	seen := make(map[string]bool, len(recs))
+	for i := range recs {
+		id := recs[i].RecordID()
+		if seen[id] {
+			errs = append(errs, fmt.Sprintf("%s %s appears twice", d.noun, id))
+		}
+		seen[id] = true
+		for _, e := range d.validate(&recs[i]) {
+			errs = append(errs, fmt.Sprintf("%s %s: %s: %s", d.noun, id, e.Field, e.Message))
+		}
+	}
+	return func(ctx context.Context, replace bool) (RestoreCount, error) {
+		n, err := restoreRecords(ctx, d.store, recs, replace)
+		d.invalidate()
+		return n, err
+	}, errs
+}
+
+// backupsConfigured answers 501 when there is nowhere to keep backups.
+func backupsConfigured(w http.ResponseWriter) bool {
+	if backups == nil {
+		http.Error(w, "Backups are not configured; set BACKUP_DIR or BACKUP_S3_BUCKET", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// listBackupsHandler lists the stored dumps.
+func listBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !backupsConfigured(w) {
+		return
+	}
+	list, err := backups.list(r.Context())
+	if err != nil {
+		logFor(r.Context()).Error("failed to list backups", "error", err)
+		writeProblem(w, r, http.StatusInternalServerError, "Failed to list backups")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, list, "backups")
+}
+
+// createBackupHandler dumps the data now.
+func createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !backupsConfigured(w) {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	// The dump reads every collection, so no query timeout here
+	info, err := createBackup(r.Context(), backups)
+	release()
+	if err != nil {
+		logFor(r.Context()).Error("backup failed", "error", err)
+		writeProblem(w, r, http.StatusInternalServerError, "Backup failed")
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+	logFor(r.Context()).Info("backup created on request", "name", info.Name, "principal", p.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, info, "backup")
+}
+
+// backups is the configured backup target, nil when backups are off.
+var backups backupTarget
+
+// initBackups sets up the backup target. It must run after config is
+// loaded.
+func initBackups(ctx context.Context) error {
+	t, err := newBackupTarget(ctx)
+	if err != nil {
+		return err
+	}
+	backups = t
+	return nil
+}
+
+// openData connects the datasets, submissions and worlds for the backup
+// and restore commands, as main does for the server.
+func openData() error {
+	return errors.Join(openDatasets(), openSubmissions(), openWorlds())
+}
+
+// runBackupCommand implements the "backup" subcommand:
+//
+//	soulforged-go backup
+//	soulforged-go backup list
+func runBackupCommand(ctx context.Context, args []string, out io.Writer) error {
+	if err := openData(); err != nil {
+		return err
+	}
+	if err := initBackups(ctx); err != nil {
+		return err
+	}
+	if backups == nil {
+		return errors.New("backups are not configured; set BACKUP_DIR or BACKUP_S3_BUCKET")
+	}
+
+	switch {
+	case len(args) == 0:
+		info, err := createBackup(ctx, backups)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Backed up to %s (%d bytes) in %s\n", info.Name, info.Size, backups)
+		return nil
+	case len(args) == 1 && args[0] == "list":
+		list, err := backups.list(ctx)
+		if err != nil {
+			return err
+		}
+		for _, b := range list {
+			fmt.Fprintf(out, "%s\t%d\n", b.Name, b.Size)
+		}
+		return nil
+	default:
+		return errors.New("usage: backup | backup list")
+	}
+}
+
+// runRestoreCommand implements the "restore" subcommand:
+//
+//	soulforged-go restore [--replace] <name>
+//	soulforged-go restore [--replace] --file <dump.json>
+//
+// It restores a dump from the backup target, or a local file, after
+// validating every document in it. Without --replace documents missing from
+// the dump are kept. Running servers pick the restored data up on their next
+// cache refresh.
+func runRestoreCommand(ctx context.Context, args []string, out io.Writer) error {
+	flags := commandFlags("restore")
+	replace := flags.Bool("replace", false, "delete documents missing from the dump")
+	file := flags.String("file", "", "restore a local dump file instead of a stored backup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file != "" && flags.NArg() != 0 || *file == "" && flags.NArg() != 1 {
+		return errors.New("usage: restore [--replace] <name> | restore [--replace] --file <dump.json>")
+	}
+	if err := openData(); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	if *file != "" {
+		data, err = os.ReadFile(*file)
+	} else {
+		if err := initBackups(ctx); err != nil {
+			return err
+		}
+		if backups == nil {
+			return errors.New("backups are not configured; set BACKUP_DIR or BACKUP_S3_BUCKET, or use --file")
+		}
+		if !backupName.MatchString(flags.Arg(0)) {
+			return fmt.Errorf("%q is not a backup name; see \"backup list\"", flags.Arg(0))
+		}
+		data, err = backups.get(ctx, flags.Arg(0))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the backup: %w", err)
+	}
+
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("the backup is not valid JSON: %w", err)
+	}
+	report, err := restore(ctx, &b, *replace)
+	names := make([]string, 0, len(report))
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s: %d written, %d deleted\n", name, report[name].Written, report[name].Deleted)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Restored the backup of %s\n", b.CreatedAt.Format(time.RFC3339))
+	return nil
+}
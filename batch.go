@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchIDs caps the IDs of one POST /api/map/batch.
+const maxBatchIDs = 200
+
+// batchRequest is the POST /api/map/batch body.
+type batchRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchLookup is the POST /api/map/batch response.
+type BatchLookup struct {
+	// Locations holds the location of each requested ID, in the order
+	// asked, null where there is none
+	Locations []*MapLocation `json:"locations"`
+	// Missing lists the IDs without a location, in the order asked
+	Missing []string `json:"missing"`
+}
+
+// batchHandler looks up the locations of the {"ids": [...]} in the body in
+// the cached snapshot, as GET /api/map/{id} would one at a time, ?space=, the
+// schema and redaction included.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	var body batchRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.IDs) == 0 || len(body.IDs) > maxBatchIDs {
+		http.Error(w, fmt.Sprintf("Between 1 and %d location IDs are required", maxBatchIDs), http.StatusBadRequest)
+		return
+	}
+	space, err := spaceQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	schema, err := requestSchema(r)
+	if err != nil {
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snap, err := visibleSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	out := BatchLookup{Locations: make([]*MapLocation, len(body.IDs)), Missing: []string{}}
+	for i, id := range body.IDs {
+		loc, found := snap.lookup(id)
+		if !found {
+			out.Missing = append(out.Missing, id)
+			continue
+		}
+		if space != nil {
+			loc.XY.X, loc.XY.Y = space.apply(loc.XY.X, loc.XY.Y)
+		}
+		out.Locations[i] = &loc
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if schema == schemaFlat {
+		flat := make([]*FlatLocation, len(out.Locations))
+		for i, loc := range out.Locations {
+			if loc != nil {
+				f := flatten(*loc)
+				flat[i] = &f
+			}
+		}
+		w.Header().Set("Content-Type", flatContentType)
+		writeJSON(w, struct {
+			Locations []*FlatLocation `json:"locations"`
+			Missing   []string        `json:"missing"`
+		}{flat, out.Missing}, "map locations")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, out, "map locations")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// version, commit and buildTime identify this build. They are set at link
+// time with
+//
+//	-ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// Without them commit and buildTime are taken from the VCS information the Go
+// toolchain stamps into builds made in a checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// serverVersionHeader carries the version on every response.
+const serverVersionHeader = "X-Server-Version"
+
+// apiVersions lists the API versions served, oldest first, by the name of
+// their prefix under /api.
+var apiVersions = []string{"v1"}
+
+// BuildInfo is the /api/version response body, for tools to tell which
+// deployment, and so which API features, they are talking to.
+type BuildInfo struct {
+	Version string `json:"version"`
+	// Commit is the git commit built, suffixed with -dirty when the
+	// checkout had local changes; empty when unknown
+	Commit string `json:"commit,omitempty"`
+	// BuildTime is when the binary was built, in RFC 3339, or for VCS
+	// stamped builds when the commit was made
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// APIVersions are the versions served, each at /api/{version}; the
+	// unversioned /api routes are the deprecated alias of the first
+	APIVersions []string `json:"apiVersions"`
+}
+
+// currentBuild returns the build's information, read once.
+var currentBuild = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version(), APIVersions: apiVersions}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	var revision, modified, committed string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		case "vcs.time":
+			committed = s.Value
+		}
+	}
+	if info.Commit == "" && revision != "" {
+		info.Commit = revision
+		if modified == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = committed
+	}
+	return info
+})
+
+// advertiseVersion sets X-Server-Version on the response.
+func advertiseVersion(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(serverVersionHeader, version)
+		handler(w, r)
+	}
+}
+
+// versionHandler serves /api/version.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	writeJSON(w, currentBuild(), "build info")
+}
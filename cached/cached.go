@@ -0,0 +1,199 @@
+// Package cached holds values that are expensive to load, such as snapshots
+// of a database collection, and reloads them with at most one load in flight
+// per value.
+package cached
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Value is a lazily loaded value. Reads are served from memory; a read finding
+// nothing loaded or an invalidated value waits for a load, which every
+// concurrent read shares, and a read finding a value older than its TTL
+// serves it while it is revalidated in the background. A read whose load
+// fails or outlasts Wait is served the last value loaded, if there is one.
+//
+// Loads never overlap, so a load may read the value it replaces with Peek
+// and derive the next one from it. A load runs on a context detached from
+// the cancellation of the read that started it: a reader giving up does not
+// abort the load the others wait for. The zero Value is ready to use.
+type Value[T any] struct {
+	// TTL is how long a loaded value is served before a read revalidates
+	// it. Zero means it is only renewed by Invalidate and Reload.
+	TTL time.Duration
+	// Valid reports whether a loaded value may be served. Values it rejects
+	// are reloaded on every read. Nil accepts every value.
+	Valid func(T) bool
+	// Wait bounds how long a read waits for a load. A read running out of
+	// it gets ErrLoadTimeout, or the last value loaded; the load carries on
+	// for the reads after it. Zero waits as long as the read's context
+	// allows.
+	Wait time.Duration
+	// Fallback, if set, is told why a read was served the last value loaded
+	// instead of a fresh one: the load's error or ErrLoadTimeout.
+	Fallback func(err error)
+
+	current atomic.Pointer[entry[T]]
+	// epoch counts the invalidations; a value loaded under an older epoch
+	// is stale
+	epoch atomic.Uint64
+
+	mu sync.Mutex
+	// flight is the latest load started, possibly finished
+	flight *flight[T]
+}
+
+type entry[T any] struct {
+	value T
+	// checked is when the load that produced value finished
+	checked time.Time
+	epoch   uint64
+}
+
+// flight is one load and the reads waiting for it.
+type flight[T any] struct {
+	epoch uint64
+	// after is the load this one must wait for
+	after *flight[T]
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// ErrLoadTimeout is returned by reads that waited Wait for a load with no
+// earlier value to fall back on.
+var ErrLoadTimeout = errors.New("cached: load did not finish in time")
+
+// Get returns the value, loading it with load when nothing has been loaded
+// yet, the value was invalidated or Valid rejects it. It joins a load already
+// in flight rather than starting another. When the load fails or outlasts
+// Wait, an invalidated value that Valid accepts is served instead.
+func (v *Value[T]) Get(ctx context.Context, load func(context.Context) (T, error)) (T, error) {
+	e := v.current.Load()
+	if e != nil && v.usable(e) {
+		if v.TTL > 0 && time.Since(e.checked) >= v.TTL {
+			v.start(ctx, load, false)
+		}
+		return e.value, nil
+	}
+	value, err := v.start(ctx, load, false).waitFor(ctx, v.Wait)
+	if err == nil || ctx.Err() != nil {
+		return value, err
+	}
+	if e := v.current.Load(); e != nil && (v.Valid == nil || v.Valid(e.value)) {
+		if v.Fallback != nil {
+			v.Fallback(err)
+		}
+		return e.value, nil
+	}
+	return value, err
+}
+
+// Reload loads the value again and waits for it. Unlike Get it never joins a
+// load already in flight, which may have read the source before the change
+// prompting the reload.
+func (v *Value[T]) Reload(ctx context.Context, load func(context.Context) (T, error)) (T, error) {
+	return v.start(ctx, load, true).waitFor(ctx, 0)
+}
+
+// Invalidate marks the value stale: the next read waits for a fresh load
+// instead of serving it. A load in flight when Invalidate is called does not
+// count as fresh.
+func (v *Value[T]) Invalidate() {
+	v.epoch.Add(1)
+}
+
+// Peek returns the last loaded value, whether stale or not, or the zero value
+// when nothing has been loaded.
+func (v *Value[T]) Peek() T {
+	if e := v.current.Load(); e != nil {
+		return e.value
+	}
+	var zero T
+	return zero
+}
+
+// Fresh reports whether a read would be served without waiting for a load.
+func (v *Value[T]) Fresh() bool {
+	e := v.current.Load()
+	return e != nil && v.usable(e)
+}
+
+// Stale reports whether the last loaded value was invalidated since.
+func (v *Value[T]) Stale() bool {
+	e := v.current.Load()
+	return e != nil && e.epoch != v.epoch.Load()
+}
+
+// Checked returns when the last loaded value was loaded or revalidated.
+func (v *Value[T]) Checked() time.Time {
+	if e := v.current.Load(); e != nil {
+		return e.checked
+	}
+	return time.Time{}
+}
+
+func (v *Value[T]) usable(e *entry[T]) bool {
+	return e.epoch == v.epoch.Load() && (v.Valid == nil || v.Valid(e.value))
+}
+
+// start returns the load the caller should wait for: the one in flight if it
+// started after the last invalidation and force is false, or a new one queued
+// behind it.
+func (v *Value[T]) start(ctx context.Context, load func(context.Context) (T, error), force bool) *flight[T] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	epoch := v.epoch.Load()
+	if f := v.flight; f != nil && !force && f.epoch == epoch && !f.finished() {
+		return f
+	}
+	f := &flight[T]{epoch: epoch, after: v.flight, done: make(chan struct{})}
+	v.flight = f
+	go v.run(context.WithoutCancel(ctx), f, load)
+	return f
+}
+
+func (v *Value[T]) run(ctx context.Context, f *flight[T], load func(context.Context) (T, error)) {
+	if f.after != nil {
+		<-f.after.done
+		f.after = nil
+	}
+	f.value, f.err = load(ctx)
+	if f.err == nil {
+		v.current.Store(&entry[T]{value: f.value, checked: time.Now(), epoch: f.epoch})
+	}
+	close(f.done)
+}
+
+func (f *flight[T]) finished() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitFor waits for the load, at most timeout unless that is zero.
+func (f *flight[T]) waitFor(ctx context.Context, timeout time.Duration) (T, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	var zero T
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case <-expired:
+		return zero, ErrLoadTimeout
+	}
+}
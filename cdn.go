@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CDN providers, as config.CDN.Provider names them.
+const (
+	cdnCloudflare = "cloudflare"
+	cdnFastly     = "fastly"
+)
+
+// cdnPurgeTimeout bounds one purge request.
+const cdnPurgeTimeout = 10 * time.Second
+
+// cdnPurged holds the cache versions the CDN was last purged for, or found
+// with on the first refresh.
+var cdnPurged struct {
+	mu       sync.Mutex
+	versions map[string]string
+}
+
+// purgeCDNOnChange purges the configured CDN after a refresh if any cache
+// changed since the last purge. Comparing with the versions of the last
+// purge rather than of the previous refresh also catches writes through the
+// API, whose caches are reloaded by the next read instead. A failed purge is
+// logged and tried again after the next refresh; until then the CDN serves
+// what it has for at most config.CDN.SharedMaxAge.
+func purgeCDNOnChange(ctx context.Context) {
+	if config.CDN.Provider == "" || following() {
+		return
+	}
+
+	cdnPurged.mu.Lock()
+	defer cdnPurged.mu.Unlock()
+	versions := cacheVersions()
+	if cdnPurged.versions == nil {
+		cdnPurged.versions = versions
+		return
+	}
+	var changed []string
+	for name, version := range versions {
+		if cdnPurged.versions[name] != version {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	sort.Strings(changed)
+
+	if err := purgeCDN(ctx); err != nil {
+		cdnPurges.WithLabelValues("failed").Inc()
+		slog.Warn("failed to purge the CDN", "provider", config.CDN.Provider, "caches", changed, "error", err)
+		return
+	}
+	cdnPurges.WithLabelValues("purged").Inc()
+	slog.Info("purged the CDN", "provider", config.CDN.Provider, "caches", changed)
+	cdnPurged.versions = versions
+}
+
+// purgeCDN asks the provider to drop everything it cached. The API's
+// responses are derived from one another, such as the map from the
+// locations and their translations, so purging by URL would miss some.
+func purgeCDN(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, cdnPurgeTimeout)
+	defer cancel()
+
+	var endpoint string
+	var body []byte
+	header := http.Header{}
+	switch config.CDN.Provider {
+	case cdnCloudflare:
+		endpoint = "https://api.cloudflare.com/client/v4/zones/" + url.PathEscape(config.CDN.Zone) + "/purge_cache"
+		body = []byte(`{"purge_everything":true}`)
+		header.Set("Authorization", "Bearer "+config.CDN.Token)
+		header.Set("Content-Type", "application/json")
+	case cdnFastly:
+		endpoint = "https://api.fastly.com/service/" + url.PathEscape(config.CDN.Zone) + "/purge_all"
+		header.Set("Fastly-Key", config.CDN.Token)
+		header.Set("Accept", "application/json")
+	}
+	if config.CDN.PurgeURL != "" {
+		endpoint = config.CDN.PurgeURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("User-Agent", "soulforged-go/"+version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", config.CDN.Provider, resp.Status)
+	}
+	return nil
+}
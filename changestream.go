@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Delays between attempts to reopen a failed change stream.
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// changeDocument is the subset of a change stream event we care about.
+type changeDocument struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      *MapLocation `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Watch follows the maplocations change stream and delivers every insert,
+// update, replace and delete until ctx is cancelled. A broken stream is
+// reopened with backoff, resuming after the last event seen. Change streams
+// need a replica set or sharded cluster.
+func (s *mongoStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		s.watch(ctx, events)
+	}()
+	return events, nil
+}
+
+func (s *mongoStorage) watch(ctx context.Context, events chan<- ChangeEvent) {
+	var resumeToken bson.Raw
+	backoff := minWatchBackoff
+
+	for ctx.Err() == nil {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := s.coll.Watch(ctx, mongo.Pipeline{}, opts)
+		if err != nil {
+			slog.Error("failed to open change stream", "error", err)
+		} else {
+			backoff = minWatchBackoff
+			for stream.Next(ctx) {
+				var change changeDocument
+				if err := stream.Decode(&change); err != nil {
+					slog.Error("failed to decode change event", "error", err)
+					continue
+				}
+				resumeToken = stream.ResumeToken()
+
+				ev, ok := toChangeEvent(change)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+				}
+			}
+			if err := stream.Err(); err != nil && ctx.Err() == nil {
+				slog.Warn("change stream interrupted", "error", err)
+			}
+			stream.Close(context.Background())
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxWatchBackoff)
+	}
+}
+
+// toChangeEvent converts a change stream document into a ChangeEvent. ok is
+// false for operation types other than insert, update, replace and delete.
+// Moving a location to the trash is reported as its delete and restoring it
+// as its insert.
+func toChangeEvent(change changeDocument) (ev ChangeEvent, ok bool) {
+	ev = ChangeEvent{ID: change.DocumentKey.ID}
+	switch change.OperationType {
+	case "update":
+		ev.Type, ev.Location = "update", change.FullDocument
+		if _, err := change.UpdateDescription.UpdatedFields.LookupErr("deletedAt"); err == nil {
+			ev.Type, ev.Location = "delete", nil
+		} else if slices.Contains(change.UpdateDescription.RemovedFields, "deletedAt") {
+			ev.Type = "insert"
+		}
+	case "insert", "replace":
+		ev.Type = change.OperationType
+		ev.Location = change.FullDocument
+	case "delete":
+		ev.Type = "delete"
+	default:
+		return ChangeEvent{}, false
+	}
+	return ev, true
+}
+
+// watchChanges forwards the store's change events to the hub until ctx is
+// cancelled and, with config.RefreshOnChange, has the refresher reload the
+// cache after each of them.
+func watchChanges(ctx context.Context) {
+	events, err := store.Watch(ctx)
+	if err != nil {
+		slog.Error("failed to watch for changes", "error", err)
+		return
+	}
+	for ev := range events {
+		hub.publish(ev)
+		if config.RefreshOnChange {
+			notifyCacheChanged()
+		}
+	}
+}
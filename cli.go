@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// subcommands are the administrative commands run instead of the server when
+// named as the first argument, e.g. "soulforged-go keys list". "serve", or
+// no command at all, runs the server.
+var subcommands = map[string]func(ctx context.Context, args []string, out io.Writer) error{
+	"backup":   runBackupCommand,
+	"export":   runExportCommand,
+	"keys":     runKeysCommand,
+	"migrate":  runMigrateCommand,
+	"restore":  runRestoreCommand,
+	"seed":     runSeedCommand,
+	"users":    runUsersCommand,
+	"validate": runValidateCommand,
+}
+
+// printUsage lists the commands, for an unknown one.
+func printUsage(out io.Writer) {
+	names := []string{"serve"}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "usage: soulforged-go [command] [flags]\n\ncommands: %s\n\nWithout a command the server runs, taking the serve flags.\n", strings.Join(names, ", "))
+}
+
+// subcommandMain runs a subcommand against the configured storage and exits.
+// Configuration comes from CONFIG_FILE and the environment, as for the server.
+func subcommandMain(run func(context.Context, []string, io.Writer) error, args []string) {
+	// Keep stdout for the command's output, such as an export
+	initLogger(os.Stderr)
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	config = cfg
+
+	if err := initStorage(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize storage:", err)
+		os.Exit(1)
+	}
+	// seed and validate check locations against the configured rules
+	if err := loadValidationRules(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load validation rules:", err)
+		os.Exit(1)
+	}
+
+	err = run(context.Background(), args, os.Stdout)
+	store.Close(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runUsersCommand implements the "users" subcommand:
+//
+//	soulforged-go users role <username> <viewer|contributor|admin>
+func runUsersCommand(ctx context.Context, args []string, out io.Writer) error {
+	users, ok := store.(UserStorage)
+	if !ok {
+		return fmt.Errorf("the storage backend does not support user accounts")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if len(args) != 3 || args[0] != "role" {
+		return errors.New("usage: users role <username> <viewer|contributor|admin>")
+	}
+	username, role := args[1], strings.ToLower(args[2])
+	if roleRank[role] == 0 {
+		return fmt.Errorf("unknown role %q", args[2])
+	}
+	if err := users.SetUserRole(ctx, userID(username), role); err != nil {
+		return fmt.Errorf("failed to set role of %s: %w", username, err)
+	}
+	fmt.Fprintf(out, "%s is now %s\n", username, role)
+	return nil
+}
+
+// commandFlags returns a flag set for the named subcommand. Parse errors are
+// printed with the subcommand's usage.
+func commandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("soulforged-go "+name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+// runSeedCommand implements the "seed" subcommand:
+//
+//	soulforged-go seed --file data.json
+//	soulforged-go seed --file data.csv
+//
+// It upserts the locations of a JSON array or a CSV file in the export's
+// format, like POST /api/map/import. Rows failing validation are reported
+// and skipped; with --strict any of them aborts the seed before anything is
+// written.
+func runSeedCommand(ctx context.Context, args []string, out io.Writer) error {
+	flags := commandFlags("seed")
+	file := flags.String("file", "", "JSON or CSV file of locations")
+	strict := flags.Bool("strict", false, "write nothing if any row is invalid")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || flags.NArg() != 0 {
+		return errors.New("usage: seed --file <data.json|data.csv> [--strict]")
+	}
+	format, ok := importFormat("", filepath.Base(*file))
+	if !ok {
+		return fmt.Errorf("%s: the file must end in .json or .csv", *file)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rows []importRow
+	if format == "json" {
+		rows, err = parseImportJSON(f)
+	} else {
+		rows, err = parseImportCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	var valid []MapLocation
+	invalid := 0
+	seen := make(map[string]int, len(rows))
+	for _, row := range rows {
+		errs := row.err
+		if errs == nil {
+			errs = validateLocation(row.loc)
+		}
+		if first, ok := seen[row.loc.ID]; ok && errs == nil {
+			errs = []FieldError{{Field: "id", Message: fmt.Sprintf("duplicates row %d", first)}}
+		}
+		if errs != nil {
+			invalid++
+			for _, e := range errs {
+				fmt.Fprintf(out, "row %d (%s): %s: %s\n", row.row, row.loc.ID, e.Field, e.Message)
+			}
+			continue
+		}
+		seen[row.loc.ID] = row.row
+		valid = append(valid, row.loc)
+	}
+	if invalid > 0 && *strict {
+		return fmt.Errorf("%d of %d rows are invalid; nothing was written", invalid, len(rows))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	created, updated, failed := 0, 0, 0
+	if len(valid) > 0 {
+		wasCreated, errs, err := upsertLocations(ctx, valid)
+		if err != nil {
+			return fmt.Errorf("failed to write locations: %w", err)
+		}
+		for i, loc := range valid {
+			switch {
+			case errs[i] != nil:
+				failed++
+				fmt.Fprintf(out, "%s: %v\n", loc.ID, errs[i])
+			case wasCreated[i]:
+				created++
+			default:
+				updated++
+			}
+		}
+	}
+	fmt.Fprintf(out, "Seeded %s: %d created, %d updated, %d invalid, %d failed\n", *file, created, updated, invalid, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d locations could not be written", failed)
+	}
+	return nil
+}
+
+// runExportCommand implements the "export" subcommand:
+//
+//	soulforged-go export [--format csv|tsv|json] [--out file]
+//
+// It writes every stored location to standard output or to --out, as
+// /api/map/export does for CSV. JSON is an array that "seed" reads back.
+func runExportCommand(ctx context.Context, args []string, out io.Writer) error {
+	flags := commandFlags("export")
+	format := flags.String("format", "csv", "csv, tsv or json")
+	path := flags.String("out", "", "file to write instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: export [--format csv|tsv|json] [--out file]")
+	}
+	if *format != "csv" && *format != "tsv" && *format != "json" {
+		return fmt.Errorf("unknown format %q, want csv, tsv or json", *format)
+	}
+
+	w := out
+	if *path != "" {
+		f, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n := 0
+	var err error
+	if *format == "json" {
+		err = exportJSON(ctx, w, &n)
+	} else {
+		cw := csv.NewWriter(w)
+		if *format == "tsv" {
+			cw.Comma = '\t'
+		}
+		cw.Write(importColumns)
+		err = streamLocations(ctx, func(loc MapLocation) error {
+			n++
+			return cw.Write(exportRecord(loc))
+		})
+		cw.Flush()
+		err = errors.Join(err, cw.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("export failed after %d locations: %w", n, err)
+	}
+	if *path != "" {
+		fmt.Fprintf(out, "Exported %d locations to %s\n", n, *path)
+	}
+	return nil
+}
+
+// exportJSON writes the locations as an indented JSON array, one location
+// per element, counting them in n.
+func exportJSON(ctx context.Context, w io.Writer, n *int) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	err := streamLocations(ctx, func(loc MapLocation) error {
+		body, err := json.Marshal(exact(loc))
+		if err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if *n == 0 {
+			sep = "\n  "
+		}
+		*n++
+		_, err = io.WriteString(w, sep+string(body))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// runValidateCommand implements the "validate" subcommand:
+//
+//	soulforged-go validate
+//
+// It checks every stored location against the validation rules, as
+// /admin/validate does, and fails when any of them breaks one, so that it can
+// gate a deploy or run from cron.
+func runValidateCommand(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("usage: validate")
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	n, bad := 0, 0
+	err := streamLocations(ctx, func(loc MapLocation) error {
+		n++
+		errs := validateLocation(loc)
+		if errs == nil {
+			return nil
+		}
+		if bad == 0 {
+			fmt.Fprintln(tw, "ID\tFIELD\tPROBLEM")
+		}
+		bad++
+		for _, e := range errs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", loc.ID, e.Field, e.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read locations: %w", err)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d locations fail validation", bad, n)
+	}
+	fmt.Fprintf(out, "All %d locations pass validation\n", n)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings the server can produce, in order of preference.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// negotiateEncoding picks the response content coding from Accept-Encoding:
+// brotli if the client accepts it, then gzip, else "" for identity.
+func negotiateEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+			accepted[strings.ToLower(strings.TrimSpace(coding))] = q > 0
+		}
+	}
+
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+		if ok, listed := accepted[coding]; ok || (!listed && accepted["*"]) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// newEncoder returns a compressor for coding writing to w.
+func newEncoder(coding string, w io.Writer) io.WriteCloser {
+	if coding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	return gzip.NewWriter(w)
+}
+
+// compressBytes compresses body with coding in one go.
+func compressBytes(coding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := newEncoder(coding, &buf)
+	if _, err := enc.Write(body); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressResponses compresses response bodies with the coding negotiated
+// from Accept-Encoding. Responses that already carry a Content-Encoding, such
+// as the pre-compressed /api/map snapshot, event streams, images, ranges and
+// bodiless responses are passed through untouched.
+func compressResponses(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		coding := negotiateEncoding(r)
+		if coding == "" || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, coding: coding}
+		defer cw.Close()
+		handler(cw, r)
+	}
+}
+
+// compressWriter decides on the first WriteHeader or Write whether to
+// compress, based on the headers the handler has set by then.
+type compressWriter struct {
+	http.ResponseWriter
+	coding  string
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) decide(status int) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	h := cw.Header()
+	switch {
+	case h.Get("Content-Encoding") != "",
+		status < http.StatusOK, status == http.StatusNoContent, status == http.StatusNotModified,
+		status == http.StatusPartialContent,
+		strings.HasPrefix(h.Get("Content-Type"), "text/event-stream"),
+		// Already compressed
+		strings.HasPrefix(h.Get("Content-Type"), "image/"), h.Get("Content-Type") == "application/zip":
+		return
+	}
+
+	h.Set("Content-Encoding", cw.coding)
+	h.Del("Content-Length")
+	cw.enc = newEncoder(cw.coding, cw.ResponseWriter)
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	cw.decide(status)
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.decide(http.StatusOK)
+	if cw.enc == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.enc.Write(p)
+}
+
+// Flush pushes out whatever the compressor has buffered so far.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over uncompressed, as WebSocket needs.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.decided = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the compressed stream, if one was started.
+func (cw *compressWriter) Close() error {
+	if cw.enc == nil {
+		return nil
+	}
+	return cw.enc.Close()
+}
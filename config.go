@@ -0,0 +1,1050 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMongoPoolSize is the default maximum size of the MongoDB connection
+// pool.
+const defaultMongoPoolSize = 10
+
+// Config holds the server settings that deployments tune. Values come from
+// the defaults below, then the YAML file named by -config or CONFIG_FILE,
+// then environment variables, then command-line flags, each overriding the
+// last.
+type Config struct {
+	// Port is the HTTP listen port (PORT, -port), serving HTTPS when TLS is
+	// configured.
+	Port int `yaml:"port"`
+	// GRPCPort is the listen port of the gRPC MapService, or 0 not to serve
+	// it (GRPC_PORT, -grpc-port).
+	GRPCPort int `yaml:"grpcPort"`
+	// DebugPort serves net/http/pprof and expvar under /debug/, without
+	// authentication, or 0 not to serve them (DEBUG_PORT, -debug-port). Keep
+	// it off the public network.
+	DebugPort int `yaml:"debugPort"`
+	// Worlds names the game worlds served under /api/worlds/{world}/, the
+	// first being the default world that the unprefixed routes serve
+	// (WORLDS, comma-separated). The default world's locations live in
+	// Mongo.Collection, every other world's in Mongo.Collection_{world}.
+	Worlds []string `yaml:"worlds"`
+	// RefreshInterval is how often the background refresher reloads the
+	// cache (REFRESH_INTERVAL, -refresh-interval). It is also the max-age
+	// responses built from the map and dataset caches may be kept for.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+	// RefreshOnChange also refreshes the cache shortly after the storage
+	// reports a change, so edits show up without waiting for the next poll
+	// (REFRESH_ON_CHANGE). With it on, RefreshInterval is only a safety net
+	// and can be made much longer.
+	RefreshOnChange bool `yaml:"refreshOnChange"`
+	// CacheTTLs bounds how long a cached snapshot is served before a read
+	// revalidates it in the background, keyed by "map" (every world) or a
+	// dataset name. The stale snapshot keeps being served until the reload
+	// finishes. Caches without one rely on the refresher alone
+	// (CACHE_TTLS, e.g. "map=30s,resources=5m").
+	CacheTTLs map[string]time.Duration `yaml:"cacheTTLs"`
+	// CacheLoadWait bounds how long a read waits for a cache to load
+	// (CACHE_LOAD_WAIT). Past it the read is served the last snapshot
+	// loaded, as it is when the load fails, or without one 503 with
+	// Retry-After; the load itself carries on. Zero waits as long as the
+	// client does.
+	CacheLoadWait time.Duration `yaml:"cacheLoadWait"`
+	// MaintenanceDuringReload answers reads with 503 and Retry-After while
+	// a reload asked for at /api/admin/cache/refresh runs
+	// (MAINTENANCE_DURING_RELOAD). By default they are served the previous
+	// snapshot until the new one is swapped in, which is never empty or
+	// half loaded but may be out of date for as long as the reload takes;
+	// turning it on trades that for an outage of the same length.
+	MaintenanceDuringReload bool `yaml:"maintenanceDuringReload"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish, and
+	// the storage to disconnect, once a shutdown signal arrives
+	// (SHUTDOWN_TIMEOUT, -shutdown-timeout).
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// MaxWriteStaleness refuses writes with 503 once background refreshes
+	// have been failing for longer, so that clients retry them against a
+	// replica with current data; reads are still served. It trades write
+	// availability for not basing writes on data the other replicas have
+	// long moved past. Zero accepts writes however stale the cache is
+	// (MAX_WRITE_STALENESS).
+	MaxWriteStaleness time.Duration `yaml:"maxWriteStaleness"`
+	// RefreshFailureThreshold is how long background refreshes may keep
+	// failing before /readyz reports unavailable
+	// (REFRESH_FAILURE_THRESHOLD, -refresh-failure-threshold).
+	RefreshFailureThreshold time.Duration `yaml:"refreshFailureThreshold"`
+	// CoordinatePrecision is the number of decimal places coordinates are
+	// rounded to in responses, from 0 to 15, or -1 to keep full precision
+	// (COORDINATE_PRECISION). What the server stores, caches or backs up
+	// is never rounded.
+	CoordinatePrecision int              `yaml:"coordinatePrecision"`
+	Mongo               MongoConfig      `yaml:"mongo"`
+	CORS                CORSConfig       `yaml:"cors"`
+	Auth                AuthConfig       `yaml:"auth"`
+	RateLimit           RateLimitConfig  `yaml:"rateLimit"`
+	Redis               RedisConfig      `yaml:"redis"`
+	Election            ElectionConfig   `yaml:"election"`
+	TLS                 TLSConfig        `yaml:"tls"`
+	HTTP                HTTPConfig       `yaml:"http"`
+	Tracing             TracingConfig    `yaml:"tracing"`
+	Tiles               TilesConfig      `yaml:"tiles"`
+	Webhooks            WebhooksConfig   `yaml:"webhooks"`
+	Discord             DiscordConfig    `yaml:"discord"`
+	Backup              BackupConfig     `yaml:"backup"`
+	CDN                 CDNConfig        `yaml:"cdn"`
+	Analytics           AnalyticsConfig  `yaml:"analytics"`
+	Storage             StorageConfig    `yaml:"storage"`
+	Validation          ValidationConfig `yaml:"validation"`
+	Logging             LoggingConfig    `yaml:"logging"`
+	Redaction           RedactionConfig  `yaml:"redaction"`
+	// Fixtures runs the server from a JSON file instead of a database
+	// (FIXTURES, -fixtures): every collection is kept in memory, seeded from
+	// the file, a dump in the backup layout or an array of locations, or
+	// from a small built-in demo data set given "embedded". Writes are not
+	// saved, so every run starts from the fixtures again. This is meant for
+	// front-end development and integration tests.
+	Fixtures string `yaml:"fixtures"`
+	// Layers declares the map overlays served under /api/layers besides
+	// the locations, each in a collection of its own. They are set in the
+	// config file only.
+	Layers []LayerConfig `yaml:"layers"`
+	// Spaces declares the coordinate spaces, besides the game's own in
+	// which locations are stored, that ?space= and /api/transform convert
+	// to, such as the wiki's maps or screenshot pixels. They are set in the
+	// config file only.
+	Spaces []SpaceConfig `yaml:"spaces"`
+}
+
+// StorageConfig selects where the data lives.
+type StorageConfig struct {
+	// Backend is mongo, the default, or memory, which keeps everything in
+	// process so the server can run without a MongoDB instance
+	// (STORAGE_BACKEND).
+	Backend string `yaml:"backend"`
+	// File is the JSON file the memory backend loads from and saves to;
+	// without one nothing is kept across restarts (STORAGE_FILE).
+	File string `yaml:"file"`
+}
+
+// ValidationConfig holds the rules written locations must satisfy.
+type ValidationConfig struct {
+	// MaxNameLength caps location names, in characters
+	// (MAX_LOCATION_NAME_LENGTH).
+	MaxNameLength int `yaml:"maxNameLength"`
+	// MapBounds restricts coordinates to the box "minX,minY,maxX,maxY", or
+	// not at all when empty (MAP_BOUNDS).
+	MapBounds string `yaml:"mapBounds"`
+	// RegionsFile is the path of a JSON array of regions, which restricts
+	// coordinates to those regions (REGIONS_FILE).
+	RegionsFile string `yaml:"regionsFile"`
+}
+
+// RedactionConfig hides location fields and tags from the callers of the
+// public read API, on every route, feed and gRPC call serving locations
+// except the editors' history; the cache keeps them.
+type RedactionConfig struct {
+	// Fields names the fields left out, by their JSON names; the ID, name
+	// and coordinates are always served (REDACT_FIELDS, comma-separated).
+	Fields []string `yaml:"fields"`
+	// Tags lists the tags left out of the tags of each location
+	// (REDACT_TAGS, comma-separated).
+	Tags []string `yaml:"tags"`
+	// Role is the least role that is served them, signed in or by API key;
+	// anonymous callers never are (REDACT_ROLE).
+	Role string `yaml:"role"`
+}
+
+// LoggingConfig turns on the optional logs. The snapshot diffs are off by
+// default since hashing every document on each refresh is not free.
+type LoggingConfig struct {
+	// SnapshotDiff logs how many locations each refresh added, updated and
+	// removed (LOG_SNAPSHOT_DIFF); SnapshotDiffIDs logs their IDs as well
+	// (LOG_SNAPSHOT_DIFF_IDS).
+	SnapshotDiff    bool `yaml:"snapshotDiff"`
+	SnapshotDiffIDs bool `yaml:"snapshotDiffIDs"`
+}
+
+// BackupConfig says where POST /api/admin/backups and the backup command
+// keep the dumps: files in Dir or objects in an S3 bucket, whose region and
+// credentials come from the standard AWS_* variables.
+type BackupConfig struct {
+	// Dir is the backup directory (BACKUP_DIR).
+	Dir string `yaml:"dir"`
+	// S3Bucket and S3Prefix keep the dumps in S3 instead
+	// (BACKUP_S3_BUCKET, BACKUP_S3_PREFIX).
+	S3Bucket string `yaml:"s3Bucket"`
+	S3Prefix string `yaml:"s3Prefix"`
+	// Interval schedules a backup that often; zero only backs up on
+	// request (BACKUP_INTERVAL).
+	Interval time.Duration `yaml:"interval"`
+	// Keep is how many dumps are kept, the oldest being deleted; zero
+	// keeps them all (BACKUP_KEEP).
+	Keep int `yaml:"keep"`
+}
+
+// CDNConfig is for running the API behind a CDN: how long it may keep the
+// responses built from the caches, and how to purge it when they change.
+type CDNConfig struct {
+	// SharedMaxAge is how long a CDN may keep those responses, sent as
+	// s-maxage and Surrogate-Control; zero leaves them to max-age
+	// (CDN_SHARED_MAX_AGE). With a purge set up it can be far longer than
+	// RefreshInterval.
+	SharedMaxAge time.Duration `yaml:"sharedMaxAge"`
+	// Provider is cloudflare or fastly to purge the CDN whenever a refresh
+	// finds the data changed, or empty for no purge (CDN_PROVIDER).
+	Provider string `yaml:"provider"`
+	// Zone is the Cloudflare zone ID or the Fastly service ID (CDN_ZONE).
+	Zone string `yaml:"zone"`
+	// Token is the API token allowed to purge it (CDN_TOKEN).
+	Token string `yaml:"token"`
+	// PurgeURL replaces the provider's purge endpoint, for a proxy or a test
+	// double (CDN_PURGE_URL).
+	PurgeURL string `yaml:"purgeURL"`
+}
+
+// AnalyticsConfig sets up the anonymous usage analytics of
+// /api/admin/analytics: requests per route and dataset, distinct clients by
+// a salted hash of their IP, and search terms, kept per day in the analytics
+// collection.
+type AnalyticsConfig struct {
+	// Enabled records them; turning it off opts the deployment out
+	// (ANALYTICS_ENABLED). Clients sending DNT: 1 or Sec-GPC: 1 are never
+	// recorded.
+	Enabled bool `yaml:"enabled"`
+	// FlushInterval is how often the figures are written to the analytics
+	// collection (ANALYTICS_FLUSH_INTERVAL).
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Salt is mixed into the client hashes (ANALYTICS_SALT). Replicas must
+	// share it for a client to count once; without one a generated salt is
+	// kept in the analytics collection, or on the memory backend each
+	// process draws its own and a warning is logged.
+	Salt string `yaml:"salt"`
+	// Retention is how long the daily figures are kept
+	// (ANALYTICS_RETENTION).
+	Retention time.Duration `yaml:"retention"`
+}
+
+// DiscordConfig turns on the Discord bot, which answers map lookups in chat
+// and announces location changes.
+type DiscordConfig struct {
+	// Token is the bot token; the bot only runs with one set
+	// (DISCORD_TOKEN).
+	Token string `yaml:"token"`
+	// Channel is the ID of the channel changes are announced in; empty
+	// announces nothing (DISCORD_CHANNEL).
+	Channel string `yaml:"channel"`
+	// Prefix starts the bot's commands, as in "!where" (DISCORD_PREFIX).
+	Prefix string `yaml:"prefix"`
+	// Announce lists the changes announced: create, update and delete
+	// (DISCORD_ANNOUNCE).
+	Announce []string `yaml:"announce"`
+}
+
+// WebhooksConfig bounds the deliveries to the hooks registered at
+// /api/admin/webhooks.
+type WebhooksConfig struct {
+	// Timeout bounds one delivery attempt (WEBHOOK_TIMEOUT).
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts is how often a delivery is tried before giving up
+	// (WEBHOOK_MAX_ATTEMPTS).
+	MaxAttempts int `yaml:"maxAttempts"`
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// further one (WEBHOOK_RETRY_BACKOFF).
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+	// QueueSize is how many events may wait for the dispatcher before new
+	// ones are dropped (WEBHOOK_QUEUE_SIZE).
+	QueueSize int `yaml:"queueSize"`
+}
+
+// TilesConfig says where /tiles/{z}/{x}/{y}.png finds the map background
+// tiles: files under Dir, laid out as {z}/{x}/{y}.png, or the GridFS bucket
+// "tiles" with files of the same names. With neither /tiles answers 404.
+type TilesConfig struct {
+	// Dir is the tile directory (TILES_DIR).
+	Dir string `yaml:"dir"`
+	// GridFS serves the tiles from MongoDB instead (TILES_GRIDFS).
+	GridFS bool `yaml:"gridFS"`
+	// MaxAge is how long clients and CDNs may cache a tile
+	// (TILES_MAX_AGE).
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// TracingConfig turns on OpenTelemetry tracing of HTTP requests and MongoDB
+// commands. The OTLP exporter takes its endpoint, headers and TLS settings
+// from the standard OTEL_EXPORTER_OTLP_* variables and the service name from
+// OTEL_SERVICE_NAME.
+type TracingConfig struct {
+	// Enabled exports spans over OTLP/gRPC (TRACING_ENABLED).
+	Enabled bool `yaml:"enabled"`
+	// SampleRatio is the fraction of traces started here that are kept;
+	// requests arriving with a sampled parent always are
+	// (TRACING_SAMPLE_RATIO).
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// HTTPConfig bounds what a client can make the HTTP server hold on to. A zero
+// timeout means none.
+type HTTPConfig struct {
+	// ReadHeaderTimeout bounds reading the request headers
+	// (HTTP_READ_HEADER_TIMEOUT).
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	// ReadTimeout bounds reading the whole request, body included
+	// (HTTP_READ_TIMEOUT).
+	ReadTimeout time.Duration `yaml:"readTimeout"`
+	// WriteTimeout bounds writing the response (HTTP_WRITE_TIMEOUT). The
+	// streaming routes are exempt.
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// IdleTimeout is how long a keep-alive connection may wait for its next
+	// request (HTTP_IDLE_TIMEOUT).
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// MaxHeaderBytes caps the size of the request headers
+	// (HTTP_MAX_HEADER_BYTES).
+	MaxHeaderBytes int `yaml:"maxHeaderBytes"`
+	// MaxBodyBytes caps every request body; endpoints may set lower limits
+	// of their own (HTTP_MAX_BODY_BYTES).
+	MaxBodyBytes int `yaml:"maxBodyBytes"`
+	// LegacySunset is when the unversioned /api routes will stop being
+	// served, announced in their Sunset header, or zero while undecided
+	// (HTTP_LEGACY_SUNSET, RFC 3339). The /api/v1 routes replace them.
+	LegacySunset time.Time `yaml:"legacySunset"`
+	// DefaultSchema is the layout of locations in JSON responses that do
+	// not ask for one with ?schema= or an Accept profile: nested, with the
+	// coordinates under xy, or flat, with x and y at the top level as older
+	// tools expect (HTTP_DEFAULT_SCHEMA).
+	DefaultSchema string `yaml:"defaultSchema"`
+}
+
+// MongoConfig holds the MongoDB connection settings.
+type MongoConfig struct {
+	// URI is the connection string (MONGO_URI).
+	URI string `yaml:"uri"`
+	// Database and Collection name where map locations live
+	// (MONGO_DATABASE, MONGO_COLLECTION).
+	Database   string `yaml:"database"`
+	Collection string `yaml:"collection"`
+	// PoolSize is the maximum connection pool size (MONGO_POOL_SIZE).
+	PoolSize int `yaml:"poolSize"`
+	// MaxConcurrency bounds request-driven operations in flight; it defaults
+	// to PoolSize (MONGO_MAX_CONCURRENCY).
+	MaxConcurrency int `yaml:"maxConcurrency"`
+	// WaitTimeout is how long a request waits for an operation slot
+	// (MONGO_WAIT_TIMEOUT).
+	WaitTimeout time.Duration `yaml:"waitTimeout"`
+	// QueryTimeout bounds each individual query (MONGO_QUERY_TIMEOUT).
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
+	// Migrate applies pending schema migrations on startup; turn it off to
+	// run them with the migrate subcommand instead (MONGO_MIGRATE).
+	Migrate bool `yaml:"migrate"`
+	// ConnectRetries is how often a failed first connection is retried
+	// before startup gives up, 0 not to retry (MONGO_CONNECT_RETRIES).
+	// ConnectBackoff is the wait before the first retry, doubling up to a
+	// minute after each (MONGO_CONNECT_BACKOFF). Once connected the driver
+	// reconnects by itself, for as long as it takes.
+	ConnectRetries int           `yaml:"connectRetries"`
+	ConnectBackoff time.Duration `yaml:"connectBackoff"`
+	// AppName shows up, with the server version, in Mongo's logs and
+	// currentOp output (MONGO_APP_NAME).
+	AppName string `yaml:"appName"`
+	// ReadURI points the read paths at a separate deployment or connection
+	// (MONGO_READ_URI), and ReadPreference, such as secondaryPreferred,
+	// picks which members serve them (MONGO_READ_PREFERENCE). With neither
+	// set reads share the primary connection used for writes.
+	ReadURI        string `yaml:"readURI"`
+	ReadPreference string `yaml:"readPreference"`
+	// GeoIndexMin and GeoIndexMax are the range of the 2d index on xy
+	// (GEO_INDEX_MIN, GEO_INDEX_MAX). Every stored coordinate must fall
+	// inside it.
+	GeoIndexMin float64 `yaml:"geoIndexMin"`
+	GeoIndexMax float64 `yaml:"geoIndexMax"`
+}
+
+// RedisConfig points replicas at a shared cache. With no URL each replica
+// keeps to its own cache.
+type RedisConfig struct {
+	// URL is a redis:// or rediss:// URL (REDIS_URL).
+	URL string `yaml:"url"`
+	// KeyPrefix namespaces the keys and channels used, so that deployments
+	// can share a Redis (REDIS_KEY_PREFIX).
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// ElectionConfig has the replicas elect one of them to run the background
+// refresher. The leader loads the caches and publishes their versions, and
+// the others only reload a cache when its version moves, or adopt the map
+// from Redis when it is shared. Without a backend every replica refreshes on
+// its own.
+type ElectionConfig struct {
+	// Backend holds the lease: "mongo", a document in the leases
+	// collection, or "redis", a key under the Redis key prefix
+	// (ELECTION_BACKEND).
+	Backend string `yaml:"backend"`
+	// Lease is how long a leader that stops renewing keeps the role; it
+	// renews every third of it, and followers check the published
+	// versions as often (ELECTION_LEASE).
+	Lease time.Duration `yaml:"lease"`
+}
+
+// LayerConfig declares a map overlay, such as dungeons, vendors or portals,
+// served under /api/layers/{name} from the layer_{name} collection. Its
+// records have an ID, a position and the properties of Fields.
+type LayerConfig struct {
+	// Name is a lower-case slug
+	Name        string       `yaml:"name"`
+	Description string       `yaml:"description"`
+	Fields      []LayerField `yaml:"fields"`
+	// Read is the role needed to read the layer, empty for anyone, and
+	// Write the role needed to create and replace records, contributor
+	// when empty. Removing records takes an admin.
+	Read  string `yaml:"read"`
+	Write string `yaml:"write"`
+}
+
+// SpaceConfig declares a coordinate space by the affine transform taking game
+// coordinates into it.
+type SpaceConfig struct {
+	// Name is a lower-case slug other than game
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Transform is [a, b, c, d, e, f], mapping game (x, y) to
+	// (a·x + b·y + c, d·x + e·y + f). It must be invertible.
+	Transform [6]float64 `yaml:"transform"`
+}
+
+// LayerField is a property the records of a layer may have.
+type LayerField struct {
+	Name string `yaml:"name" json:"name"`
+	// Type is string, number, integer, boolean or strings, a list of
+	// strings
+	Type     string `yaml:"type" json:"type"`
+	Required bool   `yaml:"required" json:"required,omitempty"`
+	// Enum lists the values a string or each of a list of strings may take
+	Enum []string `yaml:"enum" json:"enum,omitempty"`
+}
+
+// TLSConfig turns on HTTPS, with either a certificate from files or one
+// obtained from Let's Encrypt. With neither the server speaks plain HTTP.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files (TLS_CERT_FILE, TLS_KEY_FILE,
+	// -tls-cert, -tls-key).
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// AutocertDomains are the host names to obtain certificates for from
+	// Let's Encrypt instead (TLS_AUTOCERT_DOMAINS, comma-separated). The
+	// server must be reachable on them at port 443, or at RedirectPort 80
+	// for HTTP challenges.
+	AutocertDomains []string `yaml:"autocertDomains"`
+	// AutocertCacheDir keeps the obtained certificates across restarts
+	// (TLS_AUTOCERT_CACHE_DIR).
+	AutocertCacheDir string `yaml:"autocertCacheDir"`
+	// AutocertEmail is given to Let's Encrypt for expiry notices
+	// (TLS_AUTOCERT_EMAIL).
+	AutocertEmail string `yaml:"autocertEmail"`
+	// RedirectPort serves plain HTTP that redirects to HTTPS, and answers
+	// autocert's HTTP challenges; 0 disables it (TLS_REDIRECT_PORT).
+	RedirectPort int `yaml:"redirectPort"`
+}
+
+// enabled reports whether the server should speak HTTPS.
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// CORSConfig controls cross-origin access for browser clients hosted on other
+// domains. With no allowed origins CORS is effectively off.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as https://map.example.org; "*"
+	// allows any (CORS_ALLOWED_ORIGINS, comma-separated).
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// AllowedMethods and AllowedHeaders are answered to preflight requests
+	// (CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS).
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// MaxAge is how long browsers may cache a preflight (CORS_MAX_AGE).
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// AuthConfig controls user accounts. Accounts are disabled while JWTSecret is
+// empty.
+type AuthConfig struct {
+	// AdminAPIKey is an X-API-Key that acts as admin besides the keys
+	// issued with the keys subcommand (ADMIN_API_KEY).
+	AdminAPIKey string `yaml:"adminAPIKey"`
+	// JWTSecret signs the bearer tokens; at least 32 bytes (JWT_SECRET).
+	JWTSecret string `yaml:"jwtSecret"`
+	// TokenTTL is how long a token stays valid (JWT_TTL).
+	TokenTTL time.Duration `yaml:"tokenTTL"`
+	// DefaultRole is given to newly registered accounts (AUTH_DEFAULT_ROLE).
+	DefaultRole string `yaml:"defaultRole"`
+}
+
+// RateLimit is a token bucket: Rate requests per second on average with bursts
+// of up to Burst. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RateLimitConfig holds the per-client limits of each route group.
+type RateLimitConfig struct {
+	// TrustForwardedFor limits by the X-Forwarded-For address set by a
+	// reverse proxy instead of the connection's (RATE_LIMIT_TRUST_FORWARDED_FOR).
+	// Only enable it behind a proxy that sets the header.
+	TrustForwardedFor bool `yaml:"trustForwardedFor"`
+	// Read covers GET, HEAD and OPTIONS, and the POST routes that only
+	// read, such as /graphql and /api/map/batch (RATE_LIMIT_READ_RATE,
+	// _BURST).
+	Read RateLimit `yaml:"read"`
+	// Write covers every other request (RATE_LIMIT_WRITE_RATE, _BURST).
+	Write RateLimit `yaml:"write"`
+	// Auth covers /api/auth/ (RATE_LIMIT_AUTH_RATE, _BURST).
+	Auth RateLimit `yaml:"auth"`
+}
+
+// group returns the limit of the named route group.
+func (c RateLimitConfig) group(name string) RateLimit {
+	switch name {
+	case rateGroupWrite:
+		return c.Write
+	case rateGroupAuth:
+		return c.Auth
+	default:
+		return c.Read
+	}
+}
+
+// config is the configuration loaded at startup by loadConfig.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Port:                    8080,
+		Worlds:                  []string{"default"},
+		RefreshInterval:         20 * time.Second,
+		CacheLoadWait:           5 * time.Second,
+		RefreshOnChange:         true,
+		ShutdownTimeout:         15 * time.Second,
+		RefreshFailureThreshold: 2 * time.Minute,
+		CoordinatePrecision:     -1,
+		Mongo: MongoConfig{
+			Database:       "soulforged-db",
+			Collection:     "maplocations",
+			PoolSize:       defaultMongoPoolSize,
+			WaitTimeout:    2 * time.Second,
+			QueryTimeout:   10 * time.Second,
+			Migrate:        true,
+			ConnectRetries: 10,
+			ConnectBackoff: time.Second,
+			AppName:        "soulforged-go",
+			GeoIndexMin:    -geoIndexRange,
+			GeoIndexMax:    geoIndexRange,
+		},
+		Storage: StorageConfig{
+			Backend: storageMongo,
+		},
+		Validation: ValidationConfig{
+			MaxNameLength: 100,
+		},
+		Redaction: RedactionConfig{
+			Role: roleViewer,
+		},
+		Election: ElectionConfig{
+			Lease: 15 * time.Second,
+		},
+		Redis: RedisConfig{
+			KeyPrefix: "soulforged:",
+		},
+		TLS: TLSConfig{
+			AutocertCacheDir: "autocert",
+		},
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      maxImportBody,
+			DefaultSchema:     schemaNested,
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+		Tiles: TilesConfig{
+			MaxAge: 24 * time.Hour,
+		},
+		Discord: DiscordConfig{
+			Prefix:   "!",
+			Announce: []string{auditCreate},
+		},
+		Analytics: AnalyticsConfig{
+			Enabled:       true,
+			FlushInterval: 5 * time.Minute,
+			Retention:     90 * 24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:      10 * time.Second,
+			MaxAttempts:  6,
+			RetryBackoff: time.Second,
+			QueueSize:    1000,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "X-API-Key", "X-Request-ID", "If-None-Match", "If-Match", "If-Unmodified-Since"},
+			MaxAge:         10 * time.Minute,
+		},
+		Auth: AuthConfig{
+			TokenTTL:    24 * time.Hour,
+			DefaultRole: roleViewer,
+		},
+		RateLimit: RateLimitConfig{
+			Read:  RateLimit{Rate: 20, Burst: 40},
+			Write: RateLimit{Rate: 1, Burst: 5},
+			Auth:  RateLimit{Rate: 0.2, Burst: 5},
+		},
+	}
+}
+
+// loadConfig builds the configuration from the defaults, the config file, the
+// environment and the command-line arguments, and validates the result.
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("soulforged-go", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	port := flags.Int("port", 0, "HTTP listen port")
+	grpcPort := flags.Int("grpc-port", 0, "gRPC listen port (0 disables gRPC)")
+	debugPort := flags.Int("debug-port", 0, "pprof and expvar listen port (0 disables them)")
+	refresh := flags.Duration("refresh-interval", 0, "cache refresh interval")
+	shutdown := flags.Duration("shutdown-timeout", 0, "graceful shutdown timeout")
+	failureThreshold := flags.Duration("refresh-failure-threshold", 0, "how long refreshes may fail before readiness is lost")
+	uri := flags.String("mongo-uri", "", "MongoDB connection string")
+	certFile := flags.String("tls-cert", "", "TLS certificate file")
+	keyFile := flags.String("tls-key", "", "TLS private key file")
+	fixtures := flags.String("fixtures", "", `serve in-memory data seeded from this JSON file, or "embedded" for demo data`)
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, err
+	}
+
+	// Only flags given explicitly override the file and environment
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "grpc-port":
+			cfg.GRPCPort = *grpcPort
+		case "debug-port":
+			cfg.DebugPort = *debugPort
+		case "refresh-interval":
+			cfg.RefreshInterval = *refresh
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = *shutdown
+		case "refresh-failure-threshold":
+			cfg.RefreshFailureThreshold = *failureThreshold
+		case "mongo-uri":
+			cfg.Mongo.URI = *uri
+		case "tls-cert":
+			cfg.TLS.CertFile = *certFile
+		case "tls-key":
+			cfg.TLS.KeyFile = *keyFile
+		case "fixtures":
+			cfg.Fixtures = *fixtures
+		}
+	})
+
+	if cfg.Mongo.MaxConcurrency == 0 {
+		cfg.Mongo.MaxConcurrency = cfg.Mongo.PoolSize
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile merges the YAML file at path into cfg. Unknown keys are rejected so
+// that typos don't go unnoticed.
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variable overrides to cfg.
+func (cfg *Config) loadEnv() error {
+	str := func(dst *string) func(string) error {
+		return func(v string) error { *dst = v; return nil }
+	}
+	integer := func(dst *int) func(string) error {
+		return func(v string) error {
+			n, err := strconv.Atoi(v)
+			*dst = n
+			return err
+		}
+	}
+	list := func(dst *[]string) func(string) error {
+		return func(v string) error {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+			return nil
+		}
+	}
+	number := func(dst *float64) func(string) error {
+		return func(v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			*dst = f
+			return err
+		}
+	}
+	boolean := func(dst *bool) func(string) error {
+		return func(v string) error {
+			b, err := strconv.ParseBool(v)
+			*dst = b
+			return err
+		}
+	}
+	duration := func(dst *time.Duration) func(string) error {
+		return func(v string) error {
+			d, err := time.ParseDuration(v)
+			*dst = d
+			return err
+		}
+	}
+	timestamp := func(dst *time.Time) func(string) error {
+		return func(v string) error {
+			t, err := time.Parse(time.RFC3339, v)
+			*dst = t
+			return err
+		}
+	}
+	durations := func(dst *map[string]time.Duration) func(string) error {
+		return func(v string) error {
+			*dst = map[string]time.Duration{}
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				key, value, ok := strings.Cut(item, "=")
+				if !ok {
+					return fmt.Errorf("%q is not key=duration", item)
+				}
+				d, err := time.ParseDuration(strings.TrimSpace(value))
+				if err != nil {
+					return err
+				}
+				(*dst)[strings.TrimSpace(key)] = d
+			}
+			return nil
+		}
+	}
+
+	for _, env := range []struct {
+		name  string
+		apply func(string) error
+	}{
+		{"PORT", integer(&cfg.Port)},
+		{"GRPC_PORT", integer(&cfg.GRPCPort)},
+		{"DEBUG_PORT", integer(&cfg.DebugPort)},
+		{"WORLDS", list(&cfg.Worlds)},
+		{"REFRESH_INTERVAL", duration(&cfg.RefreshInterval)},
+		{"REFRESH_ON_CHANGE", boolean(&cfg.RefreshOnChange)},
+		{"CACHE_TTLS", durations(&cfg.CacheTTLs)},
+		{"CACHE_LOAD_WAIT", duration(&cfg.CacheLoadWait)},
+		{"MAINTENANCE_DURING_RELOAD", boolean(&cfg.MaintenanceDuringReload)},
+		{"SHUTDOWN_TIMEOUT", duration(&cfg.ShutdownTimeout)},
+		{"COORDINATE_PRECISION", integer(&cfg.CoordinatePrecision)},
+		{"REFRESH_FAILURE_THRESHOLD", duration(&cfg.RefreshFailureThreshold)},
+		{"MAX_WRITE_STALENESS", duration(&cfg.MaxWriteStaleness)},
+		{"FIXTURES", str(&cfg.Fixtures)},
+		{"MONGO_URI", str(&cfg.Mongo.URI)},
+		{"MONGO_DATABASE", str(&cfg.Mongo.Database)},
+		{"MONGO_COLLECTION", str(&cfg.Mongo.Collection)},
+		{"MONGO_POOL_SIZE", integer(&cfg.Mongo.PoolSize)},
+		{"MONGO_MAX_CONCURRENCY", integer(&cfg.Mongo.MaxConcurrency)},
+		{"MONGO_WAIT_TIMEOUT", duration(&cfg.Mongo.WaitTimeout)},
+		{"MONGO_QUERY_TIMEOUT", duration(&cfg.Mongo.QueryTimeout)},
+		{"MONGO_MIGRATE", boolean(&cfg.Mongo.Migrate)},
+		{"MONGO_CONNECT_RETRIES", integer(&cfg.Mongo.ConnectRetries)},
+		{"MONGO_CONNECT_BACKOFF", duration(&cfg.Mongo.ConnectBackoff)},
+		{"MONGO_APP_NAME", str(&cfg.Mongo.AppName)},
+		{"MONGO_READ_URI", str(&cfg.Mongo.ReadURI)},
+		{"GEO_INDEX_MIN", number(&cfg.Mongo.GeoIndexMin)},
+		{"GEO_INDEX_MAX", number(&cfg.Mongo.GeoIndexMax)},
+		{"MONGO_READ_PREFERENCE", str(&cfg.Mongo.ReadPreference)},
+		{"STORAGE_BACKEND", str(&cfg.Storage.Backend)},
+		{"STORAGE_FILE", str(&cfg.Storage.File)},
+		{"MAX_LOCATION_NAME_LENGTH", integer(&cfg.Validation.MaxNameLength)},
+		{"MAP_BOUNDS", str(&cfg.Validation.MapBounds)},
+		{"REGIONS_FILE", str(&cfg.Validation.RegionsFile)},
+		{"LOG_SNAPSHOT_DIFF", boolean(&cfg.Logging.SnapshotDiff)},
+		{"REDACT_FIELDS", list(&cfg.Redaction.Fields)},
+		{"REDACT_TAGS", list(&cfg.Redaction.Tags)},
+		{"REDACT_ROLE", str(&cfg.Redaction.Role)},
+		{"LOG_SNAPSHOT_DIFF_IDS", boolean(&cfg.Logging.SnapshotDiffIDs)},
+		{"REDIS_URL", str(&cfg.Redis.URL)},
+		{"REDIS_KEY_PREFIX", str(&cfg.Redis.KeyPrefix)},
+		{"ELECTION_BACKEND", str(&cfg.Election.Backend)},
+		{"ELECTION_LEASE", duration(&cfg.Election.Lease)},
+		{"HTTP_READ_HEADER_TIMEOUT", duration(&cfg.HTTP.ReadHeaderTimeout)},
+		{"HTTP_READ_TIMEOUT", duration(&cfg.HTTP.ReadTimeout)},
+		{"HTTP_WRITE_TIMEOUT", duration(&cfg.HTTP.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", duration(&cfg.HTTP.IdleTimeout)},
+		{"HTTP_MAX_HEADER_BYTES", integer(&cfg.HTTP.MaxHeaderBytes)},
+		{"HTTP_MAX_BODY_BYTES", integer(&cfg.HTTP.MaxBodyBytes)},
+		{"HTTP_LEGACY_SUNSET", timestamp(&cfg.HTTP.LegacySunset)},
+		{"HTTP_DEFAULT_SCHEMA", str(&cfg.HTTP.DefaultSchema)},
+		{"TRACING_ENABLED", boolean(&cfg.Tracing.Enabled)},
+		{"TRACING_SAMPLE_RATIO", number(&cfg.Tracing.SampleRatio)},
+		{"TILES_DIR", str(&cfg.Tiles.Dir)},
+		{"TILES_GRIDFS", boolean(&cfg.Tiles.GridFS)},
+		{"TILES_MAX_AGE", duration(&cfg.Tiles.MaxAge)},
+		{"WEBHOOK_TIMEOUT", duration(&cfg.Webhooks.Timeout)},
+		{"WEBHOOK_MAX_ATTEMPTS", integer(&cfg.Webhooks.MaxAttempts)},
+		{"WEBHOOK_RETRY_BACKOFF", duration(&cfg.Webhooks.RetryBackoff)},
+		{"WEBHOOK_QUEUE_SIZE", integer(&cfg.Webhooks.QueueSize)},
+		{"BACKUP_DIR", str(&cfg.Backup.Dir)},
+		{"BACKUP_S3_BUCKET", str(&cfg.Backup.S3Bucket)},
+		{"BACKUP_S3_PREFIX", str(&cfg.Backup.S3Prefix)},
+		{"BACKUP_INTERVAL", duration(&cfg.Backup.Interval)},
+		{"BACKUP_KEEP", integer(&cfg.Backup.Keep)},
+		{"CDN_SHARED_MAX_AGE", duration(&cfg.CDN.SharedMaxAge)},
+		{"CDN_PROVIDER", str(&cfg.CDN.Provider)},
+		{"CDN_ZONE", str(&cfg.CDN.Zone)},
+		{"CDN_TOKEN", str(&cfg.CDN.Token)},
+		{"CDN_PURGE_URL", str(&cfg.CDN.PurgeURL)},
+		{"ANALYTICS_ENABLED", boolean(&cfg.Analytics.Enabled)},
+		{"ANALYTICS_FLUSH_INTERVAL", duration(&cfg.Analytics.FlushInterval)},
+		{"ANALYTICS_SALT", str(&cfg.Analytics.Salt)},
+		{"ANALYTICS_RETENTION", duration(&cfg.Analytics.Retention)},
+		{"DISCORD_TOKEN", str(&cfg.Discord.Token)},
+		{"DISCORD_CHANNEL", str(&cfg.Discord.Channel)},
+		{"DISCORD_PREFIX", str(&cfg.Discord.Prefix)},
+		{"DISCORD_ANNOUNCE", list(&cfg.Discord.Announce)},
+		{"TLS_CERT_FILE", str(&cfg.TLS.CertFile)},
+		{"TLS_KEY_FILE", str(&cfg.TLS.KeyFile)},
+		{"TLS_AUTOCERT_DOMAINS", list(&cfg.TLS.AutocertDomains)},
+		{"TLS_AUTOCERT_CACHE_DIR", str(&cfg.TLS.AutocertCacheDir)},
+		{"TLS_AUTOCERT_EMAIL", str(&cfg.TLS.AutocertEmail)},
+		{"TLS_REDIRECT_PORT", integer(&cfg.TLS.RedirectPort)},
+		{"CORS_ALLOWED_ORIGINS", list(&cfg.CORS.AllowedOrigins)},
+		{"CORS_ALLOWED_METHODS", list(&cfg.CORS.AllowedMethods)},
+		{"CORS_ALLOWED_HEADERS", list(&cfg.CORS.AllowedHeaders)},
+		{"CORS_MAX_AGE", duration(&cfg.CORS.MaxAge)},
+		{"ADMIN_API_KEY", str(&cfg.Auth.AdminAPIKey)},
+		{"JWT_SECRET", str(&cfg.Auth.JWTSecret)},
+		{"JWT_TTL", duration(&cfg.Auth.TokenTTL)},
+		{"AUTH_DEFAULT_ROLE", str(&cfg.Auth.DefaultRole)},
+		{"RATE_LIMIT_TRUST_FORWARDED_FOR", boolean(&cfg.RateLimit.TrustForwardedFor)},
+		{"RATE_LIMIT_READ_RATE", number(&cfg.RateLimit.Read.Rate)},
+		{"RATE_LIMIT_READ_BURST", integer(&cfg.RateLimit.Read.Burst)},
+		{"RATE_LIMIT_WRITE_RATE", number(&cfg.RateLimit.Write.Rate)},
+		{"RATE_LIMIT_WRITE_BURST", integer(&cfg.RateLimit.Write.Burst)},
+		{"RATE_LIMIT_AUTH_RATE", number(&cfg.RateLimit.Auth.Rate)},
+		{"RATE_LIMIT_AUTH_BURST", integer(&cfg.RateLimit.Auth.Burst)},
+	} {
+		if v := os.Getenv(env.name); v != "" {
+			if err := env.apply(v); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", env.name, v, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate reports every setting that is out of range.
+func (cfg Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Port > 0 && cfg.Port < 65536, "port must be between 1 and 65535, got %d", cfg.Port)
+	check(cfg.GRPCPort >= 0 && cfg.GRPCPort < 65536, "grpc port must be between 0 and 65535, got %d", cfg.GRPCPort)
+	check(cfg.GRPCPort != cfg.Port, "grpc port must differ from the HTTP port %d", cfg.Port)
+	check(cfg.DebugPort >= 0 && cfg.DebugPort < 65536, "debug port must be between 0 and 65535, got %d", cfg.DebugPort)
+	check(cfg.DebugPort == 0 || (cfg.DebugPort != cfg.Port && cfg.DebugPort != cfg.GRPCPort && cfg.DebugPort != cfg.TLS.RedirectPort),
+		"debug port must differ from the HTTP, gRPC and redirect ports")
+	check(cfg.CoordinatePrecision >= -1 && cfg.CoordinatePrecision <= maxCoordinatePrecision,
+		"coordinate precision must be between -1 and %d, got %d", maxCoordinatePrecision, cfg.CoordinatePrecision)
+	check(len(cfg.Worlds) > 0, "at least one world must be configured")
+	seenWorlds := map[string]bool{}
+	for _, name := range cfg.Worlds {
+		check(worldNamePattern.MatchString(name), "world name %q must be 1-32 lowercase letters, digits or hyphens", name)
+		check(!seenWorlds[name], "world %q is listed twice", name)
+		seenWorlds[name] = true
+	}
+	seenLayers := map[string]bool{builtinLayer: true}
+	for _, l := range cfg.Layers {
+		check(worldNamePattern.MatchString(l.Name), "layer name %q must be 1-32 lowercase letters, digits or hyphens", l.Name)
+		check(!seenLayers[l.Name], "layer %q is declared twice or is built in", l.Name)
+		seenLayers[l.Name] = true
+		check(l.Read == "" || roleRank[l.Read] > 0, "layer %s: unknown read role %q", l.Name, l.Read)
+		check(l.Write == "" || roleRank[l.Write] > 0, "layer %s: unknown write role %q", l.Name, l.Write)
+		seenFields := map[string]bool{}
+		for _, f := range l.Fields {
+			check(layerFieldPattern.MatchString(f.Name) && !reservedLayerParams[f.Name],
+				"layer %s: field name %q must be 1-32 letters, digits or underscores and not a query parameter", l.Name, f.Name)
+			check(!seenFields[f.Name], "layer %s: field %q is declared twice", l.Name, f.Name)
+			seenFields[f.Name] = true
+			check(layerFieldTypes[f.Type], "layer %s: field %s has unknown type %q", l.Name, f.Name, f.Type)
+			check(len(f.Enum) == 0 || f.Type == layerString || f.Type == layerStrings,
+				"layer %s: only string fields may have an enum, %s is %s", l.Name, f.Name, f.Type)
+		}
+	}
+	seenSpaces := map[string]bool{gameSpace: true}
+	for _, sp := range cfg.Spaces {
+		check(worldNamePattern.MatchString(sp.Name), "space name %q must be 1-32 lowercase letters, digits or hyphens", sp.Name)
+		check(!seenSpaces[sp.Name], "space %q is declared twice or is built in", sp.Name)
+		seenSpaces[sp.Name] = true
+		finite := true
+		for _, v := range sp.Transform {
+			finite = finite && isFinite(v)
+		}
+		check(finite, "space %s: transform must be finite numbers", sp.Name)
+		check(!finite || affine(sp.Transform).invertible(), "space %s: transform must be invertible", sp.Name)
+	}
+	check(cfg.RefreshInterval > 0, "refresh interval must be positive, got %s", cfg.RefreshInterval)
+	check(cfg.CacheLoadWait >= 0, "cache load wait must not be negative, got %s", cfg.CacheLoadWait)
+	for key, ttl := range cfg.CacheTTLs {
+		known := key == "map"
+		for _, l := range cfg.Layers {
+			known = known || key == layerCollection(l.Name)
+		}
+		for _, d := range datasets {
+			known = known || d.collection() == key
+		}
+		check(known, "cache TTL for unknown cache %q", key)
+		check(ttl >= 0, "cache TTL of %s must not be negative, got %s", key, ttl)
+	}
+	check(cfg.ShutdownTimeout >= 0, "shutdown timeout must not be negative, got %s", cfg.ShutdownTimeout)
+	check(cfg.RefreshFailureThreshold >= 0, "refresh failure threshold must not be negative, got %s", cfg.RefreshFailureThreshold)
+	check(cfg.MaxWriteStaleness >= 0, "max write staleness must not be negative, got %s", cfg.MaxWriteStaleness)
+	check(cfg.Mongo.Database != "", "mongo database must not be empty")
+	check(cfg.Mongo.Collection != "", "mongo collection must not be empty")
+	check(cfg.Mongo.PoolSize > 0, "mongo pool size must be positive, got %d", cfg.Mongo.PoolSize)
+	check(cfg.Mongo.MaxConcurrency > 0, "mongo max concurrency must be positive, got %d", cfg.Mongo.MaxConcurrency)
+	check(cfg.Mongo.WaitTimeout >= 0, "mongo wait timeout must not be negative, got %s", cfg.Mongo.WaitTimeout)
+	check(cfg.Mongo.QueryTimeout > 0, "mongo query timeout must be positive, got %s", cfg.Mongo.QueryTimeout)
+	check(cfg.Mongo.ConnectRetries >= 0, "mongo connect retries must not be negative, got %d", cfg.Mongo.ConnectRetries)
+	check(cfg.Mongo.ConnectBackoff > 0, "mongo connect backoff must be positive, got %s", cfg.Mongo.ConnectBackoff)
+	check(cfg.Mongo.AppName != "", "mongo app name must not be empty")
+	check(isFinite(cfg.Mongo.GeoIndexMin) && isFinite(cfg.Mongo.GeoIndexMax) && cfg.Mongo.GeoIndexMin < cfg.Mongo.GeoIndexMax,
+		"mongo geo index min must be below geo index max, both finite, got %v and %v", cfg.Mongo.GeoIndexMin, cfg.Mongo.GeoIndexMax)
+	if cfg.Mongo.ReadPreference != "" {
+		_, err := readpref.ModeFromString(cfg.Mongo.ReadPreference)
+		check(err == nil, "mongo read preference: %v", err)
+	}
+	check(cfg.Storage.Backend == storageMongo || cfg.Storage.Backend == storageMemory,
+		"storage backend must be %s or %s, got %q", storageMongo, storageMemory, cfg.Storage.Backend)
+	check(cfg.Storage.File == "" || cfg.Storage.Backend == storageMemory, "storage file needs the %s backend", storageMemory)
+	for _, field := range cfg.Redaction.Fields {
+		_, ok := locationRedactors[field]
+		check(ok, "redacted field %q must be one of the optional location fields", field)
+	}
+	for _, tag := range cfg.Redaction.Tags {
+		check(slugPattern.MatchString(tag), "redacted tag %q must be lower-case letters, digits and hyphens", tag)
+	}
+	check(roleRank[cfg.Redaction.Role] > 0, "redaction role must be viewer, contributor or admin, got %q", cfg.Redaction.Role)
+	check(cfg.Validation.MaxNameLength >= 1, "max location name length must be positive, got %d", cfg.Validation.MaxNameLength)
+	if cfg.Validation.MapBounds != "" {
+		_, err := parseBounds(cfg.Validation.MapBounds)
+		check(err == nil, "map bounds: %v", err)
+	}
+	switch cfg.Election.Backend {
+	case "", electionMongo:
+	case electionRedis:
+		check(cfg.Redis.URL != "", "election backend redis needs a Redis URL")
+	default:
+		check(false, "election backend must be %s or %s, got %q", electionMongo, electionRedis, cfg.Election.Backend)
+	}
+	check(cfg.Election.Lease >= time.Second, "election lease must be at least 1s, got %s", cfg.Election.Lease)
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read header", cfg.HTTP.ReadHeaderTimeout}, {"read", cfg.HTTP.ReadTimeout},
+		{"write", cfg.HTTP.WriteTimeout}, {"idle", cfg.HTTP.IdleTimeout},
+	} {
+		check(timeout.value >= 0, "http %s timeout must not be negative, got %s", timeout.name, timeout.value)
+	}
+	check(cfg.HTTP.MaxHeaderBytes > 0, "http max header bytes must be positive, got %d", cfg.HTTP.MaxHeaderBytes)
+	check(cfg.HTTP.MaxBodyBytes > 0, "http max body bytes must be positive, got %d", cfg.HTTP.MaxBodyBytes)
+	check(slices.Contains(locationSchemas, cfg.HTTP.DefaultSchema), "http default schema must be one of %s, got %q", strings.Join(locationSchemas, ", "), cfg.HTTP.DefaultSchema)
+	check((cfg.TLS.CertFile == "") == (cfg.TLS.KeyFile == ""), "tls cert file and key file must be set together")
+	check(cfg.TLS.CertFile == "" || len(cfg.TLS.AutocertDomains) == 0, "tls cert files and autocert domains are mutually exclusive")
+	check(len(cfg.TLS.AutocertDomains) == 0 || cfg.TLS.AutocertCacheDir != "", "tls autocert cache dir must not be empty")
+	check(cfg.TLS.RedirectPort >= 0 && cfg.TLS.RedirectPort < 65536, "tls redirect port must be between 0 and 65535, got %d", cfg.TLS.RedirectPort)
+	check(cfg.TLS.RedirectPort == 0 || cfg.TLS.enabled(), "tls redirect port needs a certificate or autocert domains")
+	check(cfg.TLS.RedirectPort == 0 || (cfg.TLS.RedirectPort != cfg.Port && cfg.TLS.RedirectPort != cfg.GRPCPort),
+		"tls redirect port must differ from the HTTP and gRPC ports")
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing sample ratio must be between 0 and 1, got %g", cfg.Tracing.SampleRatio)
+	check(cfg.Tiles.Dir == "" || !cfg.Tiles.GridFS, "tiles dir and tiles GridFS are mutually exclusive")
+	check(cfg.Tiles.MaxAge >= 0, "tiles max age must not be negative, got %s", cfg.Tiles.MaxAge)
+	check(cfg.Webhooks.Timeout > 0, "webhook timeout must be positive, got %s", cfg.Webhooks.Timeout)
+	check(cfg.Webhooks.MaxAttempts >= 1, "webhook max attempts must be at least 1, got %d", cfg.Webhooks.MaxAttempts)
+	check(cfg.Webhooks.RetryBackoff > 0, "webhook retry backoff must be positive, got %s", cfg.Webhooks.RetryBackoff)
+	check(cfg.Backup.Dir == "" || cfg.Backup.S3Bucket == "", "backup dir and backup S3 bucket are mutually exclusive")
+	check(cfg.Backup.Interval >= 0, "backup interval must not be negative, got %s", cfg.Backup.Interval)
+	check(cfg.Backup.Interval == 0 || cfg.Backup.Dir != "" || cfg.Backup.S3Bucket != "", "backup interval needs a backup dir or S3 bucket")
+	check(cfg.Backup.Keep >= 0, "backup keep must not be negative, got %d", cfg.Backup.Keep)
+	check(cfg.CDN.SharedMaxAge >= 0, "cdn shared max age must not be negative, got %s", cfg.CDN.SharedMaxAge)
+	check(cfg.CDN.Provider == "" || cfg.CDN.Provider == cdnCloudflare || cfg.CDN.Provider == cdnFastly,
+		"cdn provider must be cloudflare or fastly, got %q", cfg.CDN.Provider)
+	check(cfg.CDN.Provider == "" || (cfg.CDN.Zone != "" && cfg.CDN.Token != ""), "cdn provider needs a zone and a token")
+	check(cfg.CDN.PurgeURL == "" || strings.HasPrefix(cfg.CDN.PurgeURL, "http://") || strings.HasPrefix(cfg.CDN.PurgeURL, "https://"),
+		"cdn purge url must start with http:// or https://, got %q", cfg.CDN.PurgeURL)
+	check(!cfg.Analytics.Enabled || cfg.Analytics.FlushInterval > 0, "analytics flush interval must be positive, got %s", cfg.Analytics.FlushInterval)
+	check(!cfg.Analytics.Enabled || cfg.Analytics.Retention >= 24*time.Hour, "analytics retention must be at least 24h, got %s", cfg.Analytics.Retention)
+	check(cfg.Discord.Prefix != "" && !strings.ContainsAny(cfg.Discord.Prefix, " \t\n"), "discord prefix must be non-empty without spaces, got %q", cfg.Discord.Prefix)
+	for _, a := range cfg.Discord.Announce {
+		check(a == auditCreate || a == auditUpdate || a == auditDelete, "discord announce entries must be create, update or delete, got %q", a)
+	}
+	check(cfg.Webhooks.QueueSize >= 1, "webhook queue size must be at least 1, got %d", cfg.Webhooks.QueueSize)
+	check(cfg.CORS.MaxAge >= 0, "cors max age must not be negative, got %s", cfg.CORS.MaxAge)
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		check(origin == "*" || strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"),
+			"cors origin %q must be * or start with http:// or https://", origin)
+	}
+
+	check(cfg.Auth.JWTSecret == "" || len(cfg.Auth.JWTSecret) >= 32, "jwt secret must be at least 32 bytes")
+	check(cfg.Auth.TokenTTL > 0, "jwt ttl must be positive, got %s", cfg.Auth.TokenTTL)
+	check(roleRank[cfg.Auth.DefaultRole] > 0, "default role must be viewer, contributor or admin, got %q", cfg.Auth.DefaultRole)
+	for _, group := range []string{rateGroupRead, rateGroupWrite, rateGroupAuth} {
+		limit := cfg.RateLimit.group(group)
+		check(limit.Rate >= 0 && isFinite(limit.Rate), "%s rate limit must be a non-negative number, got %v", group, limit.Rate)
+		check(limit.Rate == 0 || limit.Burst >= 1, "%s rate limit burst must be at least 1, got %d", group, limit.Burst)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers browser clients may read on
+// cross-origin responses besides the CORS-safelisted ones.
+var corsExposedHeaders = []string{
+	"ETag", "X-Request-ID", "X-Original-Count", "X-Returned-Count", "X-Result-Truncated", "Retry-After",
+	"X-Total-Count", "Link", "X-Data-Stale-Since", "Deprecation", "Sunset", serverVersionHeader,
+}
+
+// corsOriginAllowed reports whether origin is on config.CORS.AllowedOrigins;
+// a "*" entry allows any origin.
+func corsOriginAllowed(origin string) bool {
+	for _, allowed := range config.CORS.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS adds CORS headers for allow-listed origins and answers preflight
+// requests itself. Requests without an Origin header are passed through
+// untouched, as are requests from origins not on the list (the browser then
+// blocks the response). Preflights from those origins get a 403.
+func withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !corsOriginAllowed(origin) {
+			if preflight {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			handler(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !slices.Contains(config.CORS.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+			http.Error(w, "Method not allowed", http.StatusForbidden)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(config.CORS.AllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(config.CORS.AllowedHeaders, ", "))
+		if config.CORS.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORS.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
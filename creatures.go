@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCreatureSpawns caps the spawn list of a single creature.
+const maxCreatureSpawns = 200
+
+// Creature is a bestiary entry with the places it spawns, stored in the
+// creatures collection.
+type Creature struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// DangerTier runs from 1 (harmless) to maxDangerLevel, on the same scale
+	// as MapLocation.DangerLevel
+	DangerTier int             `json:"dangerTier" bson:"dangerTier"`
+	Spawns     []CreatureSpawn `json:"spawns" bson:"spawns"`
+}
+
+// CreatureSpawn is one place a creature is found.
+type CreatureSpawn struct {
+	// Location is the ID of the map location
+	Location string `json:"location" bson:"location"`
+	// Region defaults to the region of the map location
+	Region string `json:"region,omitempty" bson:"region,omitempty"`
+}
+
+func (c Creature) RecordID() string       { return c.ID }
+func (c *Creature) setRecordID(id string) { c.ID = id }
+
+// creatures is served at /api/creatures.
+var creatures = newDataset("creatures", "creature", checkCreature, creatureFilter)
+
+// checkCreature validates c and fills in the region of each spawn from the
+// cached map snapshot, if one is loaded.
+func checkCreature(c *Creature) []FieldError {
+	var errs []FieldError
+
+	if strings.TrimSpace(c.Name) == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "must not be empty"})
+	} else if n := utf8.RuneCountInString(c.Name); n > validationRules.MaxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters, got %d", validationRules.MaxNameLength, n)})
+	}
+	if c.DangerTier < 1 || c.DangerTier > maxDangerLevel {
+		errs = append(errs, FieldError{Field: "dangerTier", Message: fmt.Sprintf("must be between 1 and %d, got %d", maxDangerLevel, c.DangerTier)})
+	}
+	if len(c.Spawns) > maxCreatureSpawns {
+		errs = append(errs, FieldError{Field: "spawns", Message: fmt.Sprintf("must have at most %d entries, got %d", maxCreatureSpawns, len(c.Spawns))})
+	}
+	if c.Spawns == nil {
+		c.Spawns = []CreatureSpawn{}
+	}
+
+	snap := cache.Peek()
+	for i := range c.Spawns {
+		spawn := &c.Spawns[i]
+		if spawn.Location == "" {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("spawns[%d].location", i), Message: "must not be empty"})
+			continue
+		}
+		if snap == nil || len(snap.locations) == 0 {
+			continue
+		}
+		loc, found := snap.lookup(spawn.Location)
+		if !found {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("spawns[%d].location", i), Message: fmt.Sprintf("no map location has ID %q", spawn.Location)})
+		} else if spawn.Region == "" {
+			spawn.Region = loc.Region
+		}
+	}
+
+	return errs
+}
+
+// creatureFilter selects creatures by ?region=, matching any of their spawns
+// ignoring case, and by ?danger=, a tier such as 3 or a range such as 2-4.
+func creatureFilter(q url.Values) (func(*Creature) bool, error) {
+	region := strings.TrimSpace(q.Get("region"))
+	lo, hi := 1, maxDangerLevel
+	if v := q.Get("danger"); v != "" {
+		from, to, isRange := strings.Cut(v, "-")
+		if !isRange {
+			to = from
+		}
+		var errLo, errHi error
+		lo, errLo = strconv.Atoi(from)
+		hi, errHi = strconv.Atoi(to)
+		if errLo != nil || errHi != nil || lo < 1 || hi > maxDangerLevel || lo > hi {
+			return nil, fmt.Errorf("danger must be a tier or range of tiers between 1 and %d, such as 3 or 2-4", maxDangerLevel)
+		}
+	}
+	if region == "" && lo == 1 && hi == maxDangerLevel {
+		return nil, nil
+	}
+
+	return func(c *Creature) bool {
+		if c.DangerTier < lo || c.DangerTier > hi {
+			return false
+		}
+		if region == "" {
+			return true
+		}
+		for _, spawn := range c.Spawns {
+			if strings.EqualFold(spawn.Region, region) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
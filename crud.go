@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// maxLocationBody caps the size of a single location payload in bytes.
+const maxLocationBody = 64 << 10
+
+// invalidateCache marks the cached snapshot of the request's world stale so
+// the next read reloads it from storage.
+func invalidateCache(ctx context.Context) {
+	worldFor(ctx).cache.Invalidate()
+}
+
+// decodeLocation reads and validates a MapLocation request body. When id is
+// non-empty it is the ID from the URL: the body may omit its ID but must not
+// contradict it. On failure the error response is written and false returned.
+func decodeLocation(w http.ResponseWriter, r *http.Request, id string, loc *MapLocation) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(loc); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+
+	if id != "" {
+		if loc.ID != "" && loc.ID != id {
+			writeValidationErrors(w, r, "Invalid map location", []FieldError{{Field: "id", Message: "does not match the URL"}})
+			return false
+		}
+		loc.ID = id
+	}
+
+	if errs := validateNewLocation(r.Context(), *loc); errs != nil {
+		writeValidationErrors(w, r, "Invalid map location", errs)
+		return false
+	}
+	return true
+}
+
+// writeProblem answers with an RFC 7807 problem of the given status.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	validation.Write(w, r, validation.NewProblem(status, detail))
+}
+
+// writeValidationErrors answers 400 with an RFC 7807 problem carrying
+// message and the list of field violations.
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, message string, errs []FieldError) {
+	validation.Write(w, r, validation.Invalid(message, errs))
+}
+
+// writeStorageError answers a failed write with a problem fitting err.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		writeProblem(w, r, http.StatusConflict, "A map location with this ID already exists")
+	case errors.Is(err, ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "Map location not found")
+	case errors.Is(err, ErrRejected):
+		writeProblem(w, r, http.StatusBadRequest, "The storage backend rejected the map location: "+err.Error())
+	default:
+		logFor(r.Context()).Error("failed to write map data", "error", err)
+		writeProblem(w, r, http.StatusInternalServerError, "Failed to write map data")
+	}
+}
+
+// createMapLocationHandler inserts a new location from the request body.
+func createMapLocationHandler(w http.ResponseWriter, r *http.Request) {
+	var loc MapLocation
+	if !decodeLocation(w, r, "", &loc) {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	stampLocation(&loc, 0)
+	if err := storeFor(ctx).InsertLocation(ctx, loc); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	invalidateCache(ctx)
+	recordAudit(ctx, auditCreate, worldFor(ctx).collection(), loc.ID, nil, loc)
+	notifyWebhooks(ctx, auditCreate, loc.ID, &loc)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", locationETag(loc))
+	w.Header().Set("Location", publicPath(r.Context(), "/api/map/"+url.PathEscape(loc.ID)))
+	w.WriteHeader(http.StatusCreated)
+
+	writeJSON(w, loc, "map location")
+}
+
+// updateMapLocationHandler replaces the location at /api/map/{id} with the
+// request body, creating it if it does not exist yet. The body may omit the ID
+// but must not contradict the path.
+//
+// Replacing an existing location needs the version the edit is based on,
+// as If-Match with the ETag from GET /api/map/{id} or as the body's version,
+// so that concurrent editors don't silently overwrite each other. If-Match:
+// * replaces whatever version is stored. A mismatch answers 409 with the
+// current location; without either, only a new location can be created.
+// Clients that only track updatedAt can send If-Unmodified-Since instead,
+// which answers 412 once the location has changed since.
+func updateMapLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+
+	var loc MapLocation
+	if !decodeLocation(w, r, id, &loc) {
+		return
+	}
+	version, conditional, ok := locationPrecondition(w, r, loc)
+	if !ok {
+		return
+	}
+	since, hasSince := ifUnmodifiedSince(r)
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	before := auditedLocation(ctx, loc.ID)
+	if hasSince {
+		cur, ok := unmodifiedLocation(w, r, ctx, id, since)
+		if !ok {
+			return
+		}
+		// The version filter of the replace catches changes made after this
+		// read, so the check and the write don't race
+		if !conditional || version == anyVersion {
+			version, conditional = cur.Version, true
+		}
+	}
+	created := !conditional
+	if version == anyVersion {
+		cur, err := storeFor(ctx).GetLocation(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			writeProblem(w, r, http.StatusPreconditionFailed, "If-Match: * needs an existing map location")
+			return
+		}
+		if err != nil {
+			writeLoadError(w, r, err)
+			return
+		}
+		version = cur.Version
+	}
+	stampLocation(&loc, version)
+	if conditional {
+		err = storeFor(ctx).ReplaceLocation(ctx, loc, version)
+	} else {
+		err = storeFor(ctx).InsertLocation(ctx, loc)
+	}
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		writeProblem(w, r, http.StatusPreconditionRequired, "Replacing a map location needs If-Match or the version it is based on")
+		return
+	case errors.Is(err, ErrVersionConflict) && hasSince:
+		writeProblem(w, r, http.StatusPreconditionFailed, "The map location changed since If-Unmodified-Since")
+		return
+	case errors.Is(err, ErrVersionConflict):
+		writeVersionConflict(w, r, ctx, id)
+		return
+	case err != nil:
+		writeStorageError(w, r, err)
+		return
+	}
+	invalidateCache(ctx)
+	if created {
+		recordAudit(ctx, auditCreate, worldFor(ctx).collection(), loc.ID, nil, loc)
+		notifyWebhooks(ctx, auditCreate, loc.ID, &loc)
+	} else {
+		recordAudit(ctx, auditUpdate, worldFor(ctx).collection(), loc.ID, before, loc)
+		notifyWebhooks(ctx, auditUpdate, loc.ID, &loc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", locationETag(loc))
+	if created {
+		w.Header().Set("Location", publicPath(r.Context(), "/api/map/"+url.PathEscape(loc.ID)))
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	writeJSON(w, loc, "map location")
+}
+
+// anyVersion is the version locationPrecondition reports for If-Match: *.
+const anyVersion = -1
+
+// locationPrecondition returns the version a PUT of loc is based on, from
+// If-Match or else the body. conditional is false when there is neither. On
+// failure the error response is written and ok is false.
+func locationPrecondition(w http.ResponseWriter, r *http.Request, loc MapLocation) (version int64, conditional, ok bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case ifMatch == "*":
+		return anyVersion, true, true
+	case ifMatch != "":
+		// Weak tags never match: If-Match uses the strong comparison
+		v, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+		if err != nil || v < 0 || !strings.HasPrefix(ifMatch, `"`) {
+			writeProblem(w, r, http.StatusPreconditionFailed, "If-Match must be the ETag of the map location")
+			return 0, false, false
+		}
+		return v, true, true
+	case loc.Version > 0:
+		return loc.Version, true, true
+	}
+	return 0, false, true
+}
+
+// ifUnmodifiedSince returns the time of the request's If-Unmodified-Since.
+// As RFC 9110 prescribes, it is ignored alongside If-Match and when it is not
+// a valid HTTP date.
+func ifUnmodifiedSince(r *http.Request) (time.Time, bool) {
+	if r.Header.Get("If-Match") != "" {
+		return time.Time{}, false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	return since, err == nil
+}
+
+// unmodifiedLocation returns the stored location with the given ID if its
+// updatedAt is no later than since, to the second HTTP dates are given in.
+// Locations written before updatedAt was kept count as unmodified. On
+// failure the error response, 412 when the location is missing or changed,
+// is written and ok is false.
+func unmodifiedLocation(w http.ResponseWriter, r *http.Request, ctx context.Context, id string, since time.Time) (loc MapLocation, ok bool) {
+	cur, err := storeFor(ctx).GetLocation(ctx, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeProblem(w, r, http.StatusPreconditionFailed, "If-Unmodified-Since needs an existing map location")
+		return MapLocation{}, false
+	case err != nil:
+		writeLoadError(w, r, err)
+		return MapLocation{}, false
+	case cur.UpdatedAt != nil && cur.UpdatedAt.Truncate(time.Second).After(since):
+		writeProblem(w, r, http.StatusPreconditionFailed, "The map location changed since If-Unmodified-Since")
+		return MapLocation{}, false
+	}
+	return cur, true
+}
+
+// writeVersionConflict answers 409 with the location as it is stored now,
+// so the client can merge its edit and retry with the current ETag.
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, ctx context.Context, id string) {
+	cur, err := storeFor(ctx).GetLocation(ctx, id)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", locationETag(cur))
+	w.WriteHeader(http.StatusConflict)
+	writeJSON(w, cur, "map location")
+}
+
+// deleteMapLocationHandler removes the location at /api/map/{id}, with
+// If-Unmodified-Since only if it has not changed since.
+func deleteMapLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	since, hasSince := ifUnmodifiedSince(r)
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	version := int64(anyVersion)
+	if hasSince {
+		cur, ok := unmodifiedLocation(w, r, ctx, id, since)
+		if !ok {
+			return
+		}
+		// As with a replace, the version filter catches changes made after
+		// this read
+		version = cur.Version
+	}
+	before := auditedLocation(ctx, id)
+	err = storeFor(ctx).DeleteLocation(ctx, id, version)
+	switch {
+	case errors.Is(err, ErrVersionConflict):
+		writeProblem(w, r, http.StatusPreconditionFailed, "The map location changed since If-Unmodified-Since")
+		return
+	case err != nil:
+		writeStorageError(w, r, err)
+		return
+	}
+	invalidateCache(ctx)
+	// A trashed location keeps its image until it is purged
+	if _, ok := storeFor(ctx).(TrashStorage); !ok {
+		deleteLocationImage(ctx, id)
+	}
+	recordAudit(ctx, auditDelete, worldFor(ctx).collection(), id, before, nil)
+	notifyWebhooks(ctx, auditDelete, id, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
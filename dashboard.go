@@ -0,0 +1,230 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sizes of the in-process request statistics behind the dashboard.
+const (
+	// rateWindow is the span request rates are averaged over, kept as one
+	// bucket per second
+	rateWindow = 60
+	// maxRecentErrors is how many failed requests and refreshes are kept
+	maxRecentErrors = 50
+)
+
+// processStart is when the process started, for the dashboard's uptime.
+var processStart = time.Now()
+
+// streamClients and wsClients count the open /api/map/stream and /ws
+// connections.
+var streamClients, wsClients atomic.Int64
+
+// rateCounter counts events per second over the last rateWindow seconds.
+type rateCounter struct {
+	counts [rateWindow]uint64
+	// seconds holds the Unix second each bucket counts, so buckets left
+	// over from earlier windows are told apart and reset
+	seconds [rateWindow]int64
+}
+
+func (c *rateCounter) add(now int64) {
+	i := now % rateWindow
+	if c.seconds[i] != now {
+		c.seconds[i], c.counts[i] = now, 0
+	}
+	c.counts[i]++
+}
+
+// perSecond returns the average rate over the window ending at now.
+func (c *rateCounter) perSecond(now int64) float64 {
+	var n uint64
+	for i, second := range c.seconds {
+		if now-second < rateWindow {
+			n += c.counts[i]
+		}
+	}
+	return float64(n) / rateWindow
+}
+
+// routeStats accumulates the requests to one route.
+type routeStats struct {
+	requests, clientErrors, serverErrors uint64
+	duration                             time.Duration
+	rate                                 rateCounter
+}
+
+// requestStats is what instrument and recordRefresh feed the dashboard.
+var requestStats struct {
+	mu     sync.Mutex
+	routes map[string]*routeStats
+	rate   rateCounter
+	// errors is a ring of the latest failures; next is where the next one
+	// goes
+	errors [maxRecentErrors]RecentError
+	next   int
+	total  int
+}
+
+// RecentError is a request answered with a 5xx status or a failed background
+// refresh.
+type RecentError struct {
+	Time time.Time `json:"time"`
+	// Route, Method, Path, Status and RequestID are set for requests, and
+	// Message for refreshes
+	Route     string `json:"route,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// recordRequest counts a served request under its route label.
+func recordRequest(r *http.Request, route string, status int, elapsed time.Duration) {
+	now := time.Now()
+	requestStats.mu.Lock()
+	defer requestStats.mu.Unlock()
+
+	if requestStats.routes == nil {
+		requestStats.routes = make(map[string]*routeStats)
+	}
+	stats := requestStats.routes[route]
+	if stats == nil {
+		stats = &routeStats{}
+		requestStats.routes[route] = stats
+	}
+	stats.requests++
+	stats.duration += elapsed
+	stats.rate.add(now.Unix())
+	requestStats.rate.add(now.Unix())
+	switch {
+	case status >= http.StatusInternalServerError:
+		stats.serverErrors++
+		id, _ := r.Context().Value(requestIDKey{}).(string)
+		recordError(RecentError{Time: now.UTC(), Route: route, Method: r.Method, Path: r.URL.Path, Status: status, RequestID: id})
+	case status >= http.StatusBadRequest:
+		stats.clientErrors++
+	}
+}
+
+// recordError adds e to the recent errors. The caller holds requestStats.mu.
+func recordError(e RecentError) {
+	requestStats.errors[requestStats.next] = e
+	requestStats.next = (requestStats.next + 1) % maxRecentErrors
+	requestStats.total++
+}
+
+// recordRefreshError adds a failed background refresh to the recent errors.
+func recordRefreshError(err error) {
+	requestStats.mu.Lock()
+	defer requestStats.mu.Unlock()
+	recordError(RecentError{Time: time.Now().UTC(), Message: "refresh failed: " + err.Error()})
+}
+
+// RouteReport is one route in the dashboard, as registered.
+type RouteReport struct {
+	Route        string  `json:"route"`
+	Requests     uint64  `json:"requests"`
+	ClientErrors uint64  `json:"clientErrors"`
+	ServerErrors uint64  `json:"serverErrors"`
+	PerSecond    float64 `json:"perSecond"`
+	MeanMillis   float64 `json:"meanMillis"`
+}
+
+// DashboardReport is the /api/admin/dashboard response body. Counts are since
+// the process started and rates over the last minute.
+type DashboardReport struct {
+	Version       string  `json:"version"`
+	StartedAt     string  `json:"startedAt"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Goroutines    int     `json:"goroutines"`
+	Requests      uint64  `json:"requests"`
+	PerSecond     float64 `json:"perSecond"`
+	// Routes are the routes requested so far, busiest first
+	Routes []RouteReport `json:"routes"`
+	// RecentErrors are the latest failures, newest first
+	RecentErrors []RecentError     `json:"recentErrors"`
+	Clients      ClientsReport     `json:"clients"`
+	Cache        CacheReport       `json:"cache"`
+	Connection   *ConnectionStatus `json:"connection,omitempty"`
+}
+
+// ClientsReport counts the open streaming connections.
+type ClientsReport struct {
+	Stream    int64 `json:"stream"`
+	WebSocket int64 `json:"webSocket"`
+}
+
+// dashboardReport gathers the dashboard from the in-process counters.
+func dashboardReport() DashboardReport {
+	report := DashboardReport{
+		Version:       version,
+		StartedAt:     processStart.UTC().Format(time.RFC3339),
+		UptimeSeconds: time.Since(processStart).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		Routes:        []RouteReport{},
+		RecentErrors:  []RecentError{},
+		Clients:       ClientsReport{Stream: streamClients.Load(), WebSocket: wsClients.Load()},
+		Cache:         cacheReport(),
+	}
+	if cs, ok := store.(ConnectionStorage); ok {
+		status := cs.ConnectionStatus()
+		report.Connection = &status
+	}
+
+	now := time.Now().Unix()
+	requestStats.mu.Lock()
+	report.PerSecond = requestStats.rate.perSecond(now)
+	for route, stats := range requestStats.routes {
+		report.Requests += stats.requests
+		report.Routes = append(report.Routes, RouteReport{
+			Route:        route,
+			Requests:     stats.requests,
+			ClientErrors: stats.clientErrors,
+			ServerErrors: stats.serverErrors,
+			PerSecond:    stats.rate.perSecond(now),
+			MeanMillis:   float64(stats.duration.Microseconds()) / float64(stats.requests) / 1000,
+		})
+	}
+	for i := 1; i <= min(requestStats.total, maxRecentErrors); i++ {
+		report.RecentErrors = append(report.RecentErrors, requestStats.errors[(requestStats.next-i+maxRecentErrors)%maxRecentErrors])
+	}
+	requestStats.mu.Unlock()
+
+	sort.Slice(report.Routes, func(i, j int) bool {
+		a, b := report.Routes[i], report.Routes[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Route < b.Route
+	})
+	return report
+}
+
+// dashboardHandler serves the figures behind the /admin dashboard.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, dashboardReport(), "dashboard")
+}
+
+// dashboardPage is the /admin dashboard. It holds no data itself: it asks for
+// an API key or token and polls /api/admin/dashboard with it, so it needs no
+// Grafana or other stack and loads nothing from outside.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardPageHandler serves the dashboard page at /admin.
+func dashboardPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(dashboardPage)
+}
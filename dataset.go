@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"example/souforged/cached"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Record is a document of one of the datasets kept next to the map
+// locations, such as resource nodes. Record types also implement
+// setRecordID on their pointer so that PUT can take the ID from the URL.
+type Record interface {
+	RecordID() string
+}
+
+// RecordStore persists one dataset. It returns ErrNotFound and
+// ErrAlreadyExists like Storage does.
+type RecordStore[T Record] interface {
+	// List returns every record, ordered by ID.
+	List(ctx context.Context) ([]T, error)
+	// Get returns the record with the given ID or ErrNotFound.
+	Get(ctx context.Context, id string) (T, error)
+	// Insert stores a new record or fails with ErrAlreadyExists.
+	Insert(ctx context.Context, rec T) error
+	// Upsert creates or replaces the record with rec's ID and reports
+	// whether it was created.
+	Upsert(ctx context.Context, rec T) (created bool, err error)
+	// Delete removes the record with the given ID or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+}
+
+// recordStoreFor returns the current backend's store for the dataset kept in
+// the named collection.
+func recordStoreFor[T Record](collection string) (RecordStore[T], error) {
+	switch s := store.(type) {
+	case *mongoStorage:
+		return newMongoRecords[T](s, collection), nil
+	case *memoryStorage:
+		return newMemoryRecords[T](), nil
+	default:
+		return nil, fmt.Errorf("the storage backend does not support the %s dataset", collection)
+	}
+}
+
+// datasetSnapshot is one loaded copy of a dataset. Like cacheSnapshot it is
+// never modified once published.
+type datasetSnapshot[T Record] struct {
+	items      []T
+	generation uint64
+	hash       uint64
+	loadedAt   time.Time
+
+	byIDOnce sync.Once
+	byID     map[string]int
+}
+
+// lookup returns the record with the given ID.
+func (s *datasetSnapshot[T]) lookup(id string) (T, bool) {
+	s.byIDOnce.Do(func() {
+		s.byID = make(map[string]int, len(s.items))
+		for i, rec := range s.items {
+			s.byID[rec.RecordID()] = i
+		}
+	})
+	i, ok := s.byID[id]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return s.items[i], true
+}
+
+// recordsHash hashes the BSON encoding of each record in order, as
+// snapshotHash does for locations.
+func recordsHash[T Record](items []T) uint64 {
+	h := fnv.New64a()
+	for _, rec := range items {
+		raw, _ := bson.Marshal(rec)
+		h.Write(raw)
+	}
+	return h.Sum64()
+}
+
+// dataset is a record collection served under /api/{name} with the same
+// caching as the map: an immutable snapshot loaded on first use, reloaded by
+// the background refresher and marked stale by writes through the API.
+type dataset[T Record] struct {
+	// name is both the collection name and the route segment
+	name string
+	// route is where the records are served when not at /api/{name}
+	route string
+	// private keeps shared caches from storing records that need a role
+	// to read
+	private bool
+	// noun names a single record in messages, e.g. "resource node"
+	noun string
+	// check validates a decoded record and may fill in derived fields
+	check func(rec *T) []FieldError
+	// filter builds the predicate for the query of a list request; nil
+	// means every record matches
+	filter func(q url.Values) (func(rec *T) bool, error)
+
+	store RecordStore[T]
+	cache cached.Value[*datasetSnapshot[T]]
+}
+
+// datasetRefresher is the part of a dataset the background refresher and
+// startup need, whatever its record type.
+type datasetRefresher interface {
+	collection() string
+	open() error
+	refresh(ctx context.Context) error
+	cacheEntry() CacheEntry
+	// contentHash and invalidate let followers of an elected refresher
+	// tell their snapshot from the leader's and drop it
+	contentHash() (uint64, bool)
+	invalidate()
+	// dump and prepareRestore back up and restore the dataset's records
+	dump(ctx context.Context) (any, error)
+	prepareRestore(raw json.RawMessage) (restoreFunc, []string)
+	// public and loadRecords let /api/map/download bundle the datasets
+	// anyone may read, as cached
+	public() bool
+	loadRecords(ctx context.Context) ([]Record, uint64, error)
+	// basePath lets the analytics tell which dataset a route serves
+	basePath() string
+}
+
+// datasets lists every dataset, registered by newDataset.
+var datasets []datasetRefresher
+
+// newDataset declares a dataset and registers it with the refresher.
+func newDataset[T Record](name, noun string, check func(*T) []FieldError, filter func(url.Values) (func(*T) bool, error)) *dataset[T] {
+	d := &dataset[T]{name: name, noun: noun, check: check, filter: filter}
+	datasets = append(datasets, d)
+	return d
+}
+
+// openDatasets connects every dataset, the configured layers included, to
+// the storage backend. It must run after initStorage.
+func openDatasets() error {
+	declareLayers()
+	for _, d := range datasets {
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshDatasets reloads every dataset, returning the failures joined.
+func refreshDatasets(ctx context.Context) error {
+	var errs []error
+	for _, d := range datasets {
+		errs = append(errs, d.refresh(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (d *dataset[T]) collection() string { return d.name }
+
+func (d *dataset[T]) open() error {
+	s, err := recordStoreFor[T](d.name)
+	if err != nil {
+		return err
+	}
+	d.store = s
+	tuneCache(&d.cache, d.name, d.name)
+	return nil
+}
+
+// load returns the current snapshot, fetching the dataset from storage when
+// it has not been loaded yet or was invalidated.
+func (d *dataset[T]) load(ctx context.Context) (*datasetSnapshot[T], error) {
+	return d.cache.Get(ctx, func(ctx context.Context) (*datasetSnapshot[T], error) {
+		release, err := acquireMongo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return d.fetch(ctx)
+	})
+}
+
+func (d *dataset[T]) contentHash() (uint64, bool) {
+	if s := d.cache.Peek(); s != nil {
+		return s.hash, true
+	}
+	return 0, false
+}
+
+func (d *dataset[T]) public() bool { return !d.private }
+
+func (d *dataset[T]) loadRecords(ctx context.Context) ([]Record, uint64, error) {
+	snap, err := d.load(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	recs := make([]Record, len(snap.items))
+	for i, item := range snap.items {
+		recs[i] = item
+	}
+	return recs, snap.hash, nil
+}
+
+// refresh reloads the dataset from storage once.
+func (d *dataset[T]) refresh(ctx context.Context) error {
+	_, err := d.cache.Reload(ctx, func(ctx context.Context) (*datasetSnapshot[T], error) {
+		s, err := d.fetch(ctx)
+		if err != nil {
+			refreshFailures.Inc()
+			slog.Error("failed to refresh dataset", "dataset", d.name, "error", err)
+		}
+		return s, err
+	})
+	return err
+}
+
+// fetch reads the dataset from storage and returns its next snapshot, which
+// is the current one when nothing changed. It runs as a load of d.cache.
+func (d *dataset[T]) fetch(ctx context.Context) (*datasetSnapshot[T], error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	items, err := d.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := recordsHash(items)
+	prev := d.cache.Peek()
+	if prev != nil && len(prev.items) == len(items) && prev.hash == hash {
+		return prev, nil
+	}
+	next := &datasetSnapshot[T]{items: items, generation: 1, hash: hash, loadedAt: time.Now()}
+	if prev != nil {
+		next.generation = prev.generation + 1
+	}
+	slog.Debug("dataset updated", "dataset", d.name, "records", len(items))
+	return next, nil
+}
+
+// invalidate marks the snapshot stale so the next read reloads it.
+func (d *dataset[T]) invalidate() {
+	d.cache.Invalidate()
+}
+
+// mount registers the dataset's routes on g: GET /{name} lists the records
+// matching the query, as listed explains, and POST creates one; a record at
+// /{name}/{id} is read with GET, replaced with PUT and removed with DELETE.
+func (d *dataset[T]) mount(g routeGroup, listed string) {
+	contributor, admin := g.group("", withRole(roleContributor)), g.group("", requireAdmin)
+	base := "/" + d.name
+	g.get(base, listed, d.listHandler)
+	contributor.post(base, "Create a "+d.noun+" (contributor)", d.createHandler)
+	g.get(base+"/{id}", "A single "+d.noun, d.getHandler)
+	contributor.put(base+"/{id}", "Replace a "+d.noun+" (contributor)", d.updateHandler)
+	admin.delete(base+"/{id}", "Remove a "+d.noun+" (admin)", d.deleteHandler)
+}
+
+func (d *dataset[T]) listHandler(w http.ResponseWriter, r *http.Request) {
+	var match func(*T) bool
+	if d.filter != nil {
+		var err error
+		if match, err = d.filter(r.URL.Query()); err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	snap, err := d.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if d.private {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		setSnapshotCaching(w.Header())
+	}
+	if notModified(w, r, snapshotETag(snap.hash, r.URL.RawQuery), snap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	items := snap.items
+	if match != nil {
+		items = []T{}
+		for i := range snap.items {
+			if match(&snap.items[i]) {
+				items = append(items, snap.items[i])
+			}
+		}
+	}
+	writeJSON(w, items, d.noun+"s")
+}
+
+func (d *dataset[T]) getHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+
+	snap, err := d.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	rec, found := snap.lookup(id)
+	if !found {
+		http.Error(w, upperFirst(d.noun)+" not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", d.cacheControl("public, no-cache, must-revalidate"))
+
+	writeJSON(w, rec, d.noun)
+}
+
+// decode reads and checks a record body. When id is non-empty it is the ID
+// from the URL: the body may omit its ID but must not contradict it.
+func (d *dataset[T]) decode(w http.ResponseWriter, r *http.Request, id string, rec *T) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rec); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+
+	if id != "" {
+		if got := (*rec).RecordID(); got != "" && got != id {
+			writeValidationErrors(w, r, "Invalid "+d.noun, []FieldError{{Field: "id", Message: "does not match the URL"}})
+			return false
+		}
+		if s, ok := any(rec).(interface{ setRecordID(string) }); ok {
+			s.setRecordID(id)
+		}
+	}
+
+	if errs := d.validate(rec); errs != nil {
+		writeValidationErrors(w, r, "Invalid "+d.noun, errs)
+		return false
+	}
+	return true
+}
+
+// validate checks a record, which must have an ID, with d.check.
+func (d *dataset[T]) validate(rec *T) []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace((*rec).RecordID()) == "" {
+		errs = append(errs, FieldError{Field: "id", Message: "must not be empty"})
+	}
+	if d.check != nil {
+		errs = append(errs, d.check(rec)...)
+	}
+	return errs
+}
+
+// write runs op against the store within the usual slot and timeout, then
+// invalidates the snapshot. On failure the error response is written.
+func (d *dataset[T]) write(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) bool {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return false
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := op(ctx); err != nil {
+		switch {
+		case errors.Is(err, ErrAlreadyExists):
+			writeProblem(w, r, http.StatusConflict, "A "+d.noun+" with this ID already exists")
+		case errors.Is(err, ErrNotFound):
+			writeProblem(w, r, http.StatusNotFound, upperFirst(d.noun)+" not found")
+		case errors.Is(err, ErrRejected):
+			writeProblem(w, r, http.StatusBadRequest, "The storage backend rejected the "+d.noun+": "+err.Error())
+		default:
+			logFor(r.Context()).Error("failed to write dataset", "dataset", d.name, "error", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Failed to write "+d.noun)
+		}
+		return false
+	}
+	d.invalidate()
+	return true
+}
+
+func (d *dataset[T]) createHandler(w http.ResponseWriter, r *http.Request) {
+	var rec T
+	if !d.decode(w, r, "", &rec) {
+		return
+	}
+	if !d.write(w, r, func(ctx context.Context) error {
+		if err := d.store.Insert(ctx, rec); err != nil {
+			return err
+		}
+		recordAudit(ctx, auditCreate, d.name, rec.RecordID(), nil, rec)
+		return nil
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPath(r.Context(), d.recordURL(rec.RecordID())))
+	w.WriteHeader(http.StatusCreated)
+
+	writeJSON(w, rec, d.noun)
+}
+
+func (d *dataset[T]) updateHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+
+	var rec T
+	if !d.decode(w, r, id, &rec) {
+		return
+	}
+	var created bool
+	if !d.write(w, r, func(ctx context.Context) (err error) {
+		before := d.audited(ctx, id)
+		if created, err = d.store.Upsert(ctx, rec); err != nil {
+			return err
+		}
+		if created {
+			recordAudit(ctx, auditCreate, d.name, id, nil, rec)
+		} else {
+			recordAudit(ctx, auditUpdate, d.name, id, before, rec)
+		}
+		return nil
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.Header().Set("Location", apiPath(r.Context(), d.recordURL(id)))
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	writeJSON(w, rec, d.noun)
+}
+
+func (d *dataset[T]) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	if !d.write(w, r, func(ctx context.Context) error {
+		before := d.audited(ctx, id)
+		if err := d.store.Delete(ctx, id); err != nil {
+			return err
+		}
+		recordAudit(ctx, auditDelete, d.name, id, before, nil)
+		return nil
+	}) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// audited returns the stored record with the given ID as the Before of an
+// audit entry, like auditedLocation does for the map.
+func (d *dataset[T]) audited(ctx context.Context, id string) any {
+	if _, ok := store.(AuditStorage); !ok {
+		return nil
+	}
+	rec, err := d.store.Get(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return rec
+}
+
+// cacheControl returns the Cache-Control of a read, public unless the dataset
+// is private.
+func (d *dataset[T]) cacheControl(public string) string {
+	if d.private {
+		return "private, no-cache"
+	}
+	return public
+}
+
+// basePath returns the unversioned path the records are listed at.
+func (d *dataset[T]) basePath() string {
+	if d.route != "" {
+		return d.route
+	}
+	return "/api/" + d.name
+}
+
+// recordURL returns the path of the record with the given ID.
+func (d *dataset[T]) recordURL(id string) string {
+	return d.basePath() + "/" + url.PathEscape(id)
+}
+
+// upperFirst capitalises the first letter of an ASCII noun for messages.
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+)
+
+// newDebugServer returns the server on config.DebugPort for the handlers
+// net/http/pprof and expvar register on the default mux: /debug/pprof/ and
+// /debug/vars. It has no write timeout, since CPU profiles and traces run for
+// as long as their ?seconds= asks.
+func newDebugServer() *http.Server {
+	// Besides memstats, /debug/vars shows what the caches hold, to tell a
+	// growing map apart from a leak
+	expvar.Publish("caches", expvar.Func(func() any { return cacheReport().Entries }))
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.DebugPort),
+		Handler:           http.DefaultServeMux,
+		ReadHeaderTimeout: config.HTTP.ReadHeaderTimeout,
+		IdleTimeout:       config.HTTP.IdleTimeout,
+	}
+}
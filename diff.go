@@ -0,0 +1,87 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SnapshotDiff lists the IDs that changed between two cache snapshots.
+type SnapshotDiff struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+// Empty reports whether the two snapshots held the same content.
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// locationHash hashes the BSON encoding of loc, so every stored field takes
+// part in change detection.
+func locationHash(loc MapLocation) uint64 {
+	h := fnv.New64a()
+	raw, _ := bson.Marshal(loc)
+	h.Write(raw)
+	return h.Sum64()
+}
+
+// diffSnapshots compares two snapshots by ID and content hash. The ID lists
+// are sorted.
+func diffSnapshots(prev, next []MapLocation) SnapshotDiff {
+	prevHashes := make(map[string]uint64, len(prev))
+	for _, loc := range prev {
+		prevHashes[loc.ID] = locationHash(loc)
+	}
+
+	var diff SnapshotDiff
+	seen := make(map[string]bool, len(next))
+	for _, loc := range next {
+		seen[loc.ID] = true
+		old, ok := prevHashes[loc.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, loc.ID)
+		case old != locationHash(loc):
+			diff.Updated = append(diff.Updated, loc.ID)
+		}
+	}
+	for id := range prevHashes {
+		if !seen[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// onSnapshotChange is called before the cache moves from prev to the snapshot
+// of the given generation. It logs the diff when diff logging is enabled and
+// pushes it to WebSocket subscribers. It runs as a load of cache, so calls
+// never overlap.
+func onSnapshotChange(prev, next []MapLocation, generation uint64) {
+	logging := config.Logging.SnapshotDiff || config.Logging.SnapshotDiffIDs
+	if !logging && diffHub.count() == 0 {
+		return
+	}
+
+	diff := diffSnapshots(prev, next)
+	if logging {
+		slog.Info("snapshot diff", "generation", generation,
+			"added", len(diff.Added), "updated", len(diff.Updated), "removed", len(diff.Removed))
+		if config.Logging.SnapshotDiffIDs && !diff.Empty() {
+			slog.Info("snapshot diff IDs", "generation", generation,
+				"added", diff.Added, "updated", diff.Updated, "removed", diff.Removed)
+		}
+	}
+
+	if !diff.Empty() {
+		diffHub.publish(newDiffMessage(diff, next, generation))
+	}
+}
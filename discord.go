@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Limits on the Discord bot's replies.
+const (
+	// discordWhereMatches is how many further matches !where mentions
+	discordWhereMatches = 5
+	// discordMessageLimit is Discord's limit on a message's length
+	discordMessageLimit = 2000
+)
+
+// discordAnnouncements maps change stream event types to the names used in
+// config.Discord.Announce.
+var discordAnnouncements = map[string]string{
+	"insert":  auditCreate,
+	"update":  auditUpdate,
+	"replace": auditUpdate,
+	"delete":  auditDelete,
+}
+
+// runDiscordBot connects to Discord with config.Discord.Token, answers
+// commands from the cached map and, with config.Discord.Channel set,
+// announces the changes to the default world's locations there. It returns
+// once ctx is cancelled. The bot reads the same cache and change feed as the
+// HTTP API, so it adds no load on storage of its own. Every replica with a
+// token runs a bot, so in a cluster set it on one of them only.
+func runDiscordBot(ctx context.Context) {
+	session, err := discordgo.New("Bot " + config.Discord.Token)
+	if err != nil {
+		slog.Error("failed to create Discord session", "error", err)
+		return
+	}
+	// Prefix commands need the privileged message content intent, which must
+	// also be enabled for the bot in the Discord developer portal
+	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsDirectMessages | discordgo.IntentsMessageContent
+	session.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if m.Author == nil || m.Author.Bot {
+			return
+		}
+		reply, ok := discordCommand(ctx, m.Content)
+		if !ok {
+			return
+		}
+		if _, err := s.ChannelMessageSendReply(m.ChannelID, reply, m.Reference()); err != nil {
+			slog.Warn("failed to answer Discord command", "channel", m.ChannelID, "error", err)
+		}
+	})
+
+	if err := session.Open(); err != nil {
+		slog.Error("failed to connect to Discord", "error", err)
+		return
+	}
+	defer session.Close()
+	slog.Info("Discord bot connected", "prefix", config.Discord.Prefix, "channel", config.Discord.Channel)
+
+	if config.Discord.Channel == "" {
+		<-ctx.Done()
+		return
+	}
+	announceChanges(ctx, session)
+}
+
+// announceChanges posts the hub's change events configured in
+// config.Discord.Announce to the announcement channel until ctx is
+// cancelled. The hub drops subscribers that fall behind; the bot then
+// subscribes again, missing the changes in between.
+func announceChanges(ctx context.Context, session *discordgo.Session) {
+	for ctx.Err() == nil {
+		events, unsubscribe := hub.subscribe()
+		for ev := range events {
+			if !slices.Contains(config.Discord.Announce, discordAnnouncements[ev.Type]) {
+				continue
+			}
+			if _, err := session.ChannelMessageSend(config.Discord.Channel, announcement(ev)); err != nil {
+				slog.Warn("failed to post Discord announcement", "id", ev.ID, "error", err)
+			}
+		}
+		unsubscribe()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			slog.Warn("Discord announcements fell behind the change feed; resubscribing")
+		}
+	}
+}
+
+// announcement is the message posted for a change event.
+func announcement(ev ChangeEvent) string {
+	if ev.Type == "delete" || ev.Location == nil {
+		return fmt.Sprintf("Location `%s` was removed from the map.", ev.ID)
+	}
+	verb := "New discovery"
+	if ev.Type != "insert" {
+		verb = "Updated"
+	}
+	msg := fmt.Sprintf("%s: %s", verb, describeLocation(*ev.Location))
+	if ev.Location.DiscoveredBy != "" && ev.Type == "insert" {
+		msg += ", discovered by " + ev.Location.DiscoveredBy
+	}
+	return msg
+}
+
+// describeLocation renders a location for chat.
+func describeLocation(loc MapLocation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** (`%s`) at %s, %s", loc.Location, loc.ID, formatCoordinate(loc.XY.X), formatCoordinate(loc.XY.Y))
+	var details []string
+	for _, d := range []string{loc.Region, loc.Biome} {
+		if d != "" {
+			details = append(details, d)
+		}
+	}
+	if loc.DangerLevel > 0 {
+		details = append(details, fmt.Sprintf("danger %d/%d", loc.DangerLevel, maxDangerLevel))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " [%s]", strings.Join(details, ", "))
+	}
+	return b.String()
+}
+
+// discordCommand answers a chat message starting with the command prefix.
+// ok is false for messages that are not commands.
+func discordCommand(ctx context.Context, content string) (reply string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(content), config.Discord.Prefix)
+	if !ok {
+		return "", false
+	}
+	cmd, arg, _ := strings.Cut(rest, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch strings.ToLower(cmd) {
+	case "where":
+		reply = discordWhere(ctx, arg)
+	case "help":
+		reply = fmt.Sprintf("`%[1]swhere <name or id>` finds a location on the map.\n`%[1]shelp` shows this message.", config.Discord.Prefix)
+	default:
+		return "", false
+	}
+	if r := []rune(reply); len(r) > discordMessageLimit {
+		reply = string(r[:discordMessageLimit-1]) + "…"
+	}
+	return reply, true
+}
+
+// discordWhere looks a location up in the cached snapshot by ID, then by
+// name as /api/map/search does without a text index.
+func discordWhere(ctx context.Context, query string) string {
+	if query == "" || len(query) > maxSearchQuery {
+		return fmt.Sprintf("Usage: `%swhere <name or id>`", config.Discord.Prefix)
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	snap, err := loadSnapshot(ctx)
+	if err != nil {
+		slog.Warn("failed to load the map for Discord", "error", err)
+		return "The map is unavailable right now, try again later."
+	}
+	if loc, ok := snap.lookup(query); ok {
+		return describeLocation(loc)
+	}
+
+	results := searchLocations(snap.locations, query, discordWhereMatches+1)
+	if len(results) == 0 {
+		return fmt.Sprintf("No location matches %q.", query)
+	}
+	reply := describeLocation(results[0].MapLocation)
+	if len(results) > 1 {
+		var others []string
+		for _, r := range results[1:] {
+			others = append(others, fmt.Sprintf("%s (`%s`)", r.Location, r.ID))
+		}
+		reply += "\nAlso matching: " + strings.Join(others, ", ")
+	}
+	return reply
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// maxDistanceIDs caps the number of locations in one distance matrix.
+const maxDistanceIDs = 100
+
+// DistanceMatrix is the /api/map/distances response. Row i, column j of each
+// matrix is the distance from IDs[i] to IDs[j]. Path holds the cheapest route
+// cost over the travel edges by ?by=, null where no route exists.
+type DistanceMatrix struct {
+	IDs       []string     `json:"ids"`
+	Euclidean [][]float64  `json:"euclidean"`
+	By        string       `json:"by"`
+	Path      [][]*float64 `json:"path"`
+}
+
+// distancesHandler computes the pairwise distances between the locations
+// listed in ?ids= (comma-separated) or, for POST, in the body as
+// {"ids": [...]}.
+func distancesHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	case http.MethodPost:
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ids = body.IDs
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(ids) == 0 || len(ids) > maxDistanceIDs {
+		http.Error(w, fmt.Sprintf("Between 1 and %d location IDs are required", maxDistanceIDs), http.StatusBadRequest)
+		return
+	}
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "time"
+	}
+	weight, ok := routeWeights[by]
+	if !ok {
+		http.Error(w, "Query parameter by must be time or terrain", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	locations := make([]MapLocation, len(ids))
+	for i, id := range ids {
+		loc, found := snap.lookup(id)
+		if !found {
+			http.Error(w, fmt.Sprintf("Map location %q not found", id), http.StatusNotFound)
+			return
+		}
+		locations[i] = loc
+	}
+	edgeSnap, err := edges.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	matrix := DistanceMatrix{
+		IDs:       ids,
+		Euclidean: make([][]float64, len(ids)),
+		By:        by,
+		Path:      make([][]*float64, len(ids)),
+	}
+	arcs := routeGraph(edgeSnap)
+	for i, from := range locations {
+		matrix.Euclidean[i] = make([]float64, len(ids))
+		matrix.Path[i] = make([]*float64, len(ids))
+		dist, _, done := dijkstra(arcs, from.ID, "", weight)
+		for j, to := range locations {
+			matrix.Euclidean[i][j] = math.Hypot(to.XY.X-from.XY.X, to.XY.Y-from.XY.Y)
+			if done[to.ID] {
+				d := dist[to.ID]
+				matrix.Path[i][j] = &d
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, matrix, "distance matrix")
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadFormat versions the layout of the /api/map/download bundle.
+const downloadFormat = 1
+
+// DownloadManifest is manifest.json in a download bundle.
+type DownloadManifest struct {
+	Format      int       `json:"format"`
+	World       string    `json:"world"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	// ServerVersion is the build that made the bundle
+	ServerVersion string         `json:"serverVersion"`
+	Files         []DownloadFile `json:"files"`
+}
+
+// DownloadFile describes one file of a download bundle.
+type DownloadFile struct {
+	Name string `json:"name"`
+	// Dataset is the collection the file holds, "map" for the locations,
+	// and empty for the SQL script
+	Dataset string `json:"dataset,omitempty"`
+	Records int    `json:"records"`
+	// Version is the content hash of the dataset, as the ETags and
+	// /api/admin/cache use
+	Version string `json:"version,omitempty"`
+	Bytes   int    `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// downloadBundle is a built bundle and what it was built from.
+type downloadBundle struct {
+	// key hashes the content hashes of everything in the bundle
+	key         uint64
+	zip         []byte
+	sha256      []byte
+	generatedAt time.Time
+}
+
+// downloadVariant identifies a bundle: a world's, with or without the SQL
+// script, and redacted or not.
+type downloadVariant struct {
+	world    *world
+	sql      bool
+	redacted bool
+}
+
+// downloads holds the bundles built so far. Once a variant has been asked
+// for, rebuildDownloads keeps it current after each refresh, so that the
+// next download is served at once.
+var downloads struct {
+	mu      sync.Mutex
+	bundles map[downloadVariant]*downloadBundle
+}
+
+// bundleSources is what goes into a bundle, loaded from the caches.
+type bundleSources struct {
+	key      uint64
+	snap     *cacheSnapshot
+	datasets []bundleDataset
+}
+
+type bundleDataset struct {
+	name    string
+	records []Record
+	hash    uint64
+}
+
+// loadBundleSources loads the world's map and the public datasets.
+func loadBundleSources(ctx context.Context, variant downloadVariant) (*bundleSources, error) {
+	snap, err := loadSnapshot(context.WithValue(ctx, worldKey{}, variant.world))
+	if err != nil {
+		return nil, err
+	}
+	if variant.redacted {
+		snap = snap.redacted()
+	}
+	src := &bundleSources{snap: snap}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "map=%x", snap.hash)
+	for _, d := range datasets {
+		if !d.public() {
+			continue
+		}
+		recs, hash, err := d.loadRecords(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", d.collection(), err)
+		}
+		src.datasets = append(src.datasets, bundleDataset{name: d.collection(), records: recs, hash: hash})
+		fmt.Fprintf(h, "|%s=%x", d.collection(), hash)
+	}
+	sort.Slice(src.datasets, func(i, j int) bool { return src.datasets[i].name < src.datasets[j].name })
+	src.key = h.Sum64()
+	return src, nil
+}
+
+// currentDownload returns the bundle of variant, building it again if the
+// caches changed since it was built.
+func currentDownload(ctx context.Context, variant downloadVariant) (*downloadBundle, error) {
+	src, err := loadBundleSources(ctx, variant)
+	if err != nil {
+		return nil, err
+	}
+
+	downloads.mu.Lock()
+	defer downloads.mu.Unlock()
+	if b := downloads.bundles[variant]; b != nil && b.key == src.key {
+		return b, nil
+	}
+	b, err := buildDownload(variant, src)
+	if err != nil {
+		return nil, err
+	}
+	if downloads.bundles == nil {
+		downloads.bundles = make(map[downloadVariant]*downloadBundle)
+	}
+	downloads.bundles[variant] = b
+	return b, nil
+}
+
+// rebuildDownloads brings the bundles built so far up to date after a
+// refresh. A failure is logged; the download builds the bundle itself then.
+func rebuildDownloads(ctx context.Context) {
+	downloads.mu.Lock()
+	variants := make([]downloadVariant, 0, len(downloads.bundles))
+	for v := range downloads.bundles {
+		variants = append(variants, v)
+	}
+	downloads.mu.Unlock()
+
+	for _, v := range variants {
+		if _, err := currentDownload(ctx, v); err != nil {
+			slog.Warn("failed to rebuild the download bundle", "world", v.world.name, "sql", v.sql, "redacted", v.redacted, "error", err)
+		}
+	}
+}
+
+// buildDownload zips the sources with their manifest.
+func buildDownload(variant downloadVariant, src *bundleSources) (*downloadBundle, error) {
+	b := &downloadBundle{key: src.key, generatedAt: time.Now().UTC().Truncate(time.Second)}
+	manifest := DownloadManifest{
+		Format:        downloadFormat,
+		World:         variant.world.name,
+		GeneratedAt:   b.generatedAt,
+		ServerVersion: version,
+		Files:         []DownloadFile{},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(f DownloadFile, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: b.generatedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		f.Bytes, f.SHA256 = len(data), hex.EncodeToString(sum[:])
+		manifest.Files = append(manifest.Files, f)
+		return nil
+	}
+
+	data, err := json.Marshal(src.snap.locations)
+	if err != nil {
+		return nil, err
+	}
+	if err := add(DownloadFile{Name: "map.json", Dataset: "map", Records: len(src.snap.locations), Version: strconv.FormatUint(src.snap.hash, 16)}, data); err != nil {
+		return nil, err
+	}
+	for _, d := range src.datasets {
+		data, err := json.Marshal(d.records)
+		if err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", d.name, err)
+		}
+		if err := add(DownloadFile{Name: "datasets/" + d.name + ".json", Dataset: d.name, Records: len(d.records), Version: strconv.FormatUint(d.hash, 16)}, data); err != nil {
+			return nil, err
+		}
+	}
+	if variant.sql {
+		script, err := downloadSQL(manifest, src)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(DownloadFile{Name: "soulforged.sql"}, script); err != nil {
+			return nil, err
+		}
+	}
+
+	// The manifest comes last so that it lists the checksums of the rest
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: b.generatedAt})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	b.zip = buf.Bytes()
+	sum := sha256.Sum256(b.zip)
+	b.sha256 = sum[:]
+	return b, nil
+}
+
+// downloadSQL writes a script building an SQLite database of the bundle,
+// run as "sqlite3 soulforged.db < soulforged.sql". The bundle carries the
+// script rather than the database file because writing one takes an SQLite
+// driver, which the server does without. The locations get a column per
+// field; the records of the datasets are kept as JSON, for SQLite's
+// json_extract, since their fields differ from one to the next.
+func downloadSQL(manifest DownloadManifest, src *bundleSources) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- The %s world of soulforged-go %s, generated %s\n", manifest.World, manifest.ServerVersion, manifest.GeneratedAt.Format(time.RFC3339))
+	b.WriteString("-- Build a database with: sqlite3 soulforged.db < soulforged.sql\n")
+	b.WriteString("BEGIN TRANSACTION;\n")
+
+	b.WriteString("CREATE TABLE locations (id TEXT PRIMARY KEY, location TEXT NOT NULL, x REAL NOT NULL, y REAL NOT NULL, " +
+		"region TEXT, biome TEXT, danger_level INTEGER, discovered_by TEXT, tags TEXT, weight REAL, version INTEGER, updated_at TEXT);\n")
+	for _, loc := range src.snap.locations {
+		tags := "NULL"
+		if len(loc.Tags) > 0 {
+			data, err := json.Marshal(loc.Tags)
+			if err != nil {
+				return nil, err
+			}
+			tags = sqlString(string(data))
+		}
+		weight := "NULL"
+		if loc.Weight != 0 {
+			weight = sqlNumber(loc.Weight)
+		}
+		updated := "NULL"
+		if loc.UpdatedAt != nil {
+			updated = sqlString(loc.UpdatedAt.UTC().Format(time.RFC3339Nano))
+		}
+		fmt.Fprintf(&b, "INSERT INTO locations VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s);\n",
+			sqlString(loc.ID), sqlString(loc.Location), sqlNumber(loc.XY.X), sqlNumber(loc.XY.Y),
+			sqlOptional(loc.Region), sqlOptional(loc.Biome), sqlInteger(int64(loc.DangerLevel)), sqlOptional(loc.DiscoveredBy),
+			tags, weight, sqlInteger(loc.Version), updated)
+	}
+
+	for _, d := range src.datasets {
+		fmt.Fprintf(&b, "CREATE TABLE %s (id TEXT PRIMARY KEY, record TEXT NOT NULL);\n", sqlIdentifier(d.name))
+		for _, rec := range d.records {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return nil, fmt.Errorf("dataset %s: %w", d.name, err)
+			}
+			fmt.Fprintf(&b, "INSERT INTO %s VALUES (%s, %s);\n", sqlIdentifier(d.name), sqlString(rec.RecordID()), sqlString(string(data)))
+		}
+	}
+	b.WriteString("COMMIT;\n")
+	return []byte(b.String()), nil
+}
+
+func sqlString(s string) string     { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+func sqlIdentifier(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+func sqlNumber(v float64) string    { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+// sqlOptional and sqlInteger write unset fields as NULL.
+func sqlOptional(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return sqlString(s)
+}
+
+func sqlInteger(n int64) string {
+	if n == 0 {
+		return "NULL"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// downloadHandler serves the world's map and the public datasets as one zip
+// with a manifest of their versions and checksums, and the checksum of the
+// whole in Repr-Digest. ?sql=true adds soulforged.sql, a script building an
+// SQLite database of it (see downloadSQL).
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	variant := downloadVariant{world: worldFor(r.Context()), redacted: redacts(r.Context())}
+	if v := r.URL.Query().Get("sql"); v != "" {
+		var err error
+		if variant.sql, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Query parameter sql must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	b, err := currentDownload(r.Context(), variant)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	name := fmt.Sprintf("soulforged-%s-%s.zip", variant.world.name, b.generatedAt.Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(b.sha256)+":")
+	setSnapshotCaching(w.Header())
+	varyRedaction(w.Header())
+	if notModified(w, r, `"`+hex.EncodeToString(b.sha256)+`"`, b.generatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.zip)))
+	w.Write(b.zip)
+}
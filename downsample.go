@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// downsampleHandler serves at most ?max= markers picked from the cached
+// snapshot so that the reduced set keeps the overall spatial distribution.
+func downsampleHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil || limit < 1 {
+		http.Error(w, "Query parameter max must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := visibleSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	locations := snap.locations
+	sampled := downsample(locations, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	varyRedaction(w.Header())
+	w.Header().Set("X-Original-Count", strconv.Itoa(len(locations)))
+	w.Header().Set("X-Returned-Count", strconv.Itoa(len(sampled)))
+
+	writeJSON(w, sampled, "map data")
+}
+
+// downsample grid-samples locations down to at most limit entries. The bounding
+// box is split into a k x k grid and the location closest to the centre of
+// each occupied cell is kept, using the finest grid whose occupied cells still
+// fit in limit. The result is a new slice and the input is left untouched.
+func downsample(locations []MapLocation, limit int) []MapLocation {
+	if len(locations) <= limit {
+		return append([]MapLocation(nil), locations...)
+	}
+
+	b, _ := locationBounds(locations)
+
+	// Occupancy grows (almost) monotonically with k, so binary search for the
+	// largest k that fits. A single cell always fits, which keeps lo valid.
+	lo, hi := 1, maxDownsampleGrid
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if len(sampleGrid(locations, b, mid)) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	best := sampleGrid(locations, b, lo)
+	cells := make([]int, 0, len(best))
+	for cell := range best {
+		cells = append(cells, cell)
+	}
+	sort.Ints(cells)
+
+	sampled := make([]MapLocation, 0, len(cells))
+	for _, cell := range cells {
+		sampled = append(sampled, locations[best[cell]])
+	}
+	return sampled
+}
+
+// maxDownsampleGrid caps the grid resolution tried by downsample.
+const maxDownsampleGrid = 1 << 16
+
+// sampleGrid splits b into a k x k grid and returns, keyed by row-major cell
+// index, the index of the location closest to the centre of each occupied
+// cell.
+func sampleGrid(locations []MapLocation, b Bounds, k int) map[int]int {
+	cellW := (b.MaxX - b.MinX) / float64(k)
+	cellH := (b.MaxY - b.MinY) / float64(k)
+
+	best := make(map[int]int)
+	bestDist := make(map[int]float64)
+	for i, loc := range locations {
+		col := gridCell(loc.XY.X, b.MinX, cellW, k)
+		row := gridCell(loc.XY.Y, b.MinY, cellH, k)
+		cell := row*k + col
+
+		cx := b.MinX + (float64(col)+0.5)*cellW
+		cy := b.MinY + (float64(row)+0.5)*cellH
+		d := math.Hypot(loc.XY.X-cx, loc.XY.Y-cy)
+		if prev, ok := bestDist[cell]; !ok || d < prev {
+			best[cell] = i
+			bestDist[cell] = d
+		}
+	}
+	return best
+}
+
+// gridCell returns the column (or row) of v in a grid of k cells of the given
+// size starting at origin, clamping the upper edge into the last cell.
+func gridCell(v, origin, size float64, k int) int {
+	if size <= 0 {
+		return 0
+	}
+	cell := int((v - origin) / size)
+	if cell >= k {
+		cell = k - 1
+	}
+	if cell < 0 {
+		cell = 0
+	}
+	return cell
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Election backends, as config.Election.Backend names them.
+const (
+	electionMongo = "mongo"
+	electionRedis = "redis"
+)
+
+// refresherLease is the name of the lease the replicas compete for.
+const refresherLease = "refresher"
+
+// leaseStore holds the refresher lease and the cache versions its holder
+// publishes for the others.
+type leaseStore interface {
+	// acquire takes the lease for holder, or renews it if holder already
+	// has it, for ttl, and reports whether holder has it now.
+	acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// release gives up the lease if holder has it.
+	release(ctx context.Context, holder string) error
+	// publishVersions stores the cache versions of the leader.
+	publishVersions(ctx context.Context, versions map[string]string) error
+	// versions returns what the leader published last, nil if nothing.
+	versions(ctx context.Context) (map[string]string, error)
+}
+
+// ElectionStatus describes this replica's part in the election in
+// /api/admin/cache.
+type ElectionStatus struct {
+	Backend string `json:"backend"`
+	// Holder identifies this replica in the lease
+	Holder  string    `json:"holder"`
+	Leading bool      `json:"leading"`
+	Since   time.Time `json:"since"`
+	// LastError is set while the lease store is unreachable, in which case
+	// this replica refreshes by itself
+	LastError string `json:"lastError,omitempty"`
+}
+
+// election has the replicas take turns holding the refresher lease.
+type election struct {
+	leases leaseStore
+
+	mu     sync.Mutex
+	status ElectionStatus
+}
+
+// refreshElection is nil unless config.Election.Backend is set.
+var refreshElection *election
+
+// initElection sets up the configured election backend. It must run after
+// initStorage and initSharedCache.
+func initElection() error {
+	var leases leaseStore
+	switch config.Election.Backend {
+	case "":
+		return nil
+	case electionMongo:
+		s, ok := store.(*mongoStorage)
+		if !ok {
+			return errors.New("the storage backend does not support mongo elections")
+		}
+		leases = &mongoLeases{coll: s.client.Database(config.Mongo.Database).Collection("leases")}
+	case electionRedis:
+		leases = &redisLeases{
+			client:      sharedCache.client,
+			key:         config.Redis.KeyPrefix + "leader",
+			versionsKey: config.Redis.KeyPrefix + "leader:versions",
+		}
+	}
+
+	host, _ := os.Hostname()
+	refreshElection = &election{
+		leases: leases,
+		status: ElectionStatus{Backend: config.Election.Backend, Holder: host + "-" + newRequestID()[:8], Since: time.Now().UTC()},
+	}
+	slog.Info("electing the refresher", "backend", config.Election.Backend, "holder", refreshElection.status.Holder)
+	return nil
+}
+
+// following reports whether another replica runs the refresher. Replicas
+// that cannot reach the lease store refresh by themselves rather than all
+// waiting on a leader that may not exist.
+func following() bool {
+	if refreshElection == nil {
+		return false
+	}
+	refreshElection.mu.Lock()
+	defer refreshElection.mu.Unlock()
+	return !refreshElection.status.Leading && refreshElection.status.LastError == ""
+}
+
+// electionStatus returns this replica's status, or nil without an election.
+func electionStatus() *ElectionStatus {
+	if refreshElection == nil {
+		return nil
+	}
+	refreshElection.mu.Lock()
+	defer refreshElection.mu.Unlock()
+	status := refreshElection.status
+	return &status
+}
+
+// runElection campaigns for the lease every third of config.Election.Lease
+// until ctx is cancelled. In between, a follower applies the versions the
+// leader published.
+func runElection(ctx context.Context) {
+	ticker := time.NewTicker(config.Election.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		refreshElection.campaign(ctx)
+		if following() {
+			refreshElection.follow(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign takes or renews the lease and has the refresher start at once
+// when this replica becomes the leader.
+func (e *election) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, config.Election.Lease/3)
+	defer cancel()
+	leading, err := e.leases.acquire(ctx, e.status.Holder, config.Election.Lease)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		if e.status.LastError == "" {
+			slog.Warn("lease store unreachable; refreshing without a leader", "error", err)
+		}
+		e.status.LastError = err.Error()
+		return
+	}
+	e.status.LastError = ""
+	if leading == e.status.Leading {
+		return
+	}
+	e.status.Leading = leading
+	e.status.Since = time.Now().UTC()
+	refreshLeader.Set(boolGauge(leading))
+	if leading {
+		slog.Info("took over the refresher")
+		requestRefresh()
+	} else {
+		slog.Info("another replica took over the refresher")
+	}
+}
+
+// follow marks stale each loaded cache whose version differs from the one
+// the leader published, so that the next read reloads it. The default
+// world's map is left to followSharedCache when it is shared.
+func (e *election) follow(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, config.Election.Lease/3)
+	defer cancel()
+	published, err := e.leases.versions(ctx)
+	if err != nil {
+		slog.Warn("failed to read the published cache versions", "error", err)
+		return
+	}
+
+	for name, version := range cacheVersions() {
+		if name == "map" && sharedCache != nil {
+			continue
+		}
+		if leader, ok := published[name]; ok && leader != version {
+			slog.Debug("cache outdated by the leader", "cache", name)
+			invalidateNamedCache(name)
+		}
+	}
+}
+
+// publishCacheVersions stores the versions of the caches for the followers
+// after the leader refreshed them. A failure only delays the followers until
+// the next refresh, so it is logged rather than returned.
+func publishCacheVersions(ctx context.Context) {
+	if refreshElection == nil || following() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Election.Lease/3)
+	defer cancel()
+	if err := refreshElection.leases.publishVersions(ctx, cacheVersions()); err != nil {
+		slog.Warn("failed to publish cache versions", "error", err)
+	}
+}
+
+// resign gives up the lease on shutdown so that another replica takes over
+// without waiting for it to expire.
+func (e *election) resign(ctx context.Context) {
+	e.mu.Lock()
+	leading := e.status.Leading
+	e.mu.Unlock()
+	if !leading {
+		return
+	}
+	if err := e.leases.release(ctx, e.status.Holder); err != nil {
+		slog.Warn("failed to release the refresher lease", "error", err)
+	}
+}
+
+// cacheVersions returns the content hash of every loaded cache, by the names
+// of /api/admin/cache.
+func cacheVersions() map[string]string {
+	versions := map[string]string{}
+	for _, wd := range worlds {
+		if s := wd.cache.Peek(); s != nil {
+			versions[wd.cacheName()] = strconv.FormatUint(s.hash, 16)
+		}
+	}
+	for _, d := range datasets {
+		if hash, ok := d.contentHash(); ok {
+			versions[d.collection()] = strconv.FormatUint(hash, 16)
+		}
+	}
+	return versions
+}
+
+// invalidateNamedCache marks the cache named as in cacheVersions stale.
+func invalidateNamedCache(name string) {
+	for _, wd := range worlds {
+		if wd.cacheName() == name {
+			wd.cache.Invalidate()
+			return
+		}
+	}
+	for _, d := range datasets {
+		if d.collection() == name {
+			d.invalidate()
+			return
+		}
+	}
+}
+
+// mongoLeases keeps the lease in a document of the leases collection whose
+// ID is the lease name. Expiry is judged by the clock of the replica asking,
+// so the replicas' clocks must agree to well within the lease.
+type mongoLeases struct {
+	coll *mongo.Collection
+}
+
+type mongoLease struct {
+	Holder   string            `bson:"holder"`
+	Expires  time.Time         `bson:"expires"`
+	Versions map[string]string `bson:"versions,omitempty"`
+}
+
+func (m *mongoLeases) acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{"_id": refresherLease, "$or": bson.A{
+		bson.M{"holder": holder},
+		bson.M{"expires": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires": now.Add(ttl)}}
+	// With another holder the filter matches nothing and the upsert
+	// collides with the existing document
+	_, err := m.coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update the lease: %w", err)
+	}
+	return true, nil
+}
+
+func (m *mongoLeases) release(ctx context.Context, holder string) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"_id": refresherLease, "holder": holder},
+		bson.M{"$set": bson.M{"expires": time.Time{}}})
+	return err
+}
+
+func (m *mongoLeases) publishVersions(ctx context.Context, versions map[string]string) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"_id": refresherLease}, bson.M{"$set": bson.M{"versions": versions}})
+	return err
+}
+
+func (m *mongoLeases) versions(ctx context.Context) (map[string]string, error) {
+	var lease mongoLease
+	err := m.coll.FindOne(ctx, bson.M{"_id": refresherLease}).Decode(&lease)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return lease.Versions, err
+}
+
+// redisLeases keeps the lease in a key holding the holder's name that
+// expires with the lease, and the versions as JSON in another.
+type redisLeases struct {
+	client      *redis.Client
+	key         string
+	versionsKey string
+}
+
+// Renewing and releasing only touch the key while it names the holder.
+var (
+	renewLease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`)
+	dropLease  = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
+)
+
+func (r *redisLeases) acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	taken, err := r.client.SetNX(ctx, r.key, holder, ttl).Result()
+	if err != nil || taken {
+		return taken, err
+	}
+	renewed, err := renewLease.Run(ctx, r.client, []string{r.key}, holder, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (r *redisLeases) release(ctx context.Context, holder string) error {
+	return dropLease.Run(ctx, r.client, []string{r.key}, holder).Err()
+}
+
+func (r *redisLeases) publishVersions(ctx context.Context, versions map[string]string) error {
+	body, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.versionsKey, body, 0).Err()
+}
+
+func (r *redisLeases) versions(ctx context.Context) (map[string]string, error) {
+	body, err := r.client.Get(ctx, r.versionsKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions map[string]string
+	if err := json.Unmarshal(body, &versions); err != nil {
+		return nil, fmt.Errorf("invalid published cache versions: %w", err)
+	}
+	return versions, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotHash hashes the content and order of a snapshot. It is computed once
+// per refresh and is the basis of the /api/map ETags.
+func snapshotHash(locations []MapLocation) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, loc := range locations {
+		binary.LittleEndian.PutUint64(buf[:], locationHash(loc))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// snapshotETag derives a strong ETag from the snapshot hash and the variant
+// of the representation (sort order, media type) so that differently shaped
+// responses of the same snapshot never share a tag.
+func snapshotETag(hash uint64, variant string) string {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], hash)
+	h.Write(buf[:])
+	h.Write([]byte(variant))
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// locationETag is the strong ETag of a single location, its quoted version.
+func locationETag(loc MapLocation) string {
+	return `"` + strconv.FormatInt(loc.Version, 10) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == want {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and Last-Modified of a response and reports
+// whether the request's conditional headers show that the client's copy is
+// current. If-Modified-Since only counts without If-None-Match, as RFC 9110
+// prescribes, and to the second Last-Modified is given in.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// snapshotCacheControl lets browsers and CDNs keep a response built from the
+// cached snapshot for as long as the refresher takes to replace it. A CDN
+// given config.CDN.SharedMaxAge may keep it that long instead, relying on
+// being purged when the data changes.
+func snapshotCacheControl() string {
+	cc := fmt.Sprintf("public, max-age=%d", int(config.RefreshInterval.Seconds()))
+	if config.CDN.SharedMaxAge > 0 {
+		cc += fmt.Sprintf(", s-maxage=%d", int(config.CDN.SharedMaxAge.Seconds()))
+	}
+	return cc
+}
+
+// setSnapshotCaching sets the caching headers of a response built from a
+// cached snapshot: snapshotCacheControl, and Surrogate-Control for the CDNs
+// that read it and strip it before passing the response on.
+func setSnapshotCaching(h http.Header) {
+	h.Set("Cache-Control", snapshotCacheControl())
+	if config.CDN.SharedMaxAge > 0 {
+		h.Set("Surrogate-Control", fmt.Sprintf("max-age=%d", int(config.CDN.SharedMaxAge.Seconds())))
+	}
+}
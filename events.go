@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// Limits on events and on the windows they are queried over.
+const (
+	maxEventDescription = 2000
+	maxEventLocations   = 50
+	// maxEventDuration is the longest a single occurrence may last, which
+	// is enough for a seasonal event
+	maxEventDuration = 120 * 24 * time.Hour
+	// maxEventWindow is the longest ?from= to ?to= span expanded into
+	// occurrences, and defaultEventWindow the span from now when neither
+	// is given
+	maxEventWindow      = 366 * 24 * time.Hour
+	defaultEventWindow  = 30 * 24 * time.Hour
+	maxEventOccurrences = 1000
+)
+
+// Recurrence frequencies, named as in iCalendar RRULEs.
+const (
+	recurDaily   = "daily"
+	recurWeekly  = "weekly"
+	recurMonthly = "monthly"
+	recurYearly  = "yearly"
+)
+
+// Event is a scheduled happening in game, such as a boss spawn or a seasonal
+// event, stored in the events collection. A recurring event repeats its first
+// occurrence, from Start to End, by its recurrence rule.
+type Event struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// Kind groups events, e.g. "boss-spawn" or "seasonal"; it is a
+	// lower-case slug
+	Kind        string    `json:"kind,omitempty" bson:"kind,omitempty"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Start       time.Time `json:"start" bson:"start"`
+	End         time.Time `json:"end" bson:"end"`
+	// Recurrence is nil for a one-off event
+	Recurrence *Recurrence `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	// Locations are the IDs of the map locations the event takes place at
+	Locations []string `json:"locations" bson:"locations"`
+}
+
+// Recurrence is a recurrence rule: the event repeats every Interval days,
+// weeks, months or years after its start, for Count occurrences in all or
+// until the occurrence starting at or before Until, or forever when neither
+// is set.
+type Recurrence struct {
+	// Frequency is daily, weekly, monthly or yearly
+	Frequency string `json:"frequency" bson:"frequency"`
+	// Interval defaults to 1
+	Interval int        `json:"interval,omitempty" bson:"interval,omitempty"`
+	Count    int        `json:"count,omitempty" bson:"count,omitempty"`
+	Until    *time.Time `json:"until,omitempty" bson:"until,omitempty"`
+}
+
+func (e Event) RecordID() string       { return e.ID }
+func (e *Event) setRecordID(id string) { e.ID = id }
+
+// EventOccurrence is one occurrence of an event in /api/events/occurrences.
+type EventOccurrence struct {
+	Event Event     `json:"event"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// occurrences returns the starts of the occurrences overlapping [from, to),
+// at most limit of them.
+func (e *Event) occurrences(from, to time.Time, limit int) []time.Time {
+	duration := e.End.Sub(e.Start)
+	rule := e.Recurrence
+	if rule == nil {
+		if e.Start.Before(to) && e.End.After(from) {
+			return []time.Time{e.Start}
+		}
+		return nil
+	}
+
+	interval := max(rule.Interval, 1)
+	// Daily and weekly occurrences are evenly spaced, so those ending
+	// before from are skipped without stepping through them
+	first := 0
+	var period time.Duration
+	switch rule.Frequency {
+	case recurDaily:
+		period = time.Duration(interval) * 24 * time.Hour
+	case recurWeekly:
+		period = time.Duration(interval) * 7 * 24 * time.Hour
+	}
+	if period > 0 && from.Sub(e.Start) > duration+period {
+		first = int((from.Sub(e.Start) - duration) / period)
+	}
+
+	var starts []time.Time
+	for i := first; len(starts) < limit; i++ {
+		if rule.Count > 0 && i >= rule.Count {
+			break
+		}
+		var start time.Time
+		switch rule.Frequency {
+		case recurDaily, recurWeekly:
+			start = e.Start.Add(time.Duration(i) * period)
+		case recurMonthly:
+			start = e.Start.AddDate(0, i*interval, 0)
+		case recurYearly:
+			start = e.Start.AddDate(i*interval, 0, 0)
+		default:
+			return nil
+		}
+		if !start.Before(to) || (rule.Until != nil && start.After(*rule.Until)) {
+			break
+		}
+		if start.Add(duration).After(from) {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+// events is served at /api/events.
+var events = newDataset("events", "event", checkEvent, eventFilter)
+
+// checkEvent validates e, checking its locations against the cached map
+// snapshot if one is loaded.
+func checkEvent(e *Event) []FieldError {
+	var errs validation.Errors
+
+	if errs.NonEmpty("name", e.Name) {
+		errs.MaxLength("name", e.Name, validationRules.MaxNameLength)
+	}
+	if e.Kind != "" && (!slugPattern.MatchString(e.Kind) || len(e.Kind) > maxMetadataLength) {
+		errs.Add("kind", "must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)
+	}
+	errs.MaxLength("description", e.Description, maxEventDescription)
+
+	if e.Start.IsZero() {
+		errs.Add("start", "must not be empty")
+	}
+	if !e.End.After(e.Start) {
+		errs.Add("end", "must be after start")
+	} else if e.End.Sub(e.Start) > maxEventDuration {
+		errs.Add("end", "must be at most %s after start", formatWindow(maxEventDuration))
+	}
+
+	if rule := e.Recurrence; rule != nil {
+		switch rule.Frequency {
+		case recurDaily, recurWeekly, recurMonthly, recurYearly:
+		default:
+			errs.Add("recurrence.frequency", "must be %s, %s, %s or %s", recurDaily, recurWeekly, recurMonthly, recurYearly)
+		}
+		if rule.Interval < 0 {
+			errs.Add("recurrence.interval", "must be positive, got %d", rule.Interval)
+		}
+		if rule.Count < 0 {
+			errs.Add("recurrence.count", "must be positive, got %d", rule.Count)
+		}
+		if rule.Count > 0 && rule.Until != nil {
+			errs.Add("recurrence", "must not have both count and until")
+		}
+		if rule.Until != nil && rule.Until.Before(e.Start) {
+			errs.Add("recurrence.until", "must not be before start")
+		}
+	}
+
+	if len(e.Locations) > maxEventLocations {
+		errs.Add("locations", "must have at most %d entries, got %d", maxEventLocations, len(e.Locations))
+	}
+	if e.Locations == nil {
+		e.Locations = []string{}
+	}
+	snap := cache.Peek()
+	for i, id := range e.Locations {
+		field := fmt.Sprintf("locations[%d]", i)
+		if !errs.NonEmpty(field, id) || snap == nil || len(snap.locations) == 0 {
+			continue
+		}
+		if _, found := snap.lookup(id); !found {
+			errs.Add(field, "no map location has ID %q", id)
+		}
+	}
+	errs.Unique(e.Locations, func(i int) string { return fmt.Sprintf("locations[%d]", i) })
+
+	return errs
+}
+
+// eventFilter selects events by ?kind=, by ?location=, one of the locations
+// they take place at, and by ?from= and ?to=, keeping those with an
+// occurrence overlapping the window. Either end may be left open.
+func eventFilter(q url.Values) (func(*Event) bool, error) {
+	kind, location := q.Get("kind"), q.Get("location")
+	from, to, err := parseEventWindow(q, false)
+	if err != nil {
+		return nil, err
+	}
+	windowed := !from.IsZero() || !to.IsZero()
+	if kind == "" && location == "" && !windowed {
+		return nil, nil
+	}
+	if to.IsZero() {
+		// Far enough for any occurrence without overflowing
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return func(e *Event) bool {
+		if (kind != "" && e.Kind != kind) || (location != "" && !slices.Contains(e.Locations, location)) {
+			return false
+		}
+		return !windowed || len(e.occurrences(from, to, 1)) > 0
+	}, nil
+}
+
+// parseEventWindow reads ?from= and ?to=, each an RFC 3339 time or a date.
+// With bounded set a missing from means now and a missing to
+// defaultEventWindow after from, and the window may span at most
+// maxEventWindow; otherwise missing ends are returned as zero times.
+func parseEventWindow(q url.Values, bounded bool) (from, to time.Time, err error) {
+	if from, err = parseAuditTime(q.Get("from")); err != nil {
+		return from, to, fmt.Errorf("from must be an RFC 3339 time or a date")
+	}
+	if to, err = parseAuditTime(q.Get("to")); err != nil {
+		return from, to, fmt.Errorf("to must be an RFC 3339 time or a date")
+	}
+	if bounded {
+		if from.IsZero() {
+			from = time.Now().UTC()
+		}
+		if to.IsZero() {
+			to = from.Add(defaultEventWindow)
+		}
+		if to.Sub(from) > maxEventWindow {
+			return from, to, fmt.Errorf("the window from from to to must be at most %s", formatWindow(maxEventWindow))
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+// eventOccurrencesHandler expands the events matching ?kind= and ?location=
+// into their occurrences between ?from= (default now) and ?to= (default 30
+// days later), soonest first.
+func eventOccurrencesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := parseEventWindow(q, true)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	match, err := eventFilter(url.Values{"kind": q["kind"], "location": q["location"]})
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snap, err := events.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	out := []EventOccurrence{}
+	for i := range snap.items {
+		e := &snap.items[i]
+		if match != nil && !match(e) {
+			continue
+		}
+		for _, start := range e.occurrences(from, to, maxEventOccurrences) {
+			out = append(out, EventOccurrence{Event: *e, Start: start, End: start.Add(e.End.Sub(e.Start))})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	if len(out) > maxEventOccurrences {
+		out = out[:maxEventOccurrences]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, out, "event occurrences")
+}
+
+// eventLocationNames returns the names of the event's locations that are on
+// the map, for the LOCATION of its calendar entry.
+func eventLocationNames(e *Event, snap *cacheSnapshot) string {
+	names := make([]string, 0, len(e.Locations))
+	for _, id := range e.Locations {
+		if loc, found := snap.lookup(id); found {
+			names = append(names, loc.Location)
+		}
+	}
+	return strings.Join(names, ", ")
+}
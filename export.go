@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportHandler streams every location as CSV (the default) or, with
+// ?format=tsv, tab-separated values. Like the GeoJSON lines export it reads
+// straight from storage so spreadsheet audits see current data.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	contentType, extension, comma := "text/csv; charset=utf-8", "csv", ','
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+	case "tsv":
+		contentType, extension, comma = "text/tab-separated-values; charset=utf-8", "tsv", '\t'
+	default:
+		http.Error(w, "Query parameter format must be csv or tsv", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// The slot is held for the whole stream since the cursor keeps a
+	// connection checked out
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	flusher, _ := w.(http.Flusher)
+	out := csv.NewWriter(w)
+	out.Comma = comma
+
+	// Nothing is written until the first row so that a failed query can still
+	// be answered with an error status
+	n := 0
+	start := func() {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="maplocations.`+extension+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		out.Write(importColumns)
+	}
+	redacted := redacts(ctx)
+	err = streamLocations(ctx, func(loc MapLocation) error {
+		if n == 0 {
+			start()
+		}
+		n++
+		if redacted {
+			loc = redactLocation(loc)
+		}
+		out.Write(exportRecord(loc))
+		if n%exportFlushEvery == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return out.Error()
+	})
+	if err != nil {
+		if n == 0 {
+			writeLoadError(w, r, err)
+			return
+		}
+		logFor(ctx).Error("failed to stream export", "format", extension, "error", err)
+		return
+	}
+
+	if n == 0 {
+		start()
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		logFor(ctx).Error("failed to stream export", "format", extension, "error", err)
+	}
+}
+
+// exportRecord is the CSV row of a location, in the order of importColumns.
+func exportRecord(loc MapLocation) []string {
+	return []string{
+		spreadsheetSafe(loc.ID),
+		spreadsheetSafe(loc.Location),
+		formatCoordinate(loc.XY.X),
+		formatCoordinate(loc.XY.Y),
+		spreadsheetSafe(loc.Region),
+		loc.Biome,
+		formatDangerLevel(loc.DangerLevel),
+		spreadsheetSafe(loc.DiscoveredBy),
+		strings.Join(loc.Tags, ";"),
+	}
+}
+
+// formatCoordinate renders v with the configured coordinate precision.
+func formatCoordinate(v float64) string {
+	if config.CoordinatePrecision >= 0 {
+		v = roundCoordinate(v, config.CoordinatePrecision)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// spreadsheetSafe prefixes text that a spreadsheet would evaluate as a
+// formula with an apostrophe, so a crafted location name cannot run one when
+// the export is opened.
+func spreadsheetSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// formatDangerLevel renders an unrated location as an empty cell.
+func formatDangerLevel(level int) string {
+	if level == 0 {
+		return ""
+	}
+	return strconv.Itoa(level)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// maxFavorites caps the favorites of one user.
+const maxFavorites = 1000
+
+var errTooManyFavorites = errors.New("too many favorites")
+
+// Favorites are the default world's locations a user starred, kept in the
+// favorites collection under the user's principal name so that they follow
+// the user across devices. Starred locations that were deleted since stay
+// listed until the user removes them.
+type Favorites struct {
+	User string `json:"user" bson:"_id"`
+	// Locations are the IDs in the order they were starred
+	Locations []string  `json:"locations" bson:"locations"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (f Favorites) RecordID() string { return f.User }
+
+// set returns the starred IDs as a set.
+func (f Favorites) set() map[string]bool {
+	set := make(map[string]bool, len(f.Locations))
+	for _, id := range f.Locations {
+		set[id] = true
+	}
+	return set
+}
+
+// favorites stores the favorites. They are read per user, so they are not
+// cached.
+var favorites RecordStore[Favorites]
+
+// openFavorites connects the favorites to the storage backend. It must run
+// after initStorage.
+func openFavorites() error {
+	s, err := recordStoreFor[Favorites]("favorites")
+	if err != nil {
+		return err
+	}
+	favorites = s
+	return nil
+}
+
+// loadFavorites returns the favorites of user, empty if they have none yet.
+func loadFavorites(ctx context.Context, user string) (Favorites, error) {
+	f, err := favorites.Get(ctx, user)
+	if errors.Is(err, ErrNotFound) {
+		return Favorites{User: user, Locations: []string{}}, nil
+	}
+	return f, err
+}
+
+// FavoritesView is the answer of the favorites endpoints: the starred IDs
+// and the starred locations still on the map.
+type FavoritesView struct {
+	Favorites
+	Found []MapLocation `json:"found"`
+}
+
+// favoritesHandler lists the caller's favorites.
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := requestPrincipal(r.Context())
+
+	var f Favorites
+	if !favoritesOp(w, r, func(ctx context.Context) (err error) {
+		f, err = loadFavorites(ctx, p.Name)
+		return err
+	}) {
+		return
+	}
+	writeFavorites(w, r, f)
+}
+
+// favoritesChange is the body of POST /api/users/me/favorites. Removals
+// apply after additions, so an ID in both ends up removed.
+type favoritesChange struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// changeFavoritesHandler adds and removes favorites in one request.
+func changeFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var req favoritesChange
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	changeFavorites(w, r, req)
+}
+
+// addFavoriteHandler and removeFavoriteHandler star and unstar the location
+// at /api/users/me/favorites/{id}.
+func addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	changeFavorites(w, r, favoritesChange{Add: []string{pathValue(r, "id")}})
+}
+
+func removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	changeFavorites(w, r, favoritesChange{Remove: []string{pathValue(r, "id")}})
+}
+
+// changeFavorites applies req to the caller's favorites. Added IDs must name
+// locations on the map; removed ones need not, so that favorites of deleted
+// locations can be cleared. Adding a favorite twice keeps its place.
+func changeFavorites(w http.ResponseWriter, r *http.Request, req favoritesChange) {
+	var errs validation.Errors
+	if snap, err := loadSnapshot(r.Context()); err == nil {
+		for i, id := range req.Add {
+			if _, found := snap.lookup(id); !found {
+				errs.Add(fmt.Sprintf("add[%d]", i), "no map location has ID %q", id)
+			}
+		}
+	}
+	if errs != nil {
+		writeValidationErrors(w, r, "Invalid favorites", errs)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+
+	var f Favorites
+	if !favoritesOp(w, r, func(ctx context.Context) (err error) {
+		if f, err = loadFavorites(ctx, p.Name); err != nil {
+			return err
+		}
+		set := f.set()
+		for _, id := range req.Add {
+			if !set[id] {
+				set[id] = true
+				f.Locations = append(f.Locations, id)
+			}
+		}
+		f.Locations = slices.DeleteFunc(f.Locations, func(id string) bool { return slices.Contains(req.Remove, id) })
+		if len(f.Locations) > maxFavorites {
+			return errTooManyFavorites
+		}
+		f.UpdatedAt = time.Now().UTC()
+		_, err = favorites.Upsert(ctx, f)
+		return err
+	}) {
+		return
+	}
+	writeFavorites(w, r, f)
+}
+
+// writeFavorites answers with f and its locations as currently cached.
+func writeFavorites(w http.ResponseWriter, r *http.Request, f Favorites) {
+	snap, err := visibleSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	view := FavoritesView{Favorites: f, Found: []MapLocation{}}
+	for _, id := range f.Locations {
+		if loc, found := snap.lookup(id); found {
+			view.Found = append(view.Found, loc)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, view, "favorites")
+}
+
+// favoritesOp runs op against the favorites within the usual slot and
+// timeout. On failure the error response is written.
+func favoritesOp(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) bool {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return false
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := op(ctx); err != nil {
+		switch {
+		case errors.Is(err, errTooManyFavorites):
+			http.Error(w, fmt.Sprintf("At most %d locations can be favorites", maxFavorites), http.StatusConflict)
+		default:
+			logFor(r.Context()).Error("failed to access favorites", "error", err)
+			http.Error(w, "Failed to access favorites", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// FavoriteLocation is a location in /api/map?include=favorites.
+type FavoriteLocation struct {
+	MapLocation
+	Favorite bool `json:"favorite"`
+}
+
+// markFavorites flags the locations starred in set.
+func markFavorites(locations []MapLocation, set map[string]bool) []FavoriteLocation {
+	out := make([]FavoriteLocation, len(locations))
+	for i, loc := range locations {
+		out[i] = FavoriteLocation{MapLocation: loc, Favorite: set[loc.ID]}
+	}
+	return out
+}
+
+// FlatFavoriteLocation is a FavoriteLocation in the flat schema.
+type FlatFavoriteLocation struct {
+	FlatLocation
+	Favorite bool `json:"favorite"`
+}
+
+// markFlatFavorites is markFavorites for the flat schema.
+func markFlatFavorites(locations []MapLocation, set map[string]bool) []FlatFavoriteLocation {
+	out := make([]FlatFavoriteLocation, len(locations))
+	for i, loc := range locations {
+		out[i] = FlatFavoriteLocation{FlatLocation: flatten(loc), Favorite: set[loc.ID]}
+	}
+	return out
+}
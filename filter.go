@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// locationFilter selects locations by metadata: ?region= matches the region
+// ignoring case, and each ?tag= must be among the location's tags.
+type locationFilter struct {
+	region string
+	tags   []string
+}
+
+// parseLocationFilter reads ?region= and every ?tag= from q.
+func parseLocationFilter(q url.Values) locationFilter {
+	f := locationFilter{region: strings.TrimSpace(q.Get("region"))}
+	for _, tag := range q["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			f.tags = append(f.tags, strings.ToLower(tag))
+		}
+	}
+	return f
+}
+
+// empty reports whether the filter lets every location through.
+func (f locationFilter) empty() bool {
+	return f.region == "" && len(f.tags) == 0
+}
+
+// matches reports whether loc passes the filter.
+func (f locationFilter) matches(loc *MapLocation) bool {
+	if f.region != "" && !strings.EqualFold(loc.Region, f.region) {
+		return false
+	}
+	for _, tag := range f.tags {
+		found := false
+		for _, t := range loc.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the locations passing the filter in a new slice, leaving
+// locations itself untouched.
+func (f locationFilter) apply(locations []MapLocation) []MapLocation {
+	out := []MapLocation{}
+	for i := range locations {
+		if f.matches(&locations[i]) {
+			out = append(out, locations[i])
+		}
+	}
+	return out
+}
+
+// String identifies the filter in ETag variants.
+func (f locationFilter) String() string {
+	return strings.ToLower(f.region) + "#" + strings.Join(f.tags, ",")
+}
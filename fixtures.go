@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// embeddedFixtures is the value of -fixtures selecting demoFixtures.
+const embeddedFixtures = "embedded"
+
+// demoFixtures is a small made-up map with a few records in every dataset,
+// served with -fixtures embedded.
+//
+//go:embed fixtures/demo.json
+var demoFixtures []byte
+
+// fixturesMode reports whether the server runs from fixtures, on the memory
+// backend without a database.
+func fixturesMode() bool { return config.Fixtures != "" }
+
+// loadFixtures seeds the in-memory storage from config.Fixtures: a file in
+// the backup layout, or just a JSON array of the default world's locations as
+// STORAGE_FILE holds. Writes afterwards stay in memory; the file is never
+// written. It must run after openWorlds.
+func loadFixtures(ctx context.Context) error {
+	raw := demoFixtures
+	if config.Fixtures != embeddedFixtures {
+		var err error
+		if raw, err = os.ReadFile(config.Fixtures); err != nil {
+			return err
+		}
+	}
+
+	b, err := parseFixtures(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", config.Fixtures, err)
+	}
+
+	report, err := restore(ctx, b, false)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(report))
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		slog.Info("loaded fixtures", "collection", name, "documents", report[name].Written)
+	}
+	return nil
+}
+
+// parseFixtures reads fixtures in either layout loadFixtures accepts. The
+// format of a dump may be left out.
+func parseFixtures(raw []byte) (*Backup, error) {
+	b := &Backup{Format: backupFormat}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		var locs []MapLocation
+		if err := json.Unmarshal(raw, &locs); err != nil {
+			return nil, err
+		}
+		b.Worlds = map[string][]MapLocation{defaultWorld.name: locs}
+		return b, nil
+	}
+	if err := json.Unmarshal(raw, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GeoJSONPoint is a GeoJSON Point geometry.
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature describing one map location.
+type GeoJSONFeature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Geometry   GeoJSONPoint   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection of map locations.
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+const geoJSONContentType = "application/geo+json"
+
+// wantsGeoJSON reports whether the client asked for GeoJSON, either with
+// ?format=geojson or an Accept header listing application/geo+json.
+func wantsGeoJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "geojson" || accepts(r, geoJSONContentType)
+}
+
+// toGeoJSONCollection converts locations into a FeatureCollection of Points.
+func toGeoJSONCollection(locations []MapLocation) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, len(locations))
+	for i, loc := range locations {
+		features[i] = toGeoJSONFeature(loc)
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// toGeoJSONFeature converts loc into a Point feature, applying the configured
+// coordinate precision.
+func toGeoJSONFeature(loc MapLocation) GeoJSONFeature {
+	x, y := loc.XY.X, loc.XY.Y
+	if config.CoordinatePrecision >= 0 {
+		x = roundCoordinate(x, config.CoordinatePrecision)
+		y = roundCoordinate(y, config.CoordinatePrecision)
+	}
+
+	props := map[string]any{"location": loc.Location}
+	for name, v := range map[string]string{"region": loc.Region, "biome": loc.Biome, "discoveredBy": loc.DiscoveredBy} {
+		if v != "" {
+			props[name] = v
+		}
+	}
+	if loc.DangerLevel != 0 {
+		props["dangerLevel"] = loc.DangerLevel
+	}
+	if len(loc.Tags) > 0 {
+		props["tags"] = loc.Tags
+	}
+	if loc.Weight != 0 {
+		props["weight"] = loc.Weight
+	}
+
+	return GeoJSONFeature{
+		Type:       "Feature",
+		ID:         loc.ID,
+		Geometry:   GeoJSONPoint{Type: "Point", Coordinates: [2]float64{x, y}},
+		Properties: props,
+	}
+}
+
+// exportFlushEvery is how many features are written between flushes when
+// streaming an export.
+const exportFlushEvery = 100
+
+// exportGeoJSONLinesHandler streams one GeoJSON Feature per line straight from
+// the storage backend, bypassing the cache so the export is fresh. Backends
+// that implement LocationStreamer keep memory use flat; a client disconnect
+// cancels the request context and with it the stream.
+func exportGeoJSONLinesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The slot is held for the whole stream since the cursor keeps a
+	// connection checked out
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	setHeaders := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="maplocations.geojsonl"`)
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// Headers are sent with the first feature, so from there on errors can
+	// only be logged and the stream cut short
+	n := 0
+	redacted := redacts(ctx)
+	err = streamLocations(ctx, func(loc MapLocation) error {
+		if n == 0 {
+			setHeaders()
+		}
+		n++
+		if redacted {
+			loc = redactLocation(loc)
+		}
+		if err := encoder.Encode(toGeoJSONFeature(loc)); err != nil {
+			return fmt.Errorf("failed to write GeoJSON export: %w", err)
+		}
+		if flusher != nil && n%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if n == 0 {
+			writeLoadError(w, r, err)
+			return
+		}
+		logFor(ctx).Error("failed to stream GeoJSON export", "error", err)
+		return
+	}
+
+	if n == 0 {
+		setHeaders()
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// streamLocations calls fn for every stored location, streaming when the
+// backend supports it and falling back to a full listing otherwise.
+func streamLocations(ctx context.Context, fn func(MapLocation) error) error {
+	if streamer, ok := storeFor(ctx).(LocationStreamer); ok {
+		return streamer.StreamLocations(ctx, fn)
+	}
+
+	locations, err := storeFor(ctx).ListLocations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, loc := range locations {
+		if err := fn(loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
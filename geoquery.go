@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// errSpatialUnsupported is returned when the storage backend cannot answer
+// spatial queries.
+var errSpatialUnsupported = errors.New("storage backend does not support spatial queries")
+
+// Limits for /api/map/near results.
+const (
+	defaultNearLimit = 100
+	maxNearLimit     = 5000
+)
+
+// parseBoxQuery reads the ?minX=&maxX=&minY=&maxY= viewport parameters. It
+// returns nil when none are present and an error when only some are.
+func parseBoxQuery(q url.Values) (*Bounds, error) {
+	names := []string{"minX", "minY", "maxX", "maxY"}
+	var values [4]float64
+	present := 0
+	for i, name := range names {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !isFinite(f) {
+			return nil, fmt.Errorf("%s must be a finite number", name)
+		}
+		values[i] = f
+		present++
+	}
+
+	switch present {
+	case 0:
+		return nil, nil
+	case len(names):
+	default:
+		return nil, fmt.Errorf("minX, minY, maxX and maxY must be given together")
+	}
+
+	b := Bounds{MinX: values[0], MinY: values[1], MaxX: values[2], MaxY: values[3]}
+	if b.MinX > b.MaxX || b.MinY > b.MaxY {
+		return nil, fmt.Errorf("minimum exceeds maximum")
+	}
+	return &b, nil
+}
+
+// spatialStore returns the spatial query support of the request's world, if
+// any.
+func spatialStore(ctx context.Context) (SpatialStorage, bool) {
+	s, ok := storeFor(ctx).(SpatialStorage)
+	return s, ok
+}
+
+// findInBox asks the store for the locations inside b.
+func findInBox(ctx context.Context, b Bounds) ([]MapLocation, error) {
+	spatial, ok := spatialStore(ctx)
+	if !ok {
+		return nil, errSpatialUnsupported
+	}
+
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	return spatial.LocationsInBox(ctx, b)
+}
+
+// NearResult is a location together with its distance from the query point.
+type NearResult struct {
+	MapLocation
+	Distance float64 `json:"distance"`
+	// Score is the distance divided by the weight, which weighted results
+	// are ranked by
+	Score *float64 `json:"score,omitempty"`
+}
+
+// nearHandler returns the locations within ?radius= of (?x=, ?y=), nearest
+// first, as answered by the storage backend. ?limit= caps the result count.
+// ?weighted=true ranks them by distance divided by weight instead, with the
+// score alongside each; the backend cannot rank by that, so these are
+// answered from the cached snapshot's grid as /api/map/nearest is.
+func nearHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	x, errX := strconv.ParseFloat(q.Get("x"), 64)
+	y, errY := strconv.ParseFloat(q.Get("y"), 64)
+	if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+		http.Error(w, "Query parameters x and y must be finite numbers", http.StatusBadRequest)
+		return
+	}
+	radius, err := strconv.ParseFloat(q.Get("radius"), 64)
+	if err != nil || radius < 0 || !isFinite(radius) {
+		http.Error(w, "Query parameter radius must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	limit := defaultNearLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxNearLimit {
+			http.Error(w, fmt.Sprintf("Query parameter limit must be between 1 and %d", maxNearLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	weighted := false
+	if v := q.Get("weighted"); v != "" {
+		if weighted, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Query parameter weighted must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var results []NearResult
+	if weighted {
+		results, err = weightedNear(r.Context(), x, y, radius, limit)
+	} else {
+		results, err = near(r.Context(), x, y, radius, limit)
+	}
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	varyRedaction(w.Header())
+
+	writeJSON(w, results, "nearby locations")
+}
+
+// near returns the store's answer to nearHandler, nearest first.
+func near(ctx context.Context, x, y, radius float64, limit int) ([]NearResult, error) {
+	locations, err := findNear(ctx, x, y, radius, limit)
+	if err != nil {
+		return nil, err
+	}
+	if redacts(ctx) {
+		locations = redactLocations(locations)
+	}
+	results := make([]NearResult, len(locations))
+	for i, loc := range locations {
+		results[i] = NearResult{
+			MapLocation: loc,
+			Distance:    math.Hypot(loc.XY.X-x, loc.XY.Y-y),
+		}
+	}
+	return results, nil
+}
+
+// weightedNear returns nearHandler's weighted answer from the cached
+// snapshot, lowest score first.
+func weightedNear(ctx context.Context, x, y, radius float64, limit int) ([]NearResult, error) {
+	snap, err := visibleSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	grid := snap.grid()
+	found := grid.weightedNearestK(x, y, limit, radius)
+	results := make([]NearResult, len(found))
+	for i, n := range found {
+		results[i] = NearResult{MapLocation: grid.locations[n.i], Distance: n.dist, Score: &found[i].score}
+	}
+	return results, nil
+}
+
+// findNear asks the store for up to limit locations within radius of (x, y).
+func findNear(ctx context.Context, x, y, radius float64, limit int) ([]MapLocation, error) {
+	spatial, ok := spatialStore(ctx)
+	if !ok {
+		return nil, errSpatialUnsupported
+	}
+
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	return spatial.LocationsNear(ctx, x, y, radius, limit)
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example/souforged/cached"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Limits on /graphql. The schema is cyclic (a location's resources point
+// back at their nearest location), so the depth bound is what keeps a query
+// from fanning out without end.
+const (
+	maxGraphQLBody  = 1 << 20
+	maxGraphQLDepth = 8
+)
+
+// graphqlSchemaSource describes the read-only graph over the map, the
+// resource nodes and the travel edges.
+const graphqlSchemaSource = `
+schema {
+	query: Query
+}
+
+type Query {
+	# Map locations, optionally only those with the given IDs, in the region
+	# (ignoring case) or carrying every one of the tags
+	locations(ids: [ID!], region: String, tags: [String!]): [Location!]!
+	location(id: ID!): Location
+	# Resource nodes, optionally of one type or nearest one location
+	resources(type: String, location: ID): [ResourceNode!]!
+	resource(id: ID!): ResourceNode
+	# Travel edges, optionally only those touching a location
+	edges(location: ID): [TravelEdge!]!
+	# The cheapest path between two locations, or null if none connects them
+	route(from: ID!, to: ID!, by: RouteCost = TIME): Route
+}
+
+enum RouteCost {
+	TIME
+	TERRAIN
+}
+
+type Coordinates {
+	x: Float!
+	y: Float!
+}
+
+type Location {
+	id: ID!
+	location: String!
+	xy: Coordinates!
+	region: String
+	biome: String
+	dangerLevel: Int
+	discoveredBy: String
+	tags: [String!]!
+	# The resource nodes whose nearest location this is
+	resources(type: String): [ResourceNode!]!
+	# The travel edges starting or ending here
+	edges: [TravelEdge!]!
+}
+
+type ResourceNode {
+	id: ID!
+	name: String!
+	type: String!
+	xy: Coordinates!
+	yield: Int!
+	respawnSeconds: Int!
+	nearestLocation: Location
+}
+
+type TravelEdge {
+	id: ID!
+	# Null if the location has been deleted since the edge was added
+	from: Location
+	to: Location
+	travelSeconds: Float!
+	terrainCost: Float!
+	oneWay: Boolean!
+}
+
+type Route {
+	locations: [Location!]!
+	edges: [TravelEdge!]!
+	travelSeconds: Float!
+	terrainCost: Float!
+}
+`
+
+// graphqlSchema is parsed on first use rather than at startup, so a process
+// that never serves GraphQL does not pay for it.
+var graphqlSchema = sync.OnceValue(func() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchemaSource, &queryResolver{},
+		graphql.MaxDepth(maxGraphQLDepth))
+})
+
+// graphqlRequest is the body of POST /graphql.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlHandler executes a query sent as a JSON body or, for GET, as
+// ?query=&operationName=&variables=. Errors in the query itself are
+// reported in the response's errors array with a 200, as GraphQL clients
+// expect.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "A query is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlDataKey{}, &graphqlData{})
+	resp := graphqlSchema().Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, resp, "GraphQL response")
+}
+
+// graphqlDataKey is the context key of the request's graphqlData.
+type graphqlDataKey struct{}
+
+// graphqlData holds the snapshots one query reads, so every field of the
+// response comes from the same copy of each collection, and the indexes
+// built over them for nested fields.
+type graphqlData struct {
+	mu        sync.Mutex
+	snap      *cacheSnapshot
+	resources *datasetSnapshot[ResourceNode]
+	edges     *datasetSnapshot[TravelEdge]
+
+	resourcesAt map[string][]*ResourceNode
+	edgesAt     map[string][]*TravelEdge
+}
+
+func dataFor(ctx context.Context) *graphqlData {
+	return ctx.Value(graphqlDataKey{}).(*graphqlData)
+}
+
+// graphqlLoadError logs a failed load and returns the error the client sees.
+func graphqlLoadError(ctx context.Context, what string, err error) error {
+	logFor(ctx).Error("failed to load "+what, "error", err)
+	if errors.Is(err, errMongoBusy) {
+		return errors.New("database is busy, please retry")
+	}
+	if errors.Is(err, cached.ErrLoadTimeout) {
+		return errors.New(what + " still loading, please retry")
+	}
+	return fmt.Errorf("failed to fetch %s", what)
+}
+
+func (d *graphqlData) locations(ctx context.Context) (*cacheSnapshot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.snap == nil {
+		snap, err := visibleSnapshot(ctx)
+		if err != nil {
+			return nil, graphqlLoadError(ctx, "map data", err)
+		}
+		d.snap = snap
+	}
+	return d.snap, nil
+}
+
+func (d *graphqlData) resourceNodes(ctx context.Context) (*datasetSnapshot[ResourceNode], error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resources == nil {
+		snap, err := resources.load(ctx)
+		if err != nil {
+			return nil, graphqlLoadError(ctx, "resource nodes", err)
+		}
+		d.resources = snap
+	}
+	return d.resources, nil
+}
+
+func (d *graphqlData) travelEdges(ctx context.Context) (*datasetSnapshot[TravelEdge], error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.edges == nil {
+		snap, err := edges.load(ctx)
+		if err != nil {
+			return nil, graphqlLoadError(ctx, "travel edges", err)
+		}
+		d.edges = snap
+	}
+	return d.edges, nil
+}
+
+// resourcesNear returns the nodes whose nearest location is id, indexing
+// them on first use so that listing every location's resources stays linear.
+func (d *graphqlData) resourcesNear(ctx context.Context, id string) ([]*ResourceNode, error) {
+	snap, err := d.resourceNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resourcesAt == nil {
+		d.resourcesAt = make(map[string][]*ResourceNode)
+		for i := range snap.items {
+			n := &snap.items[i]
+			d.resourcesAt[n.NearestLocation] = append(d.resourcesAt[n.NearestLocation], n)
+		}
+	}
+	return d.resourcesAt[id], nil
+}
+
+// edgesTouching returns the edges starting or ending at id, indexed like
+// resourcesNear.
+func (d *graphqlData) edgesTouching(ctx context.Context, id string) ([]*TravelEdge, error) {
+	snap, err := d.travelEdges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.edgesAt == nil {
+		d.edgesAt = make(map[string][]*TravelEdge)
+		for i := range snap.items {
+			e := &snap.items[i]
+			d.edgesAt[e.From] = append(d.edgesAt[e.From], e)
+			d.edgesAt[e.To] = append(d.edgesAt[e.To], e)
+		}
+	}
+	return d.edgesAt[id], nil
+}
+
+// lookupLocation resolves a location ID to its resolver, or nil if there is
+// no such location.
+func lookupLocation(ctx context.Context, id string) (*locationResolver, error) {
+	snap, err := dataFor(ctx).locations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := snap.lookup(id)
+	if !ok {
+		return nil, nil
+	}
+	return &locationResolver{&loc}, nil
+}
+
+// queryResolver resolves the fields of Query.
+type queryResolver struct{}
+
+func (*queryResolver) Locations(ctx context.Context, args struct {
+	IDs    *[]graphql.ID
+	Region *string
+	Tags   *[]string
+}) ([]*locationResolver, error) {
+	snap, err := dataFor(ctx).locations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter locationFilter
+	if args.Region != nil {
+		filter.region = strings.TrimSpace(*args.Region)
+	}
+	if args.Tags != nil {
+		for _, tag := range *args.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.tags = append(filter.tags, strings.ToLower(tag))
+			}
+		}
+	}
+
+	out := []*locationResolver{}
+	if args.IDs != nil {
+		for _, id := range *args.IDs {
+			if loc, ok := snap.lookup(string(id)); ok && filter.matches(&loc) {
+				out = append(out, &locationResolver{&loc})
+			}
+		}
+		return out, nil
+	}
+	for i := range snap.locations {
+		if loc := &snap.locations[i]; filter.matches(loc) {
+			out = append(out, &locationResolver{loc})
+		}
+	}
+	return out, nil
+}
+
+func (*queryResolver) Location(ctx context.Context, args struct{ ID graphql.ID }) (*locationResolver, error) {
+	return lookupLocation(ctx, string(args.ID))
+}
+
+func (*queryResolver) Resources(ctx context.Context, args struct {
+	Type     *string
+	Location *graphql.ID
+}) ([]*resourceResolver, error) {
+	var kind string
+	if args.Type != nil {
+		if kind = *args.Type; !resourceTypes[kind] {
+			return nil, errors.New("type must be one of ore, herb or fishing")
+		}
+	}
+
+	var nodes []*ResourceNode
+	if args.Location != nil {
+		near, err := dataFor(ctx).resourcesNear(ctx, string(*args.Location))
+		if err != nil {
+			return nil, err
+		}
+		nodes = near
+	} else {
+		snap, err := dataFor(ctx).resourceNodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range snap.items {
+			nodes = append(nodes, &snap.items[i])
+		}
+	}
+	return resourceResolvers(nodes, kind), nil
+}
+
+func (*queryResolver) Resource(ctx context.Context, args struct{ ID graphql.ID }) (*resourceResolver, error) {
+	snap, err := dataFor(ctx).resourceNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := snap.lookup(string(args.ID))
+	if !ok {
+		return nil, nil
+	}
+	return &resourceResolver{&n}, nil
+}
+
+func (*queryResolver) Edges(ctx context.Context, args struct{ Location *graphql.ID }) ([]*edgeResolver, error) {
+	if args.Location != nil {
+		touching, err := dataFor(ctx).edgesTouching(ctx, string(*args.Location))
+		if err != nil {
+			return nil, err
+		}
+		return edgeResolvers(touching), nil
+	}
+	snap, err := dataFor(ctx).travelEdges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]*TravelEdge, len(snap.items))
+	for i := range snap.items {
+		all[i] = &snap.items[i]
+	}
+	return edgeResolvers(all), nil
+}
+
+func (*queryResolver) Route(ctx context.Context, args struct {
+	From graphql.ID
+	To   graphql.ID
+	By   string
+}) (*routeResolver, error) {
+	weight := routeWeights[strings.ToLower(args.By)]
+	from, to := string(args.From), string(args.To)
+
+	snap, err := dataFor(ctx).locations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []string{from, to} {
+		if _, found := snap.lookup(id); !found {
+			return nil, fmt.Errorf("map location %q not found", id)
+		}
+	}
+	edgeSnap, err := dataFor(ctx).travelEdges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path, found := shortestPath(routeGraph(edgeSnap), from, to, weight)
+	if !found {
+		return nil, nil
+	}
+	start, _ := snap.lookup(from)
+	route := &routeResolver{locations: []*locationResolver{{&start}}, edges: []*edgeResolver{}}
+	for _, arc := range path {
+		loc, _ := snap.lookup(arc.to)
+		route.locations = append(route.locations, &locationResolver{&loc})
+		route.edges = append(route.edges, &edgeResolver{arc.edge})
+		route.travelSeconds += arc.edge.TravelSeconds
+		route.terrainCost += arc.edge.TerrainCost
+	}
+	return route, nil
+}
+
+// optional turns the empty value of an omitempty field into null.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// optionalInt does the same for an int, where 0 means unset.
+func optionalInt(n int) *int32 {
+	if n == 0 {
+		return nil
+	}
+	v := int32(n)
+	return &v
+}
+
+// coordinatesResolver resolves Coordinates.
+type coordinatesResolver struct{ xy Coordinates }
+
+func (c coordinatesResolver) X() float64 { return c.xy.X }
+func (c coordinatesResolver) Y() float64 { return c.xy.Y }
+
+// locationResolver resolves Location.
+type locationResolver struct{ loc *MapLocation }
+
+func (l *locationResolver) ID() graphql.ID          { return graphql.ID(l.loc.ID) }
+func (l *locationResolver) Location() string        { return l.loc.Location }
+func (l *locationResolver) XY() coordinatesResolver { return coordinatesResolver{l.loc.XY} }
+func (l *locationResolver) Region() *string         { return optional(l.loc.Region) }
+func (l *locationResolver) Biome() *string          { return optional(l.loc.Biome) }
+func (l *locationResolver) DiscoveredBy() *string   { return optional(l.loc.DiscoveredBy) }
+func (l *locationResolver) Tags() []string          { return append([]string{}, l.loc.Tags...) }
+func (l *locationResolver) DangerLevel() *int32     { return optionalInt(l.loc.DangerLevel) }
+
+func (l *locationResolver) Resources(ctx context.Context, args struct{ Type *string }) ([]*resourceResolver, error) {
+	near, err := dataFor(ctx).resourcesNear(ctx, l.loc.ID)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	if args.Type != nil {
+		kind = *args.Type
+	}
+	return resourceResolvers(near, kind), nil
+}
+
+func (l *locationResolver) Edges(ctx context.Context) ([]*edgeResolver, error) {
+	touching, err := dataFor(ctx).edgesTouching(ctx, l.loc.ID)
+	if err != nil {
+		return nil, err
+	}
+	return edgeResolvers(touching), nil
+}
+
+// resourceResolver resolves ResourceNode.
+type resourceResolver struct{ node *ResourceNode }
+
+// resourceResolvers wraps the nodes of the given type, or of every type when
+// kind is "".
+func resourceResolvers(nodes []*ResourceNode, kind string) []*resourceResolver {
+	out := []*resourceResolver{}
+	for _, n := range nodes {
+		if kind == "" || n.Type == kind {
+			out = append(out, &resourceResolver{n})
+		}
+	}
+	return out
+}
+
+func (n *resourceResolver) ID() graphql.ID          { return graphql.ID(n.node.ID) }
+func (n *resourceResolver) Name() string            { return n.node.Name }
+func (n *resourceResolver) Type() string            { return n.node.Type }
+func (n *resourceResolver) XY() coordinatesResolver { return coordinatesResolver{n.node.XY} }
+func (n *resourceResolver) Yield() int32            { return int32(n.node.Yield) }
+func (n *resourceResolver) RespawnSeconds() int32   { return int32(n.node.RespawnSeconds) }
+
+func (n *resourceResolver) NearestLocation(ctx context.Context) (*locationResolver, error) {
+	if n.node.NearestLocation == "" {
+		return nil, nil
+	}
+	return lookupLocation(ctx, n.node.NearestLocation)
+}
+
+// edgeResolver resolves TravelEdge.
+type edgeResolver struct{ edge *TravelEdge }
+
+func edgeResolvers(list []*TravelEdge) []*edgeResolver {
+	out := make([]*edgeResolver, len(list))
+	for i, e := range list {
+		out[i] = &edgeResolver{e}
+	}
+	return out
+}
+
+func (e *edgeResolver) ID() graphql.ID         { return graphql.ID(e.edge.ID) }
+func (e *edgeResolver) TravelSeconds() float64 { return e.edge.TravelSeconds }
+func (e *edgeResolver) TerrainCost() float64   { return e.edge.TerrainCost }
+func (e *edgeResolver) OneWay() bool           { return e.edge.OneWay }
+
+func (e *edgeResolver) From(ctx context.Context) (*locationResolver, error) {
+	return lookupLocation(ctx, e.edge.From)
+}
+
+func (e *edgeResolver) To(ctx context.Context) (*locationResolver, error) {
+	return lookupLocation(ctx, e.edge.To)
+}
+
+// routeResolver resolves Route.
+type routeResolver struct {
+	locations     []*locationResolver
+	edges         []*edgeResolver
+	travelSeconds float64
+	terrainCost   float64
+}
+
+func (r *routeResolver) Locations() []*locationResolver { return r.locations }
+func (r *routeResolver) Edges() []*edgeResolver         { return r.edges }
+func (r *routeResolver) TravelSeconds() float64         { return r.travelSeconds }
+func (r *routeResolver) TerrainCost() float64           { return r.terrainCost }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"example/souforged/cached"
+	"example/souforged/mappb"
+)
+
+// mapServer implements the MapService gRPC API on top of the same cache and
+// change hub as the HTTP handlers. Calls carry no credentials, so they are
+// answered as anonymous HTTP requests are, redacted. Calls carry no credentials, so they are
+// answered as anonymous HTTP requests are, redacted.
+type mapServer struct {
+	mappb.UnimplementedMapServiceServer
+}
+
+// newGRPCServer returns a server with MapService registered, using the HTTP
+// server's certificates when tlsConfig is not nil.
+func newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(logUnaryCalls),
+		grpc.ChainStreamInterceptor(logStreamCalls),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	server := grpc.NewServer(opts...)
+	mappb.RegisterMapServiceServer(server, mapServer{})
+	return server
+}
+
+// stopGRPC lets in-flight calls finish, cutting them off once ctx is done.
+// Watch streams have ended by now since the HTTP shutdown closed the hub.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// grpcError maps a cache load failure to a status the client can act on.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errMongoBusy):
+		return status.Error(codes.Unavailable, "database is busy, please retry")
+	case errors.Is(err, cached.ErrLoadTimeout):
+		return status.Error(codes.Unavailable, "map data is still loading, please retry")
+	case errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, "failed to fetch map data")
+}
+
+func (mapServer) ListLocations(ctx context.Context, req *mappb.ListLocationsRequest) (*mappb.MapLocationList, error) {
+	snap, err := visibleSnapshot(ctx)
+	if err != nil {
+		slog.Error("failed to load map data", "rpc", "ListLocations", "error", err)
+		return nil, grpcError(err)
+	}
+
+	filter := locationFilter{region: strings.TrimSpace(req.GetRegion())}
+	for _, tag := range req.GetTags() {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.tags = append(filter.tags, strings.ToLower(tag))
+		}
+	}
+	if filter.empty() {
+		return toProtoLocations(snap.locations), nil
+	}
+	return toProtoLocations(filter.apply(snap.locations)), nil
+}
+
+func (mapServer) GetLocation(ctx context.Context, req *mappb.GetLocationRequest) (*mappb.MapLocation, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id must not be empty")
+	}
+	snap, err := visibleSnapshot(ctx)
+	if err != nil {
+		slog.Error("failed to load map data", "rpc", "GetLocation", "error", err)
+		return nil, grpcError(err)
+	}
+	loc, ok := snap.lookup(req.GetId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "map location %q not found", req.GetId())
+	}
+	return toProtoLocation(&loc), nil
+}
+
+// changeTypes maps ChangeEvent types to the protobuf enum.
+var changeTypes = map[string]mappb.LocationChange_Type{
+	"insert":  mappb.LocationChange_INSERT,
+	"update":  mappb.LocationChange_UPDATE,
+	"replace": mappb.LocationChange_REPLACE,
+	"delete":  mappb.LocationChange_DELETE,
+}
+
+// WatchLocations subscribes before sending the optional snapshot, so no
+// change made in between is missed; one may arrive twice instead.
+func (mapServer) WatchLocations(req *mappb.WatchLocationsRequest, stream mappb.MapService_WatchLocationsServer) error {
+	ctx := stream.Context()
+	events, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+	redacted := redacts(ctx)
+
+	if req.GetIncludeSnapshot() {
+		snap, err := visibleSnapshot(ctx)
+		if err != nil {
+			slog.Error("failed to load map data", "rpc", "WatchLocations", "error", err)
+			return grpcError(err)
+		}
+		for i := range snap.locations {
+			loc := &snap.locations[i]
+			change := &mappb.LocationChange{Type: mappb.LocationChange_SNAPSHOT, Id: loc.ID, Location: toProtoLocation(loc)}
+			if err := stream.Send(change); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case ev, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, or the server is shutting
+				// down; the client should reconnect with a snapshot
+				return status.Error(codes.Unavailable, "change stream ended, reconnect to resume")
+			}
+			if redacted {
+				ev = redactChange(ev)
+			}
+			change := &mappb.LocationChange{Type: changeTypes[ev.Type], Id: ev.ID}
+			if ev.Location != nil {
+				change.Location = toProtoLocation(ev.Location)
+			}
+			if err := stream.Send(change); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// logUnaryCalls logs each unary call like logRequests does HTTP requests.
+func logUnaryCalls(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	slog.Info("rpc", "method", info.FullMethod, "code", status.Code(err).String(),
+		"duration_ms", float64(time.Since(start).Microseconds())/1000)
+	return resp, err
+}
+
+// logStreamCalls logs each streaming call once it ends.
+func logStreamCalls(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	slog.Info("rpc", "method", info.FullMethod, "code", status.Code(err).String(),
+		"duration_ms", float64(time.Since(start).Microseconds())/1000)
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// streamingRoutes hold their response open for as long as the client stays,
+// so the server's write timeout would cut them off. /ws is hijacked and
+// needs the read deadline lifted as well. A world's routes, and those of
+// every API version, count under their unversionedRoute.
+var streamingRoutes = map[string]bool{
+	"/api/map/stream":          true,
+	"/api/map/export":          true,
+	"/api/map/export.geojsonl": true,
+	"/ws":                      true,
+}
+
+// recoverPanics turns a panicking handler into a logged 500, instead of
+// net/http dropping the connection without a response. The panic is only
+// logged if the response had already started.
+func recoverPanics(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response
+			if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+				panic(err)
+			}
+			logFor(r.Context()).Error("handler panicked", "panic", err, "stack", string(debug.Stack()))
+			if sw.status == 0 {
+				http.Error(sw, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		handler(sw, r)
+	}
+}
+
+// limitRequests caps the request body at config.HTTP.MaxBodyBytes, refusing
+// a declared Content-Length over it outright, and lifts the server deadlines
+// for the streaming routes.
+func limitRequests(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(config.HTTP.MaxBodyBytes)
+		if r.ContentLength > limit {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		if streamingRoutes[unversionedRoute(route)] {
+			liftDeadlines(w)
+		}
+		handler(w, r)
+	}
+}
+
+// liftDeadlines clears the server's read and write deadlines on the
+// connection behind w.
+func liftDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	rc.SetReadDeadline(time.Time{})
+}
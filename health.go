@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyPingTimeout bounds the storage ping done by /readyz.
+const readyPingTimeout = 2 * time.Second
+
+// refreshState tracks the outcome of background cache refreshes for /readyz.
+var refreshState struct {
+	mu           sync.Mutex
+	failingSince time.Time
+	status       RefreshStatus
+}
+
+// RefreshStatus describes the background refresher in the /readyz report.
+type RefreshStatus struct {
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastErrorAt         *time.Time `json:"lastErrorAt,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+}
+
+// recordRefresh notes the result of a background refresh.
+func recordRefresh(err error) {
+	refreshState.mu.Lock()
+	defer refreshState.mu.Unlock()
+
+	now := time.Now().UTC()
+	if err == nil {
+		refreshState.status.LastSuccess = &now
+		refreshState.status.ConsecutiveFailures = 0
+		refreshState.failingSince = time.Time{}
+		refreshLastSuccess.Set(float64(now.Unix()))
+		return
+	}
+
+	refreshState.status.LastError = err.Error()
+	refreshState.status.LastErrorAt = &now
+	refreshState.status.ConsecutiveFailures++
+	if refreshState.failingSince.IsZero() {
+		refreshState.failingSince = now
+	}
+	refreshLastError.Set(float64(now.Unix()))
+	recordRefreshError(err)
+}
+
+// staleSince returns when the served data was last known to be current, or
+// false while background refreshes are succeeding.
+func staleSince() (time.Time, bool) {
+	refreshState.mu.Lock()
+	defer refreshState.mu.Unlock()
+
+	if refreshState.failingSince.IsZero() {
+		return time.Time{}, false
+	}
+	if last := refreshState.status.LastSuccess; last != nil {
+		return *last, true
+	}
+	return refreshState.failingSince, true
+}
+
+// markStale sets X-Data-Stale-Since on reads while refreshes are failing, so
+// that clients can tell they are being served the last data loaded before an
+// outage. Once the data is older than config.MaxWriteStaleness writes, as
+// rateGroup tells them apart, answer 503 instead.
+func markStale(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since, stale := staleSince()
+		switch {
+		case !stale:
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			w.Header().Set("X-Data-Stale-Since", since.Format(http.TimeFormat))
+		case config.MaxWriteStaleness > 0 && time.Since(since) > config.MaxWriteStaleness && rateGroup(route, r) == rateGroupWrite:
+			w.Header().Set("X-Data-Stale-Since", since.Format(http.TimeFormat))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "This instance's data is stale; writes are refused until it refreshes, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ReadinessReport is the /readyz response body. Each check is "ok" or a short
+// reason it failed.
+type ReadinessReport struct {
+	Status  string            `json:"status"`
+	Checks  map[string]string `json:"checks"`
+	Refresh RefreshStatus     `json:"refresh"`
+	// Connection is left out for backends without a server connection
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+}
+
+// healthzHandler reports that the process is alive. It never touches storage
+// so a database outage does not get the pod restarted.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler answers 200 when storage is reachable, the cache has been
+// loaded at least once and background refreshes have not been failing for
+// longer than config.RefreshFailureThreshold, and 503 otherwise.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := ReadinessReport{Status: "ok", Checks: map[string]string{}}
+	fail := func(check, reason string) {
+		report.Status = "unavailable"
+		report.Checks[check] = reason
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyPingTimeout)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		logFor(r.Context()).Warn("readiness ping failed", "error", err)
+		fail("storage", "unreachable")
+	} else {
+		report.Checks["storage"] = "ok"
+	}
+	if cs, ok := store.(ConnectionStorage); ok {
+		status := cs.ConnectionStatus()
+		report.Connection = &status
+		if status.State != connConnected {
+			fail("connection", status.State+" since "+status.Since.Format(time.RFC3339))
+		} else {
+			report.Checks["connection"] = "ok"
+		}
+	}
+
+	if sharedCache != nil {
+		if err := sharedCache.ping(ctx); err != nil {
+			logFor(r.Context()).Warn("readiness Redis ping failed", "error", err)
+			fail("sharedCache", "unreachable")
+		} else {
+			report.Checks["sharedCache"] = "ok"
+		}
+	}
+
+	if currentGeneration() > 0 {
+		report.Checks["cache"] = "ok"
+	} else {
+		fail("cache", "not loaded yet")
+	}
+
+	refreshState.mu.Lock()
+	failingSince := refreshState.failingSince
+	report.Refresh = refreshState.status
+	refreshState.mu.Unlock()
+	if !failingSince.IsZero() && time.Since(failingSince) > config.RefreshFailureThreshold {
+		fail("refresh", "failing since "+failingSince.UTC().Format(time.RFC3339))
+	} else {
+		report.Checks["refresh"] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	writeJSON(w, report, "readiness report")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LocationRevision is one write in /api/map/{id}/history, as the audit log
+// recorded it.
+type LocationRevision struct {
+	// ID is the audit entry of the write, which a revert names
+	ID     string    `json:"id"`
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	// Changes names the fields the write changed: those set for a create
+	// or restore and none for a delete
+	Changes []string `json:"changes"`
+	// Location is the location as the write left it, absent when it was
+	// deleted
+	Location *MapLocation `json:"location,omitempty"`
+}
+
+// locationRevision reads the revision out of an audit entry of the map.
+func locationRevision(e AuditEntry) (LocationRevision, error) {
+	rev := LocationRevision{ID: e.ID, At: e.At, Actor: e.Actor, Action: e.Action, Changes: []string{}}
+	var before, after MapLocation
+	if e.Before != nil {
+		if err := json.Unmarshal(e.Before, &before); err != nil {
+			return rev, err
+		}
+	}
+	if e.After != nil {
+		if err := json.Unmarshal(e.After, &after); err != nil {
+			return rev, err
+		}
+		rev.Location = &after
+		rev.Changes = changedFields(before, after)
+	}
+	return rev, nil
+}
+
+// locationHistory returns up to limit revisions of the location, newest
+// first. On failure the error response is written and ok is false.
+func locationHistory(w http.ResponseWriter, r *http.Request, id string, limit int) (revs []LocationRevision, ok bool) {
+	audit, isAudited := store.(AuditStorage)
+	if !isAudited {
+		http.Error(w, "The storage backend does not keep an audit log", http.StatusNotImplemented)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+	entries, err := audit.ListAudit(ctx, AuditQuery{Collection: worldFor(ctx).collection(), DocumentID: id, Limit: limit})
+	if err != nil {
+		writeLoadError(w, r, err)
+		return nil, false
+	}
+
+	revs = make([]LocationRevision, 0, len(entries))
+	for _, e := range entries {
+		rev, err := locationRevision(e)
+		if err != nil {
+			logFor(r.Context()).Error("unreadable audit entry", "entry", e.ID, "error", err)
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	return revs, true
+}
+
+// historyHandler lists the writes to a map location, newest first, up to
+// ?limit=.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	limit := defaultAuditLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			http.Error(w, "Query parameter limit must be between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	revs, ok := locationHistory(w, r, id, limit)
+	if !ok {
+		return
+	}
+	if len(revs) == 0 {
+		writeProblem(w, r, http.StatusNotFound, "No history for this map location")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, revs, "location history")
+}
+
+// revertLocationHandler writes a map location back as one of its revisions
+// left it. The revert is a write of its own, audited and announced as an
+// update, so it can be reverted in turn. Only the last maxAuditLimit
+// revisions can be reverted to.
+func revertLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, revision := pathValue(r, "id"), pathValue(r, "revision")
+	revs, ok := locationHistory(w, r, id, maxAuditLimit)
+	if !ok {
+		return
+	}
+	var target *LocationRevision
+	for i := range revs {
+		if revs[i].ID == revision {
+			target = &revs[i]
+			break
+		}
+	}
+	switch {
+	case target == nil:
+		writeProblem(w, r, http.StatusNotFound, "Revision not found")
+		return
+	case target.Location == nil:
+		writeProblem(w, r, http.StatusConflict, "The revision deleted the map location; restore it from the trash instead")
+		return
+	}
+	loc := *target.Location
+	if errs := validateNewLocation(r.Context(), loc); errs != nil {
+		writeValidationErrors(w, r, "The revision is no longer a valid map location", errs)
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	cur, err := storeFor(ctx).GetLocation(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeProblem(w, r, http.StatusConflict, "The map location is deleted; restore it from the trash first")
+		return
+	}
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	stampLocation(&loc, cur.Version)
+	err = storeFor(ctx).ReplaceLocation(ctx, loc, cur.Version)
+	switch {
+	case errors.Is(err, ErrVersionConflict):
+		writeVersionConflict(w, r, ctx, id)
+		return
+	case err != nil:
+		writeStorageError(w, r, err)
+		return
+	}
+	invalidateCache(ctx)
+	recordAudit(ctx, auditRevert, worldFor(ctx).collection(), id, cur, loc)
+	notifyWebhooks(ctx, auditUpdate, id, &loc)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", locationETag(loc))
+	writeJSON(w, loc, "map location")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+)
+
+// ChangeEvent describes a single change to the maplocations collection.
+// Location is nil for deletions.
+type ChangeEvent struct {
+	Type     string       `json:"type"`
+	ID       string       `json:"id"`
+	Location *MapLocation `json:"location,omitempty"`
+}
+
+// subscriberBuffer is how many messages may queue up for one subscriber
+// before it is considered too slow and dropped.
+const subscriberBuffer = 64
+
+// broadcaster fans messages out to any number of subscribers.
+type broadcaster[T any] struct {
+	mu   sync.Mutex
+	subs map[chan T]struct{}
+}
+
+func newBroadcaster[T any]() *broadcaster[T] {
+	return &broadcaster[T]{subs: make(map[chan T]struct{})}
+}
+
+// hub carries change stream events to /api/map/stream subscribers.
+var hub = newBroadcaster[ChangeEvent]()
+
+// subscribe registers a new subscriber. The returned channel is closed when
+// unsubscribe is called or when the subscriber falls too far behind.
+func (b *broadcaster[T]) subscribe() (messages <-chan T, unsubscribe func()) {
+	ch := make(chan T, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() { b.remove(ch) }
+}
+
+func (b *broadcaster[T]) remove(ch chan T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers msg to every subscriber without blocking. Subscribers whose
+// buffer is full are dropped so that one slow client cannot stall the rest.
+func (b *broadcaster[T]) publish(msg T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- msg:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// count returns the number of active subscribers.
+func (b *broadcaster[T]) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// closeAll drops every subscriber, ending their streams.
+func (b *broadcaster[T]) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
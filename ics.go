@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// icsContentType is the media type of /api/events/calendar.ics.
+const icsContentType = "text/calendar; charset=utf-8"
+
+// icsTime is the UTC date-time format of iCalendar.
+const icsTime = "20060102T150405Z"
+
+// icsMaxLine is the longest content line, in octets, before it is folded.
+const icsMaxLine = 75
+
+// calendarHandler exports the events matching the query, filtered as
+// /api/events is, as an iCalendar feed for community calendars to subscribe
+// to. Recurring events keep their rule, so subscribers expand them.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	match, err := eventFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eventSnap, err := events.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	mapSnap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", icsContentType)
+	setSnapshotCaching(w.Header())
+	// Location names come from the map, so its changes change the feed too
+	variant := fmt.Sprintf("ics|%x|%s", mapSnap.hash, r.URL.RawQuery)
+	if notModified(w, r, snapshotETag(eventSnap.hash, variant), eventSnap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var b strings.Builder
+	line := func(name, value string) { writeICSLine(&b, name+":"+value) }
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//Soulforged//Map API//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", "Soulforged events")
+	stamp := eventSnap.loadedAt.UTC().Format(icsTime)
+	for i := range eventSnap.items {
+		e := &eventSnap.items[i]
+		if match != nil && !match(e) {
+			continue
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", e.ID+"@"+r.Host)
+		line("DTSTAMP", stamp)
+		line("DTSTART", e.Start.UTC().Format(icsTime))
+		line("DTEND", e.End.UTC().Format(icsTime))
+		if e.Recurrence != nil {
+			line("RRULE", icsRule(e.Recurrence))
+		}
+		line("SUMMARY", icsText(e.Name))
+		if e.Description != "" {
+			line("DESCRIPTION", icsText(e.Description))
+		}
+		if e.Kind != "" {
+			line("CATEGORIES", icsText(e.Kind))
+		}
+		if names := eventLocationNames(e, mapSnap); names != "" {
+			line("LOCATION", icsText(names))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		logFor(r.Context()).Error("failed to write response", "what", "calendar", "error", err)
+	}
+}
+
+// icsRule formats a recurrence rule as an RRULE value.
+func icsRule(rule *Recurrence) string {
+	value := "FREQ=" + strings.ToUpper(rule.Frequency)
+	if rule.Interval > 1 {
+		value += fmt.Sprintf(";INTERVAL=%d", rule.Interval)
+	}
+	if rule.Count > 0 {
+		value += fmt.Sprintf(";COUNT=%d", rule.Count)
+	}
+	if rule.Until != nil {
+		value += ";UNTIL=" + rule.Until.UTC().Format(icsTime)
+	}
+	return value
+}
+
+// icsText escapes a TEXT value.
+var icsText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace
+
+// writeICSLine writes a content line, folding it after icsMaxLine octets
+// without splitting a UTF-8 sequence, and ends it with CRLF.
+func writeICSLine(b *strings.Builder, s string) {
+	for limit := icsMaxLine; len(s) > limit; limit = icsMaxLine - 1 {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
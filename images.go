@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxImageBody bounds an uploaded location image.
+const maxImageBody = 8 << 20
+
+// imageTypes are the content types accepted for location images, as
+// detected from the uploaded bytes rather than taken from the request.
+var imageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// LocationImage is the icon or screenshot of a map location.
+type LocationImage struct {
+	ContentType string
+	Data        []byte
+	// SHA256 is the hex digest of Data, which doubles as its ETag
+	SHA256    string
+	UpdatedAt time.Time
+}
+
+// ImageInfo describes a stored image in upload responses.
+type ImageInfo struct {
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	URL         string    `json:"url"`
+}
+
+// imageStore returns the image storage of the request's world. On failure
+// the error response is written.
+func imageStore(w http.ResponseWriter, r *http.Request) (ImageStorage, bool) {
+	images, ok := storeFor(r.Context()).(ImageStorage)
+	if !ok {
+		http.Error(w, "The storage backend does not support location images", http.StatusNotImplemented)
+	}
+	return images, ok
+}
+
+func getImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	images, ok := imageStore(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	img, err := images.GetImage(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	w.Header().Set("ETag", `"`+img.SHA256+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// ServeContent answers If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, "", img.UpdatedAt, bytes.NewReader(img.Data))
+}
+
+// uploadImageHandler stores the request body, or the "image" part of a
+// multipart form, as the location's image.
+func uploadImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	images, ok := imageStore(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := readImageUpload(w, r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid image upload: "+err.Error())
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "Images must be PNG, JPEG, GIF or WebP, got "+contentType)
+		return
+	}
+	sum := sha256.Sum256(data)
+	img := LocationImage{
+		ContentType: contentType,
+		Data:        data,
+		SHA256:      hex.EncodeToString(sum[:]),
+		UpdatedAt:   time.Now().UTC(),
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	// Images only belong to locations that exist
+	if _, err := storeFor(ctx).GetLocation(ctx, id); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	created, err := images.PutImage(ctx, id, img)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	info := ImageInfo{
+		ContentType: img.ContentType,
+		Size:        len(img.Data),
+		SHA256:      img.SHA256,
+		UpdatedAt:   img.UpdatedAt,
+		URL:         publicPath(ctx, "/api/map/"+id+"/image"),
+	}
+	action := auditUpdate
+	if created {
+		action = auditCreate
+	}
+	recordAudit(ctx, action, worldFor(ctx).collection()+"_images", id, nil, info)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", info.URL)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	writeJSON(w, info, "image")
+}
+
+// readImageUpload reads the uploaded image from a raw or multipart body.
+func readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, maxImageBody)
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		data, err := io.ReadAll(body)
+		if err == nil && len(data) == 0 {
+			err = errors.New("the body is empty")
+		}
+		return data, err
+	}
+
+	r.Body = body
+	if params["boundary"] == "" {
+		return nil, errors.New("multipart body without a boundary")
+	}
+	if err := r.ParseMultipartForm(maxImageBody); err != nil {
+		return nil, err
+	}
+	defer r.MultipartForm.RemoveAll()
+	f, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, errors.New(`the form has no "image" file`)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	images, ok := imageStore(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := images.DeleteImage(ctx, id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeProblem(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		writeStorageError(w, r, err)
+		return
+	}
+	recordAudit(ctx, auditDelete, worldFor(ctx).collection()+"_images", id, nil, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteLocationImage removes the image of a deleted location, if it had
+// one. The location is gone already, so a failure is only logged.
+func deleteLocationImage(ctx context.Context, id string) {
+	images, ok := storeFor(ctx).(ImageStorage)
+	if !ok {
+		return
+	}
+	if err := images.DeleteImage(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		logFor(ctx).Warn("failed to delete location image", "id", id, "error", err)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits on POST /api/map/import.
+const (
+	maxImportBody = 16 << 20
+	maxImportRows = 10000
+)
+
+// importColumns are the CSV columns an import understands, the same ones
+// /api/map/export writes. id, location, x and y are required.
+var importColumns = []string{"id", "location", "x", "y", "region", "biome", "dangerLevel", "discoveredBy", "tags"}
+
+// ImportRow is the outcome of one row of an import.
+type ImportRow struct {
+	// Row counts from 1: the array index plus one for JSON, the line after
+	// the header for CSV
+	Row    int          `json:"row"`
+	ID     string       `json:"id,omitempty"`
+	Status string       `json:"status"`
+	Errors []FieldError `json:"errors,omitempty"`
+	// Changes names, in a dry run, the fields an update would change; it
+	// is empty when the row repeats the stored location
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Import row statuses.
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importInvalid = "invalid"
+	// importConflict is a valid row clashing with another row or with a
+	// stored location: the same ID twice, or the name and coordinates of
+	// another location
+	importConflict = "conflict"
+	importFailed   = "failed"
+)
+
+// ImportReport is the response of POST /api/map/import. A dry run reports
+// the rows that would be created and updated against the cached map, and
+// writes nothing.
+type ImportReport struct {
+	DryRun    bool        `json:"dryRun,omitempty"`
+	Total     int         `json:"total"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Invalid   int         `json:"invalid"`
+	Conflicts int         `json:"conflicts"`
+	Failed    int         `json:"failed"`
+	Rows      []ImportRow `json:"rows"`
+}
+
+// importRow is a parsed row waiting to be written.
+type importRow struct {
+	row int
+	loc MapLocation
+	err []FieldError
+	// conflict marks err as clashes rather than validation failures
+	conflict bool
+}
+
+// importHandler upserts the locations of a JSON array or CSV upload, sent as
+// the request body (Content-Type application/json or text/csv) or as the
+// "file" field of a multipart form. Rows failing validation or in conflict
+// are reported and skipped; the rest are written in one batch. With
+// ?dryRun=true the rows are checked the same way and reported as the plan of
+// the import, without writing.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Query parameter dryRun must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBody)
+
+	body, format, err := importUpload(r)
+	if err != nil {
+		http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	var rows []importRow
+	switch format {
+	case "json":
+		rows, err = parseImportJSON(body)
+	case "csv":
+		rows, err = parseImportCSV(body)
+	}
+	if err != nil {
+		http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) > maxImportRows {
+		http.Error(w, fmt.Sprintf("At most %d rows may be imported per request", maxImportRows), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	checkImportRows(r.Context(), rows)
+
+	report := ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]ImportRow, len(rows))}
+	var valid []MapLocation
+	var validRows []int
+	for i, row := range rows {
+		report.Rows[i] = ImportRow{Row: row.row, ID: row.loc.ID}
+		switch {
+		case row.conflict:
+			report.Rows[i].Status, report.Rows[i].Errors = importConflict, row.err
+			report.Conflicts++
+		case row.err != nil:
+			report.Rows[i].Status, report.Rows[i].Errors = importInvalid, row.err
+			report.Invalid++
+		default:
+			valid = append(valid, row.loc)
+			validRows = append(validRows, i)
+		}
+	}
+
+	if dryRun {
+		if err := planImport(r.Context(), &report, valid, validRows); err != nil {
+			writeLoadError(w, r, err)
+			return
+		}
+	} else if len(valid) > 0 {
+		release, err := acquireMongo(r.Context())
+		if err != nil {
+			writeLoadError(w, r, err)
+			return
+		}
+		defer release()
+
+		// Before documents for the audit log come from the cache, which
+		// saves a read per row
+		var before *cacheSnapshot
+		if _, ok := store.(AuditStorage); ok {
+			before, _ = loadSnapshot(r.Context())
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+		defer cancel()
+
+		created, errs, err := upsertLocations(ctx, valid)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		invalidateCache(ctx)
+
+		for j, i := range validRows {
+			out := &report.Rows[i]
+			switch {
+			case errs[j] != nil:
+				out.Status, out.Errors = importFailed, []FieldError{{Message: errs[j].Error()}}
+				report.Failed++
+			case created[j]:
+				out.Status = importCreated
+				report.Created++
+				recordAudit(ctx, auditCreate, worldFor(ctx).collection(), out.ID, nil, valid[j])
+				notifyWebhooks(ctx, auditCreate, out.ID, &valid[j])
+			default:
+				out.Status = importUpdated
+				report.Updated++
+				var prev any
+				if before != nil {
+					if loc, ok := before.lookup(out.ID); ok {
+						prev = loc
+					}
+				}
+				recordAudit(ctx, auditUpdate, worldFor(ctx).collection(), out.ID, prev, valid[j])
+				notifyWebhooks(ctx, auditUpdate, out.ID, &valid[j])
+			}
+		}
+	}
+	logFor(r.Context()).Info("imported locations", "dryRun", dryRun, "total", report.Total, "created", report.Created,
+		"updated", report.Updated, "invalid", report.Invalid, "conflicts", report.Conflicts, "failed", report.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, report, "import report")
+}
+
+// checkImportRows validates every row that decoded and looks for conflicts:
+// IDs repeated within the upload, since only one of them could win and which
+// one would depend on the backend, and rows with the name and coordinates of
+// a stored location or of an earlier row. Rows failing validation are not
+// checked for conflicts.
+func checkImportRows(ctx context.Context, rows []importRow) {
+	firstRow := make(map[string]int, len(rows))
+	type place struct {
+		name string
+		xy   Coordinates
+	}
+	firstPlace := make(map[place]int, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		if row.err != nil && row.err[0].Field == "" {
+			continue
+		}
+		if row.err = append(row.err, validateLocation(row.loc)...); row.err != nil {
+			continue
+		}
+
+		if first, dup := firstRow[row.loc.ID]; dup {
+			row.err = append(row.err, FieldError{Field: "id", Message: fmt.Sprintf("duplicates row %d", first)})
+		} else {
+			firstRow[row.loc.ID] = row.row
+		}
+		at := place{strings.ToLower(strings.TrimSpace(row.loc.Location)), row.loc.XY}
+		if first, dup := firstPlace[at]; dup {
+			row.err = append(row.err, FieldError{Field: "location", Message: fmt.Sprintf("duplicates row %d at the same coordinates", first)})
+		} else {
+			firstPlace[at] = row.row
+		}
+		if id, dup := duplicateLocation(ctx, row.loc); dup {
+			row.err = append(row.err, FieldError{Field: "location", Message: fmt.Sprintf("duplicates location %q at the same coordinates", id)})
+		}
+		row.conflict = row.err != nil
+	}
+}
+
+// planImport fills in the report of a dry run: each valid row is created or
+// updated depending on whether the cached map has its ID, and updates list
+// the fields they change.
+func planImport(ctx context.Context, report *ImportReport, valid []MapLocation, validRows []int) error {
+	snap, err := loadSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	for j, i := range validRows {
+		out := &report.Rows[i]
+		stored, exists := snap.lookup(out.ID)
+		if !exists {
+			out.Status = importCreated
+			report.Created++
+			continue
+		}
+		out.Status, out.Changes = importUpdated, changedFields(stored, valid[j])
+		report.Updated++
+	}
+	return nil
+}
+
+// changedFields names the fields of a location, as in JSON, that differ
+// between before and after, leaving out the version and time stamped on
+// write.
+func changedFields(before, after MapLocation) []string {
+	changes := []string{}
+	for _, f := range []struct {
+		name    string
+		changed bool
+	}{
+		{"location", before.Location != after.Location},
+		{"xy", before.XY != after.XY},
+		{"region", before.Region != after.Region},
+		{"biome", before.Biome != after.Biome},
+		{"dangerLevel", before.DangerLevel != after.DangerLevel},
+		{"discoveredBy", before.DiscoveredBy != after.DiscoveredBy},
+		{"weight", before.Weight != after.Weight},
+		{"tags", !slices.Equal(before.Tags, after.Tags)},
+	} {
+		if f.changed {
+			changes = append(changes, f.name)
+		}
+	}
+	return changes
+}
+
+// upsertLocations writes locs in one batch when the backend supports it and
+// one at a time otherwise.
+func upsertLocations(ctx context.Context, locs []MapLocation) ([]bool, []error, error) {
+	if bulk, ok := storeFor(ctx).(BulkStorage); ok {
+		return bulk.UpsertLocations(ctx, locs)
+	}
+	created := make([]bool, len(locs))
+	errs := make([]error, len(locs))
+	for i, loc := range locs {
+		created[i], errs[i] = storeFor(ctx).UpsertLocation(ctx, loc)
+	}
+	return created, errs, nil
+}
+
+// importUpload returns the uploaded document and whether it is "json" or
+// "csv", judging by the content type of the body or of the multipart file
+// and falling back to the file name's extension.
+func importUpload(r *http.Request) (io.ReadCloser, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		format, ok := importFormat(mediaType, "")
+		if !ok {
+			return nil, "", errors.New("content type must be application/json, text/csv or multipart/form-data")
+		}
+		return r.Body, format, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid multipart body: %w", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", errors.New(`multipart body has no "file" field`)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		format, ok := importFormat(partType, part.FileName())
+		if !ok {
+			part.Close()
+			return nil, "", errors.New("uploaded file must be JSON or CSV")
+		}
+		return part, format, nil
+	}
+}
+
+// importFormat maps a media type, or failing that a file name, to an
+// import format.
+func importFormat(mediaType, filename string) (string, bool) {
+	switch mediaType {
+	case "application/json":
+		return "json", true
+	case "text/csv":
+		return "csv", true
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".json":
+		return "json", true
+	case ".csv":
+		return "csv", true
+	}
+	return "", false
+}
+
+// parseImportJSON reads an array of locations. A row that does not decode is
+// reported against that row instead of failing the upload.
+func parseImportJSON(body io.Reader) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, len(raw))
+	for i, msg := range raw {
+		rows[i].row = i + 1
+		decoder := json.NewDecoder(strings.NewReader(string(msg)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rows[i].loc); err != nil {
+			rows[i].err = []FieldError{{Message: "invalid location: " + err.Error()}}
+		}
+	}
+	return rows, nil
+}
+
+// parseImportCSV reads a CSV document whose header names its columns, in
+// any order, from importColumns. The apostrophe the export puts before
+// formula-like text is removed again.
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	column := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		known := false
+		for _, c := range importColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		column[name] = i
+	}
+	for _, required := range importColumns[:4] {
+		if _, ok := column[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxImportRows {
+			return append(rows, importRow{}), nil
+		}
+
+		cell := func(name string) string {
+			if i, ok := column[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := importRow{row: len(rows) + 1}
+		row.loc = MapLocation{
+			ID:           unspreadsheet(cell("id")),
+			Location:     unspreadsheet(cell("location")),
+			Region:       unspreadsheet(cell("region")),
+			Biome:        cell("biome"),
+			DiscoveredBy: unspreadsheet(cell("discoveredBy")),
+		}
+		for _, axis := range []struct {
+			name string
+			dst  *float64
+		}{{"x", &row.loc.XY.X}, {"y", &row.loc.XY.Y}} {
+			v, err := strconv.ParseFloat(cell(axis.name), 64)
+			if err != nil {
+				row.err = append(row.err, FieldError{Field: "xy." + axis.name, Message: "must be a number"})
+			}
+			*axis.dst = v
+		}
+		if v := cell("dangerLevel"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				row.err = append(row.err, FieldError{Field: "dangerLevel", Message: "must be an integer"})
+			}
+			row.loc.DangerLevel = n
+		}
+		for _, tag := range strings.Split(cell("tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.loc.Tags = append(row.loc.Tags, tag)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// unspreadsheet undoes spreadsheetSafe.
+func unspreadsheet(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
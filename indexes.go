@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// IndexKey is one field of an index key pattern, in declaration order.
+type IndexKey struct {
+	Field string `json:"field"`
+	Value any    `json:"value"`
+}
+
+// IndexUsage is the $indexStats access counter of an index, summed over all
+// hosts that reported it.
+type IndexUsage struct {
+	Ops   int64     `json:"ops"`
+	Since time.Time `json:"since"`
+}
+
+// IndexInfo describes one index of the maplocations collection.
+type IndexInfo struct {
+	Name   string      `json:"name"`
+	Keys   []IndexKey  `json:"keys"`
+	Unique bool        `json:"unique,omitempty"`
+	Sparse bool        `json:"sparse,omitempty"`
+	Usage  *IndexUsage `json:"usage,omitempty"`
+}
+
+// adminIndexesHandler lists the collection's indexes along with their usage
+// statistics when the server reports them.
+func adminIndexesHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := store.(*mongoStorage)
+	if !ok {
+		http.Error(w, "Index listing needs the mongo storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	indexes, err := s.listIndexes(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	// $indexStats needs extra privileges and is missing on some deployments,
+	// so the index list is still useful without it
+	usage, err := s.indexUsage(ctx)
+	if err != nil {
+		logFor(ctx).Warn("failed to fetch index usage stats", "error", err)
+	}
+	for i := range indexes {
+		indexes[i].Usage = usage[indexes[i].Name]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, indexes, "index list")
+}
+
+// listIndexes returns the indexes of the maplocations collection.
+func (s *mongoStorage) listIndexes(ctx context.Context) ([]IndexInfo, error) {
+	cursor, err := s.coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	var specs []struct {
+		Name   string `bson:"name"`
+		Key    bson.D `bson:"key"`
+		Unique bool   `bson:"unique"`
+		Sparse bool   `bson:"sparse"`
+	}
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, fmt.Errorf("failed to decode indexes: %w", err)
+	}
+
+	indexes := make([]IndexInfo, 0, len(specs))
+	for _, spec := range specs {
+		info := IndexInfo{Name: spec.Name, Unique: spec.Unique, Sparse: spec.Sparse}
+		for _, e := range spec.Key {
+			info.Keys = append(info.Keys, IndexKey{Field: e.Key, Value: e.Value})
+		}
+		indexes = append(indexes, info)
+	}
+	return indexes, nil
+}
+
+// indexUsage runs $indexStats and returns the access counters by index name.
+func (s *mongoStorage) indexUsage(ctx context.Context) (map[string]*IndexUsage, error) {
+	cursor, err := s.coll.Aggregate(ctx, bson.A{bson.D{{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []struct {
+		Name     string `bson:"name"`
+		Accesses struct {
+			Ops   int64     `bson:"ops"`
+			Since time.Time `bson:"since"`
+		} `bson:"accesses"`
+	}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*IndexUsage, len(stats))
+	for _, st := range stats {
+		u, ok := usage[st.Name]
+		if !ok {
+			usage[st.Name] = &IndexUsage{Ops: st.Accesses.Ops, Since: st.Accesses.Since}
+			continue
+		}
+		u.Ops += st.Accesses.Ops
+		if st.Accesses.Since.Before(u.Since) {
+			u.Since = st.Accesses.Since
+		}
+	}
+	return usage, nil
+}
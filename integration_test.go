@@ -0,0 +1,438 @@
+//go:build integration
+
+package main
+
+// The integration tests run the whole server against a disposable MongoDB
+// started in Docker, seeded with the demo fixtures:
+//
+//	go test -tags integration ./...
+//
+// MongoDB runs as a single-member replica set so that change streams, and
+// with them refresh on change, work as in production. Without a reachable
+// Docker daemon the integration tests are skipped and the unit tests still
+// run.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const integrationAdminKey = "integration-admin-key"
+
+// integrationServer is the server under test, nil when Docker is missing;
+// integrationSkip then says why.
+var (
+	integrationServer *httptest.Server
+	integrationSkip   string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegrationMain(m))
+}
+
+// runIntegrationMain starts MongoDB and the server, runs the tests and
+// tears both down again.
+func runIntegrationMain(m *testing.M) int {
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		integrationSkip = "Docker is unavailable: " + err.Error()
+		return m.Run()
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	mongod, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "mongo",
+		Tag:        "7",
+		Cmd:        []string{"--replSet", "rs0", "--bind_ip_all"},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to start MongoDB:", err)
+		return 1
+	}
+	defer pool.Purge(mongod)
+	// Reaped by Docker even if the tests are killed before Purge
+	mongod.Expire(600)
+
+	uri := "mongodb://" + mongod.GetHostPort("27017/tcp") + "/?directConnection=true"
+	if err := pool.Retry(func() error { return initiateReplicaSet(uri) }); err != nil {
+		fmt.Fprintln(os.Stderr, "MongoDB did not come up:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := startIntegrationServer(ctx, uri); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to start the server:", err)
+		return 1
+	}
+	defer integrationServer.Close()
+	defer store.Close(context.Background())
+
+	return m.Run()
+}
+
+// initiateReplicaSet makes the fresh mongod a one-member replica set and
+// waits for it to become primary.
+func initiateReplicaSet(uri string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	admin := client.Database("admin")
+
+	var hello struct {
+		IsWritablePrimary bool   `bson:"isWritablePrimary"`
+		SetName           string `bson:"setName"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return err
+	}
+	if hello.SetName == "" {
+		cfg := bson.D{{Key: "_id", Value: "rs0"}, {Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: "localhost:27017"}}}}}
+		if err := admin.RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: cfg}}).Err(); err != nil {
+			return err
+		}
+	}
+	if !hello.IsWritablePrimary {
+		return fmt.Errorf("not primary yet")
+	}
+	return nil
+}
+
+// startIntegrationServer configures the server for uri as main does,
+// seeds it with the demo fixtures and serves it on a random port.
+func startIntegrationServer(ctx context.Context, uri string) error {
+	for name, value := range map[string]string{
+		"STORAGE_BACKEND":   "mongo",
+		"MONGO_URI":         uri,
+		"MONGO_DATABASE":    fmt.Sprintf("soulforged_it_%d", time.Now().UnixNano()),
+		"MONGO_MIGRATE":     "true",
+		"ADMIN_API_KEY":     integrationAdminKey,
+		"REFRESH_INTERVAL":  "500ms",
+		"REFRESH_ON_CHANGE": "true",
+		// Every test client shares one IP
+		"RATE_LIMIT_READ_RATE":  "0",
+		"RATE_LIMIT_WRITE_RATE": "0",
+		"RATE_LIMIT_AUTH_RATE":  "0",
+	} {
+		os.Setenv(name, value)
+	}
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		return err
+	}
+	config = cfg
+
+	if err := initServer(ctx); err != nil {
+		return err
+	}
+	fixtures, err := parseFixtures(demoFixtures)
+	if err != nil {
+		return err
+	}
+	if _, err := restore(ctx, fixtures, true); err != nil {
+		return err
+	}
+
+	go updateCacheAsync(ctx, config.RefreshInterval)
+	go watchChanges(ctx)
+	integrationServer = httptest.NewServer(registerRoutes())
+	return nil
+}
+
+// requireIntegration skips t unless the server under test is running.
+func requireIntegration(t *testing.T) {
+	t.Helper()
+	if integrationServer == nil {
+		t.Skip("skipping integration test: " + integrationSkip)
+	}
+}
+
+// fetch sends a request to the server under test as the admin and returns
+// the response with its body read. header holds name, value pairs.
+func fetch(method, path, body string, header ...string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, integrationServer.URL+path, strings.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("X-API-Key", integrationAdminKey)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := integrationServer.Client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	return resp, raw, err
+}
+
+// call is fetch failing t on a transport error.
+func call(t *testing.T, method, path, body string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	resp, raw, err := fetch(method, path, body, header...)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp, raw
+}
+
+// mapIDs lists the IDs of the locations /api/map serves.
+func mapIDs(t *testing.T) map[string]bool {
+	t.Helper()
+	resp, body := call(t, "GET", "/api/map", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/map: status %d: %s", resp.StatusCode, body)
+	}
+	var locs []MapLocation
+	if err := json.Unmarshal(body, &locs); err != nil {
+		t.Fatalf("GET /api/map: %v", err)
+	}
+	ids := make(map[string]bool, len(locs))
+	for _, loc := range locs {
+		ids[loc.ID] = true
+	}
+	return ids
+}
+
+// eventually polls cond until it holds or timeout passes.
+func eventually(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestIntegrationGetEndpoints requests every GET route the server describes,
+// with its parameters filled in from the fixtures, and expects none of them
+// to fail on the server's side.
+func TestIntegrationGetEndpoints(t *testing.T) {
+	requireIntegration(t)
+
+	// Path parameters by the route they appear in, and the query parameters
+	// routes need to do more than reject the request
+	ids := map[string]string{
+		"/api/map/":           "ashenvale",
+		"/api/resources/":     "iron-vein",
+		"/api/creatures/":     "bog-wraith",
+		"/api/edges/":         "ashenvale-emberforge",
+		"/api/items/":         "iron-ore",
+		"/api/recipes/":       "smelt-iron",
+		"/api/events/":        "crypt-lord",
+		"/api/prices/":        "iron-ore",
+		"/api/annotations/":   "missing",
+		"/api/submissions/":   "missing",
+		"/api/admin/webhooks": "missing",
+	}
+	queries := map[string]string{
+		"/api/map/search":    "q=ash",
+		"/api/map/near":      "x=100&y=100&radius=500",
+		"/api/map/distances": "ids=ashenvale,greywatch",
+		"/api/route":         "from=ashenvale&to=emberforge",
+		"/graphql":           "query=" + url.QueryEscape("{ locations { id } }"),
+	}
+	// Streams never end and tiles are not configured
+	skip := map[string]bool{"/ws": true, "/api/map/stream": true, "/tiles/{path...}": true}
+
+	for _, r := range activeRoutes {
+		// The tables above name the routes without their version
+		unversioned := strings.Replace(r.Path, apiV1, "/api", 1)
+		if r.Method != "GET" || skip[unversioned] {
+			continue
+		}
+		path := strings.ReplaceAll(r.Path, "{world}", defaultWorld.name)
+		for prefix, id := range ids {
+			if strings.HasPrefix(unversioned, prefix) {
+				path = strings.NewReplacer("{id}", id, "{item}", id).Replace(path)
+			}
+		}
+		if q := queries[unversioned]; q != "" {
+			path += "?" + q
+		}
+		t.Run(r.Path, func(t *testing.T) {
+			resp, body := call(t, "GET", path, "")
+			if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+				t.Errorf("GET %s: status %d: %s", path, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+// TestIntegrationLocationLifecycle creates, reads, replaces, deletes and
+// restores a location, checking that each write shows on the map at once.
+func TestIntegrationLocationLifecycle(t *testing.T) {
+	requireIntegration(t)
+
+	resp, body := call(t, "POST", "/api/map", `{"id":"it-keep","location":"Integration Keep","xy":{"x":500,"y":500},"region":"east"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", resp.StatusCode, body)
+	}
+	if !mapIDs(t)["it-keep"] {
+		t.Fatal("the created location is not on the map")
+	}
+
+	resp, body = call(t, "GET", "/api/map/it-keep", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("get: status %d, ETag %q: %s", resp.StatusCode, etag, body)
+	}
+	if resp, _ := call(t, "GET", "/api/map/it-keep", "", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional get: status %d, want 304", resp.StatusCode)
+	}
+
+	update := `{"id":"it-keep","location":"Integration Keep","xy":{"x":510,"y":505},"region":"east"}`
+	if resp, body := call(t, "PUT", "/api/map/it-keep", update, "If-Match", `"stale"`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale update: status %d, want 412: %s", resp.StatusCode, body)
+	}
+	if resp, body := call(t, "PUT", "/api/map/it-keep", update, "If-Match", etag); resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: status %d: %s", resp.StatusCode, body)
+	}
+	var loc MapLocation
+	_, body = call(t, "GET", "/api/map/it-keep", "")
+	if err := json.Unmarshal(body, &loc); err != nil || loc.XY.X != 510 {
+		t.Errorf("after update: got %s (%v)", body, err)
+	}
+
+	if resp, body := call(t, "DELETE", "/api/map/it-keep", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", resp.StatusCode, body)
+	}
+	if mapIDs(t)["it-keep"] {
+		t.Error("the deleted location is still on the map")
+	}
+	if resp, body := call(t, "POST", "/api/admin/trash/it-keep/restore", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", resp.StatusCode, body)
+	}
+	if !mapIDs(t)["it-keep"] {
+		t.Error("the restored location is not on the map")
+	}
+}
+
+// TestIntegrationRefreshPicksUpExternalWrites writes to the collection
+// behind the server's back, as another replica or a script would, and waits
+// for the cache to catch up.
+func TestIntegrationRefreshPicksUpExternalWrites(t *testing.T) {
+	requireIntegration(t)
+
+	coll := store.(*mongoStorage).coll
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := coll.InsertOne(ctx, MapLocation{ID: "it-outside", Location: "Written Elsewhere", XY: Coordinates{X: 7, Y: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, "the external insert", func() bool { return mapIDs(t)["it-outside"] })
+
+	if _, err := coll.DeleteOne(ctx, bson.M{"_id": "it-outside"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, "the external delete", func() bool { return !mapIDs(t)["it-outside"] })
+}
+
+// TestIntegrationConcurrentReaders reads the map from many clients while
+// locations are written, checking that every read gets a whole snapshot.
+func TestIntegrationConcurrentReaders(t *testing.T) {
+	requireIntegration(t)
+
+	const readers, reads, writes = 16, 25, 10
+	base := len(mapIDs(t))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < writes; i++ {
+			body := fmt.Sprintf(`{"id":"it-busy-%d","location":"Busy %d","xy":{"x":%d,"y":900}}`, i, i, 600+i*10)
+			resp, raw, err := fetch("PUT", fmt.Sprintf("/api/map/it-busy-%d", i), body)
+			if err != nil || resp.StatusCode >= 300 {
+				t.Errorf("write %d: %v: %s", i, err, raw)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < reads; i++ {
+				resp, body, err := fetch("GET", "/api/map", "")
+				var locs []MapLocation
+				if err == nil {
+					err = json.Unmarshal(body, &locs)
+				}
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Errorf("read: %v: %s", err, body)
+					return
+				}
+				if len(locs) < base || len(locs) > base+writes {
+					t.Errorf("read %d locations, want between %d and %d", len(locs), base, base+writes)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	ids := mapIDs(t)
+	for i := 0; i < writes; i++ {
+		if !ids[fmt.Sprintf("it-busy-%d", i)] {
+			t.Errorf("it-busy-%d is missing after the writes", i)
+		}
+	}
+}
+
+// TestIntegrationDatasets checks the seeded datasets and the lookups built
+// on them.
+func TestIntegrationDatasets(t *testing.T) {
+	requireIntegration(t)
+
+	var craftable []Recipe
+	_, body := call(t, "GET", "/api/recipes?with=iron-ore,coal", "")
+	if err := json.Unmarshal(body, &craftable); err != nil || len(craftable) != 1 || craftable[0].ID != "smelt-iron" {
+		t.Errorf("recipes with iron ore and coal: got %s (%v)", body, err)
+	}
+
+	var occurrences []EventOccurrence
+	_, body = call(t, "GET", "/api/events/occurrences?kind=boss-spawn", "")
+	if err := json.Unmarshal(body, &occurrences); err != nil || len(occurrences) < 4 {
+		t.Errorf("weekly boss spawns in the next 30 days: got %s (%v)", body, err)
+	}
+
+	resp, body := call(t, "GET", "/api/events/calendar.ics", "")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") || !strings.Contains(string(body), "RRULE:FREQ=WEEKLY") {
+		t.Errorf("calendar: Content-Type %q, body %s", ct, body)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example/souforged/validation"
+)
+
+// maxItemDescription caps the length of an item's description.
+const maxItemDescription = 1000
+
+// Item is a thing players carry, craft and trade, stored in the items
+// collection.
+type Item struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// Category groups items, e.g. "ore" or "weapon"; it is a lower-case slug
+	Category    string `json:"category,omitempty" bson:"category,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+func (i Item) RecordID() string       { return i.ID }
+func (i *Item) setRecordID(id string) { i.ID = id }
+
+// items is served at /api/items.
+var items = newDataset("items", "item", checkItem, itemFilter)
+
+// checkItem validates it.
+func checkItem(it *Item) []FieldError {
+	var errs validation.Errors
+
+	if errs.NonEmpty("name", it.Name) {
+		errs.MaxLength("name", it.Name, validationRules.MaxNameLength)
+	}
+	if it.Category != "" && (!slugPattern.MatchString(it.Category) || len(it.Category) > maxMetadataLength) {
+		errs.Add("category", "must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)
+	}
+	errs.MaxLength("description", it.Description, maxItemDescription)
+
+	return errs
+}
+
+// itemFilter selects items by ?category= and by ?q=, a case-insensitive
+// substring of the name.
+func itemFilter(q url.Values) (func(*Item) bool, error) {
+	category, name := q.Get("category"), strings.ToLower(strings.TrimSpace(q.Get("q")))
+	if len(name) > maxSearchQuery {
+		return nil, fmt.Errorf("q must be at most %d bytes", maxSearchQuery)
+	}
+	if category == "" && name == "" {
+		return nil, nil
+	}
+	return func(it *Item) bool {
+		return (category == "" || it.Category == category) &&
+			(name == "" || strings.Contains(strings.ToLower(it.Name), name))
+	}, nil
+}
+
+// ItemRecipes is the answer to /api/items/{id}/recipes.
+type ItemRecipes struct {
+	Item Item `json:"item"`
+	// MadeBy are the recipes producing the item and UsedIn those taking it
+	// as an ingredient, i.e. what can be made with it
+	MadeBy []Recipe `json:"madeBy"`
+	UsedIn []Recipe `json:"usedIn"`
+}
+
+// itemRecipesHandler looks up the recipes an item takes part in.
+func itemRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+
+	itemSnap, err := items.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	item, found := itemSnap.lookup(id)
+	if !found {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	recipeSnap, err := recipes.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	out := ItemRecipes{Item: item, MadeBy: []Recipe{}, UsedIn: []Recipe{}}
+	for _, rec := range recipeSnap.items {
+		if rec.Output == id {
+			out.MadeBy = append(out.MadeBy, rec)
+		}
+		if rec.uses(id) {
+			out.UsedIn = append(out.UsedIn, rec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, out, "item recipes")
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example/souforged/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// builtinLayer is the layer of the map locations, which are served and
+// written at /api/map and listed with the declared layers.
+const builtinLayer = "locations"
+
+// Types of layer fields.
+const (
+	layerString  = "string"
+	layerNumber  = "number"
+	layerInteger = "integer"
+	layerBoolean = "boolean"
+	layerStrings = "strings"
+)
+
+var layerFieldTypes = map[string]bool{layerString: true, layerNumber: true, layerInteger: true, layerBoolean: true, layerStrings: true}
+
+// layerFieldPattern is what a field name may look like; it is also the
+// query parameter filtering by the field.
+var layerFieldPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,31}$`)
+
+// reservedLayerParams are the query parameters of a layer listing that are
+// not fields.
+var reservedLayerParams = map[string]bool{"minX": true, "minY": true, "maxX": true, "maxY": true}
+
+// Limits on the properties of layer records.
+const (
+	maxLayerText = 2000
+	maxLayerList = 100
+)
+
+// layerCollection names the collection, and the dataset, of a layer.
+func layerCollection(name string) string { return "layer_" + name }
+
+// LayerProperties are the values of a layer record's fields.
+type LayerProperties map[string]any
+
+// MarshalBSON stores the properties in key order, so that the same record
+// always encodes, and hashes, the same.
+func (p LayerProperties) MarshalBSON() ([]byte, error) {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	doc := make(bson.D, 0, len(keys))
+	for _, k := range keys {
+		doc = append(doc, bson.E{Key: k, Value: p[k]})
+	}
+	return bson.Marshal(doc)
+}
+
+// LayerRecord is a record of a declared layer: a point on the map with the
+// properties the layer's schema allows.
+type LayerRecord struct {
+	ID         string          `json:"id" bson:"_id"`
+	X          float64         `json:"x" bson:"x"`
+	Y          float64         `json:"y" bson:"y"`
+	Properties LayerProperties `json:"properties" bson:"properties"`
+}
+
+func (r LayerRecord) RecordID() string       { return r.ID }
+func (r *LayerRecord) setRecordID(id string) { r.ID = id }
+
+// layer is a declared overlay and the dataset holding its records.
+type layer struct {
+	LayerConfig
+	records *dataset[LayerRecord]
+}
+
+// layers lists the layers of config.Layers in declared order.
+var layers []*layer
+
+// declareLayers registers a dataset for each of config.Layers, so that the
+// refresher, backups and the cache report handle them like any other,
+// replacing those of an earlier call.
+func declareLayers() {
+	datasets = slices.DeleteFunc(datasets, func(d datasetRefresher) bool {
+		return strings.HasPrefix(d.collection(), layerCollection(""))
+	})
+	layers = nil
+	for _, cfg := range config.Layers {
+		l := &layer{LayerConfig: cfg}
+		l.records = newDataset(layerCollection(cfg.Name), "record", l.check, l.filter)
+		l.records.route = "/api/layers/" + cfg.Name
+		l.records.private = cfg.Read != ""
+		layers = append(layers, l)
+	}
+}
+
+// writeRole is the role needed to create and replace the layer's records.
+func (l *layer) writeRole() string {
+	if l.Write == "" {
+		return roleContributor
+	}
+	return l.Write
+}
+
+// mount registers the layer's routes on g, which is /api/layers, guarded by
+// the layer's roles.
+func (l *layer) mount(g routeGroup) {
+	read, write, admin := g, g.group("", withRole(l.writeRole())), g.group("", requireAdmin)
+	readNote := ""
+	if l.Read != "" {
+		read = g.group("", withRole(l.Read))
+		readNote = " (" + l.Read + ")"
+	}
+	description := l.Description
+	if description == "" {
+		description = "Records of the " + l.Name + " layer"
+	}
+
+	base := "/" + l.Name
+	read.get(base, description+", optionally in ?minX=&minY=&maxX=&maxY= and by ?{field}= values"+readNote, l.records.listHandler)
+	write.post(base, "Add a record to the "+l.Name+" layer ("+l.writeRole()+")", l.records.createHandler)
+	read.get(base+"/{id}", "A single record of the "+l.Name+" layer"+readNote, l.records.getHandler)
+	write.put(base+"/{id}", "Replace a record of the "+l.Name+" layer ("+l.writeRole()+")", l.records.updateHandler)
+	admin.delete(base+"/{id}", "Remove a record of the "+l.Name+" layer (admin)", l.records.deleteHandler)
+}
+
+// mountLayers registers /api/layers, the built-in locations layer and every
+// declared layer on api.
+func mountLayers(api routeGroup) {
+	g := api.group("/layers")
+	anyone := g.group("", identify)
+	anyone.get("", "The map layers the caller may read and the fields of their records", layersHandler)
+	anyone.get("/"+builtinLayer, "The locations layer, served as /api/map serves it", getMapDataHandler)
+	g.get("/"+builtinLayer+"/{id}", "A single location", getMapLocationHandler)
+	for _, l := range layers {
+		l.mount(g)
+	}
+}
+
+// check validates a record against the layer's fields. Properties set to
+// null are dropped.
+func (l *layer) check(rec *LayerRecord) []FieldError {
+	var errs validation.Errors
+	errs.Finite("x", rec.X)
+	errs.Finite("y", rec.Y)
+	if rec.Properties == nil {
+		rec.Properties = LayerProperties{}
+	}
+
+	declared := map[string]bool{}
+	for _, f := range l.Fields {
+		declared[f.Name] = true
+		field := "properties." + f.Name
+		v, ok := rec.Properties[f.Name]
+		if !ok || v == nil {
+			delete(rec.Properties, f.Name)
+			if f.Required {
+				errs.Add(field, "must be set")
+			}
+			continue
+		}
+		checkLayerValue(&errs, field, f, v)
+	}
+
+	var unknown []string
+	for name := range rec.Properties {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs.Add("properties."+name, "is not a field of the %s layer", l.Name)
+	}
+	return errs
+}
+
+// checkLayerValue checks a property, as decoded from JSON, against its field.
+func checkLayerValue(errs *validation.Errors, field string, f LayerField, v any) {
+	inEnum := func(field, s string) {
+		if len(f.Enum) > 0 && !slices.Contains(f.Enum, s) {
+			errs.Add(field, "must be one of %s", strings.Join(f.Enum, ", "))
+		}
+	}
+
+	switch f.Type {
+	case layerString:
+		s, ok := v.(string)
+		if !ok {
+			errs.Add(field, "must be a string")
+			return
+		}
+		errs.MaxLength(field, s, maxLayerText)
+		inEnum(field, s)
+	case layerNumber, layerInteger:
+		n, ok := v.(float64)
+		if !ok {
+			errs.Add(field, "must be a number")
+			return
+		}
+		if errs.Finite(field, n) && f.Type == layerInteger && (n != math.Trunc(n) || math.Abs(n) > 1<<53) {
+			errs.Add(field, "must be an integer")
+		}
+	case layerBoolean:
+		if _, ok := v.(bool); !ok {
+			errs.Add(field, "must be true or false")
+		}
+	case layerStrings:
+		list, ok := v.([]any)
+		if !ok {
+			errs.Add(field, "must be a list of strings")
+			return
+		}
+		if len(list) > maxLayerList {
+			errs.Add(field, "must have at most %d entries, got %d", maxLayerList, len(list))
+		}
+		for i, item := range list {
+			item, ok := item.(string)
+			name := fmt.Sprintf("%s[%d]", field, i)
+			if !ok {
+				errs.Add(name, "must be a string")
+				continue
+			}
+			errs.MaxLength(name, item, maxLayerText)
+			inEnum(name, item)
+		}
+	}
+}
+
+// filter selects records in ?minX=&minY=&maxX=&maxY= and by the value of any
+// field given as a query parameter: equal for single values, contained for
+// lists of strings.
+func (l *layer) filter(q url.Values) (func(*LayerRecord) bool, error) {
+	box, err := parseBoxQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	type condition struct {
+		name  string
+		match func(any) bool
+	}
+	var conditions []condition
+	for _, f := range l.Fields {
+		if !q.Has(f.Name) {
+			continue
+		}
+		match, err := layerValueMatcher(f, q.Get(f.Name))
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition{f.Name, match})
+	}
+	if box == nil && conditions == nil {
+		return nil, nil
+	}
+
+	return func(rec *LayerRecord) bool {
+		if box != nil && (rec.X < box.MinX || rec.X > box.MaxX || rec.Y < box.MinY || rec.Y > box.MaxY) {
+			return false
+		}
+		for _, c := range conditions {
+			if v, ok := rec.Properties[c.name]; !ok || !c.match(v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// layerValueMatcher parses the query value want for field f and returns the
+// test of a stored property against it.
+func layerValueMatcher(f LayerField, want string) (func(any) bool, error) {
+	switch f.Type {
+	case layerNumber, layerInteger:
+		n, err := strconv.ParseFloat(want, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", f.Name)
+		}
+		return func(v any) bool { got, ok := layerNumberValue(v); return ok && got == n }, nil
+	case layerBoolean:
+		b, err := strconv.ParseBool(want)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", f.Name)
+		}
+		return func(v any) bool { return v == b }, nil
+	case layerStrings:
+		return func(v any) bool {
+			list, _ := v.([]any)
+			if a, ok := v.(bson.A); ok {
+				list = a
+			}
+			return slices.Contains(list, any(want))
+		}, nil
+	default:
+		return func(v any) bool { return v == want }, nil
+	}
+}
+
+// layerNumberValue reads a number as decoded from JSON or BSON.
+func layerNumberValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// LayerInfo describes a layer in /api/layers.
+type LayerInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Builtin marks the locations layer, whose records are the map
+	// locations with their fixed fields
+	Builtin bool         `json:"builtin,omitempty"`
+	Fields  []LayerField `json:"fields"`
+	// Read is the role needed to read the layer, empty for anyone
+	Read  string `json:"read,omitempty"`
+	Write string `json:"write"`
+	Href  string `json:"href"`
+}
+
+// layersHandler lists the layers the caller may read.
+func layersHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := requestPrincipal(r.Context())
+	out := []LayerInfo{{
+		Name:        builtinLayer,
+		Description: "The map locations, written at /api/map",
+		Builtin:     true,
+		Fields:      []LayerField{},
+		Write:       roleContributor,
+		Href:        apiPath(r.Context(), "/api/layers/"+builtinLayer),
+	}}
+	for _, l := range layers {
+		if l.Read != "" && roleRank[p.Role] < roleRank[l.Read] {
+			continue
+		}
+		fields := l.Fields
+		if fields == nil {
+			fields = []LayerField{}
+		}
+		out = append(out, LayerInfo{
+			Name:        l.Name,
+			Description: l.Description,
+			Fields:      fields,
+			Read:        l.Read,
+			Write:       l.writeRole(),
+			Href:        apiPath(r.Context(), l.records.route),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	writeJSON(w, out, "layers")
+}
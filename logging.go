@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the request ID in both directions: a sane incoming
+// value is kept so IDs can be followed across services, otherwise one is
+// generated.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds accepted incoming request IDs.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// initLogger installs a JSON slog handler on w as the default logger: stdout
+// for the server, stderr for subcommands whose output goes to stdout.
+// LOG_LEVEL sets the minimum level (debug, info, warn or error; default info).
+func initLogger(w io.Writer) error {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", v)
+		}
+	}
+
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// requestID returns the ID assigned to the request carrying ctx, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logFor returns the default logger annotated with the request and trace IDs
+// from ctx.
+func logFor(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := requestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if id := traceID(ctx); id != "" {
+		logger = logger.With("trace_id", id)
+	}
+	return logger
+}
+
+// newRequestID returns a random 16-byte hex ID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether an incoming ID is safe to echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(c rune) bool {
+		return c < 0x21 || c > 0x7e
+	})
+}
+
+// logRequests assigns each request an ID, returns it in X-Request-ID, makes it
+// available to handlers through the request context and logs one line per
+// request with the route, status and duration.
+func logRequests(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		sw := &statusWriter{ResponseWriter: w}
+		handler(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logFor(r.Context()).LogAttrs(r.Context(), level, "request",
+			slog.String("route", route),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	}
+}
@@ -0,0 +1,6 @@
+// Package mappb holds the protobuf encoding of map locations served to
+// clients that send Accept: application/x-protobuf, and the MapService gRPC
+// API served on GRPC_PORT.
+package mappb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative maplocation.proto mapservice.proto
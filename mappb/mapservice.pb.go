@@ -0,0 +1,468 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0-devel
+// 	protoc        (unknown)
+// source: mapservice.proto
+
+package mappb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LocationChange_Type int32
+
+const (
+	LocationChange_TYPE_UNSPECIFIED LocationChange_Type = 0
+	LocationChange_INSERT           LocationChange_Type = 1
+	LocationChange_UPDATE           LocationChange_Type = 2
+	LocationChange_REPLACE          LocationChange_Type = 3
+	LocationChange_DELETE           LocationChange_Type = 4
+	// Sent for each location at the start of the stream when requested
+	LocationChange_SNAPSHOT LocationChange_Type = 5
+)
+
+// Enum value maps for LocationChange_Type.
+var (
+	LocationChange_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "INSERT",
+		2: "UPDATE",
+		3: "REPLACE",
+		4: "DELETE",
+		5: "SNAPSHOT",
+	}
+	LocationChange_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"INSERT":           1,
+		"UPDATE":           2,
+		"REPLACE":          3,
+		"DELETE":           4,
+		"SNAPSHOT":         5,
+	}
+)
+
+func (x LocationChange_Type) Enum() *LocationChange_Type {
+	p := new(LocationChange_Type)
+	*p = x
+	return p
+}
+
+func (x LocationChange_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (LocationChange_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_mapservice_proto_enumTypes[0].Descriptor()
+}
+
+func (LocationChange_Type) Type() protoreflect.EnumType {
+	return &file_mapservice_proto_enumTypes[0]
+}
+
+func (x LocationChange_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use LocationChange_Type.Descriptor instead.
+func (LocationChange_Type) EnumDescriptor() ([]byte, []int) {
+	return file_mapservice_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type ListLocationsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only locations in this region, when set
+	Region string `protobuf:"bytes,1,opt,name=region,proto3" json:"region,omitempty"`
+	// Only locations carrying all of these tags
+	Tags []string `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty"`
+}
+
+func (x *ListLocationsRequest) Reset() {
+	*x = ListLocationsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mapservice_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListLocationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLocationsRequest) ProtoMessage() {}
+
+func (x *ListLocationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mapservice_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLocationsRequest.ProtoReflect.Descriptor instead.
+func (*ListLocationsRequest) Descriptor() ([]byte, []int) {
+	return file_mapservice_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ListLocationsRequest) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *ListLocationsRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type GetLocationRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *GetLocationRequest) Reset() {
+	*x = GetLocationRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mapservice_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetLocationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLocationRequest) ProtoMessage() {}
+
+func (x *GetLocationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mapservice_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLocationRequest.ProtoReflect.Descriptor instead.
+func (*GetLocationRequest) Descriptor() ([]byte, []int) {
+	return file_mapservice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetLocationRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type WatchLocationsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Start the stream with a SNAPSHOT change for every current location
+	IncludeSnapshot bool `protobuf:"varint,1,opt,name=include_snapshot,json=includeSnapshot,proto3" json:"include_snapshot,omitempty"`
+}
+
+func (x *WatchLocationsRequest) Reset() {
+	*x = WatchLocationsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mapservice_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchLocationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchLocationsRequest) ProtoMessage() {}
+
+func (x *WatchLocationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mapservice_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchLocationsRequest.ProtoReflect.Descriptor instead.
+func (*WatchLocationsRequest) Descriptor() ([]byte, []int) {
+	return file_mapservice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WatchLocationsRequest) GetIncludeSnapshot() bool {
+	if x != nil {
+		return x.IncludeSnapshot
+	}
+	return false
+}
+
+type LocationChange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type LocationChange_Type `protobuf:"varint,1,opt,name=type,proto3,enum=soulforged.map.v1.LocationChange_Type" json:"type,omitempty"`
+	Id   string              `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	// Absent for DELETE
+	Location *MapLocation `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
+}
+
+func (x *LocationChange) Reset() {
+	*x = LocationChange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_mapservice_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LocationChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationChange) ProtoMessage() {}
+
+func (x *LocationChange) ProtoReflect() protoreflect.Message {
+	mi := &file_mapservice_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationChange.ProtoReflect.Descriptor instead.
+func (*LocationChange) Descriptor() ([]byte, []int) {
+	return file_mapservice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LocationChange) GetType() LocationChange_Type {
+	if x != nil {
+		return x.Type
+	}
+	return LocationChange_TYPE_UNSPECIFIED
+}
+
+func (x *LocationChange) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *LocationChange) GetLocation() *MapLocation {
+	if x != nil {
+		return x.Location
+	}
+	return nil
+}
+
+var File_mapservice_proto protoreflect.FileDescriptor
+
+var file_mapservice_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x6d, 0x61, 0x70, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x11, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d,
+	0x61, 0x70, 0x2e, 0x76, 0x31, 0x1a, 0x11, 0x6d, 0x61, 0x70, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x42, 0x0a, 0x14, 0x4c, 0x69, 0x73, 0x74,
+	0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73,
+	0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x22, 0x24, 0x0a, 0x12,
+	0x47, 0x65, 0x74, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x69, 0x64, 0x22, 0x42, 0x0a, 0x15, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x6f, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x29, 0x0a, 0x10, 0x69,
+	0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0f, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x53, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x22, 0xf5, 0x01, 0x0a, 0x0e, 0x4c, 0x6f, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x3a, 0x0a, 0x04, 0x74, 0x79, 0x70,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x26, 0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f,
+	0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x6f, 0x63, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52,
+	0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x3a, 0x0a, 0x08, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f,
+	0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x70, 0x4c,
+	0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x22, 0x5b, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x10, 0x54, 0x59, 0x50,
+	0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
+	0x0a, 0x0a, 0x06, 0x49, 0x4e, 0x53, 0x45, 0x52, 0x54, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x55,
+	0x50, 0x44, 0x41, 0x54, 0x45, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x45, 0x50, 0x4c, 0x41,
+	0x43, 0x45, 0x10, 0x03, 0x12, 0x0a, 0x0a, 0x06, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x04,
+	0x12, 0x0c, 0x0a, 0x08, 0x53, 0x4e, 0x41, 0x50, 0x53, 0x48, 0x4f, 0x54, 0x10, 0x05, 0x32, 0xa1,
+	0x02, 0x0a, 0x0a, 0x4d, 0x61, 0x70, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x5c, 0x0a,
+	0x0d, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x27,
+	0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e,
+	0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f,
+	0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x70, 0x4c,
+	0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x54, 0x0a, 0x0b, 0x47,
+	0x65, 0x74, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x25, 0x2e, 0x73, 0x6f, 0x75,
+	0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x47,
+	0x65, 0x74, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1e, 0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d,
+	0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x70, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x12, 0x5f, 0x0a, 0x0e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x12, 0x28, 0x2e, 0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64,
+	0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x6f, 0x63,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e,
+	0x73, 0x6f, 0x75, 0x6c, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2e, 0x6d, 0x61, 0x70, 0x2e, 0x76,
+	0x31, 0x2e, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65,
+	0x30, 0x01, 0x42, 0x19, 0x5a, 0x17, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2f, 0x73, 0x6f,
+	0x75, 0x66, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x2f, 0x6d, 0x61, 0x70, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_mapservice_proto_rawDescOnce sync.Once
+	file_mapservice_proto_rawDescData = file_mapservice_proto_rawDesc
+)
+
+func file_mapservice_proto_rawDescGZIP() []byte {
+	file_mapservice_proto_rawDescOnce.Do(func() {
+		file_mapservice_proto_rawDescData = protoimpl.X.CompressGZIP(file_mapservice_proto_rawDescData)
+	})
+	return file_mapservice_proto_rawDescData
+}
+
+var file_mapservice_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_mapservice_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_mapservice_proto_goTypes = []interface{}{
+	(LocationChange_Type)(0),      // 0: soulforged.map.v1.LocationChange.Type
+	(*ListLocationsRequest)(nil),  // 1: soulforged.map.v1.ListLocationsRequest
+	(*GetLocationRequest)(nil),    // 2: soulforged.map.v1.GetLocationRequest
+	(*WatchLocationsRequest)(nil), // 3: soulforged.map.v1.WatchLocationsRequest
+	(*LocationChange)(nil),        // 4: soulforged.map.v1.LocationChange
+	(*MapLocation)(nil),           // 5: soulforged.map.v1.MapLocation
+	(*MapLocationList)(nil),       // 6: soulforged.map.v1.MapLocationList
+}
+var file_mapservice_proto_depIdxs = []int32{
+	0, // 0: soulforged.map.v1.LocationChange.type:type_name -> soulforged.map.v1.LocationChange.Type
+	5, // 1: soulforged.map.v1.LocationChange.location:type_name -> soulforged.map.v1.MapLocation
+	1, // 2: soulforged.map.v1.MapService.ListLocations:input_type -> soulforged.map.v1.ListLocationsRequest
+	2, // 3: soulforged.map.v1.MapService.GetLocation:input_type -> soulforged.map.v1.GetLocationRequest
+	3, // 4: soulforged.map.v1.MapService.WatchLocations:input_type -> soulforged.map.v1.WatchLocationsRequest
+	6, // 5: soulforged.map.v1.MapService.ListLocations:output_type -> soulforged.map.v1.MapLocationList
+	5, // 6: soulforged.map.v1.MapService.GetLocation:output_type -> soulforged.map.v1.MapLocation
+	4, // 7: soulforged.map.v1.MapService.WatchLocations:output_type -> soulforged.map.v1.LocationChange
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_mapservice_proto_init() }
+func file_mapservice_proto_init() {
+	if File_mapservice_proto != nil {
+		return
+	}
+	file_maplocation_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_mapservice_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListLocationsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_mapservice_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetLocationRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_mapservice_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchLocationsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_mapservice_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LocationChange); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_mapservice_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_mapservice_proto_goTypes,
+		DependencyIndexes: file_mapservice_proto_depIdxs,
+		EnumInfos:         file_mapservice_proto_enumTypes,
+		MessageInfos:      file_mapservice_proto_msgTypes,
+	}.Build()
+	File_mapservice_proto = out.File
+	file_mapservice_proto_rawDesc = nil
+	file_mapservice_proto_goTypes = nil
+	file_mapservice_proto_depIdxs = nil
+}
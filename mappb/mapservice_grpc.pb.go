@@ -0,0 +1,217 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: mapservice.proto
+
+package mappb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MapService_ListLocations_FullMethodName  = "/soulforged.map.v1.MapService/ListLocations"
+	MapService_GetLocation_FullMethodName    = "/soulforged.map.v1.MapService/GetLocation"
+	MapService_WatchLocations_FullMethodName = "/soulforged.map.v1.MapService/WatchLocations"
+)
+
+// MapServiceClient is the client API for MapService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MapServiceClient interface {
+	// ListLocations returns every location, optionally filtered.
+	ListLocations(ctx context.Context, in *ListLocationsRequest, opts ...grpc.CallOption) (*MapLocationList, error)
+	// GetLocation returns one location or fails with NOT_FOUND.
+	GetLocation(ctx context.Context, in *GetLocationRequest, opts ...grpc.CallOption) (*MapLocation, error)
+	// WatchLocations streams changes as they happen, like /api/map/stream.
+	WatchLocations(ctx context.Context, in *WatchLocationsRequest, opts ...grpc.CallOption) (MapService_WatchLocationsClient, error)
+}
+
+type mapServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMapServiceClient(cc grpc.ClientConnInterface) MapServiceClient {
+	return &mapServiceClient{cc}
+}
+
+func (c *mapServiceClient) ListLocations(ctx context.Context, in *ListLocationsRequest, opts ...grpc.CallOption) (*MapLocationList, error) {
+	out := new(MapLocationList)
+	err := c.cc.Invoke(ctx, MapService_ListLocations_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mapServiceClient) GetLocation(ctx context.Context, in *GetLocationRequest, opts ...grpc.CallOption) (*MapLocation, error) {
+	out := new(MapLocation)
+	err := c.cc.Invoke(ctx, MapService_GetLocation_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mapServiceClient) WatchLocations(ctx context.Context, in *WatchLocationsRequest, opts ...grpc.CallOption) (MapService_WatchLocationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MapService_ServiceDesc.Streams[0], MapService_WatchLocations_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mapServiceWatchLocationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MapService_WatchLocationsClient interface {
+	Recv() (*LocationChange, error)
+	grpc.ClientStream
+}
+
+type mapServiceWatchLocationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *mapServiceWatchLocationsClient) Recv() (*LocationChange, error) {
+	m := new(LocationChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MapServiceServer is the server API for MapService service.
+// All implementations must embed UnimplementedMapServiceServer
+// for forward compatibility
+type MapServiceServer interface {
+	// ListLocations returns every location, optionally filtered.
+	ListLocations(context.Context, *ListLocationsRequest) (*MapLocationList, error)
+	// GetLocation returns one location or fails with NOT_FOUND.
+	GetLocation(context.Context, *GetLocationRequest) (*MapLocation, error)
+	// WatchLocations streams changes as they happen, like /api/map/stream.
+	WatchLocations(*WatchLocationsRequest, MapService_WatchLocationsServer) error
+	mustEmbedUnimplementedMapServiceServer()
+}
+
+// UnimplementedMapServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMapServiceServer struct {
+}
+
+func (UnimplementedMapServiceServer) ListLocations(context.Context, *ListLocationsRequest) (*MapLocationList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLocations not implemented")
+}
+func (UnimplementedMapServiceServer) GetLocation(context.Context, *GetLocationRequest) (*MapLocation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLocation not implemented")
+}
+func (UnimplementedMapServiceServer) WatchLocations(*WatchLocationsRequest, MapService_WatchLocationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchLocations not implemented")
+}
+func (UnimplementedMapServiceServer) mustEmbedUnimplementedMapServiceServer() {}
+
+// UnsafeMapServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MapServiceServer will
+// result in compilation errors.
+type UnsafeMapServiceServer interface {
+	mustEmbedUnimplementedMapServiceServer()
+}
+
+func RegisterMapServiceServer(s grpc.ServiceRegistrar, srv MapServiceServer) {
+	s.RegisterService(&MapService_ServiceDesc, srv)
+}
+
+func _MapService_ListLocations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLocationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MapServiceServer).ListLocations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MapService_ListLocations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MapServiceServer).ListLocations(ctx, req.(*ListLocationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MapService_GetLocation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLocationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MapServiceServer).GetLocation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MapService_GetLocation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MapServiceServer).GetLocation(ctx, req.(*GetLocationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MapService_WatchLocations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchLocationsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MapServiceServer).WatchLocations(m, &mapServiceWatchLocationsServer{stream})
+}
+
+type MapService_WatchLocationsServer interface {
+	Send(*LocationChange) error
+	grpc.ServerStream
+}
+
+type mapServiceWatchLocationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *mapServiceWatchLocationsServer) Send(m *LocationChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MapService_ServiceDesc is the grpc.ServiceDesc for MapService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MapService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "soulforged.map.v1.MapService",
+	HandlerType: (*MapServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListLocations",
+			Handler:    _MapService_ListLocations_Handler,
+		},
+		{
+			MethodName: "GetLocation",
+			Handler:    _MapService_GetLocation_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchLocations",
+			Handler:       _MapService_WatchLocations_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mapservice.proto",
+}
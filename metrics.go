@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Metrics exposed at /metrics.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_http_requests_total",
+		Help: "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "soulforged_http_request_duration_seconds",
+		Help:    "Time spent serving HTTP requests, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	cacheFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_cache_fallbacks_total",
+		Help: "Reads served the last snapshot loaded because its reload failed or outlasted CACHE_LOAD_WAIT, by cache and reason: error or timeout.",
+	}, []string{"cache", "reason"})
+
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "soulforged_cache_hits_total",
+		Help: "Reads served from the cached snapshot.",
+	})
+
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "soulforged_cache_misses_total",
+		Help: "Reads that had to load the snapshot from storage.",
+	})
+
+	refreshDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "soulforged_cache_refresh_duration_seconds",
+		Help:    "Time taken by background cache refreshes, successful or not.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	refreshFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "soulforged_cache_refresh_failures_total",
+		Help: "Background cache refreshes that failed.",
+	})
+
+	refreshLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "soulforged_cache_refresh_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful background cache refresh.",
+	})
+
+	refreshLastError = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "soulforged_cache_refresh_last_error_timestamp_seconds",
+		Help: "Unix time of the last failed background cache refresh.",
+	})
+
+	mongoPoolOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soulforged_mongo_pool_connections",
+		Help: "Open MongoDB connections, by client.",
+	}, []string{"client"})
+
+	mongoPoolInUse = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soulforged_mongo_pool_connections_in_use",
+		Help: "MongoDB connections checked out of the pool, by client.",
+	}, []string{"client"})
+
+	mongoConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "soulforged_mongo_connected",
+		Help: "1 while a MongoDB server taking writes is reachable, else 0.",
+	})
+
+	refreshLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "soulforged_refresh_leader",
+		Help: "1 while this replica holds the refresher lease, else 0.",
+	})
+
+	mongoPoolWaitFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_mongo_pool_checkout_failures_total",
+		Help: "Failed MongoDB connection checkouts, by client.",
+	}, []string{"client"})
+
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_rate_limited_requests_total",
+		Help: "Requests rejected with 429, by rate limit group.",
+	}, []string{"group"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_webhook_deliveries_total",
+		Help: "Webhook events by outcome: delivered, retried, failed, or dropped before delivery.",
+	}, []string{"result"})
+
+	cdnPurges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soulforged_cdn_purges_total",
+		Help: "CDN purges after a refresh found changes, by result: purged or failed.",
+	}, []string{"result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "soulforged_mongo_slots_in_use",
+		Help: "Request slots currently held out of MONGO_MAX_CONCURRENCY.",
+	}, func() float64 { return float64(len(mongoSlots)) })
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "soulforged_stream_clients",
+		Help: "Open /api/map/stream connections.",
+	}, func() float64 { return float64(streamClients.Load()) })
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "soulforged_websocket_clients",
+		Help: "Open /ws connections.",
+	}, func() float64 { return float64(wsClients.Load()) })
+)
+
+// mongoPoolMonitor feeds the connection pool gauges for the named client.
+func mongoPoolMonitor(client string) *event.PoolMonitor {
+	open := mongoPoolOpen.WithLabelValues(client)
+	inUse := mongoPoolInUse.WithLabelValues(client)
+	failures := mongoPoolWaitFailures.WithLabelValues(client)
+
+	return &event.PoolMonitor{
+		Event: func(ev *event.PoolEvent) {
+			switch ev.Type {
+			case event.ConnectionCreated:
+				open.Inc()
+			case event.ConnectionClosed:
+				open.Dec()
+			case event.GetSucceeded:
+				inUse.Inc()
+			case event.ConnectionReturned:
+				inUse.Dec()
+			case event.GetFailed:
+				failures.Inc()
+			}
+		},
+	}
+}
+
+// statusWriter records the response status for instrument. It forwards
+// Flush and Hijack so that SSE and WebSocket handlers keep working.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	sw.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(sw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// instrument records the request count and latency of handler under the
+// route label, which is the registered path rather than the request path to
+// keep the label set bounded.
+func instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		handler(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		recordRequest(r, route, status, time.Since(start))
+		recordUsage(r, route, status)
+	}
+}
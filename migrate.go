@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"example/souforged/migrations"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// locationIndexes are the indexes on a collection of map locations: the 2d
+// index on xy behind the bounding-box and radius queries, and the text index
+// on location names behind /api/map/search. Without them those queries scan
+// the whole collection. Each world's locations live in their own collection,
+// so the built-in unique index on _id already keeps ids unique per world.
+// The 2d index covers [lo, hi) on both axes.
+func locationIndexes(collection string, lo, hi float64) []migrations.Index {
+	return []migrations.Index{
+		{Collection: collection, Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "xy", Value: "2d"}},
+			Options: options.Index().SetName("xy_2d").SetMin(lo).SetMax(hi),
+		}},
+		{Collection: collection, Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "location", Value: "text"}},
+			Options: options.Index().SetName("location_text"),
+		}},
+	}
+}
+
+// auditIndexes back the /api/admin/audit filters.
+var auditIndexes = []migrations.Index{
+	{Collection: "audit", Model: mongo.IndexModel{Keys: bson.D{{Key: "at", Value: -1}}}},
+	{Collection: "audit", Model: mongo.IndexModel{Keys: bson.D{{Key: "documentId", Value: 1}, {Key: "at", Value: -1}}}},
+}
+
+// priceIndexes back the /api/prices/{item} window query.
+var priceIndexes = []migrations.Index{
+	{Collection: "prices", Model: mongo.IndexModel{Keys: bson.D{{Key: "item", Value: 1}, {Key: "observedAt", Value: 1}}}},
+}
+
+// locationCollections names the collections of every configured world.
+func locationCollections() []string {
+	colls := []string{config.Mongo.Collection}
+	for _, name := range config.Worlds[1:] {
+		colls = append(colls, worldCollection(name))
+	}
+	return colls
+}
+
+// schemaMigrations are the changes to stored documents, applied once each in
+// version order. Append new ones; never renumber or edit shipped ones.
+var schemaMigrations = []migrations.Migration{
+	{
+		Version:     1,
+		Description: "lower-case location tags",
+		// Tags are validated as lower-case slugs and filtered in lower case,
+		// so tags written before that never match a filter
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			lower := bson.D{{Key: "$setUnion", Value: bson.A{bson.D{{Key: "$map", Value: bson.D{
+				{Key: "input", Value: "$tags"},
+				{Key: "in", Value: bson.D{{Key: "$toLower", Value: "$$this"}}},
+			}}}}}}
+			for _, coll := range locationCollections() {
+				_, err := db.Collection(coll).UpdateMany(ctx,
+					bson.D{{Key: "tags", Value: bson.D{{Key: "$regex", Value: "[A-Z]"}}}},
+					mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "tags", Value: lower}}}}},
+				)
+				if err != nil {
+					return fmt.Errorf("%s: %w", coll, err)
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// ensureIndexes creates the indexes of the storage's location collection,
+// plus those of the audit log and prices, which all worlds share, for the
+// default world. The queries still work without them, only slower, so
+// failures are logged rather than fatal.
+func (s *mongoStorage) ensureIndexes(ctx context.Context, shared bool) error {
+	indexes := locationIndexes(s.coll.Name(), config.Mongo.GeoIndexMin, config.Mongo.GeoIndexMax)
+	if shared {
+		indexes = append(indexes, auditIndexes...)
+		indexes = append(indexes, priceIndexes...)
+	}
+	if err := migrations.EnsureIndexes(ctx, s.coll.Database(), indexes); err != nil {
+		// Most likely indexes created by hand with other options, which
+		// still serve the queries
+		slog.Warn("could not ensure indexes", "error", err)
+	}
+	return nil
+}
+
+// Migrate applies the pending schema migrations and returns their versions.
+// A migration held by another replica is left to it.
+func (s *mongoStorage) Migrate(ctx context.Context) ([]int, error) {
+	applied, err := migrations.Apply(ctx, s.coll.Database(), schemaMigrations)
+	for _, v := range applied {
+		slog.Info("applied schema migration", "version", v)
+	}
+	if errors.Is(err, migrations.ErrInProgress) {
+		slog.Warn("schema migration is being applied elsewhere", "error", err)
+		return applied, nil
+	}
+	return applied, err
+}
+
+// Migrations returns the recorded migrations.
+func (s *mongoStorage) Migrations(ctx context.Context) ([]migrations.Record, error) {
+	return migrations.Records(ctx, s.coll.Database())
+}
+
+// runMigrateCommand implements the "migrate" subcommand:
+//
+//	soulforged-go migrate
+//	soulforged-go migrate status
+//
+// The indexes are ensured whenever the storage is opened, so "migrate" only
+// has the schema migrations left to apply.
+func runMigrateCommand(ctx context.Context, args []string, out io.Writer) error {
+	ms, ok := store.(MigrationStorage)
+	if !ok {
+		return fmt.Errorf("the storage backend has no migrations")
+	}
+
+	switch {
+	case len(args) == 0:
+		// Migrations rewrite whole collections, so no query timeout here
+		applied, err := ms.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "Schema is up to date")
+		}
+		for _, v := range applied {
+			fmt.Fprintf(out, "Applied migration %d\n", v)
+		}
+		return nil
+
+	case len(args) == 1 && args[0] == "status":
+		ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+		defer cancel()
+		recs, err := ms.Migrations(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list migrations: %w", err)
+		}
+		byVersion := make(map[int]migrations.Record, len(recs))
+		for _, rec := range recs {
+			byVersion[rec.Version] = rec
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tSTATE\tAPPLIED")
+		for _, m := range schemaMigrations {
+			rec, ok := byVersion[m.Version]
+			state, at := "pending", "-"
+			if ok {
+				state = rec.State
+			}
+			if !rec.AppliedAt.IsZero() {
+				at = rec.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.Version, m.Description, state, at)
+		}
+		return tw.Flush()
+
+	default:
+		return errors.New("usage: migrate | migrate status")
+	}
+}
@@ -0,0 +1,152 @@
+// Package migrations keeps a MongoDB database's indexes and document schema
+// up to date: it creates the indexes the queries rely on and applies
+// numbered schema migrations once each, recording the applied versions in
+// the database itself.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collection is where the applied versions are recorded, one document per
+// migration keyed by its version.
+const Collection = "schema_migrations"
+
+// ErrInProgress is returned by Apply when another process holds a migration.
+// Either it is still running there or that process died halfway; in the
+// latter case deleting the version's document from Collection lets the next
+// run retry it.
+var ErrInProgress = errors.New("migration in progress elsewhere")
+
+// Index is an index that must exist on a collection.
+type Index struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+// EnsureIndexes creates every index that does not exist yet. Creating an
+// index that already exists with the same options is a no-op, so this is
+// safe to run on every start. It attempts all of them and reports the ones
+// that failed, typically because an index with the same keys and other
+// options was created by hand.
+func EnsureIndexes(ctx context.Context, db *mongo.Database, indexes []Index) error {
+	var errs []error
+	for _, idx := range indexes {
+		name, err := db.Collection(idx.Collection).Indexes().CreateOne(ctx, idx.Model)
+		if err != nil {
+			if name == "" {
+				name = fmt.Sprint(idx.Model.Keys)
+			}
+			errs = append(errs, fmt.Errorf("index %s on %s: %w", name, idx.Collection, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Migration is one change to the stored documents. Up must be safe to run
+// again if it fails halfway, since a failed migration is retried from the
+// start.
+type Migration struct {
+	// Version orders the migrations; it must be positive and unique and is
+	// never reused once shipped.
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is the document Apply keeps for a migration.
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	State       string    `bson:"state" json:"state"`
+	StartedAt   time.Time `bson:"startedAt" json:"startedAt"`
+	AppliedAt   time.Time `bson:"appliedAt,omitempty" json:"appliedAt,omitempty"`
+}
+
+// The states of a Record.
+const (
+	StateRunning = "running"
+	StateApplied = "applied"
+)
+
+// Apply runs the migrations that have not been applied yet in version
+// order and returns the versions it ran. Each migration is claimed by
+// inserting its Record before it runs, so of several processes starting at
+// once only one applies it. Apply stops at the first failure, leaving the
+// later migrations for the next run.
+func Apply(ctx context.Context, db *mongo.Database, ms []Migration) ([]int, error) {
+	ms = append([]Migration(nil), ms...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has non-positive version %d", m.Description, m.Version)
+		}
+		if i > 0 && ms[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration version %d is used twice", m.Version)
+		}
+	}
+
+	coll := db.Collection(Collection)
+	var applied []int
+	for _, m := range ms {
+		_, err := coll.InsertOne(ctx, Record{
+			Version:     m.Version,
+			Description: m.Description,
+			State:       StateRunning,
+			StartedAt:   time.Now().UTC(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			var rec Record
+			if err := coll.FindOne(ctx, bson.D{{Key: "_id", Value: m.Version}}).Decode(&rec); err != nil {
+				return applied, fmt.Errorf("failed to read migration %d: %w", m.Version, err)
+			}
+			if rec.State == StateApplied {
+				continue
+			}
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, ErrInProgress)
+		}
+		if err != nil {
+			return applied, fmt.Errorf("failed to claim migration %d: %w", m.Version, err)
+		}
+
+		if err := m.Up(ctx, db); err != nil {
+			// Release the claim so the next run retries it. A fresh context,
+			// since ctx may be the reason Up failed
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			coll.DeleteOne(releaseCtx, bson.D{{Key: "_id", Value: m.Version}})
+			cancel()
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		_, err = coll.UpdateByID(ctx, m.Version, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "state", Value: StateApplied},
+			{Key: "appliedAt", Value: time.Now().UTC()},
+		}}})
+		if err != nil {
+			return applied, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+// Records returns the recorded migrations in version order.
+func Records(ctx context.Context, db *mongo.Database) ([]Record, error) {
+	cur, err := db.Collection(Collection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	if err := cur.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Version < recs[j].Version })
+	return recs, nil
+}
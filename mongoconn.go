@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Pacing of the initial connection attempts: each ping may take up to
+// connectPingTimeout, and the wait between attempts doubles from
+// config.Mongo.ConnectBackoff up to maxConnectBackoff.
+const (
+	connectPingTimeout = 10 * time.Second
+	maxConnectBackoff  = time.Minute
+)
+
+// Connection states reported at /readyz.
+const (
+	connConnecting   = "connecting"
+	connConnected    = "connected"
+	connDisconnected = "disconnected"
+)
+
+// ConnectionStorage is implemented by backends that keep a connection to a
+// server and can tell whether it is up.
+type ConnectionStorage interface {
+	ConnectionStatus() ConnectionStatus
+}
+
+// ConnectionStatus describes the connection to the database in the /readyz
+// report.
+type ConnectionStatus struct {
+	// State is connecting until the first server is found, then connected
+	// or disconnected
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// Reconnects counts the times the connection came back after a loss
+	Reconnects int `json:"reconnects"`
+}
+
+// connectionMonitor follows the driver's view of the deployment. The driver
+// reconnects by itself; the monitor logs the losses and recoveries and, on
+// recovery, has the refresher reload right away instead of waiting out its
+// backoff.
+type connectionMonitor struct {
+	mu     sync.Mutex
+	status ConnectionStatus
+}
+
+func newConnectionMonitor() *connectionMonitor {
+	return &connectionMonitor{status: ConnectionStatus{State: connConnecting, Since: time.Now().UTC()}}
+}
+
+// serverMonitor returns the driver hook feeding m.
+func (m *connectionMonitor) serverMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		// Called with the topology locked, so this must not touch the client
+		TopologyDescriptionChanged: func(ev *event.TopologyDescriptionChangedEvent) {
+			m.update(ev.NewDescription.HasWritableServer())
+		},
+	}
+}
+
+// update records whether a server taking writes is known.
+func (m *connectionMonitor) update(up bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.status.State
+	switch {
+	case up && prev == connConnected, !up && prev != connConnected:
+		return
+	case up:
+		m.status.State = connConnected
+		if prev == connDisconnected {
+			m.status.Reconnects++
+			slog.Info("reconnected to MongoDB", "down_for", time.Since(m.status.Since).Round(time.Millisecond))
+			requestRefresh()
+		}
+	default:
+		m.status.State = connDisconnected
+		slog.Warn("lost connection to MongoDB; serving cached data until it is back")
+	}
+	m.status.Since = time.Now().UTC()
+	mongoConnected.Set(boolGauge(up))
+}
+
+func (m *connectionMonitor) current() ConnectionStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// pingWithRetry waits for the deployment to answer, retrying a failed ping
+// config.Mongo.ConnectRetries times with backoff so that the server survives
+// starting before its database.
+func pingWithRetry(client *mongo.Client) error {
+	backoff := config.Mongo.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), connectPingTimeout)
+		err := client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt > config.Mongo.ConnectRetries {
+			return fmt.Errorf("MongoDB unreachable after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("MongoDB unreachable, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
+
+// boolGauge is 1 for true and 0 for false.
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
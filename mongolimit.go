@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"example/souforged/cached"
+	"net/http"
+	"time"
+)
+
+// errMongoBusy is returned when no MongoDB operation slot frees up in time.
+var errMongoBusy = errors.New("too many concurrent MongoDB operations")
+
+// mongoSlots bounds the number of request-driven MongoDB operations in flight
+// so that a burst of writes, cold reads or exports cannot exhaust the
+// connection pool. The background refresher runs on its own goroutine and is
+// deliberately not counted against it.
+var (
+	mongoSlots       = make(chan struct{}, defaultMongoPoolSize)
+	mongoWaitTimeout = 2 * time.Second
+)
+
+// loadMongoLimits applies the concurrency limit and wait timeout from config.
+func loadMongoLimits() {
+	mongoSlots = make(chan struct{}, config.Mongo.MaxConcurrency)
+	mongoWaitTimeout = config.Mongo.WaitTimeout
+}
+
+// acquireMongo waits up to mongoWaitTimeout for a MongoDB operation slot. The
+// returned release function must be called once the operation is done.
+func acquireMongo(ctx context.Context) (release func(), err error) {
+	_, span := tracer.Start(ctx, "acquireMongo")
+	defer span.End()
+
+	timer := time.NewTimer(mongoWaitTimeout)
+	defer timer.Stop()
+
+	select {
+	case mongoSlots <- struct{}{}:
+		return func() { <-mongoSlots }, nil
+	case <-timer.C:
+		return nil, errMongoBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// writeLoadError logs err and answers with 503 when MongoDB is saturated or
+// 500 otherwise.
+func writeLoadError(w http.ResponseWriter, r *http.Request, err error) {
+	logFor(r.Context()).Error("failed to load map data", "error", err)
+
+	if errors.Is(err, errMongoBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Database is busy, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, cached.ErrLoadTimeout) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Map data is still loading, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errSpatialUnsupported) {
+		http.Error(w, "The storage backend does not support this query", http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, "Failed to fetch map data", http.StatusInternalServerError)
+}
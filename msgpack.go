@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const msgpackContentType = "application/x-msgpack"
+
+// wantsMsgpack reports whether the client asked for a MessagePack body via
+// the Accept header.
+func wantsMsgpack(r *http.Request) bool {
+	return accepts(r, msgpackContentType)
+}
+
+// encodeMsgpack encodes v as MessagePack with the field names and omissions
+// of its JSON encoding, so that clients can switch formats without remapping
+// fields. Times are encoded as the MessagePack timestamp extension.
+func encodeMsgpack(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// streamMapNDJSON answers /api/map?format=ndjson with one location per line,
+// streamed from the storage backend instead of the cache. Backends that
+// implement LocationStreamer never hold more than one location in memory,
+// however large the collection, so this is the format for bulk consumers.
+// Locations come in storage order, so sorting and paging are not offered;
+// ?region=, ?tag= and ?fields= apply to each line, after redaction.
+func streamMapNDJSON(w http.ResponseWriter, r *http.Request, filter locationFilter, fields []string) {
+	ctx := r.Context()
+
+	// The slot is held for the whole stream since the cursor keeps a
+	// connection checked out
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	// The stream may outlast the server's write timeout
+	liftDeadlines(w)
+	setHeaders := func() {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// Headers are sent with the first line, so from there on errors can only
+	// be logged and the stream cut short
+	redacted := redacts(ctx)
+	if redacted {
+		fields = unredactedFields(fields)
+	}
+	n := 0
+	err = streamLocations(ctx, func(loc MapLocation) error {
+		if redacted {
+			loc = redactLocation(loc)
+		}
+		if !filter.matches(&loc) {
+			return nil
+		}
+		if n == 0 {
+			setHeaders()
+		}
+		n++
+		var line any = loc
+		if fields != nil {
+			line = projectLocations([]MapLocation{loc}, fields)[0]
+		}
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to write NDJSON map data: %w", err)
+		}
+		if flusher != nil && n%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if n == 0 {
+			writeLoadError(w, r, err)
+			return
+		}
+		logFor(ctx).Error("failed to stream NDJSON map data", "error", err)
+		return
+	}
+
+	if n == 0 {
+		setHeaders()
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits for /api/map/nearest results.
+const (
+	defaultNearestLimit = 10
+	maxNearestLimit     = 100
+)
+
+// nearestHandler returns the ?limit= locations closest to (?x=, ?y=),
+// nearest first. ?type= keeps those tagged with it, such as town or dungeon.
+// ?weighted=true ranks them by distance divided by weight instead, so that
+// important locations come first even when slightly farther, with the score
+// alongside each.
+// Unlike /api/map/near it is answered from the cached snapshot's grid,
+// rebuilt with each refresh, since the overlay asks for it as the player
+// moves.
+func nearestHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	x, errX := strconv.ParseFloat(q.Get("x"), 64)
+	y, errY := strconv.ParseFloat(q.Get("y"), 64)
+	if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+		http.Error(w, "Query parameters x and y must be finite numbers", http.StatusBadRequest)
+		return
+	}
+	limit := defaultNearestLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxNearestLimit {
+			http.Error(w, fmt.Sprintf("Query parameter limit must be between 1 and %d", maxNearestLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	kind := strings.ToLower(strings.TrimSpace(q.Get("type")))
+	weighted := false
+	if v := q.Get("weighted"); v != "" {
+		var err error
+		if weighted, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Query parameter weighted must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A ?type= the caller may not see matches nothing, as on /api/map
+	snap, err := visibleSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setSnapshotCaching(w.Header())
+	varyRedaction(w.Header())
+	if notModified(w, r, snapshotETag(snap.hash, "nearest|"+r.URL.RawQuery), snap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	grid := snap.grid()
+	if kind != "" {
+		grid = snap.tagGrid(kind)
+	}
+	var found []neighbor
+	if weighted {
+		found = grid.weightedNearestK(x, y, limit, math.Inf(1))
+	} else {
+		found = grid.nearestK(x, y, limit)
+	}
+	results := make([]NearResult, len(found))
+	for i, n := range found {
+		results[i] = NearResult{MapLocation: grid.locations[n.i], Distance: n.dist}
+		if weighted {
+			results[i].Score = &found[i].score
+		}
+	}
+	writeJSON(w, results, "nearest locations")
+}
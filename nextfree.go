@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// maxNextFreeAttempts bounds how many grid positions /api/map/next-free checks
+// before giving up.
+const maxNextFreeAttempts = 10000
+
+// nextFreeHandler returns the grid position closest to (?startX=, ?startY=),
+// on a grid of spacing ?step=, that has no marker within half a step.
+func nextFreeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	startX, errX := strconv.ParseFloat(q.Get("startX"), 64)
+	startY, errY := strconv.ParseFloat(q.Get("startY"), 64)
+	step, errStep := strconv.ParseFloat(q.Get("step"), 64)
+	if errX != nil || errY != nil || !isFinite(startX) || !isFinite(startY) {
+		http.Error(w, "Query parameters startX and startY must be finite numbers", http.StatusBadRequest)
+		return
+	}
+	if errStep != nil || step <= 0 || !isFinite(step) {
+		http.Error(w, "Query parameter step must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	free, ok := findFreePosition(snap.grid(), Coordinates{X: startX, Y: startY}, step, maxNextFreeAttempts)
+
+	if !ok {
+		http.Error(w, "No free position found near the start point", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, free, "position")
+}
+
+// findFreePosition scans the grid of spacing step anchored at start in square
+// rings of increasing size, nearest positions first, and returns the first
+// position with no location within step/2 that also passes coordinate
+// validation. It gives up after maxAttempts positions.
+func findFreePosition(grid *gridIndex, start Coordinates, step float64, maxAttempts int) (Coordinates, bool) {
+	attempts := 0
+	for ring := 0; attempts < maxAttempts; ring++ {
+		for _, offset := range ringOffsets(ring) {
+			if attempts >= maxAttempts {
+				break
+			}
+			attempts++
+
+			pos := Coordinates{
+				X: start.X + float64(offset.x)*step,
+				Y: start.Y + float64(offset.y)*step,
+			}
+			if validateCoordinates(pos) != nil {
+				continue
+			}
+
+			occupied := false
+			grid.within(pos.X, pos.Y, step/2, func(int, float64) { occupied = true })
+			if !occupied {
+				return pos, true
+			}
+		}
+	}
+	return Coordinates{}, false
+}
+
+// ringOffsets returns the grid offsets at Chebyshev distance ring from the
+// origin, ordered by Euclidean distance.
+func ringOffsets(ring int) []gridKey {
+	if ring == 0 {
+		return []gridKey{{0, 0}}
+	}
+
+	offsets := make([]gridKey, 0, 8*ring)
+	for d := -ring; d <= ring; d++ {
+		offsets = append(offsets, gridKey{d, -ring}, gridKey{d, ring})
+	}
+	for d := -ring + 1; d <= ring-1; d++ {
+		offsets = append(offsets, gridKey{-ring, d}, gridKey{ring, d})
+	}
+
+	sort.SliceStable(offsets, func(i, j int) bool {
+		a, b := offsets[i], offsets[j]
+		return a.x*a.x+a.y*a.y < b.x*b.x+b.y*b.y
+	})
+	return offsets
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// The OpenAPI document is assembled in code: apiOperations describes the
+// endpoints whose parameters and payloads are worth spelling out, schemas are
+// derived from the Go types by reflection, and every other route in
+// activeRoutes gets a plain operation with its description. Keep
+// apiOperations in step with the handlers when their parameters change.
+
+// apiParam is a query or path parameter of an operation.
+type apiParam struct {
+	name, in, typ, description string
+	required                   bool
+}
+
+// queryParam and pathParam shorten the parameter tables below.
+func queryParam(name, typ, description string) apiParam {
+	return apiParam{name: name, in: "query", typ: typ, description: description}
+}
+
+func pathParam(name, description string) apiParam {
+	return apiParam{name: name, in: "path", typ: "string", description: description, required: true}
+}
+
+// apiOperation documents one method of one path. body and response are zero
+// values of the payload types, or nil when there is none.
+type apiOperation struct {
+	method, path, summary string
+	// role is the least role the operation needs, or "" when it is public
+	role     string
+	params   []apiParam
+	body     any
+	response any
+	// status is the success status, 200 unless set
+	status int
+}
+
+// idParam is the {id} of the item routes.
+var idParam = pathParam("id", "Record ID")
+
+// spaceParam selects the coordinate space of the locations served.
+var spaceParam = queryParam("space", "string", "Coordinate space to serve the coordinates in, as listed by /api/spaces; default game")
+
+// schemaParam selects the layout of the locations served.
+var schemaParam = queryParam("schema", "string", `nested for the coordinates under xy, flat for x and y at the top level; also asked for by an Accept of application/json; profile="flat"`)
+
+// ifUnmodifiedSinceParam makes a write conditional on the location's
+// updatedAt.
+var ifUnmodifiedSinceParam = apiParam{name: "If-Unmodified-Since", in: "header", typ: "string", description: "HTTP date; the write fails with 412 if the location was updated after it"}
+
+// eventParams filter /api/events and the routes derived from it.
+var eventParams = []apiParam{
+	queryParam("kind", "string", ""), queryParam("location", "string", "Only events at this location ID"),
+	queryParam("from", "string", "RFC 3339 time or date; only events occurring after it"),
+	queryParam("to", "string", "RFC 3339 time or date; only events occurring before it"),
+}
+
+// layerParam is the {layer} of the layer routes, and layerBoxParams bound
+// the records listed; any field of the layer filters by its value too.
+var (
+	layerParam     = pathParam("layer", "Layer name, as listed by /api/layers")
+	layerBoxParams = []apiParam{
+		queryParam("minX", "number", "Bounds; all four must be given together"), queryParam("minY", "number", ""),
+		queryParam("maxX", "number", ""), queryParam("maxY", "number", ""),
+	}
+)
+
+// apiOperations are the documented operations, in presentation order.
+var apiOperations = []apiOperation{
+	{method: "get", path: "/api/spaces", summary: "List the coordinate spaces", response: []CoordinateSpace{}},
+	{method: "get", path: "/api/transform", summary: "Convert a point between coordinate spaces", params: []apiParam{
+		queryParam("x", "number", ""), queryParam("y", "number", ""),
+		queryParam("from", "string", "Space of x and y, default game"), queryParam("to", "string", "Space to convert to, default game"),
+	}, response: TransformRequest{}},
+	{method: "post", path: "/api/transform", summary: "Convert points between coordinate spaces", body: TransformRequest{}, response: TransformRequest{}},
+	{method: "get", path: "/api/version", summary: "The server's build and the API versions it serves", response: BuildInfo{}},
+	{method: "get", path: "/api/map", summary: "List map locations", params: []apiParam{
+		queryParam("minX", "number", "Viewport bounds, in ?space= when given; all four must be given together"),
+		queryParam("minY", "number", ""), queryParam("maxX", "number", ""), queryParam("maxY", "number", ""),
+		queryParam("region", "string", "Only locations in this region"),
+		queryParam("tag", "string", "Only locations with this tag; repeatable"),
+		queryParam("sort", "string", "Sort fields, e.g. location,-dangerLevel"),
+		queryParam("limit", "integer", "Page size"), queryParam("offset", "integer", "Page start"),
+		queryParam("fields", "string", "Comma-separated fields to include"),
+		queryParam("format", "string", "geojson for a FeatureCollection, ndjson for one location per line streamed from storage"),
+		queryParam("include", "string", "favorites to flag the signed-in caller's favorites"),
+		spaceParam, schemaParam,
+		{name: "Accept-Language", in: "header", typ: "string", description: "Languages to name the locations in where translated; not applied to ndjson"},
+	}, response: []MapLocation{}},
+	{method: "post", path: "/api/map", summary: "Create a map location", role: roleContributor, body: MapLocation{}, response: MapLocation{}, status: http.StatusCreated},
+	{method: "get", path: "/api/map/{id}", summary: "Get a map location", params: []apiParam{idParam, spaceParam, schemaParam}, response: MapLocation{}},
+	{method: "put", path: "/api/map/{id}", summary: "Create or replace a map location", role: roleContributor, params: []apiParam{idParam,
+		{name: "If-Match", in: "header", typ: "string", description: "ETag of the version the edit is based on; needed, unless the body has it, to replace a location"},
+		ifUnmodifiedSinceParam,
+	}, body: MapLocation{}, response: MapLocation{}},
+	{method: "delete", path: "/api/map/{id}", summary: "Delete a map location", role: roleAdmin, params: []apiParam{idParam, ifUnmodifiedSinceParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/map/{id}/history", summary: "A map location's revisions, newest first", role: roleContributor, params: []apiParam{idParam,
+		queryParam("limit", "integer", ""),
+	}, response: []LocationRevision{}},
+	{method: "post", path: "/api/map/{id}/history/{revision}/revert", summary: "Revert a map location to a revision", role: roleAdmin, params: []apiParam{idParam,
+		pathParam("revision", "Revision ID, as listed in the history"),
+	}, response: MapLocation{}},
+	{method: "get", path: "/api/map/{id}/image", summary: "Get a map location's image", params: []apiParam{idParam}},
+	{method: "post", path: "/api/map/{id}/image", summary: "Upload a map location's image: PNG, JPEG, GIF or WebP, raw or as the image field of a form", role: roleAdmin, params: []apiParam{idParam}, response: ImageInfo{}},
+	{method: "delete", path: "/api/map/{id}/image", summary: "Delete a map location's image", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "post", path: "/api/map/batch", summary: "Look up several map locations by ID", params: []apiParam{spaceParam, schemaParam}, body: batchRequest{}, response: BatchLookup{}},
+	{method: "get", path: "/api/map/search", summary: "Search locations by name", params: []apiParam{
+		{name: "q", in: "query", typ: "string", description: "Search text", required: true},
+		queryParam("limit", "integer", "Maximum number of results"),
+	}, response: []SearchResult{}},
+	{method: "get", path: "/api/map/near", summary: "Locations near a point", params: []apiParam{
+		{name: "x", in: "query", typ: "number", required: true},
+		{name: "y", in: "query", typ: "number", required: true},
+		{name: "radius", in: "query", typ: "number", required: true},
+		queryParam("limit", "integer", "Maximum number of results"),
+		queryParam("weighted", "boolean", "Rank by distance divided by weight, with the score in each result"),
+	}, response: []MapLocation{}},
+	{method: "get", path: "/api/map/nearest", summary: "The locations closest to a point", params: []apiParam{
+		{name: "x", in: "query", typ: "number", required: true},
+		{name: "y", in: "query", typ: "number", required: true},
+		queryParam("type", "string", "Tag the locations must have, such as town or dungeon"),
+		queryParam("limit", "integer", "Number of locations, at most 100 (default 10)"),
+		queryParam("weighted", "boolean", "Rank by distance divided by weight, with the score in each result"),
+	}, response: []NearResult{}},
+	{method: "get", path: "/api/map/distances", summary: "Distance matrix between locations", params: []apiParam{
+		{name: "ids", in: "query", typ: "string", description: "Comma-separated location IDs", required: true},
+		queryParam("by", "string", "time or terrain"),
+	}, response: DistanceMatrix{}},
+	{method: "get", path: "/api/map/stats", summary: "World statistics", response: MapStats{}},
+	{method: "get", path: "/api/map/download", summary: "Zip of the map and the public datasets with a manifest.json (DownloadManifest) of checksums", params: []apiParam{
+		queryParam("sql", "boolean", "Also include soulforged.sql, a script building an SQLite database of the bundle with sqlite3 soulforged.db < soulforged.sql; no database file is included"),
+	}},
+	{method: "post", path: "/api/map/import", summary: "Bulk upsert locations from JSON or CSV", role: roleAdmin, params: []apiParam{
+		queryParam("dryRun", "boolean", "Check the rows and report what would be created, updated or in conflict, without writing"),
+	}, body: []MapLocation{}, response: ImportReport{}},
+	{method: "get", path: "/api/map/versions", summary: "List stored map versions", params: []apiParam{queryParam("limit", "integer", "")}, response: []SnapshotVersion{}},
+	{method: "get", path: "/api/map/diff", summary: "Compare two map versions", params: []apiParam{
+		{name: "from", in: "query", typ: "string", description: "Version ID", required: true},
+		queryParam("to", "string", "Version ID; defaults to the current map"),
+	}, response: VersionDiff{}},
+	{method: "get", path: "/api/layers", summary: "List the map layers the caller may read", response: []LayerInfo{}},
+	{method: "get", path: "/api/layers/{layer}", summary: "List the records of a layer; the locations layer is /api/map", params: append([]apiParam{layerParam}, layerBoxParams...), response: []LayerRecord{}},
+	{method: "post", path: "/api/layers/{layer}", summary: "Create a layer record", role: roleContributor, params: []apiParam{layerParam}, body: LayerRecord{}, response: LayerRecord{}, status: http.StatusCreated},
+	{method: "get", path: "/api/layers/{layer}/{id}", summary: "Get a layer record", params: []apiParam{layerParam, idParam}, response: LayerRecord{}},
+	{method: "put", path: "/api/layers/{layer}/{id}", summary: "Create or replace a layer record", role: roleContributor, params: []apiParam{layerParam, idParam}, body: LayerRecord{}, response: LayerRecord{}},
+	{method: "delete", path: "/api/layers/{layer}/{id}", summary: "Delete a layer record", role: roleAdmin, params: []apiParam{layerParam, idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/resources", summary: "List resource nodes", params: []apiParam{queryParam("type", "string", "ore, herb or fishing"), queryParam("location", "string", "Nearest location ID")}, response: []ResourceNode{}},
+	{method: "post", path: "/api/resources", summary: "Create a resource node", role: roleContributor, body: ResourceNode{}, response: ResourceNode{}, status: http.StatusCreated},
+	{method: "get", path: "/api/resources/{id}", summary: "Get a resource node", params: []apiParam{idParam}, response: ResourceNode{}},
+	{method: "put", path: "/api/resources/{id}", summary: "Create or replace a resource node", role: roleContributor, params: []apiParam{idParam}, body: ResourceNode{}, response: ResourceNode{}},
+	{method: "delete", path: "/api/resources/{id}", summary: "Delete a resource node", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/creatures", summary: "List creatures", params: []apiParam{queryParam("region", "string", ""), queryParam("danger", "string", "Tier N or range N-M")}, response: []Creature{}},
+	{method: "post", path: "/api/creatures", summary: "Create a creature", role: roleContributor, body: Creature{}, response: Creature{}, status: http.StatusCreated},
+	{method: "get", path: "/api/creatures/{id}", summary: "Get a creature", params: []apiParam{idParam}, response: Creature{}},
+	{method: "put", path: "/api/creatures/{id}", summary: "Create or replace a creature", role: roleContributor, params: []apiParam{idParam}, body: Creature{}, response: Creature{}},
+	{method: "delete", path: "/api/creatures/{id}", summary: "Delete a creature", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/edges", summary: "List travel edges", params: []apiParam{queryParam("location", "string", "Only edges touching this location")}, response: []TravelEdge{}},
+	{method: "post", path: "/api/edges", summary: "Create a travel edge", role: roleContributor, body: TravelEdge{}, response: TravelEdge{}, status: http.StatusCreated},
+	{method: "get", path: "/api/edges/{id}", summary: "Get a travel edge", params: []apiParam{idParam}, response: TravelEdge{}},
+	{method: "put", path: "/api/edges/{id}", summary: "Create or replace a travel edge", role: roleContributor, params: []apiParam{idParam}, body: TravelEdge{}, response: TravelEdge{}},
+	{method: "delete", path: "/api/edges/{id}", summary: "Delete a travel edge", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/items", summary: "List items", params: []apiParam{queryParam("category", "string", ""), queryParam("q", "string", "Part of the name")}, response: []Item{}},
+	{method: "post", path: "/api/items", summary: "Create an item", role: roleContributor, body: Item{}, response: Item{}, status: http.StatusCreated},
+	{method: "get", path: "/api/items/{id}", summary: "Get an item", params: []apiParam{idParam}, response: Item{}},
+	{method: "put", path: "/api/items/{id}", summary: "Create or replace an item", role: roleContributor, params: []apiParam{idParam}, body: Item{}, response: Item{}},
+	{method: "delete", path: "/api/items/{id}", summary: "Delete an item", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/items/{id}/recipes", summary: "Recipes making or using an item", params: []apiParam{idParam}, response: ItemRecipes{}},
+	{method: "get", path: "/api/recipes", summary: "List recipes", params: []apiParam{
+		queryParam("output", "string", "Only recipes making this item"),
+		queryParam("ingredient", "string", "Only recipes taking this item; repeatable"),
+		queryParam("station", "string", ""),
+		queryParam("with", "string", "Comma-separated item IDs; only recipes needing nothing else"),
+	}, response: []Recipe{}},
+	{method: "post", path: "/api/recipes", summary: "Create a recipe", role: roleContributor, body: Recipe{}, response: Recipe{}, status: http.StatusCreated},
+	{method: "get", path: "/api/recipes/{id}", summary: "Get a recipe", params: []apiParam{idParam}, response: Recipe{}},
+	{method: "put", path: "/api/recipes/{id}", summary: "Create or replace a recipe", role: roleContributor, params: []apiParam{idParam}, body: Recipe{}, response: Recipe{}},
+	{method: "delete", path: "/api/recipes/{id}", summary: "Delete a recipe", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/events", summary: "List events", params: eventParams, response: []Event{}},
+	{method: "post", path: "/api/events", summary: "Create an event", role: roleContributor, body: Event{}, response: Event{}, status: http.StatusCreated},
+	{method: "get", path: "/api/events/{id}", summary: "Get an event", params: []apiParam{idParam}, response: Event{}},
+	{method: "put", path: "/api/events/{id}", summary: "Create or replace an event", role: roleContributor, params: []apiParam{idParam}, body: Event{}, response: Event{}},
+	{method: "delete", path: "/api/events/{id}", summary: "Delete an event", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/events/occurrences", summary: "List event occurrences", params: eventParams, response: []EventOccurrence{}},
+	{method: "get", path: "/api/events/calendar.ics", summary: "Export events as iCalendar", params: eventParams},
+	{method: "get", path: "/api/regions", summary: "List regions", params: []apiParam{queryParam("kind", "string", "")}, response: []RegionOutline{}},
+	{method: "post", path: "/api/regions", summary: "Create a region", role: roleContributor, body: RegionOutline{}, response: RegionOutline{}, status: http.StatusCreated},
+	{method: "get", path: "/api/regions/{id}", summary: "Get a region", params: []apiParam{idParam}, response: RegionOutline{}},
+	{method: "put", path: "/api/regions/{id}", summary: "Create or replace a region", role: roleContributor, params: []apiParam{idParam}, body: RegionOutline{}, response: RegionOutline{}},
+	{method: "delete", path: "/api/regions/{id}", summary: "Delete a region", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/regions/{id}/locations", summary: "Map locations inside a region", params: []apiParam{idParam}, response: []MapLocation{}},
+	{method: "get", path: "/api/region-at", summary: "The smallest region containing a point", params: []apiParam{
+		{name: "x", in: "query", typ: "number", required: true}, {name: "y", in: "query", typ: "number", required: true},
+	}, response: RegionMatch{}},
+	{method: "post", path: "/api/prices", summary: "Report an observed price", role: roleContributor, body: priceReport{}, response: PricePoint{}, status: http.StatusCreated},
+	{method: "get", path: "/api/prices/{item}", summary: "Price history of an item", params: []apiParam{
+		pathParam("item", "Item ID"),
+		queryParam("window", "string", "How far back, e.g. 7d or 12h"),
+		queryParam("points", "integer", "Most buckets to summarise the window in"),
+	}, response: PriceHistory{}},
+	{method: "get", path: "/api/route", summary: "Cheapest path between two locations", params: []apiParam{
+		{name: "from", in: "query", typ: "string", required: true},
+		{name: "to", in: "query", typ: "string", required: true},
+		queryParam("by", "string", "time or terrain"),
+	}, response: Route{}},
+	{method: "get", path: "/graphql", summary: "Run a GraphQL query", params: []apiParam{
+		{name: "query", in: "query", typ: "string", required: true},
+		queryParam("operationName", "string", ""), queryParam("variables", "string", "JSON object"),
+	}, response: json.RawMessage{}},
+	{method: "post", path: "/graphql", summary: "Run a GraphQL query", body: graphqlRequest{}, response: json.RawMessage{}},
+	{method: "get", path: "/api/submissions", summary: "List submissions", role: roleContributor, params: []apiParam{queryParam("status", "string", "pending, approved, rejected or all")}, response: []Submission{}},
+	{method: "post", path: "/api/submissions", summary: "Propose a location", role: roleContributor, body: submissionRequest{}, response: Submission{}, status: http.StatusCreated},
+	{method: "get", path: "/api/submissions/{id}", summary: "Get a submission", role: roleContributor, params: []apiParam{idParam}, response: Submission{}},
+	{method: "post", path: "/api/submissions/{id}/approve", summary: "Approve a submission", role: roleAdmin, params: []apiParam{idParam}, body: reviewRequest{}, response: Submission{}},
+	{method: "post", path: "/api/submissions/{id}/reject", summary: "Reject a submission", role: roleAdmin, params: []apiParam{idParam}, body: reviewRequest{}, response: Submission{}},
+	{method: "get", path: "/api/annotations", summary: "List visible annotations", params: []apiParam{
+		queryParam("owner", "string", ""), queryParam("visibility", "string", "private, shared or public"),
+		queryParam("minX", "number", "Overlapping these bounds; all four must be given together"),
+		queryParam("minY", "number", ""), queryParam("maxX", "number", ""), queryParam("maxY", "number", ""),
+	}, response: []Annotation{}},
+	{method: "post", path: "/api/annotations", summary: "Create an annotation", role: roleViewer, body: annotationRequest{}, response: Annotation{}, status: http.StatusCreated},
+	{method: "get", path: "/api/annotations/{id}", summary: "Get an annotation", params: []apiParam{idParam}, response: Annotation{}},
+	{method: "put", path: "/api/annotations/{id}", summary: "Change an annotation", role: roleViewer, params: []apiParam{idParam}, body: annotationRequest{}, response: Annotation{}},
+	{method: "delete", path: "/api/annotations/{id}", summary: "Delete an annotation", role: roleViewer, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/users/me/favorites", summary: "List your favorites", role: roleViewer, response: FavoritesView{}},
+	{method: "post", path: "/api/users/me/favorites", summary: "Add and remove favorites", role: roleViewer, body: favoritesChange{}, response: FavoritesView{}},
+	{method: "put", path: "/api/users/me/favorites/{id}", summary: "Add a favorite", role: roleViewer, params: []apiParam{idParam}, response: FavoritesView{}},
+	{method: "delete", path: "/api/users/me/favorites/{id}", summary: "Remove a favorite", role: roleViewer, params: []apiParam{idParam}, response: FavoritesView{}},
+	{method: "post", path: "/api/auth/register", summary: "Create a user account", body: authCredentials{}, response: User{}, status: http.StatusCreated},
+	{method: "post", path: "/api/auth/login", summary: "Log in", body: authCredentials{}, response: tokenResponse{}},
+	{method: "get", path: "/api/admin/audit", summary: "Audit log", role: roleAdmin, params: []apiParam{
+		queryParam("id", "string", "Document ID"), queryParam("collection", "string", ""), queryParam("action", "string", "create, update, delete, restore or purge"),
+		queryParam("since", "string", "RFC 3339 time or date"), queryParam("until", "string", "RFC 3339 time or date"),
+		queryParam("limit", "integer", ""),
+	}, response: []AuditEntry{}},
+	{method: "get", path: "/api/admin/cache", summary: "Cache status", role: roleAdmin, response: CacheReport{}},
+	{method: "post", path: "/api/admin/cache/refresh", summary: "Reload the caches from storage", role: roleAdmin, response: CacheReport{}},
+	{method: "get", path: "/api/admin/dashboard", summary: "Request, cache and client figures behind the /admin dashboard", role: roleAdmin, response: DashboardReport{}},
+	{method: "get", path: "/api/admin/analytics", summary: "Anonymous usage by route, dataset and search term over the last days", role: roleAdmin, params: []apiParam{
+		queryParam("days", "integer", "Days to cover, today included; default 7"),
+	}, response: AnalyticsReport{}},
+	{method: "get", path: "/api/admin/translations", summary: "List translated location names", role: roleAdmin, response: []LocationTranslation{}},
+	{method: "post", path: "/api/admin/translations", summary: "Add the translated names of a location", role: roleAdmin, body: LocationTranslation{}, response: LocationTranslation{}, status: http.StatusCreated},
+	{method: "get", path: "/api/admin/translations/{id}", summary: "Get the translated names of a location", role: roleAdmin, params: []apiParam{idParam}, response: LocationTranslation{}},
+	{method: "put", path: "/api/admin/translations/{id}", summary: "Replace the translated names of a location", role: roleAdmin, params: []apiParam{idParam}, body: LocationTranslation{}, response: LocationTranslation{}},
+	{method: "delete", path: "/api/admin/translations/{id}", summary: "Remove the translated names of a location", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/admin/backups", summary: "List backups", role: roleAdmin, response: []BackupInfo{}},
+	{method: "post", path: "/api/admin/backups", summary: "Back up the data now", role: roleAdmin, response: BackupInfo{}, status: http.StatusCreated},
+	{method: "get", path: "/api/admin/trash", summary: "List deleted locations", role: roleAdmin, response: []TrashedLocation{}},
+	{method: "post", path: "/api/admin/trash/{id}/restore", summary: "Restore a deleted location", role: roleAdmin, params: []apiParam{idParam}, response: MapLocation{}},
+	{method: "delete", path: "/api/admin/trash/{id}", summary: "Delete a location for good", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "get", path: "/api/admin/webhooks", summary: "List webhooks", role: roleAdmin, response: []Webhook{}},
+	{method: "post", path: "/api/admin/webhooks", summary: "Register a webhook", role: roleAdmin, body: webhookRequest{}, response: Webhook{}, status: http.StatusCreated},
+	{method: "get", path: "/api/admin/webhooks/{id}", summary: "Get a webhook", role: roleAdmin, params: []apiParam{idParam}, response: Webhook{}},
+	{method: "delete", path: "/api/admin/webhooks/{id}", summary: "Remove a webhook", role: roleAdmin, params: []apiParam{idParam}, status: http.StatusNoContent},
+	{method: "post", path: "/api/admin/webhooks/{id}/test", summary: "Send a webhook a ping", role: roleAdmin, params: []apiParam{idParam}, response: WebhookTestResult{}},
+	{method: "get", path: "/readyz", summary: "Readiness probe", response: ReadinessReport{}},
+}
+
+// openAPISchemas collects the component schemas of the types reached while
+// documenting operations.
+type openAPISchemas map[string]any
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the schema of t, adding named struct types to the
+// components and referring to them.
+func (s openAPISchemas) schemaFor(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.schemaFor(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		name := upperFirst(t.Name())
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder for recursive types
+			s[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema lists the JSON fields of t, flattening embedded structs as
+// encoding/json does.
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.schemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIPath turns a route pattern into its OpenAPI path and the names of
+// its parameters: /tiles/{path...} becomes /tiles/{path}.
+func openAPIPath(pattern string) (string, []string) {
+	var names []string
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+			segs[i] = "{" + name + "}"
+			names = append(names, name)
+		}
+	}
+	return strings.Join(segs, "/"), names
+}
+
+// buildOpenAPI assembles the document for the routes registered so far.
+func buildOpenAPI() map[string]any {
+	schemas := openAPISchemas{}
+	problem := map[string]any{
+		"description": "RFC 7807 problem details",
+		"content":     map[string]any{validation.ContentType: map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(validation.Problem{}))}},
+	}
+
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		operation := map[string]any{"summary": op.summary}
+		var params []any
+		for _, p := range op.params {
+			param := map[string]any{"name": p.name, "in": p.in, "required": p.required, "schema": map[string]any{"type": p.typ}}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			params = append(params, param)
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.body != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(op.body))}},
+			}
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if op.response != nil {
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(op.response))}}
+		}
+		responses := map[string]any{strconv.Itoa(status): success}
+		if op.body != nil {
+			responses["400"] = problem
+		}
+		if op.role != "" {
+			operation["security"] = []any{map[string]any{"apiKey": []any{}}, map[string]any{"bearer": []any{}}}
+			operation["description"] = "Needs the " + op.role + " role."
+			responses["401"] = map[string]any{"description": "Not authenticated"}
+			responses["403"] = map[string]any{"description": "Missing the required role"}
+		}
+		operation["responses"] = responses
+
+		// Operations are written down by their unversioned path and
+		// documented under the v1 prefix that replaces it
+		path := op.path
+		if rest, ok := strings.CutPrefix(path, "/api/"); ok {
+			path = apiV1 + "/" + rest
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][op.method] = operation
+	}
+
+	// Routes without a documented operation still get listed
+	for _, rt := range activeRoutes {
+		p, names := openAPIPath(rt.Path)
+		method := strings.ToLower(rt.Method)
+		if method == "" {
+			method = "get"
+		}
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		if paths[p][method] != nil {
+			continue
+		}
+		operation := map[string]any{
+			"summary":   rt.Description,
+			"responses": map[string]any{"200": map[string]any{"description": "OK"}},
+		}
+		var params []any
+		for _, name := range names {
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		paths[p][method] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Soulforged map API",
+			"version":     version,
+			"description": "Map locations and the datasets around them. The routes are versioned under /api/v1; the same routes without the version, under /api, are deprecated and answer with Deprecation and Link headers naming their successor.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// openAPIDocument is built on first request, once every route is registered.
+var openAPIDocument = sync.OnceValue(func() []byte {
+	raw, _ := json.Marshal(buildOpenAPI())
+	return raw
+})
+
+// openAPIHandler serves the OpenAPI document.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(openAPIDocument())
+}
+
+// docsPage is the Swagger UI page. It loads the UI's script and stylesheet
+// from the swagger-ui-dist package on a CDN and points it at /openapi.json.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// docsHandler serves the Swagger UI at /docs.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(docsPage)
+}
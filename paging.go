@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxPageLimit caps ?limit= on /api/map.
+const maxPageLimit = 1000
+
+// page is the ?limit=&offset= window of a listing. A zero limit means the
+// rest of the listing from offset.
+type page struct {
+	limit  int
+	offset int
+}
+
+// parsePage reads ?limit= and ?offset=. ok is false when neither is given.
+func parsePage(q url.Values) (p page, ok bool, err error) {
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return page{}, false, fmt.Errorf("limit must be an integer from 1 to %d", maxPageLimit)
+		}
+		p.limit = n
+		ok = true
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page{}, false, fmt.Errorf("offset must be a non-negative integer")
+		}
+		p.offset = n
+		ok = true
+	}
+	return p, ok, nil
+}
+
+// apply returns the window of locations covered by the page, which is empty
+// rather than nil past the end so that it still encodes as [].
+func (p page) apply(locations []MapLocation) []MapLocation {
+	start := min(p.offset, len(locations))
+	end := len(locations)
+	if p.limit > 0 {
+		end = min(start+p.limit, end)
+	}
+	if start == end {
+		return []MapLocation{}
+	}
+	return locations[start:end]
+}
+
+// String identifies the page in ETag variants.
+func (p page) String() string {
+	return strconv.Itoa(p.offset) + "+" + strconv.Itoa(p.limit)
+}
+
+// setPageHeaders reports the total number of results in X-Total-Count and
+// links to the neighbouring pages, RFC 8288 style.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, p page, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if p.limit == 0 {
+		return
+	}
+
+	link := func(offset int, rel string) string {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(p.limit))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel)
+	}
+	var links []string
+	if p.offset+p.limit < total {
+		links = append(links, link(p.offset+p.limit, "next"))
+	}
+	if p.offset > 0 {
+		links = append(links, link(max(p.offset-p.limit, 0), "prev"))
+	}
+	if links != nil {
+		w.Header().Add("Link", strings.Join(links, ", "))
+	}
+}
+
+// locationFields extracts each field that ?fields= can select, keyed by its
+// JSON name.
+var locationFields = map[string]func(loc *MapLocation) any{
+	"id":           func(loc *MapLocation) any { return loc.ID },
+	"location":     func(loc *MapLocation) any { return loc.Location },
+	"xy":           func(loc *MapLocation) any { return loc.XY },
+	"region":       func(loc *MapLocation) any { return loc.Region },
+	"biome":        func(loc *MapLocation) any { return loc.Biome },
+	"dangerLevel":  func(loc *MapLocation) any { return loc.DangerLevel },
+	"discoveredBy": func(loc *MapLocation) any { return loc.DiscoveredBy },
+	"weight":       func(loc *MapLocation) any { return loc.weight() },
+	"tags":         func(loc *MapLocation) any { return loc.Tags },
+	"version":      func(loc *MapLocation) any { return loc.Version },
+	"updatedAt":    func(loc *MapLocation) any { return loc.UpdatedAt },
+}
+
+// parseFields parses a comma-separated ?fields= value such as "id,location".
+// "_id" is accepted for "id", as in ?sort=.
+func parseFields(spec string) ([]string, error) {
+	var fields []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		field := strings.TrimSpace(part)
+		if field == "_id" {
+			field = "id"
+		}
+		if _, ok := locationFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", part)
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// projectLocations keeps only the given fields of each location.
+func projectLocations(locations []MapLocation, fields []string) []map[string]any {
+	out := make([]map[string]any, len(locations))
+	for i := range locations {
+		m := make(map[string]any, len(fields))
+		for _, field := range fields {
+			m[field] = locationFields[field](&locations[i])
+		}
+		out[i] = m
+	}
+	return out
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example/souforged/validation"
+)
+
+// Limits on price reports and /api/prices/{item}.
+const (
+	defaultPriceWindow = 7 * 24 * time.Hour
+	// maxPriceWindow is also how far back a price may have been observed
+	maxPriceWindow     = 365 * 24 * time.Hour
+	defaultPricePoints = 100
+	maxPricePoints     = 1000
+	// maxPriceClockSkew is how far in the future an observation may lie, for
+	// clients whose clocks run fast
+	maxPriceClockSkew = 5 * time.Minute
+	maxMemoryPrices   = 100000
+)
+
+// PricePoint is one trade price observed in game and reported by a
+// contributor, stored in the prices collection.
+type PricePoint struct {
+	ID string `json:"id" bson:"_id"`
+	// Item is the ID of the item traded
+	Item string `json:"item" bson:"item"`
+	// Price is per unit, in the game's currency
+	Price    float64 `json:"price" bson:"price"`
+	Quantity int     `json:"quantity" bson:"quantity"`
+	// Location is the ID of the map location of the market, if known
+	Location    string    `json:"location,omitempty" bson:"location,omitempty"`
+	ObservedAt  time.Time `json:"observedAt" bson:"observedAt"`
+	SubmittedBy string    `json:"submittedBy" bson:"submittedBy"`
+}
+
+// PriceStorage is implemented by backends that can keep the price series.
+type PriceStorage interface {
+	InsertPrice(ctx context.Context, p PricePoint) error
+	// ListPrices returns the item's prices observed at or after since,
+	// oldest first.
+	ListPrices(ctx context.Context, item string, since time.Time) ([]PricePoint, error)
+}
+
+// PriceHistory is the price series of an item over a window, downsampled to
+// at most the requested number of buckets of equal length. Buckets without
+// observations are left out.
+type PriceHistory struct {
+	Item    string        `json:"item"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Buckets []PriceBucket `json:"buckets"`
+}
+
+// PriceBucket summarises the prices observed in [Start, End). Mean is
+// weighted by quantity and Volume is the quantity traded.
+type PriceBucket struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Count  int       `json:"count"`
+	Volume int       `json:"volume"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Mean   float64   `json:"mean"`
+	Median float64   `json:"median"`
+}
+
+// priceStore returns the backend's price storage. On failure the error
+// response is written.
+func priceStore(w http.ResponseWriter) (PriceStorage, bool) {
+	prices, ok := store.(PriceStorage)
+	if !ok {
+		http.Error(w, "The storage backend does not keep prices", http.StatusNotImplemented)
+	}
+	return prices, ok
+}
+
+// priceReport is the body of POST /api/prices.
+type priceReport struct {
+	Item     string  `json:"item"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	Location string  `json:"location"`
+	// ObservedAt defaults to the time of the report
+	ObservedAt *time.Time `json:"observedAt"`
+}
+
+// createPriceHandler records an observed price.
+func createPriceHandler(w http.ResponseWriter, r *http.Request) {
+	prices, ok := priceStore(w)
+	if !ok {
+		return
+	}
+
+	var req priceReport
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	p := PricePoint{Item: req.Item, Price: req.Price, Quantity: req.Quantity, Location: req.Location, ObservedAt: now}
+	if req.ObservedAt != nil {
+		p.ObservedAt = req.ObservedAt.UTC()
+	}
+	if p.Quantity == 0 {
+		p.Quantity = 1
+	}
+	if errs := checkPrice(r.Context(), p, now); errs != nil {
+		writeValidationErrors(w, r, "Invalid price", errs)
+		return
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		http.Error(w, "Failed to record price", http.StatusInternalServerError)
+		return
+	}
+	principal, _ := requestPrincipal(r.Context())
+	p.ID, p.SubmittedBy = id, principal.Name
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := prices.InsertPrice(ctx, p); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, p, "price")
+}
+
+// checkPrice validates a reported price, checking its item and location
+// against the cached items and map.
+func checkPrice(ctx context.Context, p PricePoint, now time.Time) []FieldError {
+	var errs validation.Errors
+
+	if errs.NonEmpty("item", p.Item) {
+		if snap, err := items.load(ctx); err == nil {
+			if _, found := snap.lookup(p.Item); !found {
+				errs.Add("item", "no item has ID %q", p.Item)
+			}
+		}
+	}
+	if errs.Finite("price", p.Price) && p.Price < 0 {
+		errs.Add("price", "must not be negative")
+	}
+	if p.Quantity < 0 {
+		errs.Add("quantity", "must be positive, got %d", p.Quantity)
+	}
+	if p.Location != "" {
+		if snap, err := loadSnapshot(ctx); err == nil {
+			if _, found := snap.lookup(p.Location); !found {
+				errs.Add("location", "no map location has ID %q", p.Location)
+			}
+		}
+	}
+	if p.ObservedAt.After(now.Add(maxPriceClockSkew)) {
+		errs.Add("observedAt", "must not be in the future")
+	} else if p.ObservedAt.Before(now.Add(-maxPriceWindow)) {
+		errs.Add("observedAt", "must be within the last %s", formatWindow(maxPriceWindow))
+	}
+
+	return errs
+}
+
+// priceHistoryHandler serves the prices of an item over the last ?window=
+// (default 7d), in at most ?points= buckets.
+func priceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	item := pathValue(r, "item")
+	prices, ok := priceStore(w)
+	if !ok {
+		return
+	}
+
+	window, points, err := parsePriceQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid price query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	series, err := prices.ListPrices(ctx, item, from)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, PriceHistory{Item: item, From: from, To: to, Buckets: downsamplePrices(series, from, to, points)}, "price history")
+}
+
+// parsePriceQuery reads ?window= and ?points=.
+func parsePriceQuery(q url.Values) (window time.Duration, points int, err error) {
+	window, points = defaultPriceWindow, defaultPricePoints
+	if s := q.Get("window"); s != "" {
+		window, err = parseWindow(s)
+		if err != nil || window <= 0 || window > maxPriceWindow {
+			return 0, 0, fmt.Errorf("window must be a duration such as 7d or 12h, at most %s", formatWindow(maxPriceWindow))
+		}
+	}
+	if s := q.Get("points"); s != "" {
+		points, err = strconv.Atoi(s)
+		if err != nil || points < 1 || points > maxPricePoints {
+			return 0, 0, fmt.Errorf("points must be between 1 and %d", maxPricePoints)
+		}
+	}
+	return window, points, nil
+}
+
+// parseWindow parses a Go duration, also accepting a whole number of days
+// such as 7d.
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatWindow formats whole days as parseWindow accepts them.
+func formatWindow(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	}
+	return d.String()
+}
+
+// downsamplePrices sorts the prices observed in [from, to) into points
+// buckets of equal length and summarises each.
+func downsamplePrices(series []PricePoint, from, to time.Time, points int) []PriceBucket {
+	width := to.Sub(from) / time.Duration(points)
+	if width <= 0 {
+		width = 1
+	}
+	byBucket := map[int][]PricePoint{}
+	for _, p := range series {
+		if p.ObservedAt.Before(from) || !p.ObservedAt.Before(to) {
+			continue
+		}
+		i := min(int(p.ObservedAt.Sub(from)/width), points-1)
+		byBucket[i] = append(byBucket[i], p)
+	}
+
+	buckets := []PriceBucket{}
+	for i := 0; i < points; i++ {
+		in := byBucket[i]
+		if len(in) == 0 {
+			continue
+		}
+		b := PriceBucket{
+			Start: from.Add(time.Duration(i) * width),
+			End:   from.Add(time.Duration(i+1) * width),
+			Count: len(in),
+			Min:   math.Inf(1),
+			Max:   math.Inf(-1),
+		}
+		if i == points-1 {
+			b.End = to
+		}
+		var total float64
+		unitPrices := make([]float64, len(in))
+		for j, p := range in {
+			b.Volume += p.Quantity
+			total += p.Price * float64(p.Quantity)
+			b.Min, b.Max = math.Min(b.Min, p.Price), math.Max(b.Max, p.Price)
+			unitPrices[j] = p.Price
+		}
+		b.Mean = total / float64(b.Volume)
+		sort.Float64s(unitPrices)
+		b.Median = unitPrices[len(unitPrices)/2]
+		if len(unitPrices)%2 == 0 {
+			b.Median = (unitPrices[len(unitPrices)/2-1] + b.Median) / 2
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"example/souforged/mappb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+// wantsProtobuf reports whether the client asked for a protobuf body via the
+// Accept header. JSON stays the default for everything else.
+func wantsProtobuf(r *http.Request) bool {
+	return accepts(r, protobufContentType)
+}
+
+// accepts reports whether the Accept header lists mediaType explicitly.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mt == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toProtoLocations converts locations into their protobuf representation.
+func toProtoLocations(locations []MapLocation) *mappb.MapLocationList {
+	list := &mappb.MapLocationList{
+		Locations: make([]*mappb.MapLocation, 0, len(locations)),
+	}
+	for i := range locations {
+		list.Locations = append(list.Locations, toProtoLocation(&locations[i]))
+	}
+	return list
+}
+
+// toProtoLocation converts a single location.
+func toProtoLocation(loc *MapLocation) *mappb.MapLocation {
+	var updated *timestamppb.Timestamp
+	if loc.UpdatedAt != nil {
+		updated = timestamppb.New(*loc.UpdatedAt)
+	}
+	return &mappb.MapLocation{
+		Id:           loc.ID,
+		Location:     loc.Location,
+		Xy:           &mappb.Coordinates{X: loc.XY.X, Y: loc.XY.Y},
+		Region:       loc.Region,
+		Biome:        loc.Biome,
+		DangerLevel:  int32(loc.DangerLevel),
+		DiscoveredBy: loc.DiscoveredBy,
+		Tags:         loc.Tags,
+		Weight:       loc.Weight,
+		Version:      loc.Version,
+		UpdatedAt:    updated,
+	}
+}
+
+// fromProtoLocations converts a protobuf location list back into MapLocations.
+func fromProtoLocations(list *mappb.MapLocationList) []MapLocation {
+	locations := make([]MapLocation, 0, len(list.GetLocations()))
+	for _, loc := range list.GetLocations() {
+		var updated *time.Time
+		if loc.GetUpdatedAt() != nil {
+			t := loc.GetUpdatedAt().AsTime()
+			updated = &t
+		}
+		locations = append(locations, MapLocation{
+			ID:           loc.GetId(),
+			Location:     loc.GetLocation(),
+			XY:           Coordinates{X: loc.GetXy().GetX(), Y: loc.GetXy().GetY()},
+			Region:       loc.GetRegion(),
+			Biome:        loc.GetBiome(),
+			DangerLevel:  int(loc.GetDangerLevel()),
+			DiscoveredBy: loc.GetDiscoveredBy(),
+			Tags:         loc.GetTags(),
+			Weight:       loc.GetWeight(),
+			Version:      loc.GetVersion(),
+			UpdatedAt:    updated,
+		})
+	}
+	return locations
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"example/souforged/mappb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	updated := time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	locations := []MapLocation{
+		{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 12.3, Y: -4.5}, Version: 7, UpdatedAt: &updated},
+		{ID: "b2", Location: "Mirewood", XY: Coordinates{X: 0, Y: 1e6}, Weight: 2.5},
+		{ID: "c3", Location: "", XY: Coordinates{}},
+	}
+
+	body, err := proto.Marshal(toProtoLocations(locations))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var list mappb.MapLocationList
+	if err := proto.Unmarshal(body, &list); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if got := fromProtoLocations(&list); !reflect.DeepEqual(got, locations) {
+		t.Errorf("round trip mismatch:\ngot  %+v\nwant %+v", got, locations)
+	}
+}
+
+func TestWantsProtobuf(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/x-protobuf", true},
+		{"application/json;q=0.5, application/x-protobuf", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/map", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsProtobuf(r); got != tt.want {
+			t.Errorf("wantsProtobuf(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
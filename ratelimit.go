@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Route groups with their own limits.
+const (
+	rateGroupRead  = "read"
+	rateGroupWrite = "write"
+	rateGroupAuth  = "auth"
+)
+
+// Idle clients are forgotten after rateLimitIdle; the sweep runs at most once
+// per rateLimitSweepEvery.
+const (
+	rateLimitIdle       = 10 * time.Minute
+	rateLimitSweepEvery = time.Minute
+)
+
+// rateExemptRoutes are never limited: probes and scrapes come from a few
+// addresses at a fixed pace and must not be turned away, and a map view
+// fetches dozens of tiles at once, all of them cacheable.
+var rateExemptRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true, "/tiles/{path...}": true}
+
+// clientLimiter is the token bucket of one client in one group.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per client IP and group.
+type rateLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+var limiter = &rateLimiter{clients: make(map[string]*clientLimiter)}
+
+// readOnlyPosts are the routes, as unversionedRoute gives them, taking a
+// POST that only reads: GraphQL, whose schema has no mutations, and the bulk
+// lookups and conversions whose input is too long for a query string.
+var readOnlyPosts = map[string]bool{
+	"/graphql":                true,
+	"/api/map/batch":          true,
+	"/api/map/distances":      true,
+	"/api/map/validate-batch": true,
+	"/api/transform":          true,
+}
+
+// rateGroup picks the limit group of a request: the auth endpoints, reads,
+// which are those with a safe method and the readOnlyPosts, or writes for
+// everything else.
+func rateGroup(route string, r *http.Request) string {
+	route = unversionedRoute(route)
+	switch {
+	case strings.HasPrefix(route, "/api/auth/"):
+		return rateGroupAuth
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		return rateGroupRead
+	case r.Method == http.MethodPost && readOnlyPosts[route]:
+		return rateGroupRead
+	default:
+		return rateGroupWrite
+	}
+}
+
+// allow takes a token from the bucket of ip in group. When none is left it
+// returns how long until one will be.
+func (l *rateLimiter) allow(ip, group string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepEvery {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := group + "|" + ip
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// clientIP returns the address requests are limited by. Behind a proxy that
+// sets X-Forwarded-For, config.RateLimit.TrustForwardedFor makes it the last
+// address in that header, the one the proxy itself saw.
+func clientIP(r *http.Request) string {
+	if config.RateLimit.TrustForwardedFor {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			hops := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit answers 429 with Retry-After once a client exceeds the limit of
+// the request's group. A group with a zero rate is unlimited.
+func rateLimit(route string, handler http.HandlerFunc) http.HandlerFunc {
+	if rateExemptRoutes[route] {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := rateGroup(route, r)
+		limit := config.RateLimit.group(group)
+		if limit.Rate <= 0 {
+			handler(w, r)
+			return
+		}
+
+		ok, wait := limiter.allow(clientIP(r), group, limit, time.Now())
+		if !ok {
+			rateLimitedRequests.WithLabelValues(group).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests, please slow down", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
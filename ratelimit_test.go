@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateGroup(t *testing.T) {
+	tests := []struct {
+		method, route, want string
+	}{
+		{http.MethodGet, "/api/map", rateGroupRead},
+		{http.MethodHead, "/api/v1/map/{id}", rateGroupRead},
+		{http.MethodOptions, "/api/map/{id}", rateGroupRead},
+		{http.MethodPost, "/graphql", rateGroupRead},
+		{http.MethodPost, "/api/map/batch", rateGroupRead},
+		{http.MethodPost, "/api/v1/map/batch", rateGroupRead},
+		{http.MethodPost, "/api/worlds/{world}/map/batch", rateGroupRead},
+		{http.MethodPost, "/api/map/distances", rateGroupRead},
+		{http.MethodPost, "/api/map/validate-batch", rateGroupRead},
+		{http.MethodPost, "/api/v1/transform", rateGroupRead},
+		{http.MethodPost, "/api/map", rateGroupWrite},
+		{http.MethodPut, "/api/map/{id}", rateGroupWrite},
+		{http.MethodDelete, "/api/v1/map/{id}", rateGroupWrite},
+		{http.MethodPost, "/api/map/import", rateGroupWrite},
+		{http.MethodPut, "/api/map/batch", rateGroupWrite},
+		{http.MethodPost, "/api/auth/login", rateGroupAuth},
+		{http.MethodPost, "/api/v1/auth/register", rateGroupAuth},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if got := rateGroup(tt.route, r); got != tt.want {
+			t.Errorf("rateGroup(%q) for %s = %q, want %q", tt.route, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestReadOnlyPostsUseReadLimit(t *testing.T) {
+	server := newTestServer(t, map[string]string{"RATE_LIMIT_WRITE_RATE": "0.001", "RATE_LIMIT_WRITE_BURST": "1"},
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}},
+	)
+
+	for i := 0; i < 3; i++ {
+		if resp, body := send(t, server, http.MethodPost, "/api/map/batch", `{"ids": ["a1"]}`); resp.StatusCode != http.StatusOK {
+			t.Fatalf("batch %d: status %d, want 200 under the read limit: %s", i, resp.StatusCode, body)
+		}
+	}
+	statuses := make([]int, 2)
+	for i := range statuses {
+		resp, _ := send(t, server, http.MethodDelete, "/api/map/zz", "", "X-API-Key", testAdminKey)
+		statuses[i] = resp.StatusCode
+	}
+	if statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("deletes answered %v, want the second refused with 429 by the write limit", statuses)
+	}
+}
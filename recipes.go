@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"example/souforged/validation"
+)
+
+// maxRecipeIngredients caps the ingredient list of a single recipe.
+const maxRecipeIngredients = 20
+
+// Recipe crafts Quantity of the Output item from its ingredients, stored in
+// the recipes collection.
+type Recipe struct {
+	ID string `json:"id" bson:"_id"`
+	// Output is the ID of the item made
+	Output   string `json:"output" bson:"output"`
+	Quantity int    `json:"quantity" bson:"quantity"`
+	// Ingredients are consumed by crafting, at most one entry per item
+	Ingredients []Ingredient `json:"ingredients" bson:"ingredients"`
+	// Station is where the recipe is crafted, e.g. "forge", or empty when it
+	// can be crafted anywhere
+	Station string `json:"station,omitempty" bson:"station,omitempty"`
+}
+
+// Ingredient is one input of a recipe.
+type Ingredient struct {
+	// Item is the ID of the item consumed
+	Item     string `json:"item" bson:"item"`
+	Quantity int    `json:"quantity" bson:"quantity"`
+}
+
+func (r Recipe) RecordID() string       { return r.ID }
+func (r *Recipe) setRecordID(id string) { r.ID = id }
+
+// uses reports whether the recipe takes the item as an ingredient.
+func (r *Recipe) uses(item string) bool {
+	for _, in := range r.Ingredients {
+		if in.Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// recipes is served at /api/recipes.
+var recipes = newDataset("recipes", "recipe", checkRecipe, recipeFilter)
+
+// checkRecipe validates rec, checking the items it names against the cached
+// items snapshot if one is loaded. A quantity left out means one.
+func checkRecipe(rec *Recipe) []FieldError {
+	var errs validation.Errors
+
+	snap := items.cache.Peek()
+	checkItemRef := func(field, id string) {
+		if !errs.NonEmpty(field, id) || snap == nil || len(snap.items) == 0 {
+			return
+		}
+		if _, found := snap.lookup(id); !found {
+			errs.Add(field, "no item has ID %q", id)
+		}
+	}
+
+	checkItemRef("output", rec.Output)
+	if rec.Quantity == 0 {
+		rec.Quantity = 1
+	}
+	if rec.Quantity < 0 {
+		errs.Add("quantity", "must be positive, got %d", rec.Quantity)
+	}
+
+	if len(rec.Ingredients) == 0 {
+		errs.Add("ingredients", "must not be empty")
+	} else if len(rec.Ingredients) > maxRecipeIngredients {
+		errs.Add("ingredients", "must have at most %d entries, got %d", maxRecipeIngredients, len(rec.Ingredients))
+	}
+	ids := make([]string, len(rec.Ingredients))
+	for i := range rec.Ingredients {
+		in := &rec.Ingredients[i]
+		field := fmt.Sprintf("ingredients[%d]", i)
+		checkItemRef(field+".item", in.Item)
+		if in.Item != "" && in.Item == rec.Output {
+			errs.Add(field+".item", "must differ from the output")
+		}
+		if in.Quantity == 0 {
+			in.Quantity = 1
+		}
+		if in.Quantity < 0 {
+			errs.Add(field+".quantity", "must be positive, got %d", in.Quantity)
+		}
+		ids[i] = in.Item
+	}
+	errs.Unique(ids, func(i int) string { return fmt.Sprintf("ingredients[%d].item", i) })
+
+	if rec.Station != "" && (!slugPattern.MatchString(rec.Station) || len(rec.Station) > maxMetadataLength) {
+		errs.Add("station", "must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)
+	}
+
+	return errs
+}
+
+// recipeFilter selects recipes by ?output= item, by ?ingredient= item,
+// repeatable, all of which a recipe must take, by ?station=, and by ?with=, a
+// comma-separated list of the items at hand: only recipes needing nothing
+// else are kept, answering "what can I make with these". Quantities are not
+// compared.
+func recipeFilter(q url.Values) (func(*Recipe) bool, error) {
+	output, station, ingredients := q.Get("output"), q.Get("station"), q["ingredient"]
+	var with map[string]bool
+	if spec := q.Get("with"); spec != "" {
+		with = map[string]bool{}
+		for _, id := range strings.Split(spec, ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				return nil, fmt.Errorf("with must be a comma-separated list of item IDs")
+			}
+			with[id] = true
+		}
+	}
+	if output == "" && station == "" && len(ingredients) == 0 && with == nil {
+		return nil, nil
+	}
+
+	return func(rec *Recipe) bool {
+		if (output != "" && rec.Output != output) || (station != "" && rec.Station != station) {
+			return false
+		}
+		for _, id := range ingredients {
+			if !rec.uses(id) {
+				return false
+			}
+		}
+		for _, in := range rec.Ingredients {
+			if with != nil && !with[in.Item] {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// locationRedactors clear each field config.Redaction.Fields may name, keyed
+// by its JSON name. The ID, name and coordinates are never redacted.
+var locationRedactors = map[string]func(loc *MapLocation){
+	"region":       func(loc *MapLocation) { loc.Region = "" },
+	"biome":        func(loc *MapLocation) { loc.Biome = "" },
+	"dangerLevel":  func(loc *MapLocation) { loc.DangerLevel = 0 },
+	"discoveredBy": func(loc *MapLocation) { loc.DiscoveredBy = "" },
+	"tags":         func(loc *MapLocation) { loc.Tags = nil },
+	"weight":       func(loc *MapLocation) { loc.Weight = 0 },
+	"version":      func(loc *MapLocation) { loc.Version = 0 },
+	"updatedAt":    func(loc *MapLocation) { loc.UpdatedAt = nil },
+}
+
+// redacts reports whether the locations served to the request carrying ctx
+// are redacted: whether redaction is configured and the caller is anonymous
+// or below config.Redaction.Role.
+func redacts(ctx context.Context) bool {
+	c := config.Redaction
+	if len(c.Fields) == 0 && len(c.Tags) == 0 {
+		return false
+	}
+	p, ok := requestPrincipal(ctx)
+	return !ok || roleRank[p.Role] < roleRank[c.Role]
+}
+
+// varyRedaction adds the headers redaction depends on to Vary, so that
+// caches keep the redacted and full responses apart.
+func varyRedaction(h http.Header) {
+	if len(config.Redaction.Fields) > 0 || len(config.Redaction.Tags) > 0 {
+		h.Add("Vary", "Authorization, X-API-Key")
+	}
+}
+
+// redactLocation returns a copy of loc without the redacted fields and tags.
+func redactLocation(loc MapLocation) MapLocation {
+	for _, field := range config.Redaction.Fields {
+		locationRedactors[field](&loc)
+	}
+	if len(config.Redaction.Tags) > 0 && len(loc.Tags) > 0 {
+		loc.Tags = slices.DeleteFunc(slices.Clone(loc.Tags), func(tag string) bool {
+			return slices.Contains(config.Redaction.Tags, tag)
+		})
+		if len(loc.Tags) == 0 {
+			loc.Tags = nil
+		}
+	}
+	return loc
+}
+
+// redactLocations returns redacted copies of locations, leaving the cached
+// snapshot they may belong to intact.
+func redactLocations(locations []MapLocation) []MapLocation {
+	out := make([]MapLocation, len(locations))
+	for i, loc := range locations {
+		out[i] = redactLocation(loc)
+	}
+	return out
+}
+
+// unredactedFields drops the redacted fields from a ?fields= selection.
+func unredactedFields(fields []string) []string {
+	return slices.DeleteFunc(slices.Clone(fields), func(field string) bool {
+		return slices.Contains(config.Redaction.Fields, field)
+	})
+}
+
+// visibleSnapshot returns the current snapshot of the request's world as the
+// caller may see it, redacted when redacts says so. Filters applied to it only
+// see what the caller may, so a redacted tag or field value matches nothing
+// rather than confirming that it exists.
+func visibleSnapshot(ctx context.Context) (*cacheSnapshot, error) {
+	snap, err := loadSnapshot(ctx)
+	if err != nil || !redacts(ctx) {
+		return snap, err
+	}
+	return snap.redacted(), nil
+}
+
+// redacted returns s with its locations redacted, built at most once per
+// snapshot. It keeps the generation and load time of s, so that diffs and
+// Last-Modified line up with the full snapshot, but hashes its own locations,
+// so that its ETags never match those of the full responses.
+func (s *cacheSnapshot) redacted() *cacheSnapshot {
+	s.redactedOnce.Do(func() {
+		locations := redactLocations(s.locations)
+		s.redactedSnap = &cacheSnapshot{locations: locations, generation: s.generation, hash: snapshotHash(locations), loadedAt: s.loadedAt}
+	})
+	return s.redactedSnap
+}
+
+// redactChange returns ev with its location redacted.
+func redactChange(ev ChangeEvent) ChangeEvent {
+	if ev.Location != nil {
+		loc := redactLocation(*ev.Location)
+		ev.Location = &loc
+	}
+	return ev
+}
+
+// redactStats returns a copy of stats without what a redacted caller may not
+// see: the recent locations are redacted, and the counts by a redacted field
+// left empty.
+func redactStats(stats *MapStats) *MapStats {
+	out := *stats
+	out.RecentlyAdded = make([]RecentLocation, len(stats.RecentlyAdded))
+	for i, recent := range stats.RecentlyAdded {
+		out.RecentlyAdded[i] = RecentLocation{MapLocation: redactLocation(recent.MapLocation), AddedAt: recent.AddedAt}
+	}
+	if slices.Contains(config.Redaction.Fields, "region") {
+		out.ByRegion = map[string]int{}
+	}
+	if slices.Contains(config.Redaction.Fields, "biome") {
+		out.ByBiome = map[string]int{}
+	}
+	return &out
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"example/souforged/validation"
+)
+
+// maxRegionPoints bounds the outline of a region.
+const maxRegionPoints = 1000
+
+// RegionOutline is an area of the map outlined by a polygon, stored in the
+// regions collection. Regions may nest, such as a zone inside a region; a
+// point is taken to be in the smallest region containing it. Unlike the
+// validation regions of ValidationRules, which are rectangles, outlines are
+// data edited through the API.
+type RegionOutline struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// Kind groups regions, e.g. "region" or "zone"; it is a lower-case slug
+	Kind string `json:"kind,omitempty" bson:"kind,omitempty"`
+	// Polygon lists the corners of the outline in order; the last joins
+	// back to the first
+	Polygon [][2]float64 `json:"polygon" bson:"polygon"`
+}
+
+func (r RegionOutline) RecordID() string       { return r.ID }
+func (r *RegionOutline) setRecordID(id string) { r.ID = id }
+
+// RegionMatch is the /api/region-at response body.
+type RegionMatch struct {
+	Region RegionOutline `json:"region"`
+	// Enclosing are the IDs of the larger regions also containing the point,
+	// smallest first
+	Enclosing []string `json:"enclosing"`
+}
+
+// regions is served at /api/regions.
+var regions = newDataset("regions", "region", checkRegion, regionFilter)
+
+// checkRegion validates r. The outline must enclose an area and must not
+// cross itself.
+func checkRegion(r *RegionOutline) []FieldError {
+	var errs validation.Errors
+
+	if errs.NonEmpty("name", r.Name) {
+		errs.MaxLength("name", r.Name, validationRules.MaxNameLength)
+	}
+	if r.Kind != "" && (!slugPattern.MatchString(r.Kind) || len(r.Kind) > maxMetadataLength) {
+		errs.Add("kind", "must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)
+	}
+
+	if len(r.Polygon) < 3 || len(r.Polygon) > maxRegionPoints {
+		errs.Add("polygon", "must have 3 to %d points, got %d", maxRegionPoints, len(r.Polygon))
+		return errs
+	}
+	finite := true
+	for i, p := range r.Polygon {
+		finite = errs.Finite(fmt.Sprintf("polygon[%d][0]", i), p[0]) && finite
+		finite = errs.Finite(fmt.Sprintf("polygon[%d][1]", i), p[1]) && finite
+	}
+	if !finite {
+		return errs
+	}
+	if polygonArea(r.Polygon) == 0 {
+		errs.Add("polygon", "must enclose an area")
+	} else if i, j, crossed := polygonCrossing(r.Polygon); crossed {
+		errs.Add("polygon", "must not cross itself, but edges %d and %d do", i, j)
+	}
+	return errs
+}
+
+// regionFilter selects regions by ?kind=.
+func regionFilter(q url.Values) (func(*RegionOutline) bool, error) {
+	kind := q.Get("kind")
+	if kind == "" {
+		return nil, nil
+	}
+	return func(r *RegionOutline) bool { return r.Kind == kind }, nil
+}
+
+// regionShape is a region with the bounds and area its point queries use.
+type regionShape struct {
+	region *RegionOutline
+	bounds Bounds
+	area   float64
+}
+
+// regionShapeCache holds the shapes of the regions snapshot generation they
+// were computed for, smallest area first.
+var regionShapeCache struct {
+	mu         sync.Mutex
+	generation uint64
+	shapes     []regionShape
+}
+
+// regionShapes returns the shapes of the regions in snap.
+func regionShapes(snap *datasetSnapshot[RegionOutline]) []regionShape {
+	regionShapeCache.mu.Lock()
+	defer regionShapeCache.mu.Unlock()
+
+	if regionShapeCache.shapes != nil && regionShapeCache.generation == snap.generation {
+		return regionShapeCache.shapes
+	}
+	shapes := make([]regionShape, 0, len(snap.items))
+	for i := range snap.items {
+		r := &snap.items[i]
+		if len(r.Polygon) < 3 {
+			continue
+		}
+		b := Bounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+		for _, p := range r.Polygon {
+			b.MinX, b.MaxX = min(b.MinX, p[0]), max(b.MaxX, p[0])
+			b.MinY, b.MaxY = min(b.MinY, p[1]), max(b.MaxY, p[1])
+		}
+		shapes = append(shapes, regionShape{region: r, bounds: b, area: math.Abs(polygonArea(r.Polygon))})
+	}
+	sort.SliceStable(shapes, func(i, j int) bool { return shapes[i].area < shapes[j].area })
+	regionShapeCache.shapes = shapes
+	regionShapeCache.generation = snap.generation
+	return shapes
+}
+
+// contains reports whether (x, y) lies inside the shape or on its outline.
+func (s *regionShape) contains(x, y float64) bool {
+	if x < s.bounds.MinX || x > s.bounds.MaxX || y < s.bounds.MinY || y > s.bounds.MaxY {
+		return false
+	}
+	return pointInPolygon(s.region.Polygon, x, y)
+}
+
+// pointInPolygon casts a ray from (x, y) to the right and counts the edges
+// it crosses; points on an edge count as inside.
+func pointInPolygon(polygon [][2]float64, x, y float64) bool {
+	inside := false
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		if onSegment(a, b, x, y) {
+			return true
+		}
+		if (a[1] > y) != (b[1] > y) && x < a[0]+(y-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// onSegment reports whether (x, y) lies on the segment from a to b.
+func onSegment(a, b [2]float64, x, y float64) bool {
+	if (b[0]-a[0])*(y-a[1]) != (b[1]-a[1])*(x-a[0]) {
+		return false
+	}
+	return x >= min(a[0], b[0]) && x <= max(a[0], b[0]) && y >= min(a[1], b[1]) && y <= max(a[1], b[1])
+}
+
+// polygonArea returns the signed area of the polygon by the shoelace
+// formula.
+func polygonArea(polygon [][2]float64) float64 {
+	var sum float64
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		sum += a[0]*b[1] - b[0]*a[1]
+	}
+	return sum / 2
+}
+
+// polygonCrossing finds two edges of the polygon that are not neighbours and
+// yet touch. It compares every pair, which maxRegionPoints keeps cheap.
+func polygonCrossing(polygon [][2]float64) (int, int, bool) {
+	n := len(polygon)
+	for i := 0; i < n; i++ {
+		a, b := polygon[i], polygon[(i+1)%n]
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				continue
+			}
+			if segmentsTouch(a, b, polygon[j], polygon[(j+1)%n]) {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// segmentsTouch reports whether the segments ab and cd have a point in
+// common.
+func segmentsTouch(a, b, c, d [2]float64) bool {
+	orient := func(p, q, r [2]float64) float64 {
+		return (q[0]-p[0])*(r[1]-p[1]) - (q[1]-p[1])*(r[0]-p[0])
+	}
+	d1, d2 := orient(c, d, a), orient(c, d, b)
+	d3, d4 := orient(a, b, c), orient(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return onSegment(c, d, a[0], a[1]) || onSegment(c, d, b[0], b[1]) ||
+		onSegment(a, b, c[0], c[1]) || onSegment(a, b, d[0], d[1])
+}
+
+// regionAtHandler answers which region contains ?x=&y=: the smallest one,
+// with the larger ones around it.
+func regionAtHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	x, errX := strconv.ParseFloat(q.Get("x"), 64)
+	y, errY := strconv.ParseFloat(q.Get("y"), 64)
+	if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+		http.Error(w, "x and y must be finite numbers", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := regions.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	var match *RegionMatch
+	for _, s := range regionShapes(snap) {
+		if !s.contains(x, y) {
+			continue
+		}
+		if match == nil {
+			match = &RegionMatch{Region: *s.region, Enclosing: []string{}}
+		} else {
+			match.Enclosing = append(match.Enclosing, s.region.ID)
+		}
+	}
+	if match == nil {
+		writeProblem(w, r, http.StatusNotFound, "No region contains the point")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setSnapshotCaching(w.Header())
+	if notModified(w, r, snapshotETag(snap.hash, r.URL.RawQuery), snap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, match, "region")
+}
+
+// regionLocationsHandler lists the map locations inside a region.
+func regionLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	regionSnap, err := regions.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	var shape *regionShape
+	shapes := regionShapes(regionSnap)
+	for i := range shapes {
+		if shapes[i].region.ID == pathValue(r, "id") {
+			shape = &shapes[i]
+			break
+		}
+	}
+	if shape == nil {
+		writeProblem(w, r, http.StatusNotFound, "Region not found")
+		return
+	}
+
+	mapSnap, err := visibleSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setSnapshotCaching(w.Header())
+	varyRedaction(w.Header())
+	variant := fmt.Sprintf("region-locations|%x|%s", regionSnap.hash, shape.region.ID)
+	if notModified(w, r, snapshotETag(mapSnap.hash, variant), mapSnap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	inside := []MapLocation{}
+	for _, loc := range mapSnap.locations {
+		if shape.contains(loc.XY.X, loc.XY.Y) {
+			inside = append(inside, loc)
+		}
+	}
+	writeJSON(w, inside, "map locations")
+}
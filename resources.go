@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of resource node.
+var resourceTypes = map[string]bool{"ore": true, "herb": true, "fishing": true}
+
+// ResourceNode is a harvestable spot on the map, stored in the resources
+// collection.
+type ResourceNode struct {
+	ID   string      `json:"id" bson:"_id"`
+	Name string      `json:"name" bson:"name"`
+	Type string      `json:"type" bson:"type"`
+	XY   Coordinates `json:"xy" bson:"xy"`
+	// Yield is how many units one harvest gives
+	Yield int `json:"yield" bson:"yield"`
+	// RespawnSeconds is how long the node takes to come back once harvested
+	RespawnSeconds int `json:"respawnSeconds" bson:"respawnSeconds"`
+	// NearestLocation is the ID of the map location closest to the node. It
+	// is filled in on write when omitted.
+	NearestLocation string `json:"nearestLocation,omitempty" bson:"nearestLocation,omitempty"`
+}
+
+func (n ResourceNode) RecordID() string       { return n.ID }
+func (n *ResourceNode) setRecordID(id string) { n.ID = id }
+
+// resources is served at /api/resources.
+var resources = newDataset("resources", "resource node", checkResourceNode, resourceFilter)
+
+// checkResourceNode validates n and fills in its nearest location from the
+// cached map snapshot, if one is loaded.
+func checkResourceNode(n *ResourceNode) []FieldError {
+	var errs []FieldError
+
+	if strings.TrimSpace(n.Name) == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "must not be empty"})
+	} else if c := utf8.RuneCountInString(n.Name); c > validationRules.MaxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters, got %d", validationRules.MaxNameLength, c)})
+	}
+	if !resourceTypes[n.Type] {
+		errs = append(errs, FieldError{Field: "type", Message: fmt.Sprintf("must be one of ore, herb or fishing, got %q", n.Type)})
+	}
+	xyErrs := validateCoordinates(n.XY)
+	errs = append(errs, xyErrs...)
+	if n.Yield < 0 {
+		errs = append(errs, FieldError{Field: "yield", Message: "must not be negative"})
+	}
+	if n.RespawnSeconds < 0 {
+		errs = append(errs, FieldError{Field: "respawnSeconds", Message: "must not be negative"})
+	}
+
+	if snap := cache.Peek(); snap != nil && len(snap.locations) > 0 {
+		switch _, found := snap.lookup(n.NearestLocation); {
+		case n.NearestLocation == "" && len(xyErrs) > 0:
+			// No nearest location is derived from coordinates that are
+			// rejected anyway
+		case n.NearestLocation == "":
+			if i, _ := snap.grid().nearest(n.XY.X, n.XY.Y, -1); i >= 0 {
+				n.NearestLocation = snap.locations[i].ID
+			}
+		case !found:
+			errs = append(errs, FieldError{Field: "nearestLocation", Message: fmt.Sprintf("no map location has ID %q", n.NearestLocation)})
+		}
+	}
+
+	return errs
+}
+
+// resourceFilter selects nodes by ?type= and ?location=, the ID of their
+// nearest map location.
+func resourceFilter(q url.Values) (func(*ResourceNode) bool, error) {
+	kind, location := q.Get("type"), q.Get("location")
+	if kind != "" && !resourceTypes[kind] {
+		return nil, fmt.Errorf("type must be one of ore, herb or fishing")
+	}
+	if kind == "" && location == "" {
+		return nil, nil
+	}
+	return func(n *ResourceNode) bool {
+		return (kind == "" || n.Type == kind) && (location == "" || n.NearestLocation == location)
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// trackingWriter records whether a response has been started so that error
+// paths know if it is still possible to send an error status.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	written     int64
+}
+
+func (tw *trackingWriter) WriteHeader(status int) {
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *trackingWriter) Write(p []byte) (int, error) {
+	tw.wroteHeader = true
+	n, err := tw.ResponseWriter.Write(p)
+	tw.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *trackingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// started reports whether the status line or any body bytes have gone out.
+func (tw *trackingWriter) started() bool {
+	return tw.wroteHeader || tw.written > 0
+}
+
+// writeJSON encodes v as the response body. If encoding fails before anything
+// was sent the client gets a 500 mentioning what; once the response has
+// started an error status can no longer be sent, so the failure is only
+// logged and the response is left truncated.
+func writeJSON(w http.ResponseWriter, v any, what string) {
+	tw := &trackingWriter{ResponseWriter: w}
+
+	if err := json.NewEncoder(tw).Encode(v); err != nil {
+		if tw.started() {
+			slog.Error("failed to write response", "what", what, "bytes", tw.written, "error", err)
+			return
+		}
+		http.Error(w, "Failed to encode "+what+" as JSON", http.StatusInternalServerError)
+	}
+}
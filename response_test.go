@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts the first limit body bytes and then fails, like a
+// client connection dropping partway through a response. lateStatus records
+// a status written once the body has started.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit      int
+	lateStatus int
+}
+
+func (fw *failingWriter) WriteHeader(code int) {
+	if fw.Body.Len() > 0 {
+		fw.lateStatus = code
+	}
+	fw.ResponseRecorder.WriteHeader(code)
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	room := fw.limit - fw.Body.Len()
+	if room <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > room {
+		n, _ := fw.ResponseRecorder.Write(p[:room])
+		return n, errors.New("connection reset")
+	}
+	return fw.ResponseRecorder.Write(p)
+}
+
+func TestWriteJSONWriteFailureMidStream(t *testing.T) {
+	locations := []MapLocation{
+		{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}},
+		{ID: "b2", Location: "Mirewood", XY: Coordinates{X: 3, Y: 4}},
+	}
+
+	fw := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 10}
+	writeJSON(fw, locations, "map data")
+
+	if fw.Code != http.StatusOK || fw.lateStatus != 0 {
+		t.Errorf("status = %d then %d, want only the already-sent %d", fw.Code, fw.lateStatus, http.StatusOK)
+	}
+	if body := fw.Body.String(); strings.Contains(body, "Failed to encode") || len(body) != 10 {
+		t.Errorf("body = %q, want only the first 10 bytes of the payload", body)
+	}
+}
+
+func TestWriteJSONEncodeFailureBeforeWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, map[string]any{"bad": make(chan int)}, "test data")
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(rec.Body.String(), "Failed to encode test data as JSON") {
+		t.Errorf("body = %q, want encode error message", rec.Body.String())
+	}
+}
+
+func TestCoordinatePrecision(t *testing.T) {
+	prev := config.CoordinatePrecision
+	t.Cleanup(func() { config.CoordinatePrecision = prev })
+	loc := MapLocation{ID: "a1", XY: Coordinates{X: 12.345678, Y: -0.5}}
+
+	tests := []struct {
+		prec        int
+		want, exact string
+	}{
+		{-1, `{"x":12.345678,"y":-0.5}`, `{"x":12.345678,"y":-0.5}`},
+		{0, `{"x":12,"y":-0}`, `{"x":12.345678,"y":-0.5}`},
+		{2, `{"x":12.35,"y":-0.50}`, `{"x":12.345678,"y":-0.5}`},
+	}
+	for _, tt := range tests {
+		config.CoordinatePrecision = tt.prec
+		got, err := json.Marshal(loc)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if !strings.Contains(string(got), `"xy":`+tt.want) {
+			t.Errorf("precision %d: encoded %s, want xy %s", tt.prec, got, tt.want)
+		}
+		got, err = json.Marshal(exact(loc))
+		if err != nil {
+			t.Fatalf("Marshal exact: %v", err)
+		}
+		if !strings.Contains(string(got), `"xy":`+tt.exact) || strings.Count(string(got), `"xy"`) != 1 {
+			t.Errorf("precision %d: exact encoding %s, want xy %s", tt.prec, got, tt.exact)
+		}
+	}
+
+	for _, v := range []string{"-2", "16", "two"} {
+		t.Setenv("COORDINATE_PRECISION", v)
+		if _, err := loadConfig(nil); err == nil {
+			t.Errorf("COORDINATE_PRECISION=%s: loadConfig succeeded, want an error", v)
+		}
+	}
+}
+
+func TestCoordinatePrecisionOnlyInResponses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "map.json")
+	server := newTestServer(t, map[string]string{"COORDINATE_PRECISION": "1", "STORAGE_FILE": file},
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1.26, Y: 2}},
+	)
+
+	resp, body := send(t, server, http.MethodGet, "/api/map", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"xy":{"x":1.3,"y":2.0}`) {
+		t.Errorf("GET /api/map: status %d, body %s; want xy rounded to 1 place", resp.StatusCode, body)
+	}
+	saved, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(saved), `"x": 1.26`) {
+		t.Errorf("saved %s, want x kept at 1.26", saved)
+	}
+	reloaded, err := newMemoryStorage(file)
+	if err != nil {
+		t.Fatalf("newMemoryStorage: %v", err)
+	}
+	if loc, err := reloaded.GetLocation(context.Background(), "a1"); err != nil || loc.XY != (Coordinates{X: 1.26, Y: 2}) {
+		t.Errorf("reloaded xy %v (%v), want {1.26 2}", loc.XY, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// TravelEdge is a travel connection between two map locations, stored in the
+// edges collection. Edges are two-way unless OneWay is set.
+type TravelEdge struct {
+	ID   string `json:"id" bson:"_id"`
+	From string `json:"from" bson:"from"`
+	To   string `json:"to" bson:"to"`
+	// TravelSeconds is how long the trip takes
+	TravelSeconds float64 `json:"travelSeconds" bson:"travelSeconds"`
+	// TerrainCost is an abstract difficulty, such as stamina spent
+	TerrainCost float64 `json:"terrainCost" bson:"terrainCost"`
+	OneWay      bool    `json:"oneWay,omitempty" bson:"oneWay,omitempty"`
+}
+
+func (e TravelEdge) RecordID() string       { return e.ID }
+func (e *TravelEdge) setRecordID(id string) { e.ID = id }
+
+// edges is served at /api/edges.
+var edges = newDataset("edges", "travel edge", checkTravelEdge, edgeFilter)
+
+// checkTravelEdge validates e, checking its endpoints against the cached map
+// snapshot if one is loaded.
+func checkTravelEdge(e *TravelEdge) []FieldError {
+	var errs []FieldError
+
+	snap := cache.Peek()
+	for _, end := range []struct{ field, id string }{{"from", e.From}, {"to", e.To}} {
+		if end.id == "" {
+			errs = append(errs, FieldError{Field: end.field, Message: "must not be empty"})
+		} else if snap != nil && len(snap.locations) > 0 {
+			if _, found := snap.lookup(end.id); !found {
+				errs = append(errs, FieldError{Field: end.field, Message: fmt.Sprintf("no map location has ID %q", end.id)})
+			}
+		}
+	}
+	if e.From != "" && e.From == e.To {
+		errs = append(errs, FieldError{Field: "to", Message: "must differ from from"})
+	}
+	if !(e.TravelSeconds > 0) || !isFinite(e.TravelSeconds) {
+		errs = append(errs, FieldError{Field: "travelSeconds", Message: "must be a positive number"})
+	}
+	if !(e.TerrainCost >= 0) || !isFinite(e.TerrainCost) {
+		errs = append(errs, FieldError{Field: "terrainCost", Message: "must be a non-negative number"})
+	}
+
+	return errs
+}
+
+// edgeFilter selects the edges touching ?location=.
+func edgeFilter(q url.Values) (func(*TravelEdge) bool, error) {
+	location := q.Get("location")
+	if location == "" {
+		return nil, nil
+	}
+	return func(e *TravelEdge) bool { return e.From == location || e.To == location }, nil
+}
+
+// routeArc is one traversable direction of an edge.
+type routeArc struct {
+	from, to string
+	edge     *TravelEdge
+}
+
+// routeGraphCache holds the adjacency lists built from one edges generation.
+var routeGraphCache struct {
+	mu         sync.Mutex
+	generation uint64
+	arcs       map[string][]routeArc
+}
+
+// routeGraph returns the arcs leaving each location in snap, building them on
+// first use per generation.
+func routeGraph(snap *datasetSnapshot[TravelEdge]) map[string][]routeArc {
+	routeGraphCache.mu.Lock()
+	defer routeGraphCache.mu.Unlock()
+
+	if routeGraphCache.arcs != nil && routeGraphCache.generation == snap.generation {
+		return routeGraphCache.arcs
+	}
+	arcs := make(map[string][]routeArc)
+	for i := range snap.items {
+		e := &snap.items[i]
+		arcs[e.From] = append(arcs[e.From], routeArc{from: e.From, to: e.To, edge: e})
+		if !e.OneWay {
+			arcs[e.To] = append(arcs[e.To], routeArc{from: e.To, to: e.From, edge: e})
+		}
+	}
+	routeGraphCache.generation = snap.generation
+	routeGraphCache.arcs = arcs
+	return arcs
+}
+
+// Route is the /api/route response: the locations to pass through in order,
+// the edges taken between them and the totals of both costs.
+type Route struct {
+	Locations     []MapLocation `json:"locations"`
+	Edges         []string      `json:"edges"`
+	TravelSeconds float64       `json:"travelSeconds"`
+	TerrainCost   float64       `json:"terrainCost"`
+}
+
+// routeWeights are the edge costs /api/route can minimise with ?by=.
+var routeWeights = map[string]func(*TravelEdge) float64{
+	"time":    func(e *TravelEdge) float64 { return e.TravelSeconds },
+	"terrain": func(e *TravelEdge) float64 { return e.TerrainCost },
+}
+
+// routeHandler answers /api/route?from=&to= with the cheapest path between
+// two locations over the travel edges, by travel time or, with ?by=terrain,
+// by terrain cost.
+func routeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "Query parameters from and to are required", http.StatusBadRequest)
+		return
+	}
+	by := q.Get("by")
+	if by == "" {
+		by = "time"
+	}
+	weight, ok := routeWeights[by]
+	if !ok {
+		http.Error(w, "Query parameter by must be time or terrain", http.StatusBadRequest)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	for _, id := range []string{from, to} {
+		if _, found := snap.lookup(id); !found {
+			http.Error(w, fmt.Sprintf("Map location %q not found", id), http.StatusNotFound)
+			return
+		}
+	}
+	edgeSnap, err := edges.load(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	path, found := shortestPath(routeGraph(edgeSnap), from, to, weight)
+	if !found {
+		http.Error(w, "No route connects these locations", http.StatusNotFound)
+		return
+	}
+
+	route := Route{Locations: []MapLocation{}, Edges: []string{}}
+	loc, _ := snap.lookup(from)
+	route.Locations = append(route.Locations, loc)
+	for _, arc := range path {
+		loc, _ := snap.lookup(arc.to)
+		route.Locations = append(route.Locations, loc)
+		route.Edges = append(route.Edges, arc.edge.ID)
+		route.TravelSeconds += arc.edge.TravelSeconds
+		route.TerrainCost += arc.edge.TerrainCost
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, route, "route")
+}
+
+// shortestPath returns the arcs of the cheapest path between two locations,
+// in order.
+func shortestPath(arcs map[string][]routeArc, from, to string, weight func(*TravelEdge) float64) ([]routeArc, bool) {
+	_, via, done := dijkstra(arcs, from, to, weight)
+	if !done[to] {
+		return nil, false
+	}
+
+	var path []routeArc
+	for id := to; id != from; {
+		arc := via[id]
+		path = append(path, arc)
+		id = arc.from
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, true
+}
+
+// dijkstra computes the cheapest cost from one location to every location it
+// can reach, and the arc each is reached by, stopping early once stop is
+// settled (pass "" to settle everything). Map coordinates say nothing about
+// travel time or terrain, so there is no admissible heuristic for A*.
+func dijkstra(arcs map[string][]routeArc, from, stop string, weight func(*TravelEdge) float64) (dist map[string]float64, via map[string]routeArc, done map[string]bool) {
+	dist = map[string]float64{from: 0}
+	via = map[string]routeArc{}
+	done = map[string]bool{}
+	queue := &routeQueue{{id: from}}
+
+	for queue.Len() > 0 {
+		cur := heap.Pop(queue).(routeItem)
+		if done[cur.id] {
+			continue
+		}
+		done[cur.id] = true
+		if cur.id == stop {
+			break
+		}
+		for _, arc := range arcs[cur.id] {
+			d := cur.dist + weight(arc.edge)
+			if old, seen := dist[arc.to]; !seen || d < old {
+				dist[arc.to] = d
+				via[arc.to] = arc
+				heap.Push(queue, routeItem{id: arc.to, dist: d})
+			}
+		}
+	}
+	return dist, via, done
+}
+
+// routeItem is a tentative distance in the Dijkstra queue.
+type routeItem struct {
+	id   string
+	dist float64
+}
+
+// routeQueue is a min-heap of routeItems by distance.
+type routeQueue []routeItem
+
+func (q routeQueue) Len() int           { return len(q) }
+func (q routeQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+func (q routeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x any)        { *q = append(*q, x.(routeItem)) }
+func (q *routeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// middleware wraps a handler in behaviour shared by a group of routes, such
+// as a role check. requireAdmin is one.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// withRole is the middleware form of requireRole.
+func withRole(role string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc { return requireRole(role, next) }
+}
+
+// router dispatches requests by path pattern and method. A pattern is made
+// of slash-separated segments, each a literal, a {name} parameter matching
+// one non-empty segment, or, last, a {name...} parameter matching the rest of
+// the path. Literal segments win over parameters, so /api/map/search is
+// never taken for /api/map/{id}. GET routes also answer HEAD. A path with
+// routes for other methods only answers 405 with Allow, one matching no
+// pattern falls through to notFound.
+type router struct {
+	root     routeNode
+	notFound http.HandlerFunc
+	// wrap puts the middleware every route shares around each handler as
+	// it is registered, given the route's pattern
+	wrap func(pattern string, handler http.HandlerFunc) http.HandlerFunc
+}
+
+// routeNode is one segment of the pattern tree.
+type routeNode struct {
+	literals map[string]*routeNode
+	// param matches any one segment and rest everything after, under the
+	// names of their parameters
+	param, rest         *routeNode
+	paramName, restName string
+	handlers            map[string]http.HandlerFunc
+	// unmatched answers the methods without a handler, wrapped like the
+	// handlers so that CORS preflights and 405s are logged and counted
+	unmatched http.HandlerFunc
+}
+
+// routeGroup registers routes under a common prefix with the middleware of
+// the group, innermost last, applied inside the router's own.
+type routeGroup struct {
+	router     *router
+	prefix     string
+	middleware []middleware
+	// describe records the group's routes in activeRoutes
+	describe bool
+}
+
+// newRouter returns an empty router wrapping its handlers in wrap.
+func newRouter(wrap func(pattern string, handler http.HandlerFunc) http.HandlerFunc) *router {
+	return &router{notFound: http.NotFound, wrap: wrap}
+}
+
+// group returns the root group, whose routes are described at /.
+func (rt *router) group() routeGroup {
+	return routeGroup{router: rt, describe: true}
+}
+
+// group returns a group under g's prefix and prefix, adding mws to g's
+// middleware.
+func (g routeGroup) group(prefix string, mws ...middleware) routeGroup {
+	g.prefix += prefix
+	g.middleware = append(slices.Clip(g.middleware), mws...)
+	return g
+}
+
+// handle registers handler for method on the group's prefix and path,
+// wrapped in the group's middleware. An empty method matches every method,
+// for handlers that tell them apart themselves.
+func (g routeGroup) handle(method, path, description string, handler http.HandlerFunc) {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	pattern := g.prefix + path
+	g.router.add(method, pattern, handler)
+	if g.describe {
+		activeRoutes = append(activeRoutes, route{Method: method, Path: pattern, Description: description})
+	}
+}
+
+// Shorthands for handle.
+func (g routeGroup) get(path, description string, handler http.HandlerFunc) {
+	g.handle(http.MethodGet, path, description, handler)
+}
+
+func (g routeGroup) post(path, description string, handler http.HandlerFunc) {
+	g.handle(http.MethodPost, path, description, handler)
+}
+
+func (g routeGroup) put(path, description string, handler http.HandlerFunc) {
+	g.handle(http.MethodPut, path, description, handler)
+}
+
+func (g routeGroup) delete(path, description string, handler http.HandlerFunc) {
+	g.handle(http.MethodDelete, path, description, handler)
+}
+
+// add inserts handler into the tree. It panics on a pattern registered
+// twice for the same method, as http.ServeMux does.
+func (rt *router) add(method, pattern string, handler http.HandlerFunc) {
+	n := &rt.root
+	for _, seg := range splitPath(pattern) {
+		name, isParam := strings.CutPrefix(seg, "{")
+		name, _ = strings.CutSuffix(name, "}")
+		switch {
+		case isParam && strings.HasSuffix(name, "..."):
+			if n.rest == nil {
+				n.rest = &routeNode{}
+			}
+			n.restName = strings.TrimSuffix(name, "...")
+			n = n.rest
+		case isParam:
+			if n.param == nil {
+				n.param = &routeNode{}
+			}
+			if n.paramName != "" && n.paramName != name {
+				panic("router: " + pattern + " names a parameter differently from an earlier route")
+			}
+			n.paramName = name
+			n = n.param
+		default:
+			if n.literals[seg] == nil {
+				if n.literals == nil {
+					n.literals = map[string]*routeNode{}
+				}
+				n.literals[seg] = &routeNode{}
+			}
+			n = n.literals[seg]
+		}
+	}
+
+	if n.handlers[method] != nil {
+		panic("router: multiple registrations for " + method + " " + pattern)
+	}
+	if n.handlers == nil {
+		n.handlers = map[string]http.HandlerFunc{}
+		n.unmatched = rt.wrap(pattern, n.methodNotAllowed)
+	}
+	n.handlers[method] = rt.wrap(pattern, handler)
+}
+
+// splitPath returns the segments of a path; "/" has none.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// ServeHTTP dispatches r to the handler of the route matching its path and
+// method.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// A trailing slash names no route, as /api/map/ named no location
+	params := map[string]string{}
+	var n *routeNode
+	if r.URL.Path == "/" || !strings.HasSuffix(r.URL.Path, "/") {
+		n = rt.root.match(splitPath(r.URL.Path), params)
+	}
+	if n == nil {
+		rt.notFound(w, r)
+		return
+	}
+
+	handler := n.handlers[r.Method]
+	if handler == nil && r.Method == http.MethodHead {
+		handler = n.handlers[http.MethodGet]
+	}
+	if handler == nil {
+		handler = n.handlers[""]
+	}
+	if handler == nil {
+		handler = n.unmatched
+	}
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	handler(w, r)
+}
+
+// match finds the node with handlers for segs, literals first, collecting
+// the parameters on the way.
+func (n *routeNode) match(segs []string, params map[string]string) *routeNode {
+	if len(segs) == 0 {
+		if n.handlers != nil {
+			return n
+		}
+		return nil
+	}
+	if next := n.literals[segs[0]]; next != nil {
+		if found := next.match(segs[1:], params); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && segs[0] != "" {
+		if found := n.param.match(segs[1:], params); found != nil {
+			params[n.paramName] = segs[0]
+			return found
+		}
+	}
+	if n.rest != nil && n.rest.handlers != nil {
+		params[n.restName] = strings.Join(segs, "/")
+		return n.rest
+	}
+	return nil
+}
+
+// methodNotAllowed answers a method the route has no handler for.
+func (n *routeNode) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	methods := make([]string, 0, len(n.handlers)+1)
+	for m := range n.handlers {
+		methods = append(methods, m)
+		if m == http.MethodGet {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	slices.Sort(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+type pathParamsKey struct{}
+
+// pathValue returns the value of the {name} parameter in the pattern of the
+// route serving r, or "" if it has none.
+func pathValue(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// route is an HTTP endpoint served by this process. An empty Method stands
+// for every method.
+type route struct {
+	Method      string `json:"method,omitempty"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// activeRoutes lists the routes registered by registerRoutes, in order.
+var activeRoutes []route
+
+// registerRoutes mounts every endpoint on a new router and records them in
+// activeRoutes for the service descriptor served at /. The default mux is
+// left to the debug server, since net/http/pprof and expvar register there.
+func registerRoutes() http.Handler {
+	activeRoutes = nil
+	rt := newRouter(withMiddleware)
+	rt.notFound = withMiddleware("/", http.NotFound)
+	apiRoutes(rt.group().group(apiV1, versioned(apiV1)))
+	// The unversioned routes are those of v1 before it had a prefix, kept
+	// for the tools written against them; they are described once, as v1
+	legacy := rt.group().group("/api", deprecated(legacyAPI()))
+	legacy.describe = false
+	apiRoutes(legacy)
+
+	root := rt.group()
+	reader := root.group("", identify)
+	reader.get("/graphql", "GraphQL queries over locations, resource nodes, travel edges and routes", graphqlHandler)
+	reader.post("/graphql", "GraphQL queries sent as a JSON body", graphqlHandler)
+	reader.handle("", "/ws", "WebSocket feed of the map snapshot followed by diffs", wsHandler)
+	root.group("/admin", requireAdmin).get("/validate", "Stored locations failing validation (admin)", adminValidateHandler)
+	root.group("/admin", requireAdmin).get("/indexes", "Collection indexes and their usage (admin)", adminIndexesHandler)
+	root.get("/tiles/{path...}", "Map background tiles at /tiles/{z}/{x}/{y}.png", tilesHandler)
+	root.get("/openapi.json", "OpenAPI 3 description of this API", openAPIHandler)
+	root.get("/docs", "Swagger UI for the OpenAPI description", docsHandler)
+	root.get("/admin", "Operations dashboard, asking for an admin key to load its figures", dashboardPageHandler)
+	root.get("/metrics", "Prometheus metrics", promhttp.Handler().ServeHTTP)
+	root.get("/healthz", "Liveness probe", healthzHandler)
+	root.get("/readyz", "Readiness probe: storage reachable and cache loaded", readyzHandler)
+	root.get("/", "This service descriptor", rootHandler)
+	return rt
+}
+
+// apiRoutes registers the routes of the API on api, which is /api/v1 or the
+// unversioned /api.
+func apiRoutes(api routeGroup) {
+	contributor, admin := api.group("", withRole(roleContributor)), api.group("", requireAdmin)
+
+	mapRoutes(api, false)
+	api.get("/version", "The server's version, commit, build time and the API versions it serves", versionHandler)
+	api.get("/spaces", "The coordinate spaces ?space= and /api/transform convert between", spacesHandler)
+	api.get("/transform", "Convert ?x=&y= from coordinate space ?from= to ?to=", transformHandler)
+	api.post("/transform", "Convert the points of the body between coordinate spaces", transformHandler)
+	api.get("/worlds", "The game worlds and how many locations each has", worldsHandler)
+	// A world's map routes are the default world's, so they are described
+	// once, with it
+	world := api.group(strings.TrimPrefix(worldPrefix, "/api"), selectWorld)
+	world.get("", "A single world; /api/worlds/{world}/map... serves the /api/map routes for that world, except the stream, versions and diff", worldHandler)
+	world.describe = false
+	mapRoutes(world, true)
+
+	resources.mount(api, "Resource nodes, optionally by ?type= and nearest ?location=")
+	creatures.mount(api, "Creatures and where they spawn, optionally by ?region= and ?danger= tier or range")
+	edges.mount(api, "Travel edges between map locations, optionally those touching ?location=")
+	items.mount(api, "Items, optionally by ?category= and name ?q=")
+	api.get("/items/{id}/recipes", "The recipes making an item and those it is an ingredient of", itemRecipesHandler)
+	recipes.mount(api, "Crafting recipes, optionally by ?output=, ?ingredient=, ?station= or the items at hand ?with=")
+	mountLayers(api)
+	events.mount(api, "Scheduled events, optionally by ?kind=, ?location= and occurring between ?from= and ?to=")
+	api.get("/events/occurrences", "Occurrences of the events between ?from= and ?to= (default the next 30 days), soonest first", eventOccurrencesHandler)
+	api.get("/events/calendar.ics", "iCalendar feed of the events, filtered as /api/events", calendarHandler)
+	regions.mount(api, "Region outlines, optionally by ?kind=")
+	api.group("", identify).get("/regions/{id}/locations", "The map locations inside a region's outline", regionLocationsHandler)
+	api.get("/region-at", "The smallest region whose outline contains ?x=&y=, and the larger ones around it", regionAtHandler)
+	contributor.post("/prices", "Report a price observed in game (contributor)", createPriceHandler)
+	api.get("/prices/{item}", "Price history of an item over ?window= (default 7d) in at most ?points= buckets", priceHistoryHandler)
+	api.get("/route", "Cheapest path between ?from= and ?to= over the travel edges, by ?by=time or terrain", routeHandler)
+
+	contributor.get("/submissions", "Proposed locations, by ?status= (default pending; contributors see their own)", listSubmissionsHandler)
+	contributor.post("/submissions", "Propose a location (contributor)", createSubmissionHandler)
+	contributor.get("/submissions/{id}", "A single submission (contributor; contributors see their own)", getSubmissionHandler)
+	admin.post("/submissions/{id}/approve", "Write the submission to the map (admin)", approveSubmissionHandler)
+	admin.post("/submissions/{id}/reject", "Decline the submission (admin)", rejectSubmissionHandler)
+	signedIn, anyone := api.group("", withRole(roleViewer)), api.group("", identify)
+	anyone.get("/annotations", "Annotations the caller may see, newest first, by ?owner=, ?visibility= and ?minX=&minY=&maxX=&maxY=", listAnnotationsHandler)
+	signedIn.post("/annotations", "Add a pin, note or polygon annotation (signed in)", createAnnotationHandler)
+	anyone.get("/annotations/{id}", "A single annotation, if the caller may see it", getAnnotationHandler)
+	signedIn.put("/annotations/{id}", "Change an annotation (its owner or an admin)", updateAnnotationHandler)
+	signedIn.delete("/annotations/{id}", "Remove an annotation (its owner or an admin)", deleteAnnotationHandler)
+	signedIn.get("/users/me/favorites", "The caller's favorite locations", favoritesHandler)
+	signedIn.post("/users/me/favorites", "Add and remove favorites in one go, given {\"add\": [...], \"remove\": [...]}", changeFavoritesHandler)
+	signedIn.put("/users/me/favorites/{id}", "Star a location", addFavoriteHandler)
+	signedIn.delete("/users/me/favorites/{id}", "Unstar a location", removeFavoriteHandler)
+	api.post("/auth/register", "Create a user account", registerHandler)
+	api.post("/auth/login", "Exchange a username and password for a bearer token", loginHandler)
+
+	ops := api.group("/admin", requireAdmin)
+	ops.get("/audit", "Writes to the map and datasets, newest first, by ?id=, ?collection=, ?action= and ?since=&until= (admin)", auditHandler)
+	ops.get("/cache", "Age, size and refresh status of the caches (admin)", adminCacheHandler)
+	ops.post("/cache/refresh", "Reload every cache from storage now (admin)", adminCacheRefreshHandler)
+	ops.get("/dashboard", "Request rates, recent errors, caches and streaming clients shown at /admin (admin)", dashboardHandler)
+	ops.get("/analytics", "Anonymous usage of the last ?days= days: requests by route and dataset, daily clients and top search terms (admin)", analyticsHandler)
+	mountTranslations(ops)
+	ops.get("/backups", "Stored backups (admin)", listBackupsHandler)
+	ops.post("/backups", "Dump the data now (admin)", createBackupHandler)
+	ops.get("/webhooks", "Webhooks notified of location changes (admin)", listWebhooksHandler)
+	ops.post("/webhooks", "Register a webhook and return its signing secret (admin)", createWebhookHandler)
+	ops.get("/webhooks/{id}", "A single webhook (admin)", getWebhookHandler)
+	ops.delete("/webhooks/{id}", "Remove a webhook (admin)", deleteWebhookHandler)
+	ops.post("/webhooks/{id}/test", "Send a webhook a ping (admin)", testWebhookHandler)
+}
+
+// mapRoutes registers the routes of a world's map and trash on g, which is
+// /api for the default world and worldPrefix for the others.
+func mapRoutes(g routeGroup, otherWorld bool) {
+	contributor, admin := g.group("", withRole(roleContributor)), g.group("", requireAdmin)
+
+	// The routes serving locations identify the caller, whose role decides
+	// what is redacted
+	reader := g.group("", identify)
+	reader.get("/map", "All map locations, or those in ?minX=&minY=&maxX=&maxY= or matching ?region=&tag=, as JSON, MessagePack or protobuf by Accept, or ?format=geojson or ?format=ndjson; ?include=favorites flags the caller's favorites; ?space= converts the coordinates and ?schema=flat lays them out as x and y", getMapDataHandler)
+	contributor.post("/map", "Create a map location (contributor)", createMapLocationHandler)
+	reader.post("/map/batch", "The locations of the {\"ids\": [...]} in the body, in order, null where missing", batchHandler)
+	reader.get("/map/search", "Locations whose names best match ?q=, best first", searchHandler)
+	reader.get("/map/near", "Locations within ?radius= of ?x=&y=, nearest first", nearHandler)
+	reader.get("/map/nearest", "The ?limit= locations closest to ?x=&y=, optionally tagged ?type=, nearest first", nearestHandler)
+	g.get("/map/distances", "Pairwise straight-line and route distances between ?ids=, route cost by ?by=time or terrain", distancesHandler)
+	g.post("/map/distances", "Distances between the {\"ids\": [...]} in the body", distancesHandler)
+	admin.post("/map/import", "Upsert the locations of a JSON array or CSV upload (admin), reporting each row; ?dryRun=true only reports the plan", importHandler)
+	if otherWorld {
+		for _, path := range defaultWorldRoutes {
+			g.get(path, "", defaultWorldOnly(path))
+		}
+	} else {
+		g.get("/map/versions", "Stored versions of the map, newest first, up to ?limit=", versionsHandler)
+		reader.get("/map/diff", "Locations added, removed, moved or updated between versions ?from= and ?to= (default: the current map)", diffHandler)
+		reader.get("/map/stream", "Server-Sent Events feed of location changes", streamHandler)
+	}
+	reader.get("/map/downsample", "Spatially representative subset of at most ?max= locations", downsampleHandler)
+	g.get("/map/spread", "Nearest-neighbour distance summary", spreadHandler)
+	reader.get("/map/stats", "Counts per region and biome, bounds, density grid and recently added locations", statsHandler)
+	g.get("/map/adjacency", "Neighbours of each location within ?radius=", adjacencyHandler)
+	g.get("/map/next-free", "First free grid position from ?startX=&startY=&step=", nextFreeHandler)
+	g.get("/map/voronoi", "Voronoi region of each location", voronoiHandler)
+	g.post("/map/validate-batch", "Check candidate placements for validity and collisions", validateBatchHandler)
+	reader.get("/map/export.geojsonl", "Newline-delimited GeoJSON export", exportGeoJSONLinesHandler)
+	reader.get("/map/export", "CSV export, or TSV with ?format=tsv", exportHandler)
+	reader.get("/map/download", "Zip of the map and every public dataset as JSON, with a manifest of versions and checksums; ?sql=true adds an SQLite script", downloadHandler)
+	reader.get("/map/{id}", "A single map location", getMapLocationHandler)
+	contributor.put("/map/{id}", "Replace a map location, given the version the edit is based on (contributor)", updateMapLocationHandler)
+	admin.delete("/map/{id}", "Move a map location to the trash (admin)", deleteMapLocationHandler)
+	g.get("/map/{id}/image", "The image of a map location", getImageHandler)
+	admin.post("/map/{id}/image", "Upload the image of a map location (admin)", uploadImageHandler)
+	admin.delete("/map/{id}/image", "Remove the image of a map location (admin)", deleteImageHandler)
+	contributor.get("/map/{id}/history", "The writes to a map location with who made them and what they changed, newest first, up to ?limit= (contributor)", historyHandler)
+	admin.post("/map/{id}/history/{revision}/revert", "Write a map location back as one of its revisions left it (admin)", revertLocationHandler)
+
+	trash := g.group("/admin/trash", requireAdmin)
+	trash.get("", "Deleted map locations, most recently deleted first (admin)", trashHandler)
+	trash.post("/{id}/restore", "Put a deleted location back on the map (admin)", restoreLocationHandler)
+	trash.delete("/{id}", "Remove a deleted location for good (admin)", purgeLocationHandler)
+}
+
+// withMiddleware wraps the handler of route in the middleware every endpoint
+// shares, outermost first.
+func withMiddleware(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return advertiseVersion(traceRequests(route, instrument(route, logRequests(route, recoverPanics(limitRequests(route,
+		withCORS(rateLimit(route, maintenanceDuringReload(route, markStale(route, compressResponses(handler)))))))))))
+}
+
+// ServiceDescriptor identifies the service and the endpoints it exposes.
+type ServiceDescriptor struct {
+	Name      string  `json:"name"`
+	Version   string  `json:"version"`
+	Endpoints []route `json:"endpoints"`
+}
+
+// rootHandler serves the service descriptor at /.
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, ServiceDescriptor{
+		Name:      "soulforged-go",
+		Version:   version,
+		Endpoints: activeRoutes,
+	}, "service descriptor")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Location schemas, the layouts of a location in JSON: nested is the current
+// one, with the coordinates under xy, and flat the original one older
+// community tools read, with x and y beside the other fields.
+const (
+	schemaNested = "nested"
+	schemaFlat   = "flat"
+)
+
+var locationSchemas = []string{schemaNested, schemaFlat}
+
+// flatContentType is the media type of responses in the flat schema.
+const flatContentType = `application/json; profile="` + schemaFlat + `"`
+
+// FlatLocation is a location in the flat schema. Its XY shadows the
+// embedded location's and is never set, so that only x and y are written.
+type FlatLocation struct {
+	MapLocation
+	XY *struct{} `json:"xy,omitempty"`
+	X  float64   `json:"x"`
+	Y  float64   `json:"y"`
+}
+
+// flatten lays loc out in the flat schema, rounded as Coordinates would be.
+func flatten(loc MapLocation) FlatLocation {
+	x, y := loc.XY.X, loc.XY.Y
+	if config.CoordinatePrecision >= 0 {
+		x, y = roundCoordinate(x, config.CoordinatePrecision), roundCoordinate(y, config.CoordinatePrecision)
+	}
+	return FlatLocation{MapLocation: loc, X: x, Y: y}
+}
+
+func flattenLocations(locations []MapLocation) []FlatLocation {
+	out := make([]FlatLocation, len(locations))
+	for i, loc := range locations {
+		out[i] = flatten(loc)
+	}
+	return out
+}
+
+// flattenProjected moves the xy of a ?fields= projection out to x and y.
+func flattenProjected(projected []map[string]any) {
+	for _, m := range projected {
+		if c, ok := m["xy"].(Coordinates); ok {
+			delete(m, "xy")
+			flat := flatten(MapLocation{XY: c})
+			m["x"], m["y"] = flat.X, flat.Y
+		}
+	}
+}
+
+// requestSchema returns the location schema asked for by ?schema=, else by
+// the profile of an application/json entry of the Accept header, else
+// config.HTTP.DefaultSchema. Only ?schema= must name a known schema; other
+// profiles are not ours to reject.
+func requestSchema(r *http.Request) (string, error) {
+	if s := r.URL.Query().Get("schema"); s != "" {
+		if !slices.Contains(locationSchemas, s) {
+			return "", fmt.Errorf("must be one of %s", strings.Join(locationSchemas, ", "))
+		}
+		return s, nil
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mt == "application/json" && slices.Contains(locationSchemas, params["profile"]) {
+				return params["profile"], nil
+			}
+		}
+	}
+	return config.HTTP.DefaultSchema, nil
+}
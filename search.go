@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits for /api/map/search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQuery     = 100
+)
+
+// errTextSearchUnavailable is returned by TextSearchStorage when the backend
+// could in principle search but is missing what it needs, such as the text
+// index. Searches then fall back to the cache.
+var errTextSearchUnavailable = errors.New("text search unavailable")
+
+// TextSearchStorage is implemented by backends that can rank locations by how
+// well their names match a query.
+type TextSearchStorage interface {
+	// SearchLocations returns up to limit matches for query, best first.
+	SearchLocations(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// SearchResult is a location with its relevance to a search, higher being
+// better. Scores are only comparable within one response.
+type SearchResult struct {
+	MapLocation `bson:",inline"`
+	Score       float64 `json:"score" bson:"score"`
+}
+
+// searchHandler answers /api/map/search?q= with the locations whose names
+// best match q. The storage backend's text search is used when it has one;
+// otherwise, or when it is unavailable, the cached snapshot is searched for
+// case-insensitive substrings.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" || utf8.RuneCountInString(query) > maxSearchQuery {
+		http.Error(w, fmt.Sprintf("Query parameter q must be 1 to %d characters", maxSearchQuery), http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, fmt.Sprintf("Query parameter limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := searchStore(r.Context(), query, limit)
+	if errors.Is(err, errTextSearchUnavailable) {
+		var snap *cacheSnapshot
+		if snap, err = visibleSnapshot(r.Context()); err == nil {
+			results = searchLocations(snap.locations, query, limit)
+		}
+	} else if err == nil && redacts(r.Context()) {
+		for i := range results {
+			results[i].MapLocation = redactLocation(results[i].MapLocation)
+		}
+	}
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	recordSearchTerm(r, query)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	varyRedaction(w.Header())
+
+	writeJSON(w, results, "search results")
+}
+
+// searchStore runs the search on the storage backend, or returns
+// errTextSearchUnavailable if it cannot.
+func searchStore(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	search, ok := storeFor(ctx).(TextSearchStorage)
+	if !ok {
+		return nil, errTextSearchUnavailable
+	}
+
+	release, err := acquireMongo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	return search.SearchLocations(ctx, query, limit)
+}
+
+// searchLocations ranks the locations whose names contain query, ignoring
+// case: an exact match scores 4, a match at the start of the name 3, at the
+// start of a later word 2 and anywhere else 1. Ties go to the shorter name,
+// then to the lower ID.
+func searchLocations(locations []MapLocation, query string, limit int) []SearchResult {
+	needle := strings.ToLower(query)
+	results := []SearchResult{}
+	for _, loc := range locations {
+		name := strings.ToLower(loc.Location)
+		i := strings.Index(name, needle)
+		if i < 0 {
+			continue
+		}
+
+		score := 1.0
+		switch {
+		case name == needle:
+			score = 4
+		case i == 0:
+			score = 3
+		case strings.Contains(" "+name, " "+needle):
+			score = 2
+		}
+		results = append(results, SearchResult{MapLocation: loc, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Location) != len(b.Location) {
+			return len(a.Location) < len(b.Location)
+		}
+		return a.ID < b.ID
+	})
+	return results[:min(limit, len(results))]
+}
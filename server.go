@@ -1,167 +1,1130 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"example/souforged/cached"
 
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 )
 
 // Coordinates represents the embedded document for XY field
 type Coordinates struct {
-	X  float64 `json:"x" bson:"x"`
+	X float64 `json:"x" bson:"x"`
 	Y float64 `json:"y" bson:"y"`
 }
 
+// maxCoordinatePrecision is the most decimal places COORDINATE_PRECISION
+// may ask for; a float64 holds no more than 15 significant digits.
+const maxCoordinatePrecision = 15
+
+// MarshalJSON writes the coordinates rounded to config.CoordinatePrecision
+// decimal places, or at full precision when it is negative. Encodings the
+// server reads back use exactCoordinates instead, and BSON ignores this
+// method, so only what clients are sent is rounded.
+func (c Coordinates) MarshalJSON() ([]byte, error) {
+	prec := config.CoordinatePrecision
+	if prec < 0 || !isFinite(c.X) || !isFinite(c.Y) {
+		return json.Marshal(exactCoordinates(c))
+	}
+	data := append([]byte(`{"x":`), strconv.FormatFloat(c.X, 'f', prec, 64)...)
+	data = append(data, `,"y":`...)
+	data = append(data, strconv.FormatFloat(c.Y, 'f', prec, 64)...)
+	return append(data, '}'), nil
+}
+
+// exactCoordinates encodes Coordinates at full precision whatever
+// COORDINATE_PRECISION is.
+type exactCoordinates Coordinates
+
+// exactLocation encodes a MapLocation with its coordinates at full
+// precision.
+type exactLocation struct {
+	MapLocation
+	XY exactCoordinates `json:"xy"`
+}
+
+// exact returns what to JSON-encode for v to keep its coordinates at full
+// precision: a shadow for the stored types holding coordinates, v itself
+// for the others.
+func exact(v any) any {
+	switch v := v.(type) {
+	case MapLocation:
+		return exactLocation{v, exactCoordinates(v.XY)}
+	case TrashedLocation:
+		return struct {
+			exactLocation
+			DeletedAt time.Time `json:"deletedAt"`
+		}{exactLocation{v.MapLocation, exactCoordinates(v.XY)}, v.DeletedAt}
+	case Submission:
+		return struct {
+			Submission
+			Location exactLocation `json:"location"`
+		}{v, exactLocation{v.Location, exactCoordinates(v.Location.XY)}}
+	case ResourceNode:
+		return struct {
+			ResourceNode
+			XY exactCoordinates `json:"xy"`
+		}{v, exactCoordinates(v.XY)}
+	}
+	return v
+}
+
+// exactAll is exact for every element of vs.
+func exactAll[T any](vs []T) []any {
+	out := make([]any, len(vs))
+	for i, v := range vs {
+		out[i] = exact(v)
+	}
+	return out
+}
+
+// roundCoordinate rounds v to prec decimal places using decimal rather than
+// binary rounding, so 12.300000000000001 becomes exactly 12.3.
+func roundCoordinate(v float64, prec int) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(v, 'f', prec, 64), 64)
+	if err != nil {
+		return v
+	}
+	return rounded
+}
+
 // MapLocation represents your data structure
 type MapLocation struct {
-	ID string `json:"id" bson:"_id"`
+	ID       string      `json:"id" bson:"_id"`
 	Location string      `json:"location" bson:"location"`
 	XY       Coordinates `json:"xy" bson:"xy"`
+
+	// Optional metadata. Unset fields are left out of both JSON and BSON, so
+	// older documents and clients keep the original three-field shape.
+	Region string `json:"region,omitempty" bson:"region,omitempty"`
+	Biome  string `json:"biome,omitempty" bson:"biome,omitempty"`
+	// DangerLevel runs from 1 (safe) to maxDangerLevel; 0 means unrated
+	DangerLevel  int      `json:"dangerLevel,omitempty" bson:"dangerLevel,omitempty"`
+	DiscoveredBy string   `json:"discoveredBy,omitempty" bson:"discoveredBy,omitempty"`
+	Tags         []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Weight ranks the location higher in /api/map/nearest?weighted=true,
+	// up to maxWeight; 0 stands for the default weight of 1
+	Weight float64 `json:"weight,omitempty" bson:"weight,omitempty"`
+
+	// Version counts the writes to the location and UpdatedAt is the time
+	// of the last one; both are set on write. A PUT body's version is the
+	// one the edit is based on. Documents written before versioning have
+	// neither.
+	Version   int64      `json:"version,omitempty" bson:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
 }
 
-var (
-	client     *mongo.Client
-	collection *mongo.Collection
-	data       []MapLocation
-	cache      struct {
-		data []MapLocation
+// weight returns the location's weight, 1 when it has none.
+func (loc MapLocation) weight() float64 {
+	if loc.Weight == 0 {
+		return 1
 	}
-	cacheMutex sync.Mutex
+	return loc.Weight
+}
+
+// cacheSnapshot is one loaded copy of the map data. It is never modified once
+// published, so readers use it without locking; the grid and ID index are
+// built lazily, at most once per snapshot.
+type cacheSnapshot struct {
+	locations []MapLocation
+	// generation is bumped every time the snapshot is replaced so that values
+	// derived from a snapshot can tell whether they are still current. It
+	// is drawn from one counter across every world, so caches of derived
+	// values never mistake one world's snapshot for another's.
+	generation uint64
+	// hash identifies the content and order of locations for ETags
+	hash uint64
+	// loadedAt is when the locations were read from storage. A refresh that
+	// finds nothing changed keeps the snapshot, so it is also when the data
+	// last changed and the Last-Modified of responses built from it.
+	loadedAt time.Time
+
+	gridOnce sync.Once
+	gridIdx  *gridIndex
+	byIDOnce sync.Once
+	byID     map[string]int
+	// localizedByLang holds the locations named in each language asked for
+	localizedMu     sync.Mutex
+	localizedByLang map[string]localizedLocations
+	// tagGrids index the locations with each tag asked for by nearest
+	// lookups, built on first use
+	tagGridsMu sync.Mutex
+	tagGrids   map[string]*gridIndex
+	// redactedSnap is the snapshot as callers below config.Redaction.Role
+	// see it, built on first use
+	redactedOnce sync.Once
+	redactedSnap *cacheSnapshot
+	// The /api/map body in each media type, encoded on first use
+	jsonBody, msgpackBody, protobufBody snapshotBody
+	// compressed holds the encoded bodies compressed, keyed by media type
+	// and content coding
+	compressedMu sync.Mutex
+	compressed   map[string][]byte
+}
+
+// snapshotBody is a snapshot's locations encoded in one media type.
+type snapshotBody struct {
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// get returns the body, encoding it with encode on first use.
+func (b *snapshotBody) get(encode func() ([]byte, error)) ([]byte, error) {
+	b.once.Do(func() {
+		b.body, b.err = encode()
+	})
+	return b.body, b.err
+}
+
+// mapCache holds the current snapshot of one world. Empty snapshots are
+// never served as is, so a map that failed to come up is retried on the next
+// read.
+type mapCache = cached.Value[*cacheSnapshot]
+
+func newMapCache() *mapCache {
+	return &mapCache{Valid: func(s *cacheSnapshot) bool { return len(s.locations) > 0 }}
+}
+
+// tuneCache applies the cache settings of config to c, the cache named name
+// in /api/admin/cache whose TTL is keyed by ttlKey in config.CacheTTLs. Reads
+// falling back on the last snapshot loaded are counted by why they did.
+func tuneCache[T any](c *cached.Value[T], name, ttlKey string) {
+	c.TTL = config.CacheTTLs[ttlKey]
+	c.Wait = config.CacheLoadWait
+	c.Fallback = func(err error) {
+		reason := "error"
+		if errors.Is(err, cached.ErrLoadTimeout) {
+			reason = "timeout"
+		}
+		cacheFallbacks.WithLabelValues(name, reason).Inc()
+		slog.Debug("serving the last snapshot loaded", "cache", name, "error", err)
+	}
+}
+
+var (
+	// cache is the default world's, the one the change feeds, versions and
+	// shared cache follow
+	cache = newMapCache()
+	// snapshotGenerations numbers the snapshots of every world
+	snapshotGenerations atomic.Uint64
 )
 
-func initMongoDB() error {
-	// Load environment variables from .env file
-	err := godotenv.Load()
+// loadSnapshot returns the current snapshot of the request's world, fetching
+// the map data from storage when the cache has not been populated yet or was
+// invalidated. Concurrent reads of a cold cache share a single query.
+func loadSnapshot(ctx context.Context) (*cacheSnapshot, error) {
+	w := worldFor(ctx)
+	hit := w.cache.Fresh()
+	if hit {
+		cacheHits.Inc()
+	}
+	ctx, span := tracer.Start(ctx, "loadSnapshot", trace.WithAttributes(
+		attribute.String("world", w.name), attribute.Bool("cache.hit", hit)))
+	defer span.End()
+
+	return w.cache.Get(ctx, func(ctx context.Context) (*cacheSnapshot, error) {
+		ctx, span := tracer.Start(ctx, "fetchSnapshot", trace.WithAttributes(attribute.String("world", w.name)))
+		defer span.End()
+
+		cacheMisses.Inc()
+		release, err := acquireMongo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return w.fetch(ctx)
+	})
+}
+
+// fetch reads the world's locations from storage and returns its next
+// snapshot, which is the current one when nothing changed, so generations,
+// ETags and diff broadcasts only move when the data does. It runs as a load
+// of the world's cache.
+func (w *world) fetch(ctx context.Context) (*cacheSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	fresh, err := w.storage().ListLocations(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if prev := w.cache.Peek(); prev != nil && len(prev.locations) == len(fresh) && prev.hash == snapshotHash(fresh) {
+		slog.Debug("cache unchanged", "world", w.name, "locations", len(fresh), "generation", prev.generation)
+		return prev, nil
+	}
+	if !w.isDefault() {
+		return newSnapshot(fresh), nil
 	}
 
-	// Parse the connection string
-	uri := os.Getenv("MONGO_URI")
-	if uri == "" {
-		return fmt.Errorf("MONGO_URI environment variable is not set")
+	s := nextSnapshot(fresh)
+	slog.Debug("cache updated", "locations", len(fresh))
+	shareSnapshot(ctx, s)
+	recordVersion(s)
+	return s, nil
+}
+
+// newSnapshot wraps locations in a snapshot of the next generation.
+func newSnapshot(locations []MapLocation) *cacheSnapshot {
+	return &cacheSnapshot{
+		locations:  locations,
+		generation: snapshotGenerations.Add(1),
+		hash:       snapshotHash(locations),
+		loadedAt:   time.Now(),
 	}
+}
 
-	clientOptions := options.Client().ApplyURI(uri).SetMaxPoolSize(10) // Adjust the pool size as needed
+// nextSnapshot returns the default world's snapshot following the current
+// one, announcing the change. It must run as a load of cache.
+func nextSnapshot(locations []MapLocation) *cacheSnapshot {
+	next := newSnapshot(locations)
+	if prev := cache.Peek(); prev != nil {
+		onSnapshotChange(prev.locations, locations, next.generation)
+	}
+	return next
+}
 
-	// Connect to MongoDB
-	client, err = mongo.Connect(context.Background(), clientOptions)
-	if err != nil {
-		return err
+// setCacheData publishes locations as the default world's new snapshot,
+// which also drops everything derived from the previous one.
+func setCacheData(locations []MapLocation) *cacheSnapshot {
+	s, _ := cache.Reload(context.Background(), func(context.Context) (*cacheSnapshot, error) {
+		return nextSnapshot(locations), nil
+	})
+	return s
+}
+
+// currentGeneration returns the generation of the current snapshot, or 0 if
+// nothing has been loaded yet.
+func currentGeneration() uint64 {
+	if s := cache.Peek(); s != nil {
+		return s.generation
 	}
+	return 0
+}
 
-	collection = client.Database("soulforged-db").Collection("maplocations")
+// grid returns the spatial grid for the snapshot, building it on first use.
+func (s *cacheSnapshot) grid() *gridIndex {
+	s.gridOnce.Do(func() {
+		s.gridIdx = newGridIndex(s.locations)
+	})
+	return s.gridIdx
+}
 
-	// Check the connection
-	err = client.Ping(context.Background(), nil)
-	if err != nil {
-		return err
+// tagGrid returns the spatial grid over the snapshot's locations tagged tag,
+// building it on first use.
+func (s *cacheSnapshot) tagGrid(tag string) *gridIndex {
+	s.tagGridsMu.Lock()
+	defer s.tagGridsMu.Unlock()
+	if g, ok := s.tagGrids[tag]; ok {
+		return g
+	}
+	tagged := locationFilter{tags: []string{tag}}.apply(s.locations)
+	if s.tagGrids == nil {
+		s.tagGrids = make(map[string]*gridIndex)
 	}
+	s.tagGrids[tag] = newGridIndex(tagged)
+	return s.tagGrids[tag]
+}
 
-	fmt.Println("Connected to MongoDB!")
+// encodedJSON returns the snapshot's locations encoded as the /api/map JSON
+// body, encoding them on first use. Every unsorted request for the same
+// snapshot is served these bytes.
+func (s *cacheSnapshot) encodedJSON() ([]byte, error) {
+	return s.jsonBody.get(func() ([]byte, error) {
+		var buf bytes.Buffer
+		err := json.NewEncoder(&buf).Encode(s.locations)
+		return buf.Bytes(), err
+	})
+}
 
-	return nil
+// encoded returns the snapshot's locations encoded as the /api/map body of
+// mediaType, which is JSON, MessagePack or protobuf, encoding them at most
+// once per snapshot.
+func (s *cacheSnapshot) encoded(mediaType string) ([]byte, error) {
+	switch mediaType {
+	case msgpackContentType:
+		return s.msgpackBody.get(func() ([]byte, error) {
+			return encodeMsgpack(s.locations)
+		})
+	case protobufContentType:
+		return s.protobufBody.get(func() ([]byte, error) {
+			return proto.Marshal(toProtoLocations(s.locations))
+		})
+	}
+	return s.encodedJSON()
+}
+
+// encodedBody returns the /api/map body of mediaType in the given content
+// coding ("" for identity), compressing it at most once per snapshot, media
+// type and coding.
+func (s *cacheSnapshot) encodedBody(mediaType, coding string) ([]byte, error) {
+	body, err := s.encoded(mediaType)
+	if err != nil || coding == "" {
+		return body, err
+	}
+
+	s.compressedMu.Lock()
+	defer s.compressedMu.Unlock()
+
+	key := mediaType + "|" + coding
+	if compressed, ok := s.compressed[key]; ok {
+		return compressed, nil
+	}
+	compressed, err := compressBytes(coding, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.compressed == nil {
+		s.compressed = make(map[string][]byte)
+	}
+	s.compressed[key] = compressed
+	return compressed, nil
+}
+
+// lookup finds a single location of the snapshot by ID, building the ID index
+// on first use.
+func (s *cacheSnapshot) lookup(id string) (MapLocation, bool) {
+	s.byIDOnce.Do(func() {
+		s.byID = make(map[string]int, len(s.locations))
+		for i, loc := range s.locations {
+			s.byID[loc.ID] = i
+		}
+	})
+	i, ok := s.byID[id]
+	if !ok {
+		return MapLocation{}, false
+	}
+	return s.locations[i], true
 }
 
 func getMapDataHandler(w http.ResponseWriter, r *http.Request) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+	var sortKeys []sortKey
+	if spec := r.URL.Query().Get("sort"); spec != "" {
+		keys, err := parseSortKeys(spec)
+		if err != nil {
+			http.Error(w, "Invalid sort parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sortKeys = keys
+	}
+
+	box, err := parseBoxQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid bounding box: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ?space= serves the coordinates, and takes the bounding box, in another
+	// coordinate space
+	space, err := spaceQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := parseLocationFilter(r.URL.Query())
+
+	pg, paged, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid pagination: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fields []string
+	if spec := r.URL.Query().Get("fields"); spec != "" {
+		if fields, err = parseFields(spec); err != nil {
+			http.Error(w, "Invalid fields parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// ?format= takes precedence over the Accept header
+	mediaType := "application/json"
+	switch format := r.URL.Query().Get("format"); {
+	case format == "ndjson":
+		if sortKeys != nil || box != nil || paged || space != nil {
+			http.Error(w, "format=ndjson is streamed in storage order, without sort, bounding box, pagination or space", http.StatusBadRequest)
+			return
+		}
+		varyRedaction(w.Header())
+		streamMapNDJSON(w, r, filter, fields)
+		return
+	case format != "" && format != "json" && format != "geojson":
+		http.Error(w, "Query parameter format must be json, geojson or ndjson", http.StatusBadRequest)
+		return
+	case format == "json":
+	case wantsGeoJSON(r):
+		mediaType = geoJSONContentType
+	case wantsProtobuf(r):
+		mediaType = protobufContentType
+	case wantsMsgpack(r):
+		mediaType = msgpackContentType
+	}
+	if fields != nil && mediaType != "application/json" {
+		http.Error(w, "Query parameter fields is only supported for JSON", http.StatusBadRequest)
+		return
+	}
+
+	// The schema lays out JSON only; the other formats have their own
+	schema, err := requestSchema(r)
+	if err != nil {
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	flat := schema == schemaFlat && mediaType == "application/json"
+
+	// ?include=favorites flags the caller's favorites, so the answer is
+	// personal and bypasses the shared ETag and pre-encoded bodies
+	var favorited map[string]bool
+	switch include := r.URL.Query().Get("include"); {
+	case include == "":
+	case include != "favorites":
+		http.Error(w, "Query parameter include must be favorites", http.StatusBadRequest)
+		return
+	case mediaType != "application/json":
+		http.Error(w, "Query parameter include is only supported for JSON", http.StatusBadRequest)
+		return
+	case !worldFor(r.Context()).isDefault():
+		http.Error(w, "Favorites are only kept for the default world", http.StatusBadRequest)
+		return
+	default:
+		p, ok := requestPrincipal(r.Context())
+		if !ok {
+			http.Error(w, "include=favorites requires signing in", http.StatusUnauthorized)
+			return
+		}
+		var f Favorites
+		if !favoritesOp(w, r, func(ctx context.Context) (err error) {
+			f, err = loadFavorites(ctx, p.Name)
+			return err
+		}) {
+			return
+		}
+		favorited = f.set()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	w.Header().Add("Vary", "Accept")
+
+	// Redacted before filtering and sorting, so that neither tells what was
+	// left out
+	varyRedaction(w.Header())
+	redacted := redacts(r.Context())
+	if redacted {
+		fields = unredactedFields(fields)
+	}
+
+	var locations []MapLocation
+	var snap *cacheSnapshot
+	if box != nil {
+		// Viewport queries go to the storage backend instead of the cache
+		gameBox := *box
+		if space != nil {
+			gameBox = gameBounds(*box, *space)
+		}
+		locations, err = findInBox(r.Context(), gameBox)
+		if err == nil && redacted {
+			locations = redactLocations(locations)
+		}
+	} else {
+		snap, err = visibleSnapshot(r.Context())
+		if snap != nil {
+			locations = snap.locations
+		}
+	}
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	// Names follow Accept-Language where translated
+	w.Header().Add("Vary", "Accept-Language")
+	lang, translated := mapLanguage(r)
+	langVariant := ""
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+		langVariant = lang + "@" + strconv.FormatUint(translated.hash, 16)
+		if snap != nil {
+			locations = snap.localized(lang, translated)
+		} else {
+			locations = localizeLocations(locations, translated.byLanguage[lang])
+		}
+	}
+
+	if space != nil {
+		locations = transformLocations(locations, *space)
+		if box != nil {
+			locations = inBounds(locations, *box)
+		}
+	}
+
+	if favorited != nil {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else if snap != nil {
+		variant := strings.Join([]string{r.URL.Query().Get("sort"), mediaType, negotiateEncoding(r), pg.String(), strings.Join(fields, ","), filter.String(), langVariant, r.URL.Query().Get("space"), strconv.FormatBool(flat), strconv.FormatBool(redacted)}, "|")
+		setSnapshotCaching(w.Header())
+		if notModified(w, r, snapshotETag(snap.hash, variant), snap.loadedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
-	if len(cache.data) == 0 {
-		// Fetch data from MongoDB
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
+	// Filtering, sorting and encoding, as opposed to loading
+	_, span := tracer.Start(r.Context(), "encodeMapData", trace.WithAttributes(
+		attribute.String("media_type", mediaType), attribute.Int("locations", len(locations))))
+	defer span.End()
+
+	if !filter.empty() {
+		locations = filter.apply(locations)
+	}
 
-		cursor, err := collection.Find(ctx, bson.D{})
+	if sortKeys != nil {
+		// Sort a copy so the cached snapshot keeps its original order
+		if filter.empty() {
+			locations = append([]MapLocation(nil), locations...)
+		}
+		sortLocations(locations, sortKeys)
+	}
+
+	if paged {
+		setPageHeaders(w, r, pg, len(locations))
+		locations = pg.apply(locations)
+	}
+
+	if snap != nil && sortKeys == nil && !paged && filter.empty() && fields == nil && favorited == nil && lang == "" && space == nil && !flat && mediaType != geoJSONContentType {
+		// Served pre-encoded and, when the client allows, pre-compressed
+		coding := negotiateEncoding(r)
+		body, err := snap.encodedBody(mediaType, coding)
 		if err != nil {
-			http.Error(w, "Failed to fetch map data from MongoDB", http.StatusInternalServerError)
+			http.Error(w, "Failed to encode map data", http.StatusInternalServerError)
 			return
 		}
-		defer cursor.Close(ctx)
+		w.Header().Set("Content-Type", mediaType)
+		if coding != "" {
+			w.Header().Set("Content-Encoding", coding)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if _, err := w.Write(body); err != nil {
+			logFor(r.Context()).Error("failed to write response", "what", "map data", "error", err)
+		}
+		return
+	}
 
-		if err := cursor.All(context.Background(), &data); err != nil {
-			http.Error(w, "Failed to decode map data", http.StatusInternalServerError)
+	switch mediaType {
+	case geoJSONContentType:
+		w.Header().Set("Content-Type", geoJSONContentType)
+		writeJSON(w, toGeoJSONCollection(locations), "GeoJSON map data")
+		return
+	case protobufContentType:
+		body, err := proto.Marshal(toProtoLocations(locations))
+		if err != nil {
+			http.Error(w, "Failed to encode map data as protobuf", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", protobufContentType)
+		w.Write(body)
+		return
+	case msgpackContentType:
+		body, err := encodeMsgpack(locations)
+		if err != nil {
+			http.Error(w, "Failed to encode map data as MessagePack", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", msgpackContentType)
+		w.Write(body)
+		return
+	}
+
+	if flat {
+		w.Header().Set("Content-Type", flatContentType)
+	}
+
+	if fields != nil {
+		projected := projectLocations(locations, fields)
+		if favorited != nil {
+			for i, m := range projected {
+				m["favorite"] = favorited[locations[i].ID]
+			}
+		}
+		if flat {
+			flattenProjected(projected)
+		}
+		writeJSON(w, projected, "map data")
+		return
+	}
+
+	switch {
+	case favorited != nil && flat:
+		writeJSON(w, markFlatFavorites(locations, favorited), "map data")
+	case favorited != nil:
+		writeJSON(w, markFavorites(locations, favorited), "map data")
+	case flat:
+		writeJSON(w, flattenLocations(locations), "map data")
+	default:
+		writeJSON(w, locations, "map data")
+	}
+}
+
+// getMapLocationHandler serves a single location by ID at /api/map/{id}.
+func getMapLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	space, err := spaceQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schema, err := requestSchema(r)
+	if err != nil {
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	loc, found := snap.lookup(id)
+	if !found {
+		http.Error(w, "Map location not found", http.StatusNotFound)
+		return
+	}
+	// Editors need the current ETag for If-Match, so this is revalidated on
+	// every use rather than kept for the snapshot's max-age
+	modified := snap.loadedAt
+	if loc.UpdatedAt != nil {
+		modified = *loc.UpdatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	w.Header().Add("Vary", "Accept")
+	varyRedaction(w.Header())
+	if notModified(w, r, locationETag(loc), modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-		// Update cache
-		cache.data = data
-		fmt.Println("Cache updated")
+	if redacts(r.Context()) {
+		loc = redactLocation(loc)
 	}
-	
-	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(cache.data); err != nil {
-		http.Error(w, "Failed to encode map data as JSON", http.StatusInternalServerError)
+	if space != nil {
+		loc.XY.X, loc.XY.Y = space.apply(loc.XY.X, loc.XY.Y)
+	}
+	if schema == schemaFlat {
+		w.Header().Set("Content-Type", flatContentType)
+		writeJSON(w, flatten(loc), "map location")
 		return
 	}
+	writeJSON(w, loc, "map location")
+}
+
+// Refresh pacing: every delay is spread by up to refreshJitter either way so
+// replicas don't hit the database in lockstep, and consecutive failures
+// double the delay up to refreshMaxBackoff.
+const (
+	refreshJitter     = 0.1
+	refreshMaxBackoff = 5 * time.Minute
+)
+
+// changeRefreshDelay is how long a change notification waits before
+// refreshing, so that a burst of edits costs a single reload.
+const changeRefreshDelay = 500 * time.Millisecond
+
+// cacheChanges wakes the refresher when the storage reports a change. It is
+// buffered so notifyCacheChanged never blocks and extra notifications fold
+// into the pending one.
+var cacheChanges = make(chan struct{}, 1)
+
+// notifyCacheChanged asks the refresher to reload soon.
+func notifyCacheChanged() {
+	select {
+	case cacheChanges <- struct{}{}:
+	default:
+	}
 }
 
-func updateCacheAsync(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// refreshRequests wakes the refresher when the storage is reachable again
+// after an outage or when this replica becomes the elected refresher,
+// buffered like cacheChanges.
+var refreshRequests = make(chan struct{}, 1)
 
+// requestRefresh asks the refresher to reload soon even while it is backing
+// off from failed refreshes, which an outage most likely caused.
+func requestRefresh() {
+	select {
+	case refreshRequests <- struct{}{}:
+	default:
+	}
+}
+
+// updateCacheAsync loads the cache right away and then reloads it about every
+// interval until ctx is cancelled, backing off while refreshes fail. A change
+// notification brings the next reload forward to changeRefreshDelay from now,
+// unless the refresher is backing off; a refresh request does so even then.
+// While another replica is the elected refresher, only the first load is
+// done here and runElection keeps the caches in line with the leader's.
+func updateCacheAsync(ctx context.Context, interval time.Duration) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	next := time.Now()
+	failures := 0
 	for {
 		select {
-		case <-ticker.C:
-			cacheMutex.Lock()
-			// Fetch data from MongoDB and update cache
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
-			cursor, err := collection.Find(ctx, bson.D{})
-			if err != nil {
-				fmt.Println("Error fetching data from MongoDB:", err)
-				cacheMutex.Unlock()
-				continue
+		case <-ctx.Done():
+			return
+		case <-cacheChanges:
+			if at := time.Now().Add(changeRefreshDelay); failures == 0 && at.Before(next) {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(changeRefreshDelay)
+				next = at
 			}
-			defer cursor.Close(ctx)
-
-			if err := cursor.All(context.Background(), &data); err != nil {
-				fmt.Println("Error decoding map data:", err)
-				cacheMutex.Unlock()
-				continue
+			continue
+		case <-refreshRequests:
+			if !timer.Stop() {
+				<-timer.C
 			}
+			timer.Reset(changeRefreshDelay)
+			next = time.Now().Add(changeRefreshDelay)
+			continue
+		case <-timer.C:
+		}
+
+		if following() && cache.Peek() != nil {
+			failures = 0
+		} else if err := errors.Join(refreshCache(ctx), refreshWorlds(ctx), refreshDatasets(ctx)); err != nil {
+			failures++
+		} else {
+			failures = 0
+			publishCacheVersions(ctx)
+			rebuildDownloads(ctx)
+			purgeCDNOnChange(ctx)
+		}
+		delay := refreshDelay(interval, failures)
+		timer.Reset(delay)
+		next = time.Now().Add(delay)
+	}
+}
+
+// refreshDelay returns how long to wait before the next refresh after the
+// given number of consecutive failures.
+func refreshDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < refreshMaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max(refreshMaxBackoff, interval))
+	jitter := (rand.Float64()*2 - 1) * refreshJitter * float64(delay)
+	return delay + time.Duration(jitter)
+}
+
+// refreshCache reloads the default world's cache from storage once. The
+// refresher is a single goroutine and runs outside mongoSlots.
+func refreshCache(ctx context.Context) error {
+	_, err := cache.Reload(ctx, func(ctx context.Context) (*cacheSnapshot, error) {
+		start := time.Now()
+		s, err := defaultWorld.fetch(ctx)
+		refreshDuration.Observe(time.Since(start).Seconds())
+		recordRefresh(err)
+		if err != nil {
+			refreshFailures.Inc()
+			slog.Error("failed to refresh cache", "error", err)
+		}
+		return s, err
+	})
+	return err
+}
+
+// initServer connects the storage and every collection on it and loads the
+// settings the handlers read, as configured by config. It is what main does
+// before it starts the background goroutines and serves.
+func initServer(ctx context.Context) error {
+	// Initialize the storage backend
+	if err := initStorage(); err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	if err := openDatasets(); err != nil {
+		return fmt.Errorf("failed to open datasets: %w", err)
+	}
+
+	if err := openSubmissions(); err != nil {
+		return fmt.Errorf("failed to open submissions: %w", err)
+	}
+
+	if err := openAnnotations(); err != nil {
+		return fmt.Errorf("failed to open annotations: %w", err)
+	}
+
+	if err := openFavorites(); err != nil {
+		return fmt.Errorf("failed to open favorites: %w", err)
+	}
+
+	if err := openAnalytics(); err != nil {
+		return fmt.Errorf("failed to open analytics: %w", err)
+	}
 
-			// Update cache
-			cache.data = data
-			fmt.Println("Cache updated")
-			cacheMutex.Unlock()
+	if err := openWebhooks(); err != nil {
+		return fmt.Errorf("failed to open webhooks: %w", err)
+	}
+
+	if err := openWorlds(); err != nil {
+		return fmt.Errorf("failed to open worlds: %w", err)
+	}
+
+	if fixturesMode() {
+		if err := loadFixtures(ctx); err != nil {
+			return fmt.Errorf("failed to load fixtures: %w", err)
 		}
 	}
+
+	if err := initBackups(ctx); err != nil {
+		return fmt.Errorf("failed to initialize backups: %w", err)
+	}
+
+	if err := initSharedCache(); err != nil {
+		return fmt.Errorf("failed to initialize shared cache: %w", err)
+	}
+
+	if err := initElection(); err != nil {
+		return fmt.Errorf("failed to initialize election: %w", err)
+	}
+
+	loadMongoLimits()
+
+	if err := loadValidationRules(); err != nil {
+		return fmt.Errorf("failed to load validation rules: %w", err)
+	}
+	return nil
 }
 
 func main() {
-	// Initialize the router and MongoDB
-	err := initMongoDB()
+	// Load environment variables from .env file, if there is one
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("failed to load .env file", "error", err)
+		return
+	}
+
+	if err := initLogger(os.Stdout); err != nil {
+		slog.Error("failed to configure logging", "error", err)
+		return
+	}
+
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if run, ok := subcommands[args[0]]; ok {
+			subcommandMain(run, args[1:])
+			return
+		}
+		if args[0] != "serve" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+			printUsage(os.Stderr)
+			os.Exit(2)
+		}
+		args = args[1:]
+	}
+
+	cfg, err := loadConfig(args)
 	if err != nil {
-		fmt.Println("Error initializing MongoDB:", err)
+		slog.Error("invalid configuration", "error", err)
 		return
 	}
+	config = cfg
+
+	build := currentBuild()
+	slog.Info("starting soulforged-go", "version", build.Version, "commit", build.Commit, "buildTime", build.BuildTime, "go", build.GoVersion)
 
-	// Set your update interval (e.g., every 5 minutes)
-	updateInterval := 20 * time.Second
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		slog.Error("failed to initialize tracing", "error", err)
+		return
+	}
+
+	if err := initServer(context.Background()); err != nil {
+		slog.Error("failed to start", "error", err)
+		return
+	}
+
+	// SIGINT and SIGTERM cancel ctx, which stops the background goroutines
+	// and starts the shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start updating the cache asynchronously
-	go updateCacheAsync(updateInterval)
+	go updateCacheAsync(ctx, config.RefreshInterval)
+
+	// Serve the snapshots other replicas load as soon as they load them
+	if sharedCache != nil {
+		go followSharedCache(ctx)
+	}
+
+	// Take turns with the other replicas running the refresher
+	if refreshElection != nil {
+		go runElection(ctx)
+	}
+
+	// Fan collection changes out to /api/map/stream subscribers
+	go watchChanges(ctx)
 
-	// Register the handler
-	http.HandleFunc("/api/map", getMapDataHandler)
+	// Notify the registered webhooks of location writes
+	go dispatchWebhooks(ctx)
 
-	// Set your port here
-	port := 8080
+	// Back the data up on schedule
+	if backups != nil && config.Backup.Interval > 0 {
+		go runScheduledBackups(ctx, backups)
+	}
+
+	// Store the usage analytics now and then
+	if analyticsRecords != nil {
+		go runAnalytics(ctx)
+	}
+
+	// Answer map lookups and announce changes on Discord
+	if config.Discord.Token != "" {
+		go runDiscordBot(ctx)
+	}
+
+	// Register the handlers
+	mux := registerRoutes()
+
+	tlsConfig, challenges, err := newTLSConfig()
+	if err != nil {
+		slog.Error("failed to configure TLS", "error", err)
+		return
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.Port),
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: config.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       config.HTTP.ReadTimeout,
+		WriteTimeout:      config.HTTP.WriteTimeout,
+		IdleTimeout:       config.HTTP.IdleTimeout,
+		MaxHeaderBytes:    config.HTTP.MaxHeaderBytes,
+	}
+
+	// Shutdown does not wait for hijacked WebSocket connections and would
+	// wait forever for SSE streams, so end both
+	server.RegisterOnShutdown(func() {
+		hub.closeAll()
+		diffHub.closeAll()
+	})
+
+	// Start the servers, gRPC first so a taken port fails before any
+	// HTTP request is accepted
+	serveErr := make(chan error, 4)
+	var grpcServer *grpc.Server
+	if config.GRPCPort > 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", config.GRPCPort))
+		if err != nil {
+			slog.Error("failed to listen for gRPC", "error", err)
+			return
+		}
+		grpcServer = newGRPCServer(tlsConfig)
+		slog.Info("serving gRPC", "addr", lis.Addr().String())
+		go func() {
+			serveErr <- grpcServer.Serve(lis)
+		}()
+	}
+
+	var debugServer *http.Server
+	if config.DebugPort > 0 {
+		debugServer = newDebugServer()
+		slog.Info("serving debug endpoints", "addr", debugServer.Addr)
+		go func() {
+			serveErr <- debugServer.ListenAndServe()
+		}()
+	}
+
+	var redirectServer *http.Server
+	if config.TLS.RedirectPort > 0 {
+		redirectServer = newRedirectServer(challenges)
+		slog.Info("redirecting HTTP to HTTPS", "addr", redirectServer.Addr)
+		go func() {
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	slog.Info("listening", "addr", server.Addr, "tls", tlsConfig != nil, "version", version)
+	go func() {
+		if tlsConfig != nil {
+			// The certificates are already in TLSConfig
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("server failed", "error", err)
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+	stop()
 
-	// Start the server
-	http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	// Let in-flight requests finish before the storage goes away
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down server", "error", err)
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	if debugServer != nil {
+		debugServer.Close()
+	}
+	if refreshElection != nil {
+		refreshElection.resign(shutdownCtx)
+	}
+	if err := flushAnalytics(shutdownCtx); err != nil {
+		slog.Error("failed to flush analytics", "error", err)
+	}
+	if err := store.Close(shutdownCtx); err != nil {
+		slog.Error("failed to close storage", "error", err)
+	}
+	if sharedCache != nil {
+		if err := sharedCache.close(); err != nil {
+			slog.Error("failed to close Redis connection", "error", err)
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newTestServer serves the routes over a fresh memory backend holding
+// locations, configured as main would be from env on top of the defaults.
+// The configuration and store in use before are put back once t is done.
+func newTestServer(t *testing.T, env map[string]string, locations ...MapLocation) *httptest.Server {
+	t.Helper()
+	for name, value := range map[string]string{
+		"STORAGE_BACKEND": "memory",
+		"ADMIN_API_KEY":   testAdminKey,
+		// Every test client shares one IP
+		"RATE_LIMIT_READ_RATE":  "0",
+		"RATE_LIMIT_WRITE_RATE": "0",
+		"RATE_LIMIT_AUTH_RATE":  "0",
+	} {
+		t.Setenv(name, value)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	prevConfig, prevStore := config, store
+	t.Cleanup(func() {
+		config, store = prevConfig, prevStore
+		cache.Invalidate()
+	})
+	config = cfg
+	if err := initServer(context.Background()); err != nil {
+		t.Fatalf("initServer: %v", err)
+	}
+	for _, loc := range locations {
+		if err := store.InsertLocation(context.Background(), loc); err != nil {
+			t.Fatalf("InsertLocation(%s): %v", loc.ID, err)
+		}
+	}
+	stored, err := store.ListLocations(context.Background())
+	if err != nil {
+		t.Fatalf("ListLocations: %v", err)
+	}
+	setCacheData(stored)
+
+	server := httptest.NewServer(registerRoutes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// send makes a request to server and returns the response with its body
+// read. header holds name, value pairs.
+func send(t *testing.T, server *httptest.Server, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading the body: %v", method, path, err)
+	}
+	return resp, string(raw)
+}
+
+// bearer returns the Authorization header of a user with role.
+func bearer(t *testing.T, role string) string {
+	t.Helper()
+	token, _, err := issueToken(User{ID: role + "-user", Role: role}, time.Now())
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	return "Bearer " + token
+}
+
+func TestRedactionAcrossEndpoints(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"REDACT_FIELDS": "discoveredBy",
+		"REDACT_TAGS":   "hidden",
+		"REDACT_ROLE":   roleContributor,
+		"JWT_SECRET":    strings.Repeat("s", 32),
+	},
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}, DiscoveredBy: "Mira", Tags: []string{"town", "hidden"}},
+		MapLocation{ID: "b2", Location: "Mirewood", XY: Coordinates{X: 5, Y: 5}, Tags: []string{"hidden"}},
+	)
+	viewer := bearer(t, roleViewer)
+
+	tests := []struct {
+		name, method, path, body string
+	}{
+		{"batch", http.MethodPost, "/api/map/batch", `{"ids": ["a1", "b2"]}`},
+		{"search", http.MethodGet, "/api/map/search?q=ashen", ""},
+		{"nearest", http.MethodGet, "/api/map/nearest?x=0&y=0", ""},
+		{"export", http.MethodGet, "/api/map/export", ""},
+		{"geojsonl", http.MethodGet, "/api/map/export.geojsonl", ""},
+		{"downsample", http.MethodGet, "/api/map/downsample?max=10", ""},
+		{"graphql", http.MethodPost, "/graphql", `{"query": "{ locations { id discoveredBy tags } }"}`},
+	}
+	for _, tt := range tests {
+		for _, who := range []struct{ name, auth string }{{"anonymous", ""}, {"viewer", viewer}} {
+			var header []string
+			if who.auth != "" {
+				header = []string{"Authorization", who.auth}
+			}
+			resp, body := send(t, server, tt.method, tt.path, tt.body, header...)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s as %s: status %d, want 200: %s", tt.name, who.name, resp.StatusCode, body)
+				continue
+			}
+			if strings.Contains(body, "Mira") || strings.Contains(body, "hidden") {
+				t.Errorf("%s as %s: redacted data served: %s", tt.name, who.name, body)
+			}
+		}
+
+		resp, body := send(t, server, tt.method, tt.path, tt.body, "Authorization", bearer(t, roleContributor))
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, "hidden") {
+			t.Errorf("%s as contributor: status %d, want 200 with the full data: %s", tt.name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestRedactionHidesFilteredTags(t *testing.T) {
+	server := newTestServer(t, map[string]string{"REDACT_TAGS": "hidden"},
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}, Tags: []string{"hidden"}},
+	)
+
+	for _, path := range []string{"/api/map/nearest?x=0&y=0&type=hidden", "/api/map?tag=hidden"} {
+		resp, body := send(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK || strings.TrimSpace(body) != "[]" {
+			t.Errorf("%s: status %d, body %s; want 200 with no locations", path, resp.StatusCode, body)
+		}
+		if vary := resp.Header.Values("Vary"); !strings.Contains(strings.Join(vary, ","), "X-API-Key") {
+			t.Errorf("%s: Vary %q, want it to include X-API-Key", path, vary)
+		}
+
+		resp, body = send(t, server, http.MethodGet, path, "", "X-API-Key", testAdminKey)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, "a1") {
+			t.Errorf("%s as admin: status %d, body %s; want a1", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestConditionalDelete(t *testing.T) {
+	updated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := newTestServer(t, nil,
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}, Version: 2, UpdatedAt: &updated},
+	)
+
+	resp, body := send(t, server, http.MethodDelete, "/api/map/a1", "",
+		"X-API-Key", testAdminKey, "If-Unmodified-Since", updated.Add(-time.Hour).Format(http.TimeFormat))
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE changed since: status %d, want 412: %s", resp.StatusCode, body)
+	}
+
+	// A write landing between the handler's check and its delete moves the
+	// version on, which the delete's filter catches
+	ctx := context.Background()
+	if err := store.DeleteLocation(ctx, "a1", 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("DeleteLocation at a stale version: %v, want ErrVersionConflict", err)
+	}
+	if err := store.DeleteLocation(ctx, "zz", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteLocation of a missing location: %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetLocation(ctx, "a1"); err != nil {
+		t.Fatalf("a1 deleted by a refused delete: %v", err)
+	}
+
+	resp, body = send(t, server, http.MethodDelete, "/api/map/a1", "",
+		"X-API-Key", testAdminKey, "If-Unmodified-Since", updated.Format(http.TimeFormat))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE unmodified: status %d, want 204: %s", resp.StatusCode, body)
+	}
+	if _, err := store.GetLocation(ctx, "a1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetLocation after the delete: %v, want ErrNotFound", err)
+	}
+}
+
+func TestWeightedNear(t *testing.T) {
+	server := newTestServer(t, nil,
+		MapLocation{ID: "close", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 0}},
+		MapLocation{ID: "heavy", Location: "Mirewood", XY: Coordinates{X: 3, Y: 0}, Weight: 10},
+		MapLocation{ID: "far", Location: "Duskmire", XY: Coordinates{X: 50, Y: 0}, Weight: 100},
+	)
+
+	resp, body := send(t, server, http.MethodGet, "/api/map/near?x=0&y=0&radius=10&weighted=true", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", resp.StatusCode, body)
+	}
+	var results []NearResult
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.ID)
+	}
+	if strings.Join(got, ",") != "heavy,close" {
+		t.Errorf("weighted near ranked %v, want [heavy close] with far outside the radius", got)
+	}
+	if len(results) == 2 && (results[0].Score == nil || *results[0].Score != 0.3) {
+		t.Errorf("heavy scored %v, want 0.3", results[0].Score)
+	}
+
+	resp, body = send(t, server, http.MethodGet, "/api/map/near?x=0&y=0&radius=10&weighted=maybe", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("weighted=maybe: status %d, want 400: %s", resp.StatusCode, body)
+	}
+}
+
+func TestDeletedLocationsUnreferenced(t *testing.T) {
+	// The finalizer runs once nothing refers to a1's UpdatedAt, which only
+	// a1 and its copies do
+	updated := new(time.Time)
+	*updated = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	released := make(chan struct{})
+	runtime.SetFinalizer(updated, func(*time.Time) { close(released) })
+	server := newTestServer(t, nil,
+		MapLocation{ID: "a1", Location: "Ashen Keep", XY: Coordinates{X: 1, Y: 2}, Version: 1, UpdatedAt: updated},
+		MapLocation{ID: "b2", Location: "Mirewood", XY: Coordinates{X: 5, Y: 5}},
+	)
+	updated = nil
+
+	for _, path := range []string{"/api/map/a1", "/api/admin/trash/a1"} {
+		if resp, body := send(t, server, http.MethodDelete, path, "", "X-API-Key", testAdminKey); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("DELETE %s: status %d, want 204: %s", path, resp.StatusCode, body)
+		}
+	}
+	if resp, body := send(t, server, http.MethodGet, "/api/map", ""); resp.StatusCode != http.StatusOK || strings.Contains(body, "a1") {
+		t.Fatalf("GET /api/map after the delete: status %d, body %s", resp.StatusCode, body)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-released:
+			return
+		case <-deadline:
+			t.Fatal("the deleted location is still referenced after the cache moved on")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sharedCacheTimeout bounds each Redis operation.
+const sharedCacheTimeout = 5 * time.Second
+
+// redisCache shares the cache snapshot between replicas through Redis. The
+// replica that loads a new snapshot from storage stores it under a key and
+// announces its hash on a pub/sub channel; the others fetch it from there, so
+// all replicas serve the same data without each waiting for its own refresh.
+type redisCache struct {
+	client  *redis.Client
+	key     string
+	channel string
+}
+
+// sharedCache is nil unless config.Redis.URL is set.
+var sharedCache *redisCache
+
+// initSharedCache connects to Redis when it is configured.
+func initSharedCache() error {
+	if config.Redis.URL == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(config.Redis.URL)
+	if err != nil {
+		return fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	sharedCache = &redisCache{
+		client:  client,
+		key:     config.Redis.KeyPrefix + "snapshot",
+		channel: config.Redis.KeyPrefix + "snapshot:updates",
+	}
+	slog.Info("sharing the cache through Redis", "addr", opts.Addr, "key", sharedCache.key)
+	return nil
+}
+
+// publish stores the snapshot and announces it to the other replicas. The
+// locations are stored at full precision rather than as /api/map encodes
+// them, so that followers hold the same data the hash was computed on and
+// round only when they serve it.
+func (c *redisCache) publish(ctx context.Context, s *cacheSnapshot) error {
+	body, err := json.Marshal(exactAll(s.locations))
+	if err != nil {
+		return err
+	}
+	hash := strconv.FormatUint(s.hash, 16)
+
+	ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+	defer cancel()
+
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, c.key, "hash", hash, "locations", body)
+		pipe.Publish(ctx, c.channel, hash)
+		return nil
+	})
+	return err
+}
+
+// load returns the shared snapshot and its hash, or ErrNotFound when no
+// replica has published one yet.
+func (c *redisCache) load(ctx context.Context) ([]MapLocation, uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+	defer cancel()
+
+	fields, err := c.client.HMGet(ctx, c.key, "hash", "locations").Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	rawHash, ok1 := fields[0].(string)
+	body, ok2 := fields[1].(string)
+	if !ok1 || !ok2 {
+		return nil, 0, ErrNotFound
+	}
+
+	hash, err := strconv.ParseUint(rawHash, 16, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid shared snapshot hash %q: %w", rawHash, err)
+	}
+	var locations []MapLocation
+	if err := json.Unmarshal([]byte(body), &locations); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode shared snapshot: %w", err)
+	}
+	return locations, hash, nil
+}
+
+// ping checks that Redis is reachable.
+func (c *redisCache) ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// close disconnects from Redis.
+func (c *redisCache) close() error {
+	return c.client.Close()
+}
+
+// shareSnapshot publishes a snapshot this replica loaded from storage. A
+// failure only costs the other replicas their early update, so it is logged
+// rather than returned.
+func shareSnapshot(ctx context.Context, s *cacheSnapshot) {
+	if sharedCache == nil {
+		return
+	}
+	if err := sharedCache.publish(ctx, s); err != nil {
+		slog.Warn("failed to share snapshot", "error", err)
+	}
+}
+
+// followSharedCache adopts the snapshots other replicas publish until ctx is
+// cancelled, starting with the one already stored. The go-redis subscription
+// reconnects by itself when the connection drops.
+func followSharedCache(ctx context.Context) {
+	sub := sharedCache.client.Subscribe(ctx, sharedCache.channel)
+	defer sub.Close()
+
+	adoptSharedSnapshot(ctx, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			hash, err := strconv.ParseUint(msg.Payload, 16, 64)
+			if err != nil {
+				slog.Warn("ignoring malformed snapshot announcement", "payload", msg.Payload)
+				continue
+			}
+			adoptSharedSnapshot(ctx, hash)
+		}
+	}
+}
+
+// adoptSharedSnapshot swaps in the shared snapshot unless the cache already
+// holds the one with the announced hash. A zero hash means none was announced.
+func adoptSharedSnapshot(ctx context.Context, announced uint64) {
+	if s := cache.Peek(); s != nil && announced != 0 && s.hash == announced {
+		return
+	}
+
+	locations, hash, err := sharedCache.load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to load shared snapshot", "error", err)
+		return
+	}
+
+	cache.Reload(ctx, func(context.Context) (*cacheSnapshot, error) {
+		if s := cache.Peek(); s != nil && s.hash == hash {
+			return s, nil
+		}
+		slog.Debug("adopted shared snapshot", "locations", len(locations))
+		return nextSnapshot(locations), nil
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sortKey is one field of a ?sort= specification.
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// locationComparators compares two locations on a single sortable field,
+// returning a negative, zero or positive result.
+var locationComparators = map[string]func(a, b *MapLocation) int{
+	"id":          func(a, b *MapLocation) int { return strings.Compare(a.ID, b.ID) },
+	"location":    func(a, b *MapLocation) int { return strings.Compare(a.Location, b.Location) },
+	"x":           func(a, b *MapLocation) int { return compareFloat(a.XY.X, b.XY.X) },
+	"y":           func(a, b *MapLocation) int { return compareFloat(a.XY.Y, b.XY.Y) },
+	"region":      func(a, b *MapLocation) int { return strings.Compare(a.Region, b.Region) },
+	"biome":       func(a, b *MapLocation) int { return strings.Compare(a.Biome, b.Biome) },
+	"dangerLevel": func(a, b *MapLocation) int { return a.DangerLevel - b.DangerLevel },
+}
+
+// parseSortKeys parses a comma-separated ?sort= value such as "x,-location".
+// A leading "-" sorts that field in descending order. The _id is always
+// appended as the final tiebreaker so that the resulting order is total.
+func parseSortKeys(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	hasID := false
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		key := sortKey{field: part}
+		if strings.HasPrefix(part, "-") {
+			key = sortKey{field: part[1:], desc: true}
+		}
+		if key.field == "_id" {
+			key.field = "id"
+		}
+		if _, ok := locationComparators[key.field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q", part)
+		}
+		if key.field == "id" {
+			hasID = true
+		}
+		keys = append(keys, key)
+	}
+
+	if !hasID {
+		keys = append(keys, sortKey{field: "id"})
+	}
+	return keys, nil
+}
+
+// sortLocations sorts locations in place by keys, applied in priority order.
+func sortLocations(locations []MapLocation, keys []sortKey) {
+	sort.SliceStable(locations, func(i, j int) bool {
+		for _, key := range keys {
+			c := locationComparators[key.field](&locations[i], &locations[j])
+			if key.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Bounds is the axis-aligned bounding box of a set of coordinates.
+type Bounds struct {
+	MinX float64 `json:"minX"`
+	MinY float64 `json:"minY"`
+	MaxX float64 `json:"maxX"`
+	MaxY float64 `json:"maxY"`
+}
+
+// locationBounds returns the bounding box of locations. ok is false when
+// locations is empty.
+func locationBounds(locations []MapLocation) (b Bounds, ok bool) {
+	if len(locations) == 0 {
+		return Bounds{}, false
+	}
+
+	b = Bounds{
+		MinX: math.Inf(1), MinY: math.Inf(1),
+		MaxX: math.Inf(-1), MaxY: math.Inf(-1),
+	}
+	for _, loc := range locations {
+		b.MinX = math.Min(b.MinX, loc.XY.X)
+		b.MinY = math.Min(b.MinY, loc.XY.Y)
+		b.MaxX = math.Max(b.MaxX, loc.XY.X)
+		b.MaxY = math.Max(b.MaxY, loc.XY.Y)
+	}
+	return b, true
+}
+
+type gridKey struct {
+	x, y int
+}
+
+// gridIndex is a uniform grid over a location snapshot used to answer
+// proximity queries without scanning every location. It is immutable once
+// built and indexes into the slice it was built from.
+type gridIndex struct {
+	locations []MapLocation
+	cellSize  float64
+	cells     map[gridKey][]int
+	min, max  gridKey
+	// bounds is the bounding box of the locations
+	bounds Bounds
+	// maxWeight is the greatest weight of the locations
+	maxWeight float64
+}
+
+// newGridIndex builds a grid over locations sized so that each cell holds
+// roughly one location on average.
+func newGridIndex(locations []MapLocation) *gridIndex {
+	g := &gridIndex{
+		locations: locations,
+		cellSize:  1,
+		cells:     make(map[gridKey][]int),
+		maxWeight: 1,
+	}
+
+	if b, ok := locationBounds(locations); ok {
+		g.bounds = b
+		area := (b.MaxX - b.MinX) * (b.MaxY - b.MinY)
+		if size := math.Sqrt(area / float64(len(locations))); size > 0 {
+			g.cellSize = size
+		} else if span := math.Max(b.MaxX-b.MinX, b.MaxY-b.MinY); span > 0 {
+			// All locations lie on a horizontal or vertical line
+			g.cellSize = span / float64(len(locations))
+		}
+	}
+
+	for i, loc := range locations {
+		key := g.keyFor(loc.XY.X, loc.XY.Y)
+		if i == 0 {
+			g.min, g.max = key, key
+		}
+		g.min.x, g.min.y = min(g.min.x, key.x), min(g.min.y, key.y)
+		g.max.x, g.max.y = max(g.max.x, key.x), max(g.max.y, key.y)
+		g.cells[key] = append(g.cells[key], i)
+		g.maxWeight = max(g.maxWeight, loc.weight())
+	}
+
+	return g
+}
+
+func (g *gridIndex) keyFor(x, y float64) gridKey {
+	return gridKey{
+		x: int(math.Floor(x / g.cellSize)),
+		y: int(math.Floor(y / g.cellSize)),
+	}
+}
+
+// within calls fn with the index and distance of every location whose
+// coordinates lie within radius of (x, y). The box searched is clamped to the
+// locations' bounding box before it is keyed, so that however large radius
+// or far off (x, y) is, no more than the grid's cells are looked at.
+func (g *gridIndex) within(x, y, radius float64, fn func(i int, dist float64)) {
+	clampX := func(v float64) float64 { return math.Min(math.Max(v, g.bounds.MinX), g.bounds.MaxX) }
+	clampY := func(v float64) float64 { return math.Min(math.Max(v, g.bounds.MinY), g.bounds.MaxY) }
+	lo := g.keyFor(clampX(x-radius), clampY(y-radius))
+	hi := g.keyFor(clampX(x+radius), clampY(y+radius))
+	lo.x, lo.y = max(lo.x, g.min.x), max(lo.y, g.min.y)
+	hi.x, hi.y = min(hi.x, g.max.x), min(hi.y, g.max.y)
+
+	for cx := lo.x; cx <= hi.x; cx++ {
+		for cy := lo.y; cy <= hi.y; cy++ {
+			for _, i := range g.cells[gridKey{cx, cy}] {
+				loc := g.locations[i]
+				if d := math.Hypot(loc.XY.X-x, loc.XY.Y-y); d <= radius {
+					fn(i, d)
+				}
+			}
+		}
+	}
+}
+
+// searchStart returns the cell the ring searches start from: that of (x, y)
+// moved into the locations' bounding box, so that a point far off the map
+// costs no more than one at its edge. offset is how far the point was moved;
+// since the box is convex, a location r cells from the start is at least
+// hypot(r·cellSize, offset) from (x, y). maxRing is the last ring holding
+// cells of the grid.
+func (g *gridIndex) searchStart(x, y float64) (center gridKey, offset float64, maxRing int) {
+	cx := math.Min(math.Max(x, g.bounds.MinX), g.bounds.MaxX)
+	cy := math.Min(math.Max(y, g.bounds.MinY), g.bounds.MaxY)
+	center = g.keyFor(cx, cy)
+	// Rounding may put a point on the box's edge in the next cell out
+	center.x = min(max(center.x, g.min.x), g.max.x)
+	center.y = min(max(center.y, g.min.y), g.max.y)
+	maxRing = max(center.x-g.min.x, g.max.x-center.x, center.y-g.min.y, g.max.y-center.y)
+	return center, math.Hypot(x-cx, y-cy), maxRing
+}
+
+// ringBound is the least distance from a point offset from the search start
+// of the locations in the rings after ring.
+func (g *gridIndex) ringBound(ring int, offset float64) float64 {
+	return math.Hypot(float64(ring)*g.cellSize, offset)
+}
+
+// nearest returns the index of and distance to the location closest to
+// (x, y), ignoring index skip (pass -1 to consider every location). It
+// returns -1 when there is no candidate.
+func (g *gridIndex) nearest(x, y float64, skip int) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+	if len(g.locations) == 0 {
+		return best, bestDist
+	}
+	center, offset, maxRing := g.searchStart(x, y)
+
+	for ring := 0; ring <= maxRing; ring++ {
+		g.visitRing(center, ring, func(i int) {
+			if i == skip {
+				return
+			}
+			loc := g.locations[i]
+			if d := math.Hypot(loc.XY.X-x, loc.XY.Y-y); d < bestDist {
+				best, bestDist = i, d
+			}
+		})
+
+		// Every location in ring+1 or further is at least ring cells away
+		if best >= 0 && bestDist <= g.ringBound(ring, offset) {
+			break
+		}
+	}
+
+	return best, bestDist
+}
+
+// neighbor is a location found by nearestK, its distance and the score it
+// was ranked by.
+type neighbor struct {
+	i     int
+	dist  float64
+	score float64
+}
+
+// nearestK returns the k locations closest to (x, y), nearest first.
+func (g *gridIndex) nearestK(x, y float64, k int) []neighbor {
+	return g.bestK(x, y, k, math.Inf(1), 1, func(_ int, d float64) float64 { return d })
+}
+
+// weightedNearestK returns the k locations within radius of (x, y) with the
+// lowest distance divided by their weight, lowest first. An infinite radius
+// considers every location.
+func (g *gridIndex) weightedNearestK(x, y float64, k int, radius float64) []neighbor {
+	return g.bestK(x, y, k, radius, g.maxWeight, func(i int, d float64) float64 { return d / g.locations[i].weight() })
+}
+
+// bestK returns the k locations within radius of (x, y) with the lowest
+// score, lowest first, where score maps a location's index and distance to
+// a score no lower than the distance divided by div. Like nearest, it visits
+// rings of cells outwards and stops once no further ring can hold a lower
+// score than the kth found, or a location within radius.
+func (g *gridIndex) bestK(x, y float64, k int, radius, div float64, score func(i int, dist float64) float64) []neighbor {
+	if k <= 0 || len(g.locations) == 0 {
+		return nil
+	}
+	center, offset, maxRing := g.searchStart(x, y)
+
+	best := make([]neighbor, 0, min(k, len(g.locations)))
+	for ring := 0; ring <= maxRing; ring++ {
+		g.visitRing(center, ring, func(i int) {
+			loc := g.locations[i]
+			d := math.Hypot(loc.XY.X-x, loc.XY.Y-y)
+			if d > radius {
+				return
+			}
+			s := score(i, d)
+			if len(best) == k && s >= best[k-1].score {
+				return
+			}
+			// Insert in order, dropping the worst once k are kept
+			at := sort.Search(len(best), func(j int) bool { return best[j].score > s })
+			if len(best) < k {
+				best = append(best, neighbor{})
+			}
+			copy(best[at+1:], best[at:])
+			best[at] = neighbor{i, d, s}
+		})
+
+		bound := g.ringBound(ring, offset)
+		if bound > radius || len(best) == k && best[k-1].score <= bound/div {
+			break
+		}
+	}
+	return best
+}
+
+// visitRing calls fn for every location in the cells at Chebyshev distance
+// ring from center. Only the cells inside the grid are looked at, so a ring
+// costs at most the grid's width and height however large it is.
+func (g *gridIndex) visitRing(center gridKey, ring int, fn func(i int)) {
+	visit := func(cx, cy int) {
+		for _, i := range g.cells[gridKey{cx, cy}] {
+			fn(i)
+		}
+	}
+	row := func(cy int) {
+		if cy < g.min.y || cy > g.max.y {
+			return
+		}
+		for cx := max(center.x-ring, g.min.x); cx <= min(center.x+ring, g.max.x); cx++ {
+			visit(cx, cy)
+		}
+	}
+	column := func(cx int) {
+		if cx < g.min.x || cx > g.max.x {
+			return
+		}
+		for cy := max(center.y-ring+1, g.min.y); cy <= min(center.y+ring-1, g.max.y); cy++ {
+			visit(cx, cy)
+		}
+	}
+
+	if ring == 0 {
+		visit(center.x, center.y)
+		return
+	}
+	row(center.y - ring)
+	row(center.y + ring)
+	column(center.x - ring)
+	column(center.x + ring)
+}
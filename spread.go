@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// SpreadStats summarises how spread out the markers of a snapshot are.
+// The nearest-neighbour fields are omitted when there are fewer than two
+// markers, and the extent is omitted when there are none.
+type SpreadStats struct {
+	Count                 int      `json:"count"`
+	MeanNearestNeighbor   *float64 `json:"meanNearestNeighbor,omitempty"`
+	MedianNearestNeighbor *float64 `json:"medianNearestNeighbor,omitempty"`
+	Extent                *Bounds  `json:"extent,omitempty"`
+	ExtentDiagonal        *float64 `json:"extentDiagonal,omitempty"`
+}
+
+// spreadCache holds the stats of the snapshot generation they were computed
+// for.
+var spreadCache struct {
+	mu         sync.Mutex
+	generation uint64
+	stats      *SpreadStats
+}
+
+func spreadHandler(w http.ResponseWriter, r *http.Request) {
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	spreadCache.mu.Lock()
+	if spreadCache.stats == nil || spreadCache.generation != snap.generation {
+		spreadCache.stats = computeSpread(snap.grid())
+		spreadCache.generation = snap.generation
+	}
+	stats := spreadCache.stats
+	spreadCache.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, stats, "spread stats")
+}
+
+// computeSpread derives SpreadStats from the locations indexed by grid.
+func computeSpread(grid *gridIndex) *SpreadStats {
+	locations := grid.locations
+	stats := &SpreadStats{Count: len(locations)}
+
+	b, ok := locationBounds(locations)
+	if !ok {
+		return stats
+	}
+	diagonal := math.Hypot(b.MaxX-b.MinX, b.MaxY-b.MinY)
+	stats.Extent = &b
+	stats.ExtentDiagonal = &diagonal
+
+	if len(locations) < 2 {
+		return stats
+	}
+
+	distances := make([]float64, len(locations))
+	var sum float64
+	for i, loc := range locations {
+		_, d := grid.nearest(loc.XY.X, loc.XY.Y, i)
+		distances[i] = d
+		sum += d
+	}
+	sort.Float64s(distances)
+
+	mean := sum / float64(len(distances))
+	median := distances[len(distances)/2]
+	if len(distances)%2 == 0 {
+		median = (distances[len(distances)/2-1] + median) / 2
+	}
+	stats.MeanNearestNeighbor = &mean
+	stats.MedianNearestNeighbor = &median
+
+	return stats
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Shape of /api/map/stats.
+const (
+	// statsDensityCells is the number of columns and of rows of the
+	// density grid
+	statsDensityCells = 16
+	// statsRecentLocations is how many recently added locations are listed
+	statsRecentLocations = 10
+)
+
+// MapStats summarises a world's map for the community site's statistics
+// page. Locations without a region or biome are left out of those counts.
+// Bounds and Density are omitted when the map is empty.
+type MapStats struct {
+	Count         int              `json:"count"`
+	ByRegion      map[string]int   `json:"byRegion"`
+	ByBiome       map[string]int   `json:"byBiome"`
+	Bounds        *Bounds          `json:"bounds,omitempty"`
+	Density       *DensityGrid     `json:"density,omitempty"`
+	RecentlyAdded []RecentLocation `json:"recentlyAdded"`
+}
+
+// DensityGrid counts the locations in equal cells over the map's bounds.
+// Counts is indexed by row, then column; row 0 is at MinY and column 0 at
+// MinX. Locations on the bounds' maximum edge count in the last cell.
+type DensityGrid struct {
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+	CellWidth  float64 `json:"cellWidth"`
+	CellHeight float64 `json:"cellHeight"`
+	Counts     [][]int `json:"counts"`
+}
+
+// RecentLocation is a location as it is now, with when it was added.
+type RecentLocation struct {
+	MapLocation
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// newDensityGrid returns an empty grid of cells by cells over b.
+func newDensityGrid(b Bounds, cells int) *DensityGrid {
+	g := &DensityGrid{
+		Columns:    cells,
+		Rows:       cells,
+		CellWidth:  (b.MaxX - b.MinX) / float64(cells),
+		CellHeight: (b.MaxY - b.MinY) / float64(cells),
+		Counts:     make([][]int, cells),
+	}
+	for i := range g.Counts {
+		g.Counts[i] = make([]int, cells)
+	}
+	return g
+}
+
+// densityCell returns which of cells cells of the given size, along an axis
+// starting at min, the coordinate v falls in.
+func densityCell(v, min, size float64, cells int) int {
+	if size == 0 {
+		return 0
+	}
+	return int(math.Min(float64(cells-1), math.Floor((v-min)/size)))
+}
+
+// statsCache holds the stats of the snapshot generation they were computed
+// for.
+var statsCache struct {
+	mu         sync.Mutex
+	generation uint64
+	stats      *MapStats
+}
+
+// statsHandler serves /api/map/stats. The stats are aggregated by the
+// storage backend when it implements StatsStorage and from the snapshot
+// otherwise, once per snapshot generation.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	statsCache.mu.Lock()
+	if statsCache.stats == nil || statsCache.generation != snap.generation {
+		stats, err := computeStats(r.Context(), snap)
+		if err != nil {
+			statsCache.mu.Unlock()
+			writeLoadError(w, r, err)
+			return
+		}
+		statsCache.stats, statsCache.generation = stats, snap.generation
+	}
+	stats := statsCache.stats
+	statsCache.mu.Unlock()
+	variant := "stats"
+	if redacts(r.Context()) {
+		stats, variant = redactStats(stats), "stats|redacted"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setSnapshotCaching(w.Header())
+	varyRedaction(w.Header())
+	if notModified(w, r, snapshotETag(snap.hash, variant), snap.loadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, stats, "map stats")
+}
+
+// computeStats builds the stats of the request's world.
+func computeStats(ctx context.Context, snap *cacheSnapshot) (*MapStats, error) {
+	var stats MapStats
+	if agg, ok := storeFor(ctx).(StatsStorage); ok {
+		release, err := acquireMongo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		qctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+		defer cancel()
+		if stats, err = agg.LocationStats(qctx, statsDensityCells); err != nil {
+			return nil, err
+		}
+	} else {
+		stats = aggregateStats(snap.locations, statsDensityCells)
+	}
+
+	recent, err := recentlyAdded(ctx, snap, statsRecentLocations)
+	if err != nil {
+		return nil, err
+	}
+	stats.RecentlyAdded = recent
+	return &stats, nil
+}
+
+// aggregateStats derives the stats other than RecentlyAdded from locations.
+func aggregateStats(locations []MapLocation, cells int) MapStats {
+	stats := MapStats{Count: len(locations), ByRegion: map[string]int{}, ByBiome: map[string]int{}}
+	for _, loc := range locations {
+		if loc.Region != "" {
+			stats.ByRegion[loc.Region]++
+		}
+		if loc.Biome != "" {
+			stats.ByBiome[loc.Biome]++
+		}
+	}
+
+	b, ok := locationBounds(locations)
+	if !ok {
+		return stats
+	}
+	stats.Bounds = &b
+	grid := newDensityGrid(b, cells)
+	for _, loc := range locations {
+		col := densityCell(loc.XY.X, b.MinX, grid.CellWidth, cells)
+		row := densityCell(loc.XY.Y, b.MinY, grid.CellHeight, cells)
+		grid.Counts[row][col]++
+	}
+	stats.Density = grid
+	return stats
+}
+
+// recentlyAdded lists up to n of the snapshot's locations by when the audit
+// log has them created, newest first. Locations created before the audit log
+// was kept are never listed, and without an audit log the list is empty.
+func recentlyAdded(ctx context.Context, snap *cacheSnapshot, n int) ([]RecentLocation, error) {
+	recent := []RecentLocation{}
+	audit, ok := store.(AuditStorage)
+	if !ok {
+		return recent, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+	defer cancel()
+
+	// Some of the latest creations may have been deleted since, so look
+	// further back than n
+	entries, err := audit.ListAudit(ctx, AuditQuery{Collection: worldFor(ctx).collection(), Action: auditCreate, Limit: 5 * n})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if len(recent) == n {
+			break
+		}
+		if seen[e.DocumentID] {
+			continue
+		}
+		seen[e.DocumentID] = true
+		if loc, ok := snap.lookup(e.DocumentID); ok {
+			recent = append(recent, RecentLocation{MapLocation: loc, AddedAt: e.At})
+		}
+	}
+	return recent, nil
+}
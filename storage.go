@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"example/souforged/migrations"
+)
+
+// Errors returned by Storage implementations.
+var (
+	ErrNotFound      = errors.New("map location not found")
+	ErrAlreadyExists = errors.New("map location already exists")
+	// ErrRejected wraps writes the backend refused as invalid, such as
+	// documents failing a MongoDB $jsonSchema validator
+	ErrRejected = errors.New("document rejected by storage")
+	// ErrVersionConflict is returned by ReplaceLocation and DeleteLocation
+	// when the stored location has moved on from the version the write was
+	// based on
+	ErrVersionConflict = errors.New("map location was modified concurrently")
+)
+
+// Storage is the persistence layer behind the map API. Implementations must
+// be safe for concurrent use.
+type Storage interface {
+	// ListLocations returns every stored location.
+	ListLocations(ctx context.Context) ([]MapLocation, error)
+	// GetLocation returns the location with the given ID or ErrNotFound.
+	GetLocation(ctx context.Context, id string) (MapLocation, error)
+	// InsertLocation stores a new location or fails with ErrAlreadyExists.
+	// loc is stored as given, so the caller stamps it with stampLocation.
+	InsertLocation(ctx context.Context, loc MapLocation) error
+	// UpsertLocation creates or replaces the location with loc's ID and
+	// reports whether it was created. The backend gives it the version
+	// after the stored one.
+	UpsertLocation(ctx context.Context, loc MapLocation) (created bool, err error)
+	// ReplaceLocation replaces the location with loc's ID if it is still at
+	// version, where 0 stands for a location written before versioning,
+	// and returns ErrNotFound or ErrVersionConflict otherwise. loc is
+	// stored as given, so the caller stamps it with stampLocation.
+	ReplaceLocation(ctx context.Context, loc MapLocation, version int64) error
+	// DeleteLocation removes the location with the given ID if it is still
+	// at version, or at any version given anyVersion, and returns
+	// ErrNotFound or ErrVersionConflict otherwise. Backends implementing
+	// TrashStorage keep it in the trash instead, where reads no longer see
+	// it.
+	DeleteLocation(ctx context.Context, id string, version int64) error
+	// Watch streams changes until ctx is cancelled, then closes the channel.
+	Watch(ctx context.Context) (<-chan ChangeEvent, error)
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the backend's connections.
+	Close(ctx context.Context) error
+}
+
+// SpatialStorage is implemented by backends that can answer viewport and
+// radius queries themselves rather than through the cached snapshot.
+type SpatialStorage interface {
+	// LocationsInBox returns the locations inside b.
+	LocationsInBox(ctx context.Context, b Bounds) ([]MapLocation, error)
+	// LocationsNear returns up to limit locations within radius of (x, y),
+	// nearest first.
+	LocationsNear(ctx context.Context, x, y, radius float64, limit int) ([]MapLocation, error)
+}
+
+// LocationStreamer is implemented by backends that can hand out locations one
+// at a time without materialising the whole collection.
+type LocationStreamer interface {
+	// StreamLocations calls fn for each stored location, stopping at the
+	// first error.
+	StreamLocations(ctx context.Context, fn func(MapLocation) error) error
+}
+
+// BulkStorage is implemented by backends that can upsert many locations in
+// one round trip.
+type BulkStorage interface {
+	// UpsertLocations creates or replaces each location, giving it the
+	// version after the stored one as UpsertLocation does. created and errs
+	// are indexed like locs; a row whose write failed has a non-nil entry in
+	// errs while the other rows are still written. err reports a failure of
+	// the batch as a whole.
+	UpsertLocations(ctx context.Context, locs []MapLocation) (created []bool, errs []error, err error)
+}
+
+// TrashStorage is implemented by backends whose DeleteLocation soft-deletes
+// locations. Writing a location with the ID of a trashed one replaces it and
+// takes it out of the trash.
+type TrashStorage interface {
+	// ListTrash returns the deleted locations, most recently deleted first.
+	ListTrash(ctx context.Context) ([]TrashedLocation, error)
+	// RestoreLocation takes the location out of the trash and returns it, or
+	// returns ErrNotFound if it is not there.
+	RestoreLocation(ctx context.Context, id string) (MapLocation, error)
+	// PurgeLocation removes the location from the trash for good and returns
+	// it, or returns ErrNotFound if it is not there.
+	PurgeLocation(ctx context.Context, id string) (TrashedLocation, error)
+}
+
+// WorldStorage is implemented by backends that can keep the locations of
+// further game worlds apart from the default world's.
+type WorldStorage interface {
+	// ForWorld returns the storage of the named world's locations. It shares
+	// the backend's connections and must not be closed on its own.
+	ForWorld(name string) (Storage, error)
+}
+
+// TileStorage is implemented by backends that can hold the map tile images.
+type TileStorage interface {
+	// Tile returns the file stored under name, e.g. "3/4/5.png", and when
+	// it was stored, or ErrNotFound.
+	Tile(ctx context.Context, name string) ([]byte, time.Time, error)
+}
+
+// ImageStorage is implemented by backends that can keep an image per map
+// location, apart from the location documents.
+type ImageStorage interface {
+	// PutImage stores the location's image, replacing any previous one, and
+	// reports whether there was none.
+	PutImage(ctx context.Context, id string, img LocationImage) (created bool, err error)
+	// GetImage returns the location's image or ErrNotFound.
+	GetImage(ctx context.Context, id string) (LocationImage, error)
+	// DeleteImage removes the location's image or returns ErrNotFound.
+	DeleteImage(ctx context.Context, id string) error
+}
+
+// MigrationStorage is implemented by backends with a recorded schema version.
+type MigrationStorage interface {
+	// Migrate applies the pending schema migrations, returning their
+	// versions.
+	Migrate(ctx context.Context) ([]int, error)
+	// Migrations lists the migrations applied or in progress.
+	Migrations(ctx context.Context) ([]migrations.Record, error)
+}
+
+// StatsStorage is implemented by backends that can aggregate the map
+// statistics themselves, without handing out every location.
+type StatsStorage interface {
+	// LocationStats returns the counts, bounds and density grid of the
+	// stored locations, the grid being cells by cells over the bounds.
+	// RecentlyAdded is left for the caller.
+	LocationStats(ctx context.Context, cells int) (MapStats, error)
+}
+
+// stampLocation marks loc as the write following version, at the current
+// time.
+func stampLocation(loc *MapLocation, version int64) {
+	now := time.Now().UTC()
+	loc.Version, loc.UpdatedAt = version+1, &now
+}
+
+// store is the backend selected at startup by initStorage.
+var store Storage
+
+// Storage backends config.Storage.Backend may name.
+const (
+	storageMongo  = "mongo"
+	storageMemory = "memory"
+)
+
+// initStorage selects the backend named by config.Storage.Backend. The memory
+// backend keeps everything in process and, when config.Storage.File is set,
+// loads from and saves to that JSON file, so the
+// server can run without a MongoDB instance. In fixtures mode the memory
+// backend is used regardless, without a file.
+func initStorage() error {
+	if fixturesMode() {
+		s, err := newMemoryStorage("")
+		if err != nil {
+			return err
+		}
+		store = s
+		slog.Info("serving fixtures from memory", "fixtures", config.Fixtures)
+		return nil
+	}
+
+	switch backend := config.Storage.Backend; backend {
+	case storageMongo:
+		s, err := newMongoStorage()
+		if err != nil {
+			return err
+		}
+		store = s
+	case storageMemory:
+		s, err := newMemoryStorage(config.Storage.File)
+		if err != nil {
+			return err
+		}
+		store = s
+		slog.Info("using in-memory storage", "file", config.Storage.File)
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+	return nil
+}
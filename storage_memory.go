@@ -0,0 +1,665 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStorage keeps map locations in process. When path is set the data is
+// loaded from that JSON file (an array of locations) at startup and written
+// back after every change, which makes it handy for local development and
+// tests without a MongoDB instance.
+type memoryStorage struct {
+	mu        sync.RWMutex
+	locations map[string]MapLocation
+	// trash holds the deleted locations; they are saved to path alongside
+	// the others, marked by their deletedAt
+	trash   map[string]TrashedLocation
+	path    string
+	changes *broadcaster[ChangeEvent]
+	// users, versions and the audit log are kept in process only and start
+	// out empty on every run; versions and audit entries are ordered oldest
+	// first
+	users    map[string]User
+	versions []SnapshotVersion
+	audit    []AuditEntry
+	// prices are ordered as inserted, dropping the oldest beyond
+	// maxMemoryPrices
+	prices []PricePoint
+	// images are never written to path either
+	images map[string]LocationImage
+}
+
+// newMemoryStorage returns an empty store, or one seeded from path if the
+// file exists.
+func newMemoryStorage(path string) (*memoryStorage, error) {
+	s := &memoryStorage{
+		locations: make(map[string]MapLocation),
+		trash:     make(map[string]TrashedLocation),
+		path:      path,
+		changes:   newBroadcaster[ChangeEvent](),
+		users:     make(map[string]User),
+		images:    make(map[string]LocationImage),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []memoryDocument
+	if err := json.Unmarshal(raw, &locations); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, doc := range locations {
+		if doc.DeletedAt != nil {
+			s.trash[doc.ID] = TrashedLocation{MapLocation: doc.location(), DeletedAt: *doc.DeletedAt}
+		} else {
+			s.locations[doc.ID] = doc.location()
+		}
+	}
+	return s, nil
+}
+
+// ForWorld returns a separate store for the world, kept in a file next to
+// path named after it, e.g. map.eu.json for map.json.
+func (s *memoryStorage) ForWorld(name string) (Storage, error) {
+	path := s.path
+	if path != "" {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "." + name + ext
+	}
+	return newMemoryStorage(path)
+}
+
+// sortedLocked returns all locations ordered by ID. The caller must hold mu.
+func (s *memoryStorage) sortedLocked() []MapLocation {
+	locations := make([]MapLocation, 0, len(s.locations))
+	for _, loc := range s.locations {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].ID < locations[j].ID })
+	return locations
+}
+
+// memoryDocument is a location as saved to path, its coordinates at full
+// precision.
+type memoryDocument struct {
+	MapLocation
+	XY        exactCoordinates `json:"xy"`
+	DeletedAt *time.Time       `json:"deletedAt,omitempty"`
+}
+
+func newMemoryDocument(loc MapLocation, deletedAt *time.Time) memoryDocument {
+	return memoryDocument{MapLocation: loc, XY: exactCoordinates(loc.XY), DeletedAt: deletedAt}
+}
+
+// location is the location doc was saved from.
+func (doc memoryDocument) location() MapLocation {
+	loc := doc.MapLocation
+	loc.XY = Coordinates(doc.XY)
+	return loc
+}
+
+// saveLocked writes the current data to path, replacing the file atomically.
+// The caller must hold mu.
+func (s *memoryStorage) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	docs := make([]memoryDocument, 0, len(s.locations)+len(s.trash))
+	for _, loc := range s.sortedLocked() {
+		docs = append(docs, newMemoryDocument(loc, nil))
+	}
+	for _, t := range s.trashLocked() {
+		docs = append(docs, newMemoryDocument(t.MapLocation, &t.DeletedAt))
+	}
+	raw, err := json.MarshalIndent(docs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *memoryStorage) ListLocations(ctx context.Context) ([]MapLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedLocked(), nil
+}
+
+func (s *memoryStorage) GetLocation(ctx context.Context, id string) (MapLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	loc, ok := s.locations[id]
+	if !ok {
+		return MapLocation{}, ErrNotFound
+	}
+	return loc, nil
+}
+
+func (s *memoryStorage) InsertLocation(ctx context.Context, loc MapLocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.locations[loc.ID]; ok {
+		return ErrAlreadyExists
+	}
+	trashed, wasTrashed := s.trash[loc.ID]
+	s.locations[loc.ID] = loc
+	delete(s.trash, loc.ID)
+	if err := s.saveLocked(); err != nil {
+		delete(s.locations, loc.ID)
+		if wasTrashed {
+			s.trash[loc.ID] = trashed
+		}
+		return err
+	}
+
+	s.changes.publish(ChangeEvent{Type: "insert", ID: loc.ID, Location: &loc})
+	return nil
+}
+
+func (s *memoryStorage) UpsertLocation(ctx context.Context, loc MapLocation) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.locations[loc.ID]
+	trashed, wasTrashed := s.trash[loc.ID]
+	stampLocation(&loc, max(prev.Version, trashed.Version))
+	s.locations[loc.ID] = loc
+	delete(s.trash, loc.ID)
+	if err := s.saveLocked(); err != nil {
+		if existed {
+			s.locations[loc.ID] = prev
+		} else {
+			delete(s.locations, loc.ID)
+		}
+		if wasTrashed {
+			s.trash[loc.ID] = trashed
+		}
+		return false, err
+	}
+
+	ev := ChangeEvent{Type: "replace", ID: loc.ID, Location: &loc}
+	if !existed {
+		ev.Type = "insert"
+	}
+	s.changes.publish(ev)
+	return !existed, nil
+}
+
+// UpsertLocations writes every location and saves the file once.
+func (s *memoryStorage) UpsertLocations(ctx context.Context, locs []MapLocation) ([]bool, []error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := make([]bool, len(locs))
+	stored := make([]MapLocation, len(locs))
+	prev := make(map[string]*MapLocation, len(locs))
+	trashed := make(map[string]TrashedLocation)
+	for i, loc := range locs {
+		if _, seen := prev[loc.ID]; !seen {
+			if old, ok := s.locations[loc.ID]; ok {
+				prev[loc.ID] = &old
+			} else {
+				prev[loc.ID] = nil
+			}
+			if t, ok := s.trash[loc.ID]; ok {
+				trashed[loc.ID] = t
+				delete(s.trash, loc.ID)
+			}
+		}
+		old, existed := s.locations[loc.ID]
+		created[i] = !existed
+		stampLocation(&loc, max(old.Version, trashed[loc.ID].Version))
+		stored[i] = loc
+		s.locations[loc.ID] = loc
+	}
+	if err := s.saveLocked(); err != nil {
+		for id, old := range prev {
+			if old != nil {
+				s.locations[id] = *old
+			} else {
+				delete(s.locations, id)
+			}
+		}
+		for id, t := range trashed {
+			s.trash[id] = t
+		}
+		return nil, nil, err
+	}
+
+	for i := range stored {
+		ev := ChangeEvent{Type: "replace", ID: stored[i].ID, Location: &stored[i]}
+		if created[i] {
+			ev.Type = "insert"
+		}
+		s.changes.publish(ev)
+	}
+	return created, make([]error, len(locs)), nil
+}
+
+func (s *memoryStorage) ReplaceLocation(ctx context.Context, loc MapLocation, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.locations[loc.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if prev.Version != version {
+		return ErrVersionConflict
+	}
+	s.locations[loc.ID] = loc
+	if err := s.saveLocked(); err != nil {
+		s.locations[loc.ID] = prev
+		return err
+	}
+
+	s.changes.publish(ChangeEvent{Type: "replace", ID: loc.ID, Location: &loc})
+	return nil
+}
+
+func (s *memoryStorage) DeleteLocation(ctx context.Context, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.locations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if version != anyVersion && prev.Version != version {
+		return ErrVersionConflict
+	}
+	delete(s.locations, id)
+	s.trash[id] = TrashedLocation{MapLocation: prev, DeletedAt: time.Now().UTC()}
+	if err := s.saveLocked(); err != nil {
+		s.locations[id] = prev
+		delete(s.trash, id)
+		return err
+	}
+
+	s.changes.publish(ChangeEvent{Type: "delete", ID: id})
+	return nil
+}
+
+// trashLocked returns the trashed locations, most recently deleted first.
+// The caller must hold mu.
+func (s *memoryStorage) trashLocked() []TrashedLocation {
+	trash := make([]TrashedLocation, 0, len(s.trash))
+	for _, t := range s.trash {
+		trash = append(trash, t)
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		if !trash[i].DeletedAt.Equal(trash[j].DeletedAt) {
+			return trash[i].DeletedAt.After(trash[j].DeletedAt)
+		}
+		return trash[i].ID < trash[j].ID
+	})
+	return trash
+}
+
+func (s *memoryStorage) ListTrash(ctx context.Context) ([]TrashedLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.trashLocked(), nil
+}
+
+func (s *memoryStorage) RestoreLocation(ctx context.Context, id string) (MapLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.trash[id]
+	if !ok {
+		return MapLocation{}, ErrNotFound
+	}
+	delete(s.trash, id)
+	s.locations[id] = t.MapLocation
+	if err := s.saveLocked(); err != nil {
+		delete(s.locations, id)
+		s.trash[id] = t
+		return MapLocation{}, err
+	}
+
+	loc := t.MapLocation
+	s.changes.publish(ChangeEvent{Type: "insert", ID: id, Location: &loc})
+	return loc, nil
+}
+
+func (s *memoryStorage) PurgeLocation(ctx context.Context, id string) (TrashedLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.trash[id]
+	if !ok {
+		return TrashedLocation{}, ErrNotFound
+	}
+	delete(s.trash, id)
+	if err := s.saveLocked(); err != nil {
+		s.trash[id] = t
+		return TrashedLocation{}, err
+	}
+	return t, nil
+}
+
+// Watch delivers the changes made through this store.
+func (s *memoryStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	changes, unsubscribe := s.changes.subscribe()
+	events := make(chan ChangeEvent)
+
+	go func() {
+		defer close(events)
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-changes:
+				if !ok {
+					return
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Ping always succeeds; the data is in process.
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close ends any open watches. Data is already saved after every change.
+func (s *memoryStorage) Close(ctx context.Context) error {
+	s.changes.closeAll()
+	return nil
+}
+
+// LocationsInBox scans every location; the memory backend is meant for small
+// data sets.
+func (s *memoryStorage) LocationsInBox(ctx context.Context, b Bounds) ([]MapLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := []MapLocation{}
+	for _, loc := range s.sortedLocked() {
+		if loc.XY.X >= b.MinX && loc.XY.X <= b.MaxX && loc.XY.Y >= b.MinY && loc.XY.Y <= b.MaxY {
+			found = append(found, loc)
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStorage) LocationsNear(ctx context.Context, x, y, radius float64, limit int) ([]MapLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := []MapLocation{}
+	for _, loc := range s.sortedLocked() {
+		if math.Hypot(loc.XY.X-x, loc.XY.Y-y) <= radius {
+			found = append(found, loc)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return math.Hypot(found[i].XY.X-x, found[i].XY.Y-y) < math.Hypot(found[j].XY.X-x, found[j].XY.Y-y)
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (s *memoryStorage) InsertUser(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *memoryStorage) GetUser(ctx context.Context, id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryStorage) SetUserRole(ctx context.Context, id, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	s.users[id] = user
+	return nil
+}
+
+func (s *memoryStorage) SaveVersion(ctx context.Context, v SnapshotVersion, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, have := range s.versions {
+		if have.ID == v.ID {
+			return nil
+		}
+	}
+	s.versions = append(s.versions, v)
+	if extra := len(s.versions) - keep; extra > 0 {
+		s.versions = append([]SnapshotVersion(nil), s.versions[extra:]...)
+	}
+	return nil
+}
+
+func (s *memoryStorage) ListVersions(ctx context.Context, limit int) ([]SnapshotVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := []SnapshotVersion{}
+	for i := len(s.versions) - 1; i >= 0 && len(versions) < limit; i-- {
+		v := s.versions[i]
+		v.Locations = nil
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+func (s *memoryStorage) GetVersion(ctx context.Context, id string) (SnapshotVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.versions {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return SnapshotVersion{}, ErrNotFound
+}
+
+// InsertAudit appends e, dropping the oldest entries beyond maxMemoryAudit.
+func (s *memoryStorage) InsertAudit(ctx context.Context, e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audit = append(s.audit, e)
+	if extra := len(s.audit) - maxMemoryAudit; extra > 0 {
+		s.audit = append([]AuditEntry(nil), s.audit[extra:]...)
+	}
+	return nil
+}
+
+func (s *memoryStorage) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < q.Limit; i-- {
+		if q.matches(s.audit[i]) {
+			entries = append(entries, s.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStorage) InsertPrice(ctx context.Context, p PricePoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prices = append(s.prices, p)
+	if extra := len(s.prices) - maxMemoryPrices; extra > 0 {
+		s.prices = append([]PricePoint(nil), s.prices[extra:]...)
+	}
+	return nil
+}
+
+func (s *memoryStorage) ListPrices(ctx context.Context, item string, since time.Time) ([]PricePoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prices := []PricePoint{}
+	for _, p := range s.prices {
+		if p.Item == item && !p.ObservedAt.Before(since) {
+			prices = append(prices, p)
+		}
+	}
+	sort.SliceStable(prices, func(i, j int) bool { return prices[i].ObservedAt.Before(prices[j].ObservedAt) })
+	return prices, nil
+}
+
+// memoryRecords keeps a dataset in process only; unlike the locations it is
+// not saved to STORAGE_FILE and starts out empty on every run.
+type memoryRecords[T Record] struct {
+	mu    sync.RWMutex
+	items map[string]T
+}
+
+func newMemoryRecords[T Record]() *memoryRecords[T] {
+	return &memoryRecords[T]{items: make(map[string]T)}
+}
+
+func (m *memoryRecords[T]) List(ctx context.Context) ([]T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := make([]T, 0, len(m.items))
+	for _, rec := range m.items {
+		items = append(items, rec)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].RecordID() < items[j].RecordID() })
+	return items, nil
+}
+
+func (m *memoryRecords[T]) Get(ctx context.Context, id string) (T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.items[id]
+	if !ok {
+		return rec, ErrNotFound
+	}
+	return rec, nil
+}
+
+func (m *memoryRecords[T]) Insert(ctx context.Context, rec T) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.items[rec.RecordID()]; ok {
+		return ErrAlreadyExists
+	}
+	m.items[rec.RecordID()] = rec
+	return nil
+}
+
+func (m *memoryRecords[T]) Upsert(ctx context.Context, rec T) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, existed := m.items[rec.RecordID()]
+	m.items[rec.RecordID()] = rec
+	return !existed, nil
+}
+
+func (m *memoryRecords[T]) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.items[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.items, id)
+	return nil
+}
+
+func (s *memoryStorage) PutImage(ctx context.Context, id string, img LocationImage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.images[id]
+	s.images[id] = img
+	return !exists, nil
+}
+
+func (s *memoryStorage) GetImage(ctx context.Context, id string) (LocationImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	img, ok := s.images[id]
+	if !ok {
+		return LocationImage{}, ErrNotFound
+	}
+	return img, nil
+}
+
+func (s *memoryStorage) DeleteImage(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.images[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.images, id)
+	return nil
+}
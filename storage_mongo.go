@@ -0,0 +1,977 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// geoIndexRange is the default half-width of the 2d index on xy. MongoDB
+// defaults 2d indexes to [-180, 180), which is far too small for game map
+// coordinates and would reject inserts outside it.
+const geoIndexRange = 1e6
+
+// mongoStorage keeps map locations in the maplocations collection.
+//
+// Writes and single-document reads go through coll on the primary. Bulk scans
+// (the cache refresh, exports and spatial queries) go through readColl, which
+// may use a secondary read preference or a separate connection (see
+// connectReadCollection). In that case those reads are eventually consistent:
+// a write can take a replication lag to show up in the cache, so
+// read-after-write is not guaranteed.
+type mongoStorage struct {
+	client     *mongo.Client
+	readClient *mongo.Client
+	coll       *mongo.Collection
+	readColl   *mongo.Collection
+	keys       *mongo.Collection
+	users      *mongo.Collection
+	versions   *mongo.Collection
+	audit      *mongo.Collection
+	prices     *mongo.Collection
+	conn       *connectionMonitor
+	// readOpts carries the read preference to the readColl of other datasets
+	readOpts *options.CollectionOptions
+}
+
+// newMongoStorage connects to the deployment at config.Mongo.URI.
+func newMongoStorage() (*mongoStorage, error) {
+	// Parse the connection string
+	uri := config.Mongo.URI
+	if uri == "" {
+		return nil, fmt.Errorf("MONGO_URI is not set")
+	}
+
+	conn := newConnectionMonitor()
+	clientOptions := options.Client().ApplyURI(uri).
+		SetMaxPoolSize(uint64(config.Mongo.PoolSize)).
+		SetAppName(config.Mongo.AppName + "/" + version).
+		SetPoolMonitor(mongoPoolMonitor("primary")).
+		SetServerMonitor(conn.serverMonitor()).
+		SetMonitor(otelmongo.NewMonitor())
+
+	// Connect to MongoDB
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &mongoStorage{
+		client:   client,
+		coll:     client.Database(config.Mongo.Database).Collection(config.Mongo.Collection),
+		keys:     client.Database(config.Mongo.Database).Collection("apikeys"),
+		users:    client.Database(config.Mongo.Database).Collection("users"),
+		versions: client.Database(config.Mongo.Database).Collection("mapversions"),
+		audit:    client.Database(config.Mongo.Database).Collection("audit"),
+		prices:   client.Database(config.Mongo.Database).Collection("prices"),
+		conn:     conn,
+	}
+
+	// Check the connection, giving a database that is still starting time
+	if err := pingWithRetry(client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	slog.Info("connected to MongoDB")
+
+	if err := s.ensureIndexes(context.Background(), true); err != nil {
+		return nil, err
+	}
+	if config.Mongo.Migrate {
+		if _, err := s.Migrate(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.connectReadCollection(clientOptions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connectReadCollection sets up readColl as config.Mongo.ReadURI and
+// ReadPreference say.
+func (s *mongoStorage) connectReadCollection(writeOptions *options.ClientOptions) error {
+	s.readClient = s.client
+
+	if uri := config.Mongo.ReadURI; uri != "" {
+		readOptions := options.Client().ApplyURI(uri).
+			SetMaxPoolSize(*writeOptions.MaxPoolSize).
+			SetAppName(*writeOptions.AppName).
+			SetPoolMonitor(mongoPoolMonitor("read")).
+			SetMonitor(otelmongo.NewMonitor())
+
+		var err error
+		s.readClient, err = mongo.Connect(context.Background(), readOptions)
+		if err != nil {
+			return fmt.Errorf("failed to connect to MONGO_READ_URI: %w", err)
+		}
+	}
+
+	collOptions := options.Collection()
+	if v := config.Mongo.ReadPreference; v != "" {
+		mode, err := readpref.ModeFromString(v)
+		if err != nil {
+			return fmt.Errorf("invalid MONGO_READ_PREFERENCE: %w", err)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return fmt.Errorf("invalid MONGO_READ_PREFERENCE: %w", err)
+		}
+		collOptions.SetReadPreference(rp)
+	}
+
+	s.readColl = s.readClient.Database(config.Mongo.Database).Collection(config.Mongo.Collection, collOptions)
+	s.readOpts = collOptions
+
+	if s.readClient != s.client {
+		if err := s.readClient.Ping(context.Background(), collOptions.ReadPreference); err != nil {
+			return fmt.Errorf("failed to reach MONGO_READ_URI: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// mongoWorldStorage is the storage of a world other than the default one: a
+// mongoStorage whose location collections point at the world's collection.
+type mongoWorldStorage struct {
+	*mongoStorage
+}
+
+// ForWorld returns the storage of the world's collection, creating its
+// indexes like newMongoStorage does for the default collection.
+func (s *mongoStorage) ForWorld(name string) (Storage, error) {
+	w := *s
+	db := config.Mongo.Database
+	w.coll = s.client.Database(db).Collection(worldCollection(name))
+	w.readColl = s.readClient.Database(db).Collection(worldCollection(name), s.readOpts)
+
+	if err := w.ensureIndexes(context.Background(), false); err != nil {
+		return nil, err
+	}
+	return mongoWorldStorage{&w}, nil
+}
+
+// Close leaves the shared connections to the default world's storage.
+func (mongoWorldStorage) Close(ctx context.Context) error { return nil }
+
+// Ping checks the primary connection.
+func (s *mongoStorage) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+// ConnectionStatus reports the connection to the deployment.
+func (s *mongoStorage) ConnectionStatus() ConnectionStatus {
+	return s.conn.current()
+}
+
+// Close disconnects the read and write clients.
+func (s *mongoStorage) Close(ctx context.Context) error {
+	var errs []error
+	if s.readClient != nil && s.readClient != s.client {
+		errs = append(errs, s.readClient.Disconnect(ctx))
+	}
+	errs = append(errs, s.client.Disconnect(ctx))
+	return errors.Join(errs...)
+}
+
+// notTrashed matches the locations that have not been soft-deleted.
+var notTrashed = bson.E{Key: "deletedAt", Value: bson.D{{Key: "$exists", Value: false}}}
+
+// trashed matches the soft-deleted locations.
+var trashed = bson.E{Key: "deletedAt", Value: bson.D{{Key: "$exists", Value: true}}}
+
+func (s *mongoStorage) ListLocations(ctx context.Context) ([]MapLocation, error) {
+	return s.find(ctx, bson.D{}, nil)
+}
+
+// find runs a query against readColl, leaving out the trashed locations. It
+// always returns a freshly allocated slice.
+func (s *mongoStorage) find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]MapLocation, error) {
+	cursor, err := s.readColl.Find(ctx, append(filter, notTrashed), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch map data from MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	locations := []MapLocation{}
+	if err := cursor.All(ctx, &locations); err != nil {
+		return nil, fmt.Errorf("failed to decode map data: %w", err)
+	}
+	return locations, nil
+}
+
+func (s *mongoStorage) GetLocation(ctx context.Context, id string) (MapLocation, error) {
+	var loc MapLocation
+	err := s.coll.FindOne(ctx, bson.D{{Key: "_id", Value: id}, notTrashed}).Decode(&loc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return MapLocation{}, ErrNotFound
+	}
+	return loc, err
+}
+
+// documentValidationFailure is the server error code of a write rejected by
+// a collection validator.
+const documentValidationFailure = 121
+
+// writeError maps the write errors that handlers answer specially.
+func writeError(err error) error {
+	var serverErr mongo.ServerError
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		return ErrAlreadyExists
+	case errors.As(err, &serverErr) && serverErr.HasErrorCode(documentValidationFailure):
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+// InsertLocation inserts loc, or replaces a trashed location with its ID.
+func (s *mongoStorage) InsertLocation(ctx context.Context, loc MapLocation) error {
+	_, err := s.coll.InsertOne(ctx, loc)
+	if !mongo.IsDuplicateKeyError(err) {
+		return writeError(err)
+	}
+	res, err := s.coll.ReplaceOne(ctx, bson.D{{Key: "_id", Value: loc.ID}, trashed}, loc)
+	if err != nil {
+		return writeError(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// upsertPipeline is the update replacing a location with loc at the
+// version after the stored one. Update pipelines need MongoDB 4.2.
+func upsertPipeline(loc MapLocation) mongo.Pipeline {
+	now := time.Now().UTC()
+	loc.Version, loc.UpdatedAt = 0, &now
+	next := bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$version", int64(0)}}}, int64(1)}}}
+	return mongo.Pipeline{{{Key: "$replaceWith", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+		// $literal keeps values starting with $ from being read as field paths
+		bson.D{{Key: "$literal", Value: loc}},
+		bson.D{{Key: "version", Value: next}},
+	}}}}}}
+}
+
+// UpsertLocation counts replacing a trashed location as creating it.
+func (s *mongoStorage) UpsertLocation(ctx context.Context, loc MapLocation) (bool, error) {
+	var prev TrashedLocation
+	err := s.coll.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: loc.ID}}, upsertPipeline(loc),
+		options.FindOneAndUpdate().SetUpsert(true).SetProjection(bson.D{{Key: "deletedAt", Value: 1}})).Decode(&prev)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return true, nil
+	}
+	if err != nil {
+		return false, writeError(err)
+	}
+	return !prev.DeletedAt.IsZero(), nil
+}
+
+// UpsertLocations sends one unordered bulk write of upserts, so a failing
+// row does not stop the ones after it.
+func (s *mongoStorage) UpsertLocations(ctx context.Context, locs []MapLocation) ([]bool, []error, error) {
+	created := make([]bool, len(locs))
+	errs := make([]error, len(locs))
+	if len(locs) == 0 {
+		return created, errs, nil
+	}
+
+	// Replacing a trashed location counts as creating it
+	ids := make(bson.A, len(locs))
+	for i, loc := range locs {
+		ids[i] = loc.ID
+	}
+	wasTrashed, err := s.coll.Distinct(ctx, "_id", bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, trashed})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, loc := range locs {
+		created[i] = slices.Contains(wasTrashed, any(loc.ID))
+	}
+
+	models := make([]mongo.WriteModel, len(locs))
+	for i, loc := range locs {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: loc.ID}}).
+			SetUpdate(upsertPipeline(loc)).
+			SetUpsert(true)
+	}
+	res, err := s.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, we := range bulkErr.WriteErrors {
+			errs[we.Index] = writeError(mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}})
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if res != nil {
+		for i := range res.UpsertedIDs {
+			created[i] = true
+		}
+	}
+	return created, errs, nil
+}
+
+func (s *mongoStorage) ReplaceLocation(ctx context.Context, loc MapLocation, version int64) error {
+	filter := bson.D{{Key: "_id", Value: loc.ID}, notTrashed, {Key: "version", Value: version}}
+	if version == 0 {
+		filter[2].Value = bson.D{{Key: "$exists", Value: false}}
+	}
+	res, err := s.coll.ReplaceOne(ctx, filter, loc)
+	if err != nil {
+		return writeError(err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	return s.unmatched(ctx, loc.ID)
+}
+
+// unmatched explains why a write filtering on a version matched nothing:
+// ErrNotFound if the location is gone, ErrVersionConflict if it has moved
+// on to another version.
+func (s *mongoStorage) unmatched(ctx context.Context, id string) error {
+	n, err := s.coll.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, notTrashed})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+// DeleteLocation moves the location to the trash by setting its deletedAt.
+func (s *mongoStorage) DeleteLocation(ctx context.Context, id string, version int64) error {
+	filter := bson.D{{Key: "_id", Value: id}, notTrashed}
+	switch {
+	case version == 0:
+		filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$exists", Value: false}}})
+	case version > 0:
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	res, err := s.coll.UpdateOne(ctx, filter,
+		bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: time.Now().UTC()}}}})
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.MatchedCount > 0:
+		return nil
+	case version == anyVersion:
+		return ErrNotFound
+	}
+	return s.unmatched(ctx, id)
+}
+
+func (s *mongoStorage) ListTrash(ctx context.Context) ([]TrashedLocation, error) {
+	cursor, err := s.coll.Find(ctx, bson.D{trashed}, options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the trash from MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	trash := []TrashedLocation{}
+	if err := cursor.All(ctx, &trash); err != nil {
+		return nil, fmt.Errorf("failed to decode the trash: %w", err)
+	}
+	return trash, nil
+}
+
+func (s *mongoStorage) RestoreLocation(ctx context.Context, id string) (MapLocation, error) {
+	var loc MapLocation
+	err := s.coll.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}, trashed},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&loc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return MapLocation{}, ErrNotFound
+	}
+	return loc, err
+}
+
+func (s *mongoStorage) PurgeLocation(ctx context.Context, id string) (TrashedLocation, error) {
+	var t TrashedLocation
+	err := s.coll.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}, trashed}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return TrashedLocation{}, ErrNotFound
+	}
+	return t, err
+}
+
+// LocationsInBox queries the 2d index for the locations inside b.
+func (s *mongoStorage) LocationsInBox(ctx context.Context, b Bounds) ([]MapLocation, error) {
+	filter := bson.D{{Key: "xy", Value: bson.D{{Key: "$geoWithin", Value: bson.D{
+		{Key: "$box", Value: bson.A{bson.A{b.MinX, b.MinY}, bson.A{b.MaxX, b.MaxY}}},
+	}}}}}
+	return s.find(ctx, filter, nil)
+}
+
+// LocationsNear runs a $near query on the 2d index.
+func (s *mongoStorage) LocationsNear(ctx context.Context, x, y, radius float64, limit int) ([]MapLocation, error) {
+	filter := bson.D{{Key: "xy", Value: bson.D{
+		{Key: "$near", Value: bson.A{x, y}},
+		{Key: "$maxDistance", Value: radius},
+	}}}
+	return s.find(ctx, filter, options.Find().SetLimit(int64(limit)))
+}
+
+// mongoIndexNotFound is the server error code for a query that needs an index
+// which does not exist, such as $text without a text index.
+const mongoIndexNotFound = 27
+
+// SearchLocations runs a $text query, best textScore first.
+func (s *mongoStorage) SearchLocations(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	score := bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	opts := options.Find().SetProjection(score).SetSort(score).SetLimit(int64(limit))
+
+	cursor, err := s.readColl.Find(ctx, bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: query}}}, notTrashed}, opts)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoIndexNotFound) {
+		return nil, errTextSearchUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search map data in MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := []SearchResult{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode search results: %w", err)
+	}
+	return results, nil
+}
+
+// LocationStats aggregates the stats on readColl in two passes: one for the
+// count and bounds, which the density grid's cells depend on, then a $facet
+// for the region, biome and cell counts.
+func (s *mongoStorage) LocationStats(ctx context.Context, cells int) (MapStats, error) {
+	stats := MapStats{ByRegion: map[string]int{}, ByBiome: map[string]int{}}
+	match := bson.D{{Key: "$match", Value: bson.D{notTrashed}}}
+
+	cursor, err := s.readColl.Aggregate(ctx, bson.A{match, bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: nil},
+		{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		{Key: "minX", Value: bson.D{{Key: "$min", Value: "$xy.x"}}},
+		{Key: "minY", Value: bson.D{{Key: "$min", Value: "$xy.y"}}},
+		{Key: "maxX", Value: bson.D{{Key: "$max", Value: "$xy.x"}}},
+		{Key: "maxY", Value: bson.D{{Key: "$max", Value: "$xy.y"}}},
+	}}}})
+	if err != nil {
+		return stats, fmt.Errorf("failed to aggregate map stats in MongoDB: %w", err)
+	}
+	var totals []struct {
+		Count int     `bson:"count"`
+		MinX  float64 `bson:"minX"`
+		MinY  float64 `bson:"minY"`
+		MaxX  float64 `bson:"maxX"`
+		MaxY  float64 `bson:"maxY"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return stats, fmt.Errorf("failed to decode map stats: %w", err)
+	}
+	if len(totals) == 0 {
+		return stats, nil
+	}
+	t := totals[0]
+	b := Bounds{MinX: t.MinX, MinY: t.MinY, MaxX: t.MaxX, MaxY: t.MaxY}
+	grid := newDensityGrid(b, cells)
+	stats.Count, stats.Bounds, stats.Density = t.Count, &b, grid
+
+	// cell mirrors densityCell
+	cell := func(field string, min, size float64) any {
+		if size == 0 {
+			return 0
+		}
+		return bson.D{{Key: "$toInt", Value: bson.D{{Key: "$min", Value: bson.A{cells - 1,
+			bson.D{{Key: "$floor", Value: bson.D{{Key: "$divide", Value: bson.A{
+				bson.D{{Key: "$subtract", Value: bson.A{field, min}}}, size,
+			}}}}},
+		}}}}}
+	}
+	countBy := func(field string) bson.A {
+		return bson.A{
+			bson.D{{Key: "$match", Value: bson.D{{Key: field, Value: bson.D{{Key: "$nin", Value: bson.A{nil, ""}}}}}}},
+			bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$" + field}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		}
+	}
+	cursor, err = s.readColl.Aggregate(ctx, bson.A{match, bson.D{{Key: "$facet", Value: bson.D{
+		{Key: "regions", Value: countBy("region")},
+		{Key: "biomes", Value: countBy("biome")},
+		{Key: "density", Value: bson.A{bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "col", Value: cell("$xy.x", b.MinX, grid.CellWidth)},
+				{Key: "row", Value: cell("$xy.y", b.MinY, grid.CellHeight)},
+			}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}}}},
+	}}}})
+	if err != nil {
+		return stats, fmt.Errorf("failed to aggregate map stats in MongoDB: %w", err)
+	}
+	type bucket struct {
+		ID    string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	var facets []struct {
+		Regions []bucket `bson:"regions"`
+		Biomes  []bucket `bson:"biomes"`
+		Density []struct {
+			ID struct {
+				Col int `bson:"col"`
+				Row int `bson:"row"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		} `bson:"density"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return stats, fmt.Errorf("failed to decode map stats: %w", err)
+	}
+	if len(facets) == 0 {
+		return stats, nil
+	}
+	for _, r := range facets[0].Regions {
+		stats.ByRegion[r.ID] = r.Count
+	}
+	for _, b := range facets[0].Biomes {
+		stats.ByBiome[b.ID] = b.Count
+	}
+	for _, d := range facets[0].Density {
+		// A location written between the passes may lie outside the bounds
+		if d.ID.Row < 0 || d.ID.Row >= cells || d.ID.Col < 0 || d.ID.Col >= cells {
+			continue
+		}
+		grid.Counts[d.ID.Row][d.ID.Col] = d.Count
+	}
+	return stats, nil
+}
+
+// StreamLocations decodes locations one at a time from a readColl cursor.
+// Cancelling ctx stops the cursor.
+func (s *mongoStorage) StreamLocations(ctx context.Context, fn func(MapLocation) error) error {
+	cursor, err := s.readColl.Find(ctx, bson.D{notTrashed})
+	if err != nil {
+		return fmt.Errorf("failed to fetch map data from MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var loc MapLocation
+		if err := cursor.Decode(&loc); err != nil {
+			return fmt.Errorf("failed to decode map location: %w", err)
+		}
+		if err := fn(loc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoStorage) InsertAPIKey(ctx context.Context, key APIKey) error {
+	_, err := s.keys.InsertOne(ctx, key)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (s *mongoStorage) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	var key APIKey
+	err := s.keys.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *mongoStorage) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	cursor, err := s.keys.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *mongoStorage) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	res, err := s.keys.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: at}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStorage) InsertUser(ctx context.Context, user User) error {
+	_, err := s.users.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (s *mongoStorage) GetUser(ctx context.Context, id string) (User, error) {
+	var user User
+	err := s.users.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *mongoStorage) SetUserRole(ctx context.Context, id, role string) error {
+	res, err := s.users.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveVersion inserts v with $setOnInsert, so a replica recording a
+// version another one already stored leaves its createdAt alone. Only the
+// replica that inserted it prunes.
+func (s *mongoStorage) SaveVersion(ctx context.Context, v SnapshotVersion, keep int) error {
+	res, err := s.versions.UpdateOne(ctx, bson.D{{Key: "_id", Value: v.ID}},
+		bson.D{{Key: "$setOnInsert", Value: v}}, options.Update().SetUpsert(true))
+	if err != nil || res.UpsertedCount == 0 {
+		return err
+	}
+
+	cursor, err := s.versions.Find(ctx, bson.D{}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var old []SnapshotVersion
+	if err := cursor.All(ctx, &old); err != nil {
+		return err
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	ids := make(bson.A, len(old))
+	for i, o := range old {
+		ids[i] = o.ID
+	}
+	_, err = s.versions.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
+
+func (s *mongoStorage) ListVersions(ctx context.Context, limit int) ([]SnapshotVersion, error) {
+	cursor, err := s.versions.Find(ctx, bson.D{}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.D{{Key: "locations", Value: 0}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	versions := []SnapshotVersion{}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (s *mongoStorage) GetVersion(ctx context.Context, id string) (SnapshotVersion, error) {
+	var v SnapshotVersion
+	err := s.versions.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&v)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return SnapshotVersion{}, ErrNotFound
+	}
+	return v, err
+}
+
+func (s *mongoStorage) InsertAudit(ctx context.Context, e AuditEntry) error {
+	_, err := s.audit.InsertOne(ctx, e)
+	return err
+}
+
+func (s *mongoStorage) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	filter := bson.D{}
+	if q.Collection != "" {
+		filter = append(filter, bson.E{Key: "collection", Value: q.Collection})
+	}
+	if q.DocumentID != "" {
+		filter = append(filter, bson.E{Key: "documentId", Value: q.DocumentID})
+	}
+	if q.Action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: q.Action})
+	}
+	at := bson.D{}
+	if !q.Since.IsZero() {
+		at = append(at, bson.E{Key: "$gte", Value: q.Since})
+	}
+	if !q.Until.IsZero() {
+		at = append(at, bson.E{Key: "$lt", Value: q.Until})
+	}
+	if len(at) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: at})
+	}
+
+	cursor, err := s.audit.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}}).
+		SetLimit(int64(q.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *mongoStorage) InsertPrice(ctx context.Context, p PricePoint) error {
+	_, err := s.prices.InsertOne(ctx, p)
+	return err
+}
+
+func (s *mongoStorage) ListPrices(ctx context.Context, item string, since time.Time) ([]PricePoint, error) {
+	filter := bson.D{
+		{Key: "item", Value: item},
+		{Key: "observedAt", Value: bson.D{{Key: "$gte", Value: since}}},
+	}
+	cursor, err := s.prices.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "observedAt", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices from MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	prices := []PricePoint{}
+	if err := cursor.All(ctx, &prices); err != nil {
+		return nil, fmt.Errorf("failed to decode prices: %w", err)
+	}
+	return prices, nil
+}
+
+// mongoRecords stores a dataset in its own collection of the map database,
+// reading through the same client and read preference as the map.
+type mongoRecords[T Record] struct {
+	coll     *mongo.Collection
+	readColl *mongo.Collection
+}
+
+func newMongoRecords[T Record](s *mongoStorage, collection string) *mongoRecords[T] {
+	return &mongoRecords[T]{
+		coll:     s.client.Database(config.Mongo.Database).Collection(collection),
+		readColl: s.readClient.Database(config.Mongo.Database).Collection(collection, s.readOpts),
+	}
+}
+
+func (m *mongoRecords[T]) List(ctx context.Context) ([]T, error) {
+	cursor, err := m.readColl.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from MongoDB: %w", m.coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", m.coll.Name(), err)
+	}
+	return items, nil
+}
+
+func (m *mongoRecords[T]) Get(ctx context.Context, id string) (T, error) {
+	var rec T
+	err := m.coll.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return rec, ErrNotFound
+	}
+	return rec, err
+}
+
+func (m *mongoRecords[T]) Insert(ctx context.Context, rec T) error {
+	_, err := m.coll.InsertOne(ctx, rec)
+	return writeError(err)
+}
+
+func (m *mongoRecords[T]) Upsert(ctx context.Context, rec T) (bool, error) {
+	res, err := m.coll.ReplaceOne(ctx, bson.D{{Key: "_id", Value: rec.RecordID()}}, rec,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return false, writeError(err)
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+func (m *mongoRecords[T]) Delete(ctx context.Context, id string) error {
+	res, err := m.coll.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Tile reads a tile from the GridFS bucket "tiles". When several files share
+// the name the newest wins.
+func (s *mongoStorage) Tile(ctx context.Context, name string) ([]byte, time.Time, error) {
+	bucket, err := gridfs.NewBucket(s.client.Database(config.Mongo.Database), options.GridFSBucket().SetName("tiles"))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// GridFS downloads take a deadline rather than a context
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+	}
+	stream, err := bucket.OpenDownloadStreamByName(name)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, stream.GetFile().UploadDate, nil
+}
+
+// imageBucket is the GridFS bucket of the images of the locations in coll,
+// one file per location named by its ID.
+func (s *mongoStorage) imageBucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(s.coll.Database(), options.GridFSBucket().SetName(s.coll.Name()+"_images"))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// imageFiles returns the GridFS files of the location's image, newest first.
+// There is more than one only while PutImage replaces it.
+func imageFiles(ctx context.Context, bucket *gridfs.Bucket, id string) ([]gridfs.File, error) {
+	cur, err := bucket.FindContext(ctx, bson.D{{Key: "filename", Value: id}},
+		options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var files []gridfs.File
+	err = cur.All(ctx, &files)
+	return files, err
+}
+
+// imageMetadata is stored with each image file.
+type imageMetadata struct {
+	ContentType string `bson:"contentType"`
+	SHA256      string `bson:"sha256"`
+}
+
+// PutImage uploads the new file before removing the old ones, so readers
+// never find the location without an image.
+func (s *mongoStorage) PutImage(ctx context.Context, id string, img LocationImage) (bool, error) {
+	bucket, err := s.imageBucket(ctx)
+	if err != nil {
+		return false, err
+	}
+	old, err := imageFiles(ctx, bucket, id)
+	if err != nil {
+		return false, err
+	}
+	opts := options.GridFSUpload().SetMetadata(imageMetadata{ContentType: img.ContentType, SHA256: img.SHA256})
+	if _, err := bucket.UploadFromStream(id, bytes.NewReader(img.Data), opts); err != nil {
+		return false, err
+	}
+	for _, f := range old {
+		if err := bucket.DeleteContext(ctx, f.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return false, err
+		}
+	}
+	return len(old) == 0, nil
+}
+
+func (s *mongoStorage) GetImage(ctx context.Context, id string) (LocationImage, error) {
+	bucket, err := s.imageBucket(ctx)
+	if err != nil {
+		return LocationImage{}, err
+	}
+	files, err := imageFiles(ctx, bucket, id)
+	if err != nil {
+		return LocationImage{}, err
+	}
+	if len(files) == 0 {
+		return LocationImage{}, ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	if _, err := bucket.DownloadToStream(files[0].ID, &buf); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			// Replaced since the lookup
+			return LocationImage{}, ErrNotFound
+		}
+		return LocationImage{}, err
+	}
+	var meta imageMetadata
+	if err := bson.Unmarshal(files[0].Metadata, &meta); err != nil {
+		return LocationImage{}, fmt.Errorf("invalid image metadata: %w", err)
+	}
+	return LocationImage{
+		ContentType: meta.ContentType,
+		Data:        buf.Bytes(),
+		SHA256:      meta.SHA256,
+		UpdatedAt:   files[0].UploadDate,
+	}, nil
+}
+
+func (s *mongoStorage) DeleteImage(ctx context.Context, id string) error {
+	bucket, err := s.imageBucket(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := imageFiles(ctx, bucket, id)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return ErrNotFound
+	}
+	for _, f := range files {
+		if err := bucket.DeleteContext(ctx, f.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
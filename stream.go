@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamHeartbeat is how often an idle SSE connection gets a comment line so
+// that proxies don't time it out.
+const streamHeartbeat = 15 * time.Second
+
+// streamHandler pushes map changes to the client as Server-Sent Events. Each
+// event is named after the change type and carries the ChangeEvent as JSON.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+	redacted := redacts(r.Context())
+	streamClients.Add(1)
+	defer streamClients.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and
+				// should reload the full map
+				return
+			}
+			if redacted {
+				ev = redactChange(ev)
+			}
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				logFor(r.Context()).Error("failed to encode change event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Submission states.
+const (
+	submissionPending  = "pending"
+	submissionApproved = "approved"
+	submissionRejected = "rejected"
+)
+
+// maxSubmissionNote caps the free-text notes of submitters and reviewers.
+const maxSubmissionNote = 1000
+
+// Submission is a location proposed by a contributor, kept in the
+// submissions collection until an admin approves or rejects it. The
+// submitter and reviewer fields are the audit trail of the proposal.
+type Submission struct {
+	ID       string      `json:"id" bson:"_id"`
+	Location MapLocation `json:"location" bson:"location"`
+	// Correction is set when the location already existed at submission time
+	Correction  bool      `json:"correction" bson:"correction"`
+	Note        string    `json:"note,omitempty" bson:"note,omitempty"`
+	Status      string    `json:"status" bson:"status"`
+	SubmittedBy string    `json:"submittedBy" bson:"submittedBy"`
+	SubmittedAt time.Time `json:"submittedAt" bson:"submittedAt"`
+	// The review fields are set once an admin approves or rejects it
+	ReviewedBy string     `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	ReviewNote string     `json:"reviewNote,omitempty" bson:"reviewNote,omitempty"`
+}
+
+func (s Submission) RecordID() string { return s.ID }
+
+// submissions stores the proposals. Unlike the datasets it is not cached:
+// it is only read by contributors following up and admins reviewing.
+var submissions RecordStore[Submission]
+
+// openSubmissions connects the submissions to the storage backend. It must
+// run after initStorage.
+func openSubmissions() error {
+	s, err := recordStoreFor[Submission]("submissions")
+	if err != nil {
+		return err
+	}
+	submissions = s
+	return nil
+}
+
+// seesAllSubmissions reports whether p may read other people's submissions.
+func seesAllSubmissions(p principal) bool {
+	return roleRank[p.Role] >= roleRank[roleAdmin]
+}
+
+// submissionOp runs op against the submissions within the usual slot and
+// timeout. On failure the error response is written.
+func submissionOp(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) bool {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return false
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := op(ctx); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "Submission not found", http.StatusNotFound)
+		case errors.Is(err, ErrAlreadyExists):
+			http.Error(w, "A submission with this ID already exists", http.StatusConflict)
+		default:
+			logFor(r.Context()).Error("failed to access submissions", "error", err)
+			http.Error(w, "Failed to access submissions", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// listSubmissionsHandler lists submissions with ?status= (default pending,
+// "all" for every state), newest first. Contributors only see their own.
+func listSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = submissionPending
+	case "all", submissionPending, submissionApproved, submissionRejected:
+	default:
+		http.Error(w, "Query parameter status must be pending, approved, rejected or all", http.StatusBadRequest)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+
+	var all []Submission
+	if !submissionOp(w, r, func(ctx context.Context) (err error) {
+		all, err = submissions.List(ctx)
+		return err
+	}) {
+		return
+	}
+
+	list := []Submission{}
+	for _, s := range all {
+		if (status == "all" || s.Status == status) && (s.SubmittedBy == p.Name || seesAllSubmissions(p)) {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SubmittedAt.After(list[j].SubmittedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, list, "submissions")
+}
+
+func getSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	p, _ := requestPrincipal(r.Context())
+
+	var s Submission
+	if !submissionOp(w, r, func(ctx context.Context) (err error) {
+		s, err = submissions.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	if s.SubmittedBy != p.Name && !seesAllSubmissions(p) {
+		http.Error(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	writeJSON(w, s, "submission")
+}
+
+// submissionRequest is the body of POST /api/submissions.
+type submissionRequest struct {
+	Location MapLocation `json:"location"`
+	Note     string      `json:"note"`
+}
+
+// createSubmissionHandler stores a proposed location as pending. It is
+// validated like a direct write, so an approved submission cannot fail
+// validation later for reasons the submitter could have been told about.
+func createSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	var req submissionRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs []FieldError
+	for _, e := range validateNewLocation(r.Context(), req.Location) {
+		e.Field = "location." + e.Field
+		errs = append(errs, e)
+	}
+	if n := utf8.RuneCountInString(req.Note); n > maxSubmissionNote {
+		errs = append(errs, FieldError{Field: "note", Message: fmt.Sprintf("must be at most %d characters, got %d", maxSubmissionNote, n)})
+	}
+	if errs != nil {
+		writeValidationErrors(w, r, "Invalid submission", errs)
+		return
+	}
+
+	id, err := randomToken(8)
+	if err != nil {
+		http.Error(w, "Failed to create submission", http.StatusInternalServerError)
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+	s := Submission{
+		ID:          id,
+		Location:    req.Location,
+		Note:        req.Note,
+		Status:      submissionPending,
+		SubmittedBy: p.Name,
+		SubmittedAt: time.Now().UTC(),
+	}
+	if snap, err := loadSnapshot(r.Context()); err == nil {
+		_, s.Correction = snap.lookup(s.Location.ID)
+	}
+
+	if !submissionOp(w, r, func(ctx context.Context) error { return submissions.Insert(ctx, s) }) {
+		return
+	}
+	logFor(r.Context()).Info("location submitted", "submission", s.ID, "location", s.Location.ID, "by", s.SubmittedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPath(r.Context(), "/api/submissions/"+url.PathEscape(s.ID)))
+	w.WriteHeader(http.StatusCreated)
+
+	writeJSON(w, s, "submission")
+}
+
+// reviewRequest is the optional body of the approve and reject endpoints.
+type reviewRequest struct {
+	Note string `json:"note"`
+}
+
+// approveSubmissionHandler and rejectSubmissionHandler serve
+// /api/submissions/{id}/approve and /reject.
+func approveSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	reviewSubmission(w, r, pathValue(r, "id"), true)
+}
+
+func rejectSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	reviewSubmission(w, r, pathValue(r, "id"), false)
+}
+
+// reviewSubmission approves or rejects a pending submission. Approval writes
+// the location to the map first, so a failed write leaves the submission
+// pending to be retried.
+func reviewSubmission(w http.ResponseWriter, r *http.Request, id string, approve bool) {
+	var req reviewRequest
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if n := utf8.RuneCountInString(req.Note); n > maxSubmissionNote {
+		writeValidationErrors(w, r, "Invalid review", []FieldError{{Field: "note", Message: fmt.Sprintf("must be at most %d characters, got %d", maxSubmissionNote, n)}})
+		return
+	}
+	p, _ := requestPrincipal(r.Context())
+
+	var s Submission
+	if !submissionOp(w, r, func(ctx context.Context) (err error) {
+		s, err = submissions.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	if s.Status != submissionPending {
+		http.Error(w, "Submission was already reviewed", http.StatusConflict)
+		return
+	}
+	// The rules may have changed since the location was submitted
+	if approve {
+		if errs := validateLocation(s.Location); errs != nil {
+			writeValidationErrors(w, r, "Submitted location no longer passes validation", errs)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	s.Status = submissionRejected
+	if approve {
+		s.Status = submissionApproved
+	}
+	s.ReviewedBy, s.ReviewedAt, s.ReviewNote = p.Name, &now, req.Note
+
+	if !submissionOp(w, r, func(ctx context.Context) error {
+		if approve {
+			before := auditedLocation(ctx, s.Location.ID)
+			created, err := store.UpsertLocation(ctx, s.Location)
+			if err != nil {
+				return fmt.Errorf("failed to write location: %w", err)
+			}
+			invalidateCache(ctx)
+			if created {
+				recordAudit(ctx, auditCreate, mapCollection, s.Location.ID, nil, s.Location)
+				notifyWebhooks(ctx, auditCreate, s.Location.ID, &s.Location)
+			} else {
+				recordAudit(ctx, auditUpdate, mapCollection, s.Location.ID, before, s.Location)
+				notifyWebhooks(ctx, auditUpdate, s.Location.ID, &s.Location)
+			}
+		}
+		_, err := submissions.Upsert(ctx, s)
+		return err
+	}) {
+		return
+	}
+	logFor(r.Context()).Info("submission reviewed", "submission", s.ID, "location", s.Location.ID, "status", s.Status, "by", s.ReviewedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	writeJSON(w, s, "submission")
+}
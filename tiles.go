@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTileZoom bounds the zoom level of tile requests.
+const maxTileZoom = 24
+
+// missingTileMaxAge is how long a 404 for a tile may be cached. Tiles past
+// the edge of the map are requested constantly and never appear.
+const missingTileMaxAge = 5 * time.Minute
+
+// parseTilePath parses "{z}/{x}/{y}.png", with x and y inside the 2^z by 2^z
+// grid of zoom level z, into the tile's canonical name.
+func parseTilePath(path string) (string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".png") {
+		return "", false
+	}
+	parts[2] = strings.TrimSuffix(parts[2], ".png")
+
+	var n [3]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return "", false
+		}
+		n[i] = v
+	}
+	z, x, y := n[0], n[1], n[2]
+	if z > maxTileZoom || x >= 1<<z || y >= 1<<z {
+		return "", false
+	}
+	return fmt.Sprintf("%d/%d/%d.png", z, x, y), true
+}
+
+// tilesHandler serves /tiles/{z}/{x}/{y}.png from config.Tiles.Dir or the
+// tiles GridFS bucket, with conditional and range requests handled by
+// http.ServeContent.
+func tilesHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := parseTilePath(pathValue(r, "path"))
+	if !ok {
+		http.Error(w, "Tile paths are /tiles/{z}/{x}/{y}.png", http.StatusNotFound)
+		return
+	}
+
+	tiles, gridFS := store.(TileStorage)
+	switch {
+	case config.Tiles.Dir != "":
+		f, err := os.Open(filepath.Join(config.Tiles.Dir, filepath.FromSlash(name)))
+		if errors.Is(err, fs.ErrNotExist) {
+			missingTile(w)
+			return
+		}
+		if err != nil {
+			logFor(r.Context()).Error("failed to open tile", "tile", name, "error", err)
+			http.Error(w, "Failed to read tile", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			missingTile(w)
+			return
+		}
+		serveTile(w, r, name, info.ModTime(), f)
+
+	case config.Tiles.GridFS && gridFS:
+		ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+		defer cancel()
+		data, modTime, err := tiles.Tile(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			missingTile(w)
+			return
+		}
+		if err != nil {
+			logFor(r.Context()).Error("failed to load tile", "tile", name, "error", err)
+			http.Error(w, "Failed to read tile", http.StatusInternalServerError)
+			return
+		}
+		serveTile(w, r, name, modTime, bytes.NewReader(data))
+
+	default:
+		http.Error(w, "Map tiles are not configured", http.StatusNotFound)
+	}
+}
+
+// serveTile writes a tile with config.Tiles.MaxAge caching.
+func serveTile(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.Tiles.MaxAge.Seconds())))
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// missingTile answers 404 for a tile that does not exist.
+func missingTile(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(missingTileMaxAge.Seconds())))
+	http.Error(w, "Tile not found", http.StatusNotFound)
+}
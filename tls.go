@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig builds the certificate setup described by config.TLS, or
+// returns nil when TLS is off. It also returns the wrapper for the redirect
+// server's handler, through which autocert answers its HTTP challenges.
+func newTLSConfig() (*tls.Config, func(http.Handler) http.Handler, error) {
+	c := config.TLS
+	switch {
+	case c.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		slog.Info("using TLS certificate", "file", c.CertFile)
+		tlsConfig := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+		return tlsConfig, func(h http.Handler) http.Handler { return h }, nil
+
+	case len(c.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(c.AutocertCacheDir),
+			Email:      c.AutocertEmail,
+		}
+		slog.Info("using Let's Encrypt certificates", "domains", c.AutocertDomains, "cache", c.AutocertCacheDir)
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler, nil
+	}
+	return nil, nil, nil
+}
+
+// redirectTimeout bounds the plain-HTTP connections, which only ever carry
+// a redirect or a challenge response.
+const redirectTimeout = 10 * time.Second
+
+// newRedirectServer returns the plain-HTTP server on config.TLS.RedirectPort,
+// sending every request to the same URL over HTTPS. challenges wraps the
+// redirect so autocert can answer its challenges first.
+func newRedirectServer(challenges func(http.Handler) http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.TLS.RedirectPort),
+		Handler:           challenges(http.HandlerFunc(redirectToHTTPS)),
+		ReadHeaderTimeout: redirectTimeout,
+		IdleTimeout:       redirectTimeout,
+	}
+}
+
+// redirectToHTTPS redirects to the HTTPS port, keeping the host, path and
+// query. 308 keeps the method and body of non-GET requests.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if config.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(config.Port))
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
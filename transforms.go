@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// gameSpace is the coordinate space of the game client, in which locations
+// are stored and served unless ?space= asks for another.
+const gameSpace = "game"
+
+// maxTransformPoints caps the points of one POST /api/transform.
+const maxTransformPoints = 10000
+
+// affine is the transform [a, b, c, d, e, f] mapping (x, y) to
+// (a·x + b·y + c, d·x + e·y + f).
+type affine [6]float64
+
+var identity = affine{1, 0, 0, 0, 1, 0}
+
+func (t affine) apply(x, y float64) (float64, float64) {
+	return t[0]*x + t[1]*y + t[2], t[3]*x + t[4]*y + t[5]
+}
+
+func (t affine) invertible() bool { return t[0]*t[4]-t[1]*t[3] != 0 }
+
+// inverse returns the transform undoing t, which must be invertible.
+func (t affine) inverse() affine {
+	det := t[0]*t[4] - t[1]*t[3]
+	a, b, d, e := t[4]/det, -t[1]/det, -t[3]/det, t[0]/det
+	return affine{a, b, -(a*t[2] + b*t[5]), d, e, -(d*t[2] + e*t[5])}
+}
+
+// then returns the transform applying t, then u.
+func (t affine) then(u affine) affine {
+	return affine{
+		u[0]*t[0] + u[1]*t[3], u[0]*t[1] + u[1]*t[4], u[0]*t[2] + u[1]*t[5] + u[2],
+		u[3]*t[0] + u[4]*t[3], u[3]*t[1] + u[4]*t[4], u[3]*t[2] + u[4]*t[5] + u[5],
+	}
+}
+
+// CoordinateSpace describes a space in /api/spaces.
+type CoordinateSpace struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Transform takes game coordinates into the space, as in SpaceConfig
+	Transform affine `json:"transform"`
+}
+
+// coordinateSpaces lists the game's space and those of config.Spaces.
+func coordinateSpaces() []CoordinateSpace {
+	spaces := []CoordinateSpace{{Name: gameSpace, Description: "The game client's coordinates, in which locations are stored", Transform: identity}}
+	for _, sp := range config.Spaces {
+		spaces = append(spaces, CoordinateSpace{Name: sp.Name, Description: sp.Description, Transform: sp.Transform})
+	}
+	return spaces
+}
+
+// lookupSpace returns the transform from game coordinates into the space
+// named; "" is the game's.
+func lookupSpace(name string) (affine, error) {
+	if name == "" || name == gameSpace {
+		return identity, nil
+	}
+	for _, sp := range config.Spaces {
+		if sp.Name == name {
+			return sp.Transform, nil
+		}
+	}
+	return affine{}, fmt.Errorf("unknown coordinate space %q; /api/spaces lists them", name)
+}
+
+// spaceQuery parses ?space=, returning the transform of the locations into
+// it, or nil when they stay in game coordinates.
+func spaceQuery(q url.Values) (*affine, error) {
+	name := q.Get("space")
+	if name == "" || name == gameSpace {
+		return nil, nil
+	}
+	t, err := lookupSpace(name)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// transformLocations returns a copy of locations moved into the space of t,
+// leaving locations itself untouched.
+func transformLocations(locations []MapLocation, t affine) []MapLocation {
+	out := append([]MapLocation(nil), locations...)
+	for i := range out {
+		out[i].XY.X, out[i].XY.Y = t.apply(out[i].XY.X, out[i].XY.Y)
+	}
+	return out
+}
+
+// gameBounds returns the game coordinates box covering b, a box in the space
+// of t. Under a rotation or shear it covers more than b, so what it finds is
+// filtered by b again once moved into the space.
+func gameBounds(b Bounds, t affine) Bounds {
+	inv := t.inverse()
+	out := Bounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, corner := range [][2]float64{{b.MinX, b.MinY}, {b.MinX, b.MaxY}, {b.MaxX, b.MinY}, {b.MaxX, b.MaxY}} {
+		x, y := inv.apply(corner[0], corner[1])
+		out.MinX, out.MaxX = min(out.MinX, x), max(out.MaxX, x)
+		out.MinY, out.MaxY = min(out.MinY, y), max(out.MaxY, y)
+	}
+	return out
+}
+
+// inBounds keeps the locations inside b.
+func inBounds(locations []MapLocation, b Bounds) []MapLocation {
+	out := locations[:0]
+	for _, loc := range locations {
+		if loc.XY.X >= b.MinX && loc.XY.X <= b.MaxX && loc.XY.Y >= b.MinY && loc.XY.Y <= b.MaxY {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// spacesHandler lists the coordinate spaces.
+func spacesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, coordinateSpaces(), "coordinate spaces")
+}
+
+// TransformRequest is the POST /api/transform body. From and To default to
+// the game's space.
+type TransformRequest struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Points []Coordinates `json:"points"`
+}
+
+// transformHandler converts points between coordinate spaces: one given by
+// ?x=&y= or, for POST, those of a TransformRequest, from ?from= (or the
+// body's from) to ?to=.
+func transformHandler(w http.ResponseWriter, r *http.Request) {
+	var req TransformRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		q := r.URL.Query()
+		x, errX := strconv.ParseFloat(q.Get("x"), 64)
+		y, errY := strconv.ParseFloat(q.Get("y"), 64)
+		if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+			http.Error(w, "x and y must be finite numbers", http.StatusBadRequest)
+			return
+		}
+		req = TransformRequest{From: q.Get("from"), To: q.Get("to"), Points: []Coordinates{{X: x, Y: y}}}
+	case http.MethodPost:
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Points) == 0 || len(req.Points) > maxTransformPoints {
+			http.Error(w, fmt.Sprintf("Between 1 and %d points are required", maxTransformPoints), http.StatusBadRequest)
+			return
+		}
+		for i, p := range req.Points {
+			if !isFinite(p.X) || !isFinite(p.Y) {
+				http.Error(w, fmt.Sprintf("Point %d must have finite coordinates", i), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, err := lookupSpace(req.From)
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := lookupSpace(req.To)
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.From == "" {
+		req.From = gameSpace
+	}
+	if req.To == "" {
+		req.To = gameSpace
+	}
+
+	t := from.inverse().then(to)
+	for i, p := range req.Points {
+		req.Points[i].X, req.Points[i].Y = t.apply(p.X, p.Y)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, req, "transformed points")
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"example/souforged/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// canonicalLanguage is the language of the names stored with the locations.
+const canonicalLanguage = "en"
+
+// languageTagPattern is what a language in a translation may look like: a
+// BCP 47 tag such as de, pt-br or zh-hant, kept in lower case.
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// LocationTranslation holds the names of a map location in other languages.
+// Its ID is the location's; translations apply to the location of that ID in
+// every world.
+type LocationTranslation struct {
+	ID string `json:"id" bson:"_id"`
+	// Names maps language tags, such as de or pt-br, to the name of the
+	// location in that language
+	Names TranslatedNames `json:"names" bson:"names"`
+}
+
+// TranslatedNames maps language tags to names.
+type TranslatedNames map[string]string
+
+// MarshalBSON stores the names in language order, so that the same
+// translation always hashes the same and refreshes see no change where there
+// is none.
+func (n TranslatedNames) MarshalBSON() ([]byte, error) {
+	tags := make([]string, 0, len(n))
+	for tag := range n {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	doc := make(bson.D, 0, len(tags))
+	for _, tag := range tags {
+		doc = append(doc, bson.E{Key: tag, Value: n[tag]})
+	}
+	return bson.Marshal(doc)
+}
+
+func (t LocationTranslation) RecordID() string       { return t.ID }
+func (t *LocationTranslation) setRecordID(id string) { t.ID = id }
+
+// translations is managed by admins at /api/admin/translations.
+var translations = newDataset("translations", "translation", checkTranslation, nil)
+
+// checkTranslation validates t, lower-casing its language tags.
+func checkTranslation(t *LocationTranslation) []FieldError {
+	var errs validation.Errors
+	if len(t.Names) == 0 {
+		errs.Add("names", "must not be empty")
+		return errs
+	}
+
+	tags := make([]string, 0, len(t.Names))
+	for tag := range t.Names {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	names := make(map[string]string, len(t.Names))
+	for _, lang := range tags {
+		name, field := t.Names[lang], "names."+lang
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if !languageTagPattern.MatchString(lang) {
+			errs.Add(field, "must be keyed by a language tag such as de or pt-br")
+			continue
+		}
+		if primary, _, _ := strings.Cut(lang, "-"); primary == canonicalLanguage {
+			errs.Add(field, "is the language of the location's own name")
+			continue
+		}
+		if _, dup := names[lang]; dup {
+			errs.Add(field, "repeats the language %s", lang)
+			continue
+		}
+		if errs.NonEmpty(field, name) {
+			errs.MaxLength(field, name, validationRules.MaxNameLength)
+		}
+		names[lang] = name
+	}
+	t.Names = names
+	return errs
+}
+
+// mountTranslations registers the admin routes of the translations on ops,
+// which is /api/admin.
+func mountTranslations(ops routeGroup) {
+	translations.route = "/api/admin/translations"
+	translations.private = true
+	ops.get("/translations", "Translated location names (admin); /api/map serves them by Accept-Language", translations.listHandler)
+	ops.post("/translations", "Add the translated names of a location (admin)", translations.createHandler)
+	ops.get("/translations/{id}", "The translated names of a location (admin)", translations.getHandler)
+	ops.put("/translations/{id}", "Replace the translated names of a location (admin)", translations.updateHandler)
+	ops.delete("/translations/{id}", "Remove the translated names of a location (admin)", translations.deleteHandler)
+}
+
+// translationIndex is the translations snapshot by language, then location
+// ID.
+type translationIndex struct {
+	generation uint64
+	hash       uint64
+	byLanguage map[string]map[string]string
+}
+
+// translationIndexCache holds the index of the translations snapshot
+// generation it was built for.
+var translationIndexCache struct {
+	mu    sync.Mutex
+	index *translationIndex
+}
+
+// loadTranslationIndex returns the index of the current translations.
+func loadTranslationIndex(r *http.Request) (*translationIndex, error) {
+	snap, err := translations.load(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	translationIndexCache.mu.Lock()
+	defer translationIndexCache.mu.Unlock()
+	if idx := translationIndexCache.index; idx != nil && idx.generation == snap.generation {
+		return idx, nil
+	}
+	idx := &translationIndex{generation: snap.generation, hash: snap.hash, byLanguage: map[string]map[string]string{}}
+	for _, t := range snap.items {
+		for lang, name := range t.Names {
+			if idx.byLanguage[lang] == nil {
+				idx.byLanguage[lang] = map[string]string{}
+			}
+			idx.byLanguage[lang][t.ID] = name
+		}
+	}
+	translationIndexCache.index = idx
+	return idx, nil
+}
+
+// negotiateLanguage picks the translated language the request's
+// Accept-Language prefers: a tag the index has, or failing that its primary
+// language, so that de-AT is served de. It returns "" when none matches or
+// the canonical language comes first, in which case the canonical names are
+// served.
+func negotiateLanguage(r *http.Request, idx *translationIndex) string {
+	for _, tag := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		primary, _, _ := strings.Cut(tag, "-")
+		if primary == canonicalLanguage {
+			return ""
+		}
+		if _, ok := idx.byLanguage[tag]; ok {
+			return tag
+		}
+		if _, ok := idx.byLanguage[primary]; ok {
+			return primary
+		}
+	}
+	return ""
+}
+
+// acceptedLanguages lists the language tags of an Accept-Language header,
+// most preferred first, in lower case and without the wildcard or those
+// refused with q=0.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			langs = append(langs, accepted{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// mapLanguage chooses the language of the map names for r. Failing to load
+// the translations is not worth failing the map for, so the canonical names
+// are served instead.
+func mapLanguage(r *http.Request) (string, *translationIndex) {
+	if r.Header.Get("Accept-Language") == "" {
+		return "", nil
+	}
+	idx, err := loadTranslationIndex(r)
+	if err != nil {
+		logFor(r.Context()).Warn("serving canonical names; failed to load translations", "error", err)
+		return "", nil
+	}
+	return negotiateLanguage(r, idx), idx
+}
+
+// localizeLocations returns a copy of locations with the names given by ID
+// in names, leaving locations itself untouched.
+func localizeLocations(locations []MapLocation, names map[string]string) []MapLocation {
+	out := append([]MapLocation(nil), locations...)
+	for i := range out {
+		if name, ok := names[out[i].ID]; ok {
+			out[i].Location = name
+		}
+	}
+	return out
+}
+
+// localizedLocations is a snapshot's locations in one language, as of one
+// translations generation.
+type localizedLocations struct {
+	generation uint64
+	locations  []MapLocation
+}
+
+// localized returns the snapshot's locations named in lang by idx, built once
+// per language and translations generation.
+func (s *cacheSnapshot) localized(lang string, idx *translationIndex) []MapLocation {
+	s.localizedMu.Lock()
+	defer s.localizedMu.Unlock()
+	if l, ok := s.localizedByLang[lang]; ok && l.generation == idx.generation {
+		return l.locations
+	}
+	if s.localizedByLang == nil {
+		s.localizedByLang = make(map[string]localizedLocations)
+	}
+	locations := localizeLocations(s.locations, idx.byLanguage[lang])
+	s.localizedByLang[lang] = localizedLocations{generation: idx.generation, locations: locations}
+	return locations
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// TrashedLocation is a deleted location kept in the trash.
+type TrashedLocation struct {
+	MapLocation `bson:",inline"`
+	DeletedAt   time.Time `json:"deletedAt" bson:"deletedAt"`
+}
+
+// trashStore returns the trash of the request's world. On failure the error
+// response is written.
+func trashStore(w http.ResponseWriter, r *http.Request) (TrashStorage, bool) {
+	trash, ok := storeFor(r.Context()).(TrashStorage)
+	if !ok {
+		http.Error(w, "The storage backend deletes locations for good; there is no trash", http.StatusNotImplemented)
+	}
+	return trash, ok
+}
+
+// trashHandler lists the deleted locations, most recently deleted first.
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	trash, ok := trashStore(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	list, err := trash.ListTrash(ctx)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, list, "trash")
+}
+
+// restoreLocationHandler takes the location out of the trash and answers
+// with it.
+func restoreLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	trash, ok := trashStore(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	loc, err := trash.RestoreLocation(ctx, id)
+	if err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	invalidateCache(ctx)
+	recordAudit(ctx, auditRestore, worldFor(ctx).collection(), id, nil, loc)
+	notifyWebhooks(ctx, auditCreate, id, &loc)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, loc, "map location")
+}
+
+// purgeLocationHandler removes the location from the trash for good, along
+// with its image.
+func purgeLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	trash, ok := trashStore(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	before, err := trash.PurgeLocation(ctx, id)
+	if err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	deleteLocationImage(ctx, id)
+	recordAudit(ctx, auditPurge, worldFor(ctx).collection(), id, before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTrashError answers a failed restore or purge.
+func writeTrashError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "No location with this ID is in the trash")
+		return
+	}
+	writeStorageError(w, r, err)
+}
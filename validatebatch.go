@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// maxValidateBatchItems caps the number of coordinates per batch request.
+const maxValidateBatchItems = 1000
+
+// maxValidateBatchBody caps the size of a batch request body in bytes.
+const maxValidateBatchBody = 1 << 20
+
+// ValidateBatchRequest is the body of POST /api/map/validate-batch.
+type ValidateBatchRequest struct {
+	// Radius is the distance within which an existing marker counts as a
+	// collision
+	Radius      float64       `json:"radius"`
+	Coordinates []Coordinates `json:"coordinates"`
+}
+
+// PlacementCheck is the verdict for one candidate placement.
+type PlacementCheck struct {
+	Index      int          `json:"index"`
+	Valid      bool         `json:"valid"`
+	Errors     []FieldError `json:"errors,omitempty"`
+	Collides   bool         `json:"collides"`
+	Collisions []string     `json:"collisions,omitempty"`
+}
+
+// validateBatchHandler checks candidate placements against the validation
+// rules and the cached markers without writing anything.
+func validateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req ValidateBatchRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValidateBatchBody))
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Radius < 0 || !isFinite(req.Radius) {
+		http.Error(w, "Field radius must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	if len(req.Coordinates) > maxValidateBatchItems {
+		http.Error(w, fmt.Sprintf("At most %d coordinates may be checked per request", maxValidateBatchItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	grid := snap.grid()
+	checks := make([]PlacementCheck, len(req.Coordinates))
+	for i, xy := range req.Coordinates {
+		check := PlacementCheck{Index: i, Errors: validateCoordinates(xy)}
+		check.Valid = check.Errors == nil
+		if check.Valid {
+			grid.within(xy.X, xy.Y, req.Radius, func(j int, _ float64) {
+				check.Collisions = append(check.Collisions, grid.locations[j].ID)
+			})
+			sort.Strings(check.Collisions)
+			check.Collides = len(check.Collisions) > 0
+		}
+		checks[i] = check
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, checks, "placement checks")
+}
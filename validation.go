@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"example/souforged/validation"
+)
+
+// Limits on the optional location metadata.
+const (
+	maxDangerLevel    = 5
+	maxTags           = 20
+	maxMetadataLength = 64
+	maxWeight         = 100
+)
+
+// slugPattern is the form of biome names and tags: lower-case words joined by
+// hyphens, such as "old-growth".
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// FieldError describes a single validation failure on a MapLocation field.
+type FieldError = validation.FieldError
+
+// Region is a named playable area with its own coordinate bounds and,
+// optionally, the maximum number of decimal places coordinates may use in it.
+type Region struct {
+	Name      string `json:"name"`
+	Bounds    Bounds `json:"bounds"`
+	Precision *int   `json:"precision,omitempty"`
+}
+
+// Contains reports whether xy lies inside the region's bounds.
+func (r Region) Contains(xy Coordinates) bool {
+	return xy.X >= r.Bounds.MinX && xy.X <= r.Bounds.MaxX &&
+		xy.Y >= r.Bounds.MinY && xy.Y <= r.Bounds.MaxY
+}
+
+// ValidationRules are the constraints a MapLocation must satisfy.
+type ValidationRules struct {
+	MaxNameLength int
+	// Bounds restricts coordinates to a box when non-nil
+	Bounds *Bounds
+	// Regions, when non-empty, restricts coordinates to the listed regions.
+	// A location is validated against the first region containing it.
+	Regions []Region
+}
+
+var validationRules = ValidationRules{MaxNameLength: 100}
+
+// loadValidationRules sets the validation rules from config.Validation,
+// reading the regions file it names.
+func loadValidationRules() error {
+	validationRules.MaxNameLength = config.Validation.MaxNameLength
+
+	if v := config.Validation.MapBounds; v != "" {
+		b, err := parseBounds(v)
+		if err != nil {
+			return fmt.Errorf("map bounds: %w", err)
+		}
+		validationRules.Bounds = &b
+	}
+
+	if path := config.Validation.RegionsFile; path != "" {
+		regions, err := loadRegions(path)
+		if err != nil {
+			return fmt.Errorf("regions file: %w", err)
+		}
+		validationRules.Regions = regions
+	}
+
+	return nil
+}
+
+// loadRegions reads and checks the region table stored at path.
+func loadRegions(path string) ([]Region, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []Region
+	if err := json.Unmarshal(raw, &regions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for _, r := range regions {
+		if r.Name == "" {
+			return nil, fmt.Errorf("region without a name in %s", path)
+		}
+		if r.Bounds.MinX > r.Bounds.MaxX || r.Bounds.MinY > r.Bounds.MaxY {
+			return nil, fmt.Errorf("region %q: minimum exceeds maximum", r.Name)
+		}
+		if r.Precision != nil && *r.Precision < 0 {
+			return nil, fmt.Errorf("region %q: precision must not be negative", r.Name)
+		}
+	}
+	return regions, nil
+}
+
+// regionAt returns the first configured region containing xy.
+func regionAt(xy Coordinates) (Region, bool) {
+	for _, r := range validationRules.Regions {
+		if r.Contains(xy) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// regionNamed returns the configured region with the given name, ignoring
+// case.
+func regionNamed(name string) (Region, bool) {
+	for _, r := range validationRules.Regions {
+		if strings.EqualFold(r.Name, name) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// parseBounds parses a "minX,minY,maxX,maxY" box.
+func parseBounds(s string) (Bounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Bounds{}, fmt.Errorf("expected minX,minY,maxX,maxY, got %q", s)
+	}
+
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Bounds{}, fmt.Errorf("invalid number %q", p)
+		}
+		v[i] = f
+	}
+
+	b := Bounds{MinX: v[0], MinY: v[1], MaxX: v[2], MaxY: v[3]}
+	if b.MinX > b.MaxX || b.MinY > b.MaxY {
+		return Bounds{}, fmt.Errorf("minimum exceeds maximum in %q", s)
+	}
+	return b, nil
+}
+
+// validateLocation checks loc against the current validation rules and
+// returns every violation found, or nil if loc is valid.
+func validateLocation(loc MapLocation) []FieldError {
+	var errs validation.Errors
+
+	errs.NonEmpty("id", loc.ID)
+	if errs.NonEmpty("location", loc.Location) {
+		errs.MaxLength("location", loc.Location, validationRules.MaxNameLength)
+	}
+
+	errs = append(errs, validateCoordinates(loc.XY)...)
+	errs = append(errs, validateMetadata(loc)...)
+
+	return errs
+}
+
+// validateNewLocation is validateLocation for a location about to be written,
+// which must also not duplicate another location: one with a different ID but
+// the same name at the same coordinates is almost certainly the same place
+// entered twice.
+func validateNewLocation(ctx context.Context, loc MapLocation) []FieldError {
+	errs := validateLocation(loc)
+	if errs != nil {
+		return errs
+	}
+	if id, ok := duplicateLocation(ctx, loc); ok {
+		errs = append(errs, FieldError{Field: "location", Message: fmt.Sprintf("duplicates location %q at the same coordinates", id)})
+	}
+	return errs
+}
+
+// duplicateLocation returns the ID of a location cached for the request's
+// world, other than loc, with loc's name, ignoring case, and coordinates.
+func duplicateLocation(ctx context.Context, loc MapLocation) (string, bool) {
+	snap := worldFor(ctx).cache.Peek()
+	if snap == nil || len(snap.locations) == 0 {
+		return "", false
+	}
+	grid := snap.grid()
+	dup := ""
+	grid.within(loc.XY.X, loc.XY.Y, 0, func(i int, _ float64) {
+		other := grid.locations[i]
+		if dup == "" && other.ID != loc.ID && strings.EqualFold(strings.TrimSpace(other.Location), strings.TrimSpace(loc.Location)) {
+			dup = other.ID
+		}
+	})
+	return dup, dup != ""
+}
+
+// validateMetadata checks the optional region, biome, danger level, weight,
+// discoverer and tags of loc.
+func validateMetadata(loc MapLocation) []FieldError {
+	var errs []FieldError
+
+	if loc.Region != "" {
+		if len(validationRules.Regions) > 0 {
+			if _, ok := regionNamed(loc.Region); !ok {
+				errs = append(errs, FieldError{Field: "region", Message: fmt.Sprintf("must name a configured region, got %q", loc.Region)})
+			}
+		} else if strings.TrimSpace(loc.Region) == "" || utf8.RuneCountInString(loc.Region) > maxMetadataLength {
+			errs = append(errs, FieldError{Field: "region", Message: fmt.Sprintf("must be 1 to %d characters and not blank", maxMetadataLength)})
+		}
+	}
+
+	if loc.Biome != "" && (!slugPattern.MatchString(loc.Biome) || len(loc.Biome) > maxMetadataLength) {
+		errs = append(errs, FieldError{Field: "biome", Message: fmt.Sprintf("must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)})
+	}
+
+	if loc.DangerLevel < 0 || loc.DangerLevel > maxDangerLevel {
+		errs = append(errs, FieldError{Field: "dangerLevel", Message: fmt.Sprintf("must be between 1 and %d, or 0 for unrated, got %d", maxDangerLevel, loc.DangerLevel)})
+	}
+
+	if loc.Weight < 0 || loc.Weight > maxWeight || !isFinite(loc.Weight) {
+		errs = append(errs, FieldError{Field: "weight", Message: fmt.Sprintf("must be a positive number up to %d, or 0 for the default, got %v", maxWeight, loc.Weight)})
+	}
+
+	if loc.DiscoveredBy != "" && (strings.TrimSpace(loc.DiscoveredBy) == "" || utf8.RuneCountInString(loc.DiscoveredBy) > maxMetadataLength) {
+		errs = append(errs, FieldError{Field: "discoveredBy", Message: fmt.Sprintf("must be 1 to %d characters and not blank", maxMetadataLength)})
+	}
+
+	if len(loc.Tags) > maxTags {
+		errs = append(errs, FieldError{Field: "tags", Message: fmt.Sprintf("must have at most %d entries, got %d", maxTags, len(loc.Tags))})
+	}
+	seen := make(map[string]bool, len(loc.Tags))
+	for i, tag := range loc.Tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		switch {
+		case !slugPattern.MatchString(tag) || len(tag) > maxMetadataLength:
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("must be at most %d lower-case letters, digits and hyphens", maxMetadataLength)})
+		case seen[tag]:
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("duplicates tag %q", tag)})
+		}
+		seen[tag] = true
+	}
+
+	return errs
+}
+
+// validateCoordinates checks that xy is finite and inside the configured bounds.
+func validateCoordinates(xy Coordinates) []FieldError {
+	var errs validation.Errors
+
+	finiteX := errs.Finite("xy.x", xy.X)
+	finiteY := errs.Finite("xy.y", xy.Y)
+	if !finiteX || !finiteY {
+		return errs
+	}
+
+	if b := validationRules.Bounds; b != nil {
+		errs.Between("xy.x", xy.X, b.MinX, b.MaxX)
+		errs.Between("xy.y", xy.Y, b.MinY, b.MaxY)
+	}
+
+	if len(validationRules.Regions) > 0 {
+		region, ok := regionAt(xy)
+		if !ok {
+			errs = append(errs, FieldError{Field: "xy", Message: "is outside every configured region"})
+		} else if p := region.Precision; p != nil {
+			if roundCoordinate(xy.X, *p) != xy.X {
+				errs = append(errs, FieldError{Field: "xy.x", Message: fmt.Sprintf("must have at most %d decimal places in region %q", *p, region.Name)})
+			}
+			if roundCoordinate(xy.Y, *p) != xy.Y {
+				errs = append(errs, FieldError{Field: "xy.y", Message: fmt.Sprintf("must have at most %d decimal places in region %q", *p, region.Name)})
+			}
+		}
+	}
+
+	return errs
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
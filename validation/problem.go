@@ -0,0 +1,47 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of problem details responses.
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object, extended with the list of
+// field violations when the problem is an invalid payload.
+type Problem struct {
+	// Type is a URI identifying the kind of problem; "about:blank" means
+	// the status code says it all and Title is its reason phrase
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the request path the problem occurred on
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem returns a problem of type about:blank for status with the
+// given detail.
+func NewProblem(status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// Invalid returns the 400 problem for a payload with the given violations.
+func Invalid(detail string, errs []FieldError) Problem {
+	p := NewProblem(http.StatusBadRequest, detail)
+	p.Errors = errs
+	return p
+}
+
+// Write sends p as the response, taking the instance from r unless set.
+func Write(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,94 @@
+// Package validation collects field violations in request payloads and
+// reports them as RFC 7807 problem details.
+package validation
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a single validation failure on a payload field.
+// Field is a JSON path such as "xy.x" or "tags[2]", or empty when the
+// failure concerns the payload as a whole.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors accumulates the violations found in one payload. A nil Errors
+// means the payload is valid.
+type Errors []FieldError
+
+// Add records a violation of field.
+func (e *Errors) Add(field, format string, args ...any) {
+	*e = append(*e, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+// Prefix returns the violations with prefix and a dot prepended to each
+// field, for a payload nested in a larger one.
+func (e Errors) Prefix(prefix string) Errors {
+	out := make(Errors, len(e))
+	for i, fe := range e {
+		out[i] = fe
+		if fe.Field == "" {
+			out[i].Field = prefix
+		} else {
+			out[i].Field = prefix + "." + fe.Field
+		}
+	}
+	return out
+}
+
+// NonEmpty checks that s is not empty or blank.
+func (e *Errors) NonEmpty(field, s string) bool {
+	if strings.TrimSpace(s) == "" {
+		e.Add(field, "must not be empty")
+		return false
+	}
+	return true
+}
+
+// MaxLength checks that s has at most n characters.
+func (e *Errors) MaxLength(field, s string, n int) bool {
+	if got := utf8.RuneCountInString(s); got > n {
+		e.Add(field, "must be at most %d characters, got %d", n, got)
+		return false
+	}
+	return true
+}
+
+// Finite checks that v is neither NaN nor infinite.
+func (e *Errors) Finite(field string, v float64) bool {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		e.Add(field, "must be a finite number")
+		return false
+	}
+	return true
+}
+
+// Between checks that lo <= v <= hi.
+func (e *Errors) Between(field string, v, lo, hi float64) bool {
+	if v < lo || v > hi {
+		e.Add(field, "must be between %g and %g", lo, hi)
+		return false
+	}
+	return true
+}
+
+// Unique reports each repeated key among keys, naming the index of its first
+// occurrence. field formats the field of index i, e.g. "[3].id".
+func (e *Errors) Unique(keys []string, field func(i int) string) bool {
+	first := make(map[string]int, len(keys))
+	ok := true
+	for i, key := range keys {
+		if j, dup := first[key]; dup {
+			e.Add(field(i), "duplicates entry %d", j)
+			ok = false
+			continue
+		}
+		first[key] = i
+	}
+	return ok
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiV1 is the prefix of the first versioned API. The routes under /api
+// without a version serve the same handlers, as v1 did when it was
+// introduced, so that existing tools keep working, but are deprecated: a
+// later version may change the schema at its own prefix while they stay as
+// they are.
+const apiV1 = "/api/v1"
+
+// legacyDeprecated is when the unversioned /api routes were deprecated in
+// favour of apiV1.
+var legacyDeprecated = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// apiBaseKey is the context key of the API prefix a request was made under.
+type apiBaseKey struct{}
+
+// versioned is the middleware of the routes of one API version, base being
+// its prefix: paths the handlers link to stay under it.
+func versioned(base string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r.WithContext(context.WithValue(r.Context(), apiBaseKey{}, base)))
+		}
+	}
+}
+
+// apiPath returns path, an /api/... path, under the API version the request
+// was made to.
+func apiPath(ctx context.Context, path string) string {
+	if base, ok := ctx.Value(apiBaseKey{}).(string); ok {
+		return base + strings.TrimPrefix(path, "/api")
+	}
+	return path
+}
+
+// unversionedRoute returns the pattern route has in the default world of the
+// unversioned API, so that per-route behaviour is looked up once for every
+// version and world.
+func unversionedRoute(route string) string {
+	if rest, ok := strings.CutPrefix(route, apiV1); ok {
+		route = "/api" + rest
+	}
+	if rest, ok := strings.CutPrefix(route, worldPrefix); ok {
+		route = "/api" + rest
+	}
+	return route
+}
+
+// deprecation describes routes that are going away, for the headers of
+// RFC 9745 and RFC 8594.
+type deprecation struct {
+	// since is when the routes were deprecated, sent as Deprecation
+	since time.Time
+	// sunset is when they stop being served, sent as Sunset; zero while
+	// that is undecided
+	sunset time.Time
+	// successor returns the path replacing the one requested, linked with
+	// rel="successor-version", or "" if there is none
+	successor func(path string) string
+}
+
+// deprecated is the middleware of deprecated routes: the response is served
+// as before, with headers announcing d.
+func deprecated(d deprecation) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+			if !d.sunset.IsZero() {
+				h.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.successor != nil {
+				if path := d.successor(r.URL.Path); path != "" {
+					h.Add("Link", "<"+path+`>; rel="successor-version"`)
+				}
+			}
+			next(w, r)
+		}
+	}
+}
+
+// legacyAPI returns the deprecation of the unversioned /api routes.
+func legacyAPI() deprecation {
+	return deprecation{
+		since:  legacyDeprecated,
+		sunset: config.HTTP.LegacySunset,
+		successor: func(path string) string {
+			return apiV1 + strings.TrimPrefix(path, "/api")
+		},
+	}
+}
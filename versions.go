@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Limits on stored and listed snapshot versions.
+const (
+	maxStoredVersions   = 500
+	defaultVersionLimit = 50
+	versionSaveTimeout  = 30 * time.Second
+)
+
+// SnapshotVersion is a stored copy of the map as it was at one point. Its ID
+// is the snapshot hash, so replicas that load the same data record the same
+// version once.
+type SnapshotVersion struct {
+	ID        string        `json:"id" bson:"_id"`
+	CreatedAt time.Time     `json:"createdAt" bson:"createdAt"`
+	Count     int           `json:"count" bson:"count"`
+	Locations []MapLocation `json:"locations,omitempty" bson:"locations"`
+}
+
+// VersionStorage is implemented by backends that keep the map history.
+type VersionStorage interface {
+	// SaveVersion stores v unless a version with its ID exists, then drops
+	// the oldest versions beyond keep.
+	SaveVersion(ctx context.Context, v SnapshotVersion, keep int) error
+	// ListVersions returns up to limit versions, newest first, without
+	// their locations.
+	ListVersions(ctx context.Context, limit int) ([]SnapshotVersion, error)
+	// GetVersion returns the version with the given ID or ErrNotFound.
+	GetVersion(ctx context.Context, id string) (SnapshotVersion, error)
+}
+
+// versionID is the ID of the version holding a snapshot.
+func versionID(s *cacheSnapshot) string {
+	return strconv.FormatUint(s.hash, 16)
+}
+
+// recordVersion saves s as a version in the background, so neither the
+// refresher nor the request that loaded s waits on the write. Failures are
+// logged.
+func recordVersion(s *cacheSnapshot) {
+	versions, ok := store.(VersionStorage)
+	if !ok {
+		return
+	}
+	v := SnapshotVersion{ID: versionID(s), CreatedAt: time.Now().UTC(), Count: len(s.locations), Locations: s.locations}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), versionSaveTimeout)
+		defer cancel()
+		if err := versions.SaveVersion(ctx, v, maxStoredVersions); err != nil {
+			slog.Warn("failed to save snapshot version", "version", v.ID, "error", err)
+		}
+	}()
+}
+
+// versionsHandler lists the stored map versions, newest first, up to
+// ?limit=.
+func versionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := store.(VersionStorage)
+	if !ok {
+		http.Error(w, "The storage backend does not keep map versions", http.StatusNotImplemented)
+		return
+	}
+	limit := defaultVersionLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStoredVersions {
+			http.Error(w, "Query parameter limit must be between 1 and "+strconv.Itoa(maxStoredVersions), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	list, err := versions.ListVersions(ctx, limit)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, list, "snapshot versions")
+}
+
+// LocationMove is a location whose coordinates changed between versions.
+type LocationMove struct {
+	ID   string      `json:"id"`
+	From Coordinates `json:"from"`
+	To   Coordinates `json:"to"`
+}
+
+// VersionDiff is the /api/map/diff response. Updated lists locations whose
+// fields other than xy changed; a location can be both moved and updated.
+type VersionDiff struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Added   []MapLocation  `json:"added"`
+	Removed []MapLocation  `json:"removed"`
+	Moved   []LocationMove `json:"moved"`
+	Updated []MapLocation  `json:"updated"`
+}
+
+// diffHandler compares the version ?from= with ?to=, which defaults to the
+// data currently served.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := store.(VersionStorage)
+	if !ok {
+		http.Error(w, "The storage backend does not keep map versions", http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	fromID, toID := q.Get("from"), q.Get("to")
+	if fromID == "" {
+		http.Error(w, "Query parameter from is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	load := func(id string) ([]MapLocation, string, bool) {
+		if id == "" {
+			snap, err := loadSnapshot(ctx)
+			if err != nil {
+				writeLoadError(w, r, err)
+				return nil, "", false
+			}
+			return snap.locations, versionID(snap), true
+		}
+		v, err := versions.GetVersion(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Version "+id+" not found", http.StatusNotFound)
+			return nil, "", false
+		}
+		if err != nil {
+			writeLoadError(w, r, err)
+			return nil, "", false
+		}
+		return v.Locations, v.ID, true
+	}
+	from, fromID, ok := load(fromID)
+	if !ok {
+		return
+	}
+	to, toID, ok := load(toID)
+	if !ok {
+		return
+	}
+
+	diff := diffVersions(fromID, toID, from, to)
+	if redacts(r.Context()) {
+		diff.Added, diff.Removed, diff.Updated = redactLocations(diff.Added), redactLocations(diff.Removed), redactLocations(diff.Updated)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	varyRedaction(w.Header())
+
+	writeJSON(w, diff, "version diff")
+}
+
+// diffVersions compares two versions location by location. Every list is
+// ordered by ID.
+func diffVersions(fromID, toID string, from, to []MapLocation) VersionDiff {
+	diff := VersionDiff{
+		From: fromID, To: toID,
+		Added: []MapLocation{}, Removed: []MapLocation{}, Moved: []LocationMove{}, Updated: []MapLocation{},
+	}
+
+	old := make(map[string]MapLocation, len(from))
+	for _, loc := range from {
+		old[loc.ID] = loc
+	}
+	for _, loc := range to {
+		prev, ok := old[loc.ID]
+		if !ok {
+			diff.Added = append(diff.Added, loc)
+			continue
+		}
+		delete(old, loc.ID)
+		if prev.XY != loc.XY {
+			diff.Moved = append(diff.Moved, LocationMove{ID: loc.ID, From: prev.XY, To: loc.XY})
+		}
+		moved := prev
+		moved.XY = loc.XY
+		if locationHash(moved) != locationHash(loc) {
+			diff.Updated = append(diff.Updated, loc)
+		}
+	}
+	for _, loc := range old {
+		diff.Removed = append(diff.Removed, loc)
+	}
+
+	byID := func(locs []MapLocation) {
+		sort.Slice(locs, func(i, j int) bool { return locs[i].ID < locs[j].ID })
+	}
+	byID(diff.Added)
+	byID(diff.Removed)
+	byID(diff.Updated)
+	sort.Slice(diff.Moved, func(i, j int) bool { return diff.Moved[i].ID < diff.Moved[j].ID })
+	return diff
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+)
+
+// VoronoiRegion is the region of influence of one marker: every point of the
+// map closer to it than to any other marker. Markers sharing the exact same
+// coordinates share the same polygon.
+type VoronoiRegion struct {
+	ID      string       `json:"id"`
+	Polygon [][2]float64 `json:"polygon"`
+}
+
+// voronoiCache holds the regions of the snapshot generation they were
+// computed for.
+var voronoiCache struct {
+	mu         sync.Mutex
+	generation uint64
+	regions    []VoronoiRegion
+}
+
+func voronoiHandler(w http.ResponseWriter, r *http.Request) {
+	snap, err := loadSnapshot(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+
+	voronoiCache.mu.Lock()
+	if voronoiCache.regions == nil || voronoiCache.generation != snap.generation {
+		voronoiCache.regions = computeVoronoi(snap.locations)
+		voronoiCache.generation = snap.generation
+	}
+	regions := voronoiCache.regions
+	voronoiCache.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, regions, "Voronoi regions")
+}
+
+// computeVoronoi returns the Voronoi cell of every location, clipped to the
+// bounding box of all locations padded by 5% of its largest side.
+//
+// Each cell starts as the whole box and is clipped by the perpendicular
+// bisector between its site and every other site, nearest first. Sites are
+// found through a grid index, and the search stops once the remaining sites
+// are more than twice as far as the farthest vertex of the cell, since their
+// bisectors can no longer cut it. Duplicate coordinates are merged first so
+// that no bisector is degenerate; collinear sites simply yield strips.
+func computeVoronoi(locations []MapLocation) []VoronoiRegion {
+	regions := make([]VoronoiRegion, 0, len(locations))
+	b, ok := locationBounds(locations)
+	if !ok {
+		return regions
+	}
+
+	pad := 0.05 * math.Max(b.MaxX-b.MinX, b.MaxY-b.MinY)
+	if pad == 0 {
+		pad = 1
+	}
+	box := [][2]float64{
+		{b.MinX - pad, b.MinY - pad},
+		{b.MaxX + pad, b.MinY - pad},
+		{b.MaxX + pad, b.MaxY + pad},
+		{b.MinX - pad, b.MaxY + pad},
+	}
+
+	// Merge duplicate coordinates into unique sites
+	siteOf := make(map[Coordinates]int)
+	var sites []MapLocation
+	for _, loc := range locations {
+		if _, ok := siteOf[loc.XY]; !ok {
+			siteOf[loc.XY] = len(sites)
+			sites = append(sites, MapLocation{XY: loc.XY})
+		}
+	}
+
+	grid := newGridIndex(sites)
+	cells := make([][][2]float64, len(sites))
+	for i, site := range sites {
+		cells[i] = voronoiCell(grid, i, site.XY, box)
+	}
+
+	for _, loc := range locations {
+		regions = append(regions, VoronoiRegion{ID: loc.ID, Polygon: cells[siteOf[loc.XY]]})
+	}
+	return regions
+}
+
+// voronoiCell clips box down to the Voronoi cell of site i of grid.
+func voronoiCell(grid *gridIndex, i int, p Coordinates, box [][2]float64) [][2]float64 {
+	cell := append([][2]float64(nil), box...)
+	center, _, maxRing := grid.searchStart(p.X, p.Y)
+
+	for ring := 0; ring <= maxRing && len(cell) > 0; ring++ {
+		grid.visitRing(center, ring, func(j int) {
+			if j != i {
+				cell = clipBisector(cell, p, grid.locations[j].XY)
+			}
+		})
+
+		// Sites beyond this ring are at least ring cells away
+		var reach float64
+		for _, v := range cell {
+			reach = math.Max(reach, math.Hypot(v[0]-p.X, v[1]-p.Y))
+		}
+		if float64(ring)*grid.cellSize > 2*reach {
+			break
+		}
+	}
+
+	return cell
+}
+
+// clipBisector clips polygon to the half-plane of points at least as close to
+// p as to q (Sutherland-Hodgman against a single edge).
+func clipBisector(polygon [][2]float64, p, q Coordinates) [][2]float64 {
+	nx, ny := q.X-p.X, q.Y-p.Y
+	mx, my := (p.X+q.X)/2, (p.Y+q.Y)/2
+	side := func(v [2]float64) float64 {
+		return (v[0]-mx)*nx + (v[1]-my)*ny
+	}
+
+	var out [][2]float64
+	for k, cur := range polygon {
+		prev := polygon[(k+len(polygon)-1)%len(polygon)]
+		sc, sp := side(cur), side(prev)
+
+		if sc <= 0 {
+			if sp > 0 {
+				out = append(out, intersect(prev, cur, sp, sc))
+			}
+			out = append(out, cur)
+		} else if sp <= 0 {
+			out = append(out, intersect(prev, cur, sp, sc))
+		}
+	}
+	return out
+}
+
+// intersect returns the point on segment a-b where the signed side value,
+// sa at a and sb at b, crosses zero.
+func intersect(a, b [2]float64, sa, sb float64) [2]float64 {
+	t := sa / (sa - sb)
+	return [2]float64{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}
+}
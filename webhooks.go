@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook event types. A hook subscribed to no events receives all of them;
+// every hook receives pings.
+const (
+	webhookLocationCreated = "location.created"
+	webhookLocationUpdated = "location.updated"
+	webhookLocationDeleted = "location.deleted"
+	webhookPing            = "ping"
+)
+
+// webhookEventTypes are the events a hook can subscribe to.
+var webhookEventTypes = []string{webhookLocationCreated, webhookLocationUpdated, webhookLocationDeleted}
+
+// webhookEventFor maps an audit action on a location to its event type.
+var webhookEventFor = map[string]string{
+	auditCreate: webhookLocationCreated,
+	auditUpdate: webhookLocationUpdated,
+	auditDelete: webhookLocationDeleted,
+}
+
+// Headers of a webhook delivery. The signature is the hex HMAC-SHA256, keyed
+// with the hook's secret, of the timestamp, a dot and the body, so that a
+// receiver can reject replayed deliveries by their age.
+const (
+	webhookSignatureHeader = "X-Soulforged-Signature"
+	webhookTimestampHeader = "X-Soulforged-Timestamp"
+	webhookEventHeader     = "X-Soulforged-Event"
+	webhookDeliveryHeader  = "X-Soulforged-Delivery"
+)
+
+// Webhook is a URL notified of changes to the map locations. The secret
+// signs the deliveries; it is only shown in the response creating the hook.
+type Webhook struct {
+	ID  string `json:"id" bson:"_id"`
+	URL string `json:"url" bson:"url"`
+	// Events are the event types delivered; empty means all of them
+	Events      []string  `json:"events,omitempty" bson:"events,omitempty"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Secret      string    `json:"secret,omitempty" bson:"secret"`
+	CreatedBy   string    `json:"createdBy" bson:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+func (h Webhook) RecordID() string { return h.ID }
+
+// wants reports whether the hook is subscribed to the event type.
+func (h Webhook) wants(event string) bool {
+	return event == webhookPing || len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// redacted returns the hook without its secret, for listings.
+func (h Webhook) redacted() Webhook {
+	h.Secret = ""
+	return h
+}
+
+// WebhookEvent is the JSON body POSTed to the hooks.
+type WebhookEvent struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	At    time.Time `json:"at"`
+	World string    `json:"world"`
+	// Actor is who made the change
+	Actor      string `json:"actor,omitempty"`
+	LocationID string `json:"locationId,omitempty"`
+	// Location is the location after the change; it is absent for deletes
+	Location *MapLocation `json:"location,omitempty"`
+}
+
+// webhooks stores the registered hooks. Like the submissions it is not
+// cached; it is read once per event by the dispatcher.
+var webhooks RecordStore[Webhook]
+
+// webhookQueue buffers the events between the writes and the dispatcher, so
+// that a slow receiver never holds up a request.
+var webhookQueue chan WebhookEvent
+
+// openWebhooks connects the hooks to the storage backend. It must run after
+// initStorage.
+func openWebhooks() error {
+	s, err := recordStoreFor[Webhook]("webhooks")
+	if err != nil {
+		return err
+	}
+	webhooks = s
+	webhookQueue = make(chan WebhookEvent, config.Webhooks.QueueSize)
+	return nil
+}
+
+// notifyWebhooks queues the event of a write to a location. loc is nil for a
+// delete. A full queue drops the event rather than block the write.
+func notifyWebhooks(ctx context.Context, action, id string, loc *MapLocation) {
+	if webhookQueue == nil {
+		return
+	}
+	ev, err := newWebhookEvent(ctx, webhookEventFor[action])
+	if err != nil {
+		logFor(ctx).Error("failed to create webhook event", "error", err)
+		return
+	}
+	ev.LocationID, ev.Location = id, loc
+
+	select {
+	case webhookQueue <- ev:
+	default:
+		webhookDeliveries.WithLabelValues("dropped").Inc()
+		logFor(ctx).Warn("webhook queue is full, dropping event", "event", ev.Type, "id", id)
+	}
+}
+
+func newWebhookEvent(ctx context.Context, typ string) (WebhookEvent, error) {
+	evID, err := randomToken(12)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	ev := WebhookEvent{ID: evID, Type: typ, At: time.Now().UTC(), World: worldFor(ctx).name}
+	if p, ok := requestPrincipal(ctx); ok {
+		ev.Actor = p.Name
+	}
+	return ev, nil
+}
+
+// dispatchWebhooks delivers the queued events to the hooks subscribed to
+// them until ctx is cancelled. Each delivery retries on its own, so one
+// unreachable receiver does not delay the others.
+func dispatchWebhooks(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-webhookQueue:
+			listCtx, cancel := context.WithTimeout(ctx, config.Mongo.QueryTimeout)
+			hooks, err := webhooks.List(listCtx)
+			cancel()
+			if err != nil {
+				webhookDeliveries.WithLabelValues("dropped").Inc()
+				slog.Error("failed to list webhooks", "event", ev.Type, "error", err)
+				continue
+			}
+			body, err := json.Marshal(ev)
+			if err != nil {
+				slog.Error("failed to encode webhook event", "event", ev.Type, "error", err)
+				continue
+			}
+			for _, h := range hooks {
+				if !h.wants(ev.Type) {
+					continue
+				}
+				wg.Add(1)
+				go func(h Webhook) {
+					defer wg.Done()
+					deliverWebhook(ctx, h, ev, body)
+				}(h)
+			}
+		}
+	}
+}
+
+// deliverWebhook POSTs body to the hook, retrying network errors, 429 and
+// 5xx responses with exponential backoff up to the configured attempts.
+func deliverWebhook(ctx context.Context, h Webhook, ev WebhookEvent, body []byte) error {
+	log := slog.With("webhook", h.ID, "event", ev.Type, "delivery", ev.ID)
+	backoff := config.Webhooks.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(ctx, h, ev, body)
+		if err == nil {
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			log.Debug("webhook delivered", "attempt", attempt)
+			return nil
+		}
+		if !retry || attempt >= config.Webhooks.MaxAttempts {
+			webhookDeliveries.WithLabelValues("failed").Inc()
+			log.Warn("webhook delivery failed", "attempt", attempt, "error", err)
+			return err
+		}
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		log.Info("retrying webhook delivery", "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postWebhook makes one delivery attempt and reports whether a failure is
+// worth retrying.
+func postWebhook(ctx context.Context, h Webhook, ev WebhookEvent, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Webhooks.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "soulforged-go/"+version)
+	req.Header.Set(webhookEventHeader, ev.Type)
+	req.Header.Set(webhookDeliveryHeader, ev.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(h.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+}
+
+// webhookClient does not follow redirects: a signed delivery goes to the
+// registered URL or nowhere.
+var webhookClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// signWebhook returns the hex signature of a delivery.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getWebhookHandler serves /api/admin/webhooks/{id}, without its secret.
+func getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var h Webhook
+	if !webhookOp(w, r, func(ctx context.Context) (err error) {
+		h, err = webhooks.Get(ctx, pathValue(r, "id"))
+		return err
+	}) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, h.redacted(), "webhook")
+}
+
+// deleteWebhookHandler removes the hook at /api/admin/webhooks/{id}.
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	if !webhookOp(w, r, func(ctx context.Context) error { return webhooks.Delete(ctx, id) }) {
+		return
+	}
+	recordAudit(r.Context(), auditDelete, "webhooks", id, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookOp runs op against the hooks within the usual slot and timeout. On
+// failure the error response is written.
+func webhookOp(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) bool {
+	release, err := acquireMongo(r.Context())
+	if err != nil {
+		writeLoadError(w, r, err)
+		return false
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Mongo.QueryTimeout)
+	defer cancel()
+
+	if err := op(ctx); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return false
+		}
+		logFor(r.Context()).Error("failed to access webhooks", "error", err)
+		http.Error(w, "Failed to access webhooks", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var hooks []Webhook
+	if !webhookOp(w, r, func(ctx context.Context) (err error) {
+		hooks, err = webhooks.List(ctx)
+		return err
+	}) {
+		return
+	}
+	list := make([]Webhook, len(hooks))
+	for i, h := range hooks {
+		list[i] = h.redacted()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, list, "webhooks")
+}
+
+// webhookRequest is the body of POST /api/admin/webhooks. A missing secret
+// is generated.
+type webhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+}
+
+// validate checks a webhook registration.
+func (req webhookRequest) validate() []FieldError {
+	var errs []FieldError
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	for i, ev := range req.Events {
+		if !slices.Contains(webhookEventTypes, ev) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("events[%d]", i), Message: "must be one of " + strings.Join(webhookEventTypes, ", ")})
+		}
+	}
+	if req.Secret != "" && len(req.Secret) < 16 {
+		errs = append(errs, FieldError{Field: "secret", Message: "must be at least 16 characters"})
+	}
+	return errs
+}
+
+// createWebhookHandler registers a hook and answers with it, secret
+// included. The secret cannot be read back later.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLocationBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := req.validate(); errs != nil {
+		writeValidationErrors(w, r, "Invalid webhook", errs)
+		return
+	}
+
+	id, err := randomToken(8)
+	if err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	if req.Secret == "" {
+		if req.Secret, err = randomToken(24); err != nil {
+			http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+	}
+	p, _ := requestPrincipal(r.Context())
+	h := Webhook{
+		ID:          id,
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Secret:      req.Secret,
+		CreatedBy:   p.Name,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if !webhookOp(w, r, func(ctx context.Context) error { return webhooks.Insert(ctx, h) }) {
+		return
+	}
+	recordAudit(r.Context(), auditCreate, "webhooks", h.ID, nil, h.redacted())
+	logFor(r.Context()).Info("webhook registered", "webhook", h.ID, "url", h.URL, "by", h.CreatedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", apiPath(r.Context(), "/api/admin/webhooks/"+url.PathEscape(h.ID)))
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, h, "webhook")
+}
+
+// WebhookTestResult is the response of POST /api/admin/webhooks/{id}/test.
+type WebhookTestResult struct {
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// testWebhookHandler sends the hook a ping right away, without retries, and
+// reports how the receiver answered.
+func testWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathValue(r, "id")
+	var h Webhook
+	if !webhookOp(w, r, func(ctx context.Context) (err error) {
+		h, err = webhooks.Get(ctx, id)
+		return err
+	}) {
+		return
+	}
+	ev, err := newWebhookEvent(r.Context(), webhookPing)
+	if err != nil {
+		http.Error(w, "Failed to create webhook event", http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		http.Error(w, "Failed to encode webhook event", http.StatusInternalServerError)
+		return
+	}
+
+	var res WebhookTestResult
+	if _, err := postWebhook(r.Context(), h, ev, body); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Delivered = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, res, "webhook test")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// worldNamePattern is what a world name may look like; it ends up in
+// collection names and URLs.
+var worldNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// world is one game world's map: its storage and its cache. The default
+// world is the original single map, which the change feeds, the version
+// history, the shared Redis cache, gRPC and GraphQL follow; other worlds are
+// loaded on first use and refreshed by polling. The datasets are shared by
+// every world.
+type world struct {
+	name string
+	// store is nil for the default world, which uses store itself
+	store Storage
+	cache *mapCache
+}
+
+// defaultWorld is set up before openWorlds runs so that code reading the
+// cache works even when no worlds are configured, as in tests.
+var defaultWorld = &world{name: "default", cache: cache}
+
+// worlds lists every world in configured order, the default world first.
+var (
+	worlds       = []*world{defaultWorld}
+	worldsByName = map[string]*world{defaultWorld.name: defaultWorld}
+)
+
+// openWorlds connects each configured world to its storage. It must run
+// after initStorage.
+func openWorlds() error {
+	defaultWorld.name = config.Worlds[0]
+	tuneCache(cache, defaultWorld.cacheName(), "map")
+	worlds = []*world{defaultWorld}
+	worldsByName = map[string]*world{defaultWorld.name: defaultWorld}
+	if len(config.Worlds) == 1 {
+		return nil
+	}
+
+	ws, ok := store.(WorldStorage)
+	if !ok {
+		return errors.New("the storage backend does not support multiple worlds")
+	}
+	for _, name := range config.Worlds[1:] {
+		s, err := ws.ForWorld(name)
+		if err != nil {
+			return fmt.Errorf("failed to open world %s: %w", name, err)
+		}
+		w := &world{name: name, store: s, cache: newMapCache()}
+		tuneCache(w.cache, w.cacheName(), "map")
+		worlds = append(worlds, w)
+		worldsByName[name] = w
+	}
+	slog.Info("serving worlds", "worlds", config.Worlds)
+	return nil
+}
+
+// worldCollection names the collection holding a non-default world's
+// locations.
+func worldCollection(name string) string {
+	return config.Mongo.Collection + "_" + name
+}
+
+func (w *world) isDefault() bool { return w == defaultWorld }
+
+// cacheName names the world's cache in /api/admin/cache: "map" for the
+// default world and "map:{world}" for the others.
+func (w *world) cacheName() string {
+	if w.isDefault() {
+		return "map"
+	}
+	return "map:" + w.name
+}
+
+// storage returns the world's backend.
+func (w *world) storage() Storage {
+	if w.store == nil {
+		return store
+	}
+	return w.store
+}
+
+// collection names the world's locations in audit entries.
+func (w *world) collection() string {
+	if w.isDefault() {
+		return mapCollection
+	}
+	return mapCollection + "_" + w.name
+}
+
+// refresh reloads the world from storage.
+func (w *world) refresh(ctx context.Context) error {
+	_, err := w.cache.Reload(ctx, func(ctx context.Context) (*cacheSnapshot, error) {
+		s, err := w.fetch(ctx)
+		if err != nil {
+			slog.Error("failed to refresh world cache", "world", w.name, "error", err)
+		}
+		return s, err
+	})
+	return err
+}
+
+// refreshWorlds reloads every world other than the default one that has been
+// loaded, so worlds nobody asks for cost nothing.
+func refreshWorlds(ctx context.Context) error {
+	var errs []error
+	for _, w := range worlds[1:] {
+		if w.cache.Peek() != nil {
+			errs = append(errs, w.refresh(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type worldKey struct{}
+
+// worldFor returns the world of the request carrying ctx: the one named in
+// its /api/worlds/{world}/ path, or the default world.
+func worldFor(ctx context.Context) *world {
+	if w, ok := ctx.Value(worldKey{}).(*world); ok {
+		return w
+	}
+	return defaultWorld
+}
+
+// storeFor returns the storage of the request's world.
+func storeFor(ctx context.Context) Storage {
+	return worldFor(ctx).storage()
+}
+
+// publicPath returns the path under which the request's world and API
+// version serve an /api/... path.
+func publicPath(ctx context.Context, path string) string {
+	if w, ok := ctx.Value(worldKey{}).(*world); ok {
+		path = "/api/worlds/" + url.PathEscape(w.name) + strings.TrimPrefix(path, "/api")
+	}
+	return apiPath(ctx, path)
+}
+
+// worldPrefix is where the routes of a world other than the default one are
+// mounted; /api/worlds/{world}/map is that world's /api/map.
+const worldPrefix = "/api/worlds/{world}"
+
+// defaultWorldRoutes are the map routes that follow the default world only:
+// the change feed and the version history. Under worldPrefix they answer 404
+// rather than being taken for /map/{id}.
+var defaultWorldRoutes = []string{"/map/stream", "/map/versions", "/map/diff"}
+
+// selectWorld is the middleware of the routes under worldPrefix: it has the
+// request served against the world named by the path.
+func selectWorld(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := pathValue(r, "world")
+		wd, ok := worldsByName[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("World %q not found", name), http.StatusNotFound)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), worldKey{}, wd)))
+	}
+}
+
+// defaultWorldOnly answers a defaultWorldRoutes route under worldPrefix.
+func defaultWorldOnly(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "/api"+path+" is only served for the default world", http.StatusNotFound)
+	}
+}
+
+// WorldInfo describes a world in /api/worlds.
+type WorldInfo struct {
+	Name      string `json:"name"`
+	Default   bool   `json:"default"`
+	Locations int    `json:"locations"`
+	// Map is the world's /api/map
+	Map string `json:"map"`
+}
+
+// worldsHandler lists the worlds with the number of locations in each.
+func worldsHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]WorldInfo, 0, len(worlds))
+	for _, wd := range worlds {
+		info, err := worldInfo(r.Context(), wd)
+		if err != nil {
+			writeLoadError(w, r, err)
+			return
+		}
+		list = append(list, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+
+	writeJSON(w, list, "worlds")
+}
+
+func worldInfo(ctx context.Context, wd *world) (WorldInfo, error) {
+	ctx = context.WithValue(ctx, worldKey{}, wd)
+	snap, err := loadSnapshot(ctx)
+	if err != nil {
+		return WorldInfo{}, err
+	}
+	return WorldInfo{
+		Name:      wd.name,
+		Default:   wd.isDefault(),
+		Locations: len(snap.locations),
+		Map:       publicPath(ctx, "/api/map"),
+	}, nil
+}
+
+// worldHandler serves /api/worlds/{world} as its WorldInfo.
+func worldHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := worldInfo(r.Context(), worldFor(r.Context()))
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, no-cache, must-revalidate")
+	writeJSON(w, info, "world")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsMessage is a frame sent on /ws. The first frame is a "snapshot" carrying
+// every location; each later "diff" frame carries the locations added or
+// changed since the previous generation and the IDs of those removed.
+type wsMessage struct {
+	Type       string        `json:"type"`
+	Generation uint64        `json:"generation"`
+	Locations  []MapLocation `json:"locations,omitempty"`
+	Added      []MapLocation `json:"added,omitempty"`
+	Changed    []MapLocation `json:"changed,omitempty"`
+	Removed    []string      `json:"removed,omitempty"`
+}
+
+// diffHub carries snapshot diffs to /ws subscribers.
+var diffHub = newBroadcaster[wsMessage]()
+
+// newDiffMessage builds the diff frame for moving to next, the snapshot of the
+// given generation.
+func newDiffMessage(diff SnapshotDiff, next []MapLocation, generation uint64) wsMessage {
+	byID := make(map[string]MapLocation, len(next))
+	for _, loc := range next {
+		byID[loc.ID] = loc
+	}
+
+	msg := wsMessage{Type: "diff", Generation: generation, Removed: diff.Removed}
+	for _, id := range diff.Added {
+		msg.Added = append(msg.Added, byID[id])
+	}
+	for _, id := range diff.Updated {
+		msg.Changed = append(msg.Changed, byID[id])
+	}
+	return msg
+}
+
+// wsServer accepts WebSocket connections from any origin; the map data is
+// public, and redacted, exactly as on /api/map.
+var wsServer = websocket.Server{
+	Handshake: func(*websocket.Config, *http.Request) error { return nil },
+	Handler:   serveWebSocket,
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	wsServer.ServeHTTP(w, r)
+}
+
+// serveWebSocket sends the current snapshot followed by a diff every time a
+// refresh or write changes the cache.
+func serveWebSocket(conn *websocket.Conn) {
+	defer conn.Close()
+
+	// Subscribe before taking the snapshot so no diff can slip in between;
+	// diffs up to the snapshot's generation are skipped
+	diffs, unsubscribe := diffHub.subscribe()
+	defer unsubscribe()
+	wsClients.Add(1)
+	defer wsClients.Add(-1)
+
+	redacted := redacts(conn.Request().Context())
+	snap, err := visibleSnapshot(conn.Request().Context())
+	if err != nil {
+		logFor(conn.Request().Context()).Error("failed to load map data", "error", err)
+		return
+	}
+	generation := snap.generation
+
+	snapshot := wsMessage{Type: "snapshot", Generation: generation, Locations: snap.locations}
+	if err := websocket.JSON.Send(conn, snapshot); err != nil {
+		return
+	}
+
+	// Clients never send anything meaningful; reading only detects closes
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		io.Copy(io.Discard, conn)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-diffs:
+			if !ok {
+				// Fell too far behind; the client reconnects for a snapshot
+				return
+			}
+			if msg.Generation <= generation {
+				continue
+			}
+			if redacted {
+				msg.Added, msg.Changed = redactLocations(msg.Added), redactLocations(msg.Changed)
+			}
+			if err := websocket.JSON.Send(conn, msg); err != nil {
+				return
+			}
+		}
+	}
+}